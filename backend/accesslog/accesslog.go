@@ -0,0 +1,497 @@
+// Package accesslog collects the per-connection logs 3proxy writes for every
+// listener into a structured store, one JSON line per connection in a file per
+// UTC day, and answers queries against it.
+package accesslog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/jobs"
+)
+
+const (
+	storeDir    = "connections"  // in config.DataDir
+	offsetsFile = "offsets.json" // in storeDir, the state saved by Collect
+	dayLayout   = "2006-01-02"
+)
+
+// Record is one proxied connection
+type Record struct {
+	Time       time.Time `json:"time"` // when the connection closed
+	PlanID     string    `json:"plan_id"`
+	Subdomain  string    `json:"subdomain"`
+	Username   string    `json:"username"`
+	ClientIP   string    `json:"client_ip"`
+	Target     string    `json:"target"`    // host:port the client asked for
+	BytesIn    int64     `json:"bytes_in"`  // received from the target
+	BytesOut   int64     `json:"bytes_out"` // sent by the client
+	DurationMs int64     `json:"duration_ms"`
+	Result     string    `json:"result"`     // ok, blocked, denied, aborted or failed
+	ErrorCode  int       `json:"error_code"` // 3proxy result code, 0 on success
+}
+
+// Query selects connection records. Zero values mean no bound.
+type Query struct {
+	PlanID   string // empty for every plan
+	Since    time.Time
+	Until    time.Time // exclusive
+	ClientIP string
+	Target   string // substring of host:port
+	Result   string
+	Limit    int
+}
+
+var (
+	mu        sync.Mutex
+	startOnce sync.Once
+)
+
+// Start launches the background collection loop
+func Start() {
+	startOnce.Do(func() {
+		jobs.Every("accesslog", func() time.Duration { return config.Get().AccessLogInterval }, func() {
+			if err := Collect(); err != nil {
+				log.Printf("⚠️ Access log collection failed: %v", err)
+			}
+		})
+		log.Printf("📜 Access log collector started (dir %s, retention %s)", config.AccessLogDir, config.Get().AccessLogRetention)
+	})
+}
+
+// parseLine reads one line in the format set by logformat in
+// create_proxy_plan.sh: time|user|client ip|target|bytes in|bytes out|duration ms|code
+func parseLine(line string) (Record, error) {
+	f := strings.Split(line, "|")
+	if len(f) != 8 {
+		return Record{}, fmt.Errorf("expected 8 fields, got %d", len(f))
+	}
+
+	var r Record
+	ts, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return r, fmt.Errorf("time %q: %v", f[0], err)
+	}
+	r.Time = time.UnixMilli(int64(ts * 1000)).UTC()
+	r.Username, r.ClientIP, r.Target = f[1], f[2], f[3]
+
+	nums := []*int64{&r.BytesIn, &r.BytesOut, &r.DurationMs}
+	for i, p := range nums {
+		if *p, err = strconv.ParseInt(f[4+i], 10, 64); err != nil {
+			return r, fmt.Errorf("field %d %q: %v", 5+i, f[4+i], err)
+		}
+	}
+	if r.ErrorCode, err = strconv.Atoi(f[7]); err != nil {
+		return r, fmt.Errorf("code %q: %v", f[7], err)
+	}
+	r.Result = resultOf(r.ErrorCode)
+	return r, nil
+}
+
+// resultOf groups 3proxy result codes: 1 is a deny rule of the destination
+// policy, 2-9 are other authentication and ACL rejections, 90-99 are
+// connections closed mid-transfer by either side
+func resultOf(code int) string {
+	switch {
+	case code == 0:
+		return "ok"
+	case code == 1:
+		return "blocked"
+	case code < 10:
+		return "denied"
+	case code >= 90 && code < 100:
+		return "aborted"
+	}
+	return "failed"
+}
+
+// listenerOf returns the plan and subdomain from a raw log named
+// <plan>_<subdomain>.<date>.log
+func listenerOf(path string) (planID, subdomain string, ok bool) {
+	name, _, _ := strings.Cut(filepath.Base(path), ".")
+	i := strings.LastIndex(name, "_")
+	if i <= 0 || i == len(name)-1 {
+		return "", "", false
+	}
+	return name[:i], name[i+1:], true
+}
+
+// Collect moves new lines from the raw 3proxy logs into the store and applies
+// retention. Only complete lines are read; the rest waits for the next run.
+// The raw log offsets and the length of every day file are saved together
+// once the records are appended, so a run that fails in between is undone by
+// the next one instead of storing the same lines twice.
+func Collect() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	st := loadState()
+	if err := rollback(st.Sizes); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(config.AccessLogDir, "*.log"))
+	if err != nil {
+		return err
+	}
+
+	byDay := make(map[string][]Record)
+	seen := make(map[string]bool)
+	var expired []string
+	cutoff := time.Now().Add(-config.Get().AccessLogRetention)
+	for _, path := range files {
+		planID, subdomain, ok := listenerOf(path)
+		if !ok {
+			continue
+		}
+		seen[path] = true
+
+		records, offset, err := readFrom(path, st.Offsets[path])
+		if err != nil {
+			log.Printf("⚠️ Failed to read access log %s: %v", path, err)
+			continue
+		}
+		st.Offsets[path] = offset
+		for _, r := range records {
+			r.PlanID, r.Subdomain = planID, subdomain
+			day := r.Time.Format(dayLayout)
+			byDay[day] = append(byDay[day], r)
+		}
+
+		// 3proxy rotates the logs of running listeners itself; logs of
+		// deleted plans are removed once fully read and past retention
+		if info, err := os.Stat(path); err == nil && info.ModTime().Before(cutoff) && info.Size() == offset {
+			expired = append(expired, path)
+		}
+	}
+	for path := range st.Offsets {
+		if !seen[path] {
+			delete(st.Offsets, path)
+		}
+	}
+
+	for _, day := range sortedDays(byDay) {
+		logViolations(byDay[day])
+		if err := appendRecords(day, byDay[day]); err != nil {
+			return err
+		}
+	}
+	if err := prune(cutoff); err != nil {
+		return err
+	}
+	if err := saveState(st); err != nil {
+		return err
+	}
+
+	// Only read logs whose records are saved are removed; their offsets go
+	// on the next run
+	for _, path := range expired {
+		_ = os.Remove(path)
+	}
+	return nil
+}
+
+// logViolations reports connections blocked by the destination policy, one
+// line per plan and target
+func logViolations(records []Record) {
+	counts := make(map[[2]string]int)
+	var order [][2]string
+	for _, r := range records {
+		if r.Result != "blocked" {
+			continue
+		}
+		k := [2]string{r.PlanID, r.Target}
+		if counts[k] == 0 {
+			order = append(order, k)
+		}
+		counts[k]++
+	}
+	for _, k := range order {
+		log.Printf("🚫 Policy blocked plan %s from reaching %s (%d connection(s))", k[0], k[1], counts[k])
+	}
+}
+
+// readFrom parses the complete lines of path after offset and returns the new
+// offset. A file shorter than offset was truncated and is read from the start.
+func readFrom(path string, offset int64) ([]Record, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, offset, err
+	}
+	if info.Size() < offset {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, err
+	}
+
+	var records []Record
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			// io.EOF with a partial line: leave it for the next run
+			break
+		}
+		offset += int64(len(line))
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		r, perr := parseLine(line)
+		if perr != nil {
+			log.Printf("⚠️ Skipping malformed access log line in %s: %v", filepath.Base(path), perr)
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, offset, nil
+}
+
+func storePath(name string) string {
+	return filepath.Join(config.DataDir, storeDir, name)
+}
+
+func appendRecords(day string, records []Record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	path := storePath(day + ".jsonl")
+	_ = os.MkdirAll(filepath.Dir(path), 0755)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// state is what Collect saves after each run
+type state struct {
+	Offsets map[string]int64 `json:"offsets"` // how far each raw log has been read
+	Sizes   map[string]int64 `json:"sizes"`   // length of each day file, by day
+}
+
+func loadState() state {
+	st := state{Offsets: make(map[string]int64)}
+	data, err := os.ReadFile(storePath(offsetsFile))
+	if err != nil {
+		return st
+	}
+	if json.Unmarshal(data, &st) != nil || st.Offsets == nil {
+		// Stores from before the sizes were kept hold only the offsets
+		st = state{Offsets: make(map[string]int64)}
+		_ = json.Unmarshal(data, &st.Offsets)
+	}
+	return st
+}
+
+// saveState records the offsets with the current length of every day file.
+// It replaces the file through a temporary one, so a crash leaves either the
+// previous run's state or this one's.
+func saveState(st state) error {
+	st.Sizes = make(map[string]int64)
+	for _, day := range storedDays() {
+		info, err := os.Stat(storePath(day + ".jsonl"))
+		if err != nil {
+			return err
+		}
+		st.Sizes[day] = info.Size()
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := storePath(offsetsFile)
+	_ = os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// rollback cuts the day files back to the sizes saved with the offsets,
+// dropping records appended by a run that failed before saving them. Without
+// saved sizes nothing is known and the files are kept.
+func rollback(sizes map[string]int64) error {
+	if sizes == nil {
+		return nil
+	}
+	for _, day := range storedDays() {
+		path := storePath(day + ".jsonl")
+		info, err := os.Stat(path)
+		if err != nil || info.Size() <= sizes[day] {
+			continue
+		}
+		if err := os.Truncate(path, sizes[day]); err != nil {
+			return err
+		}
+		log.Printf("↩️ Dropped %d unsaved bytes of connection records for %s", info.Size()-sizes[day], day)
+	}
+	return nil
+}
+
+// prune removes day files that are entirely older than cutoff
+func prune(cutoff time.Time) error {
+	for _, day := range storedDays() {
+		t, _ := time.Parse(dayLayout, day)
+		if t.Add(24 * time.Hour).Before(cutoff) {
+			if err := os.Remove(storePath(day + ".jsonl")); err != nil {
+				return err
+			}
+			log.Printf("🧹 Removed connection records for %s (retention %s)", day, config.Get().AccessLogRetention)
+		}
+	}
+	return nil
+}
+
+// storedDays lists the days in the store, oldest first
+func storedDays() []string {
+	files, _ := filepath.Glob(storePath("*.jsonl"))
+	var days []string
+	for _, f := range files {
+		day := strings.TrimSuffix(filepath.Base(f), ".jsonl")
+		if _, err := time.Parse(dayLayout, day); err == nil {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days
+}
+
+func sortedDays(m map[string][]Record) []string {
+	days := make([]string, 0, len(m))
+	for d := range m {
+		days = append(days, d)
+	}
+	sort.Strings(days)
+	return days
+}
+
+func (q Query) match(r Record) bool {
+	switch {
+	case q.PlanID != "" && r.PlanID != q.PlanID,
+		!q.Since.IsZero() && r.Time.Before(q.Since),
+		!q.Until.IsZero() && !r.Time.Before(q.Until),
+		q.ClientIP != "" && r.ClientIP != q.ClientIP,
+		q.Target != "" && !strings.Contains(r.Target, q.Target),
+		q.Result != "" && r.Result != q.Result:
+		return false
+	}
+	return true
+}
+
+// Search returns the newest records matching q, at most q.Limit of them, and
+// whether older matches were left out
+func Search(q Query) ([]Record, bool, error) {
+	var out []Record
+	for _, day := range q.days() {
+		var matched []Record
+		if err := scanDay(day, q, func(r Record) { matched = append(matched, r) }); err != nil {
+			return nil, false, err
+		}
+		sort.Slice(matched, func(a, b int) bool { return matched[a].Time.After(matched[b].Time) })
+		out = append(out, matched...)
+		if q.Limit > 0 && len(out) > q.Limit {
+			return out[:q.Limit], true, nil
+		}
+	}
+	return out, false, nil
+}
+
+// Summary totals the connections matching a query
+type Summary struct {
+	Connections int   `json:"connections"`
+	BytesIn     int64 `json:"bytes_in"`
+	BytesOut    int64 `json:"bytes_out"`
+}
+
+// Summarize totals every record matching q; q.Limit is ignored
+func Summarize(q Query) (Summary, error) {
+	var s Summary
+	for _, day := range q.days() {
+		err := scanDay(day, q, func(r Record) {
+			s.Connections++
+			s.BytesIn += r.BytesIn
+			s.BytesOut += r.BytesOut
+		})
+		if err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// days lists the stored days that can hold records for q, newest first
+func (q Query) days() []string {
+	mu.Lock()
+	stored := storedDays()
+	mu.Unlock()
+
+	var days []string
+	for i := len(stored) - 1; i >= 0; i-- {
+		t, _ := time.Parse(dayLayout, stored[i])
+		if !q.Until.IsZero() && !t.Before(q.Until) {
+			continue
+		}
+		if !q.Since.IsZero() && t.Add(24*time.Hour).Before(q.Since) {
+			break
+		}
+		days = append(days, stored[i])
+	}
+	return days
+}
+
+// scanDay calls fn for every record of day matching q, in file order
+func scanDay(day string, q Query, fn func(Record)) error {
+	f, err := os.Open(storePath(day + ".jsonl"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		// Cheap pre-check before decoding every line
+		if q.PlanID != "" && !bytes.Contains(sc.Bytes(), []byte(strconv.Quote(q.PlanID))) {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			continue
+		}
+		if q.match(r) {
+			fn(r)
+		}
+	}
+	return sc.Err()
+}
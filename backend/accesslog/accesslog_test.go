@@ -0,0 +1,117 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"oceanproxy-api/config"
+)
+
+func TestParseLine(t *testing.T) {
+	r, err := parseLine("1760781600.250|alice|203.0.113.7|example.com:443|5120|734|1830|0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Username != "alice" || r.ClientIP != "203.0.113.7" || r.Target != "example.com:443" ||
+		r.BytesIn != 5120 || r.BytesOut != 734 || r.DurationMs != 1830 || r.Result != "ok" {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if got := r.Time.UnixMilli(); got != 1760781600250 {
+		t.Errorf("time = %d, want 1760781600250", got)
+	}
+
+	for code, want := range map[string]string{"1": "blocked", "5": "denied", "12": "failed", "92": "aborted"} {
+		r, err := parseLine("1760781600.000|alice|203.0.113.7|example.com:443|0|0|10|" + code)
+		if err != nil || r.Result != want {
+			t.Errorf("code %s: result %q (%v), want %s", code, r.Result, err, want)
+		}
+	}
+	if _, err := parseLine("garbage"); err == nil {
+		t.Error("expected an error for a malformed line")
+	}
+}
+
+func TestCollectAndSearch(t *testing.T) {
+	config.DataDir = t.TempDir()
+	config.AccessLogDir = t.TempDir()
+	config.Set(func(s *config.Settings) { s.AccessLogRetention = 24 * time.Hour })
+
+	now := time.Now()
+	raw := filepath.Join(config.AccessLogDir, "plan_1_usa.251018.log")
+	var lines string
+	for i := 0; i < 5; i++ {
+		ts := float64(now.Add(time.Duration(i-5)*time.Minute).UnixMilli()) / 1000
+		lines += fmt.Sprintf("%.3f|alice|203.0.113.%d|example.com:443|100|10|50|0\n", ts, i)
+	}
+	// A partial line is left for the next run
+	if err := os.WriteFile(raw, []byte(lines+"1760781600.000|alice"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Another plan's log must not leak into the results
+	other := filepath.Join(config.AccessLogDir, "plan_2_eu.251018.log")
+	if err := os.WriteFile(other, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Collect(); err != nil {
+		t.Fatal(err)
+	}
+	// A second run must not duplicate records
+	if err := Collect(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, more, err := Search(Query{PlanID: "plan_1", Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || !more {
+		t.Fatalf("got %d records (more=%v), want 3 with more", len(records), more)
+	}
+	if records[0].ClientIP != "203.0.113.4" || records[0].Subdomain != "usa" {
+		t.Errorf("newest record = %+v", records[0])
+	}
+
+	all, more, _ := Search(Query{PlanID: "plan_1"})
+	if len(all) != 5 || more {
+		t.Errorf("got %d records (more=%v), want 5", len(all), more)
+	}
+
+	filtered, _, _ := Search(Query{PlanID: "plan_1", ClientIP: "203.0.113.1", Until: now})
+	if len(filtered) != 1 {
+		t.Errorf("client_ip filter returned %d records, want 1", len(filtered))
+	}
+
+	// A run that appends but dies before saving its state is undone by the
+	// next one, which stores the lines once
+	saved, err := os.ReadFile(storePath(offsetsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := float64(now.Add(-30*time.Second).UnixMilli()) / 1000
+	f, err := os.OpenFile(raw, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(f, "\n%.3f|alice|203.0.113.9|example.com:443|100|10|50|0\n", ts)
+	f.Close()
+	if err := Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(storePath(offsetsFile), saved, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Collect(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Summarize(Query{PlanID: "plan_1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Connections != 6 || s.BytesIn != 600 {
+		t.Errorf("after an unsaved run %+v, want 6 connections and 600 bytes in", s)
+	}
+}
@@ -0,0 +1,388 @@
+// Package backup archives everything needed to rebuild a host: the plan
+// store, the JSON state next to it and the generated 3proxy and nginx
+// configs. An archive is a tar.gz whose manifest.json lists the SHA-256 of
+// every other file, so a copy can be verified before it is restored.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/proxy"
+)
+
+// FormatVersion is written to every manifest. Restore accepts archives up to
+// this version.
+const FormatVersion = 1
+
+const (
+	manifestName = "manifest.json"
+	backupDir    = "backups" // in config.DataDir
+	keepBackups  = 10        // older archives are removed after a new one is written
+
+	// Archive directories and where their files live on the host
+	dataPrefix  = "data/"
+	proxyPrefix = "3proxy/"
+	nginxPrefix = "nginx/"
+)
+
+// State files in config.DataDir besides the plan store
+var stateFiles = []string{"policies.json", "failover.json", "idempotency.json", "nodes.json", "webhooks.json", "orders.json", "catalog.json", "payments.json", "ledger.json"}
+
+// Manifest describes an archive
+type Manifest struct {
+	FormatVersion int              `json:"format_version"`
+	CreatedAt     time.Time        `json:"created_at"`
+	Host          string           `json:"host"`
+	Domain        string           `json:"domain"`
+	Plans         int              `json:"plans"`
+	Listeners     int              `json:"listeners"`
+	Ports         map[string][]int `json:"ports"` // subdomain -> allocated local ports
+	Files         []File           `json:"files"`
+}
+
+// File is one archived file and its checksum
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Archive is a verified backup held in memory
+type Archive struct {
+	Manifest Manifest
+	Entries  []proxy.Entry
+	files    map[string][]byte
+}
+
+// Info is a stored archive
+type Info struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"created_at"`
+}
+
+// Dir is where Create stores archives
+func Dir() string {
+	return filepath.Join(config.DataDir, backupDir)
+}
+
+// Create writes a new archive to Dir and returns its name and manifest
+func Create() (string, Manifest, error) {
+	files := make(map[string][]byte)
+
+	store, err := os.ReadFile(config.ProxyLogPath)
+	switch {
+	case err == nil:
+		files[dataPrefix+"proxies.json"] = store
+	case os.IsNotExist(err):
+		store = []byte("[]")
+		files[dataPrefix+"proxies.json"] = store
+	default:
+		return "", Manifest{}, err
+	}
+	var entries []proxy.Entry
+	if err := json.Unmarshal(store, &entries); err != nil {
+		return "", Manifest{}, fmt.Errorf("plan store is not valid JSON: %w", err)
+	}
+
+	for _, name := range stateFiles {
+		if err := addFile(files, dataPrefix+name, filepath.Join(config.DataDir, name)); err != nil {
+			return "", Manifest{}, err
+		}
+	}
+	for prefix, dir := range map[string]string{proxyPrefix: config.ProxyConfigDir, nginxPrefix: config.NginxStreamDir} {
+		names, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return "", Manifest{}, err
+		}
+		for _, d := range names {
+			if d.Type().IsRegular() {
+				if err := addFile(files, prefix+d.Name(), filepath.Join(dir, d.Name())); err != nil {
+					return "", Manifest{}, err
+				}
+			}
+		}
+	}
+
+	host, _ := os.Hostname()
+	m := Manifest{
+		FormatVersion: FormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		Host:          host,
+		Domain:        config.Get().BaseDomain,
+		Listeners:     len(entries),
+		Ports:         allocatedPorts(entries),
+	}
+	plans := make(map[string]bool)
+	for _, e := range entries {
+		plans[e.PlanID] = true
+	}
+	m.Plans = len(plans)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sum := sha256.Sum256(files[name])
+		m.Files = append(m.Files, File{Path: name, Size: int64(len(files[name])), SHA256: hex.EncodeToString(sum[:])})
+	}
+
+	var buf bytes.Buffer
+	if err := writeArchive(&buf, m, files); err != nil {
+		return "", Manifest{}, err
+	}
+
+	name := "oceanproxy-backup-" + m.CreatedAt.Format("20060102-150405") + ".tar.gz"
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		return "", Manifest{}, err
+	}
+	if err := writeFileAtomic(filepath.Join(Dir(), name), buf.Bytes(), 0600); err != nil {
+		return "", Manifest{}, err
+	}
+	prune()
+	return name, m, nil
+}
+
+func addFile(files map[string][]byte, name, src string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	files[name] = data
+	return nil
+}
+
+func allocatedPorts(entries []proxy.Entry) map[string][]int {
+	ports := make(map[string][]int)
+	for _, e := range entries {
+		ports[e.Subdomain] = append(ports[e.Subdomain], e.LocalPort)
+	}
+	for _, p := range ports {
+		sort.Ints(p)
+	}
+	return ports
+}
+
+// writeArchive writes the manifest first so Verify can check files as they come
+func writeArchive(w io.Writer, m Manifest, files map[string][]byte) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: m.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(manifestName, manifest); err != nil {
+		return err
+	}
+	for _, f := range m.Files {
+		if err := add(f.Path, files[f.Path]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// prune removes all but the newest keepBackups archives
+func prune() {
+	list, err := List()
+	if err != nil || len(list) <= keepBackups {
+		return
+	}
+	for _, b := range list[keepBackups:] {
+		_ = os.Remove(filepath.Join(Dir(), b.Name))
+	}
+}
+
+// List returns the stored archives, newest first
+func List() ([]Info, error) {
+	dirents, err := os.ReadDir(Dir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Info{}, nil
+		}
+		return nil, err
+	}
+	list := []Info{}
+	for _, d := range dirents {
+		if !strings.HasSuffix(d.Name(), ".tar.gz") {
+			continue
+		}
+		if info, err := d.Info(); err == nil {
+			list = append(list, Info{Name: d.Name(), Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name > list[j].Name })
+	return list, nil
+}
+
+// Open returns a stored archive by name
+func Open(name string) (*os.File, error) {
+	if name != filepath.Base(name) || !strings.HasSuffix(name, ".tar.gz") {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filepath.Join(Dir(), name))
+}
+
+// Verify reads an archive and checks its format version, that every file
+// matches the manifest's size and checksum, that nothing unlisted or outside
+// the known directories is present, and that the plan store parses
+func Verify(r io.Reader) (*Archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a gzip archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	a := &Archive{files: make(map[string][]byte)}
+	listed := make(map[string]File)
+	for first := true; ; first = false {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("corrupt archive: %w", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt archive: %w", err)
+		}
+
+		if first {
+			if hdr.Name != manifestName {
+				return nil, fmt.Errorf("archive does not start with %s", manifestName)
+			}
+			if err := json.Unmarshal(data, &a.Manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %w", err)
+			}
+			if v := a.Manifest.FormatVersion; v < 1 || v > FormatVersion {
+				return nil, fmt.Errorf("archive format version %d is not supported (up to %d)", v, FormatVersion)
+			}
+			for _, f := range a.Manifest.Files {
+				if !validPath(f.Path) {
+					return nil, fmt.Errorf("manifest lists unexpected path %q", f.Path)
+				}
+				listed[f.Path] = f
+			}
+			continue
+		}
+
+		f, ok := listed[hdr.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s is not in the manifest", hdr.Name)
+		}
+		if _, dup := a.files[hdr.Name]; dup {
+			return nil, fmt.Errorf("%s appears twice", hdr.Name)
+		}
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return nil, fmt.Errorf("%s does not match its checksum", hdr.Name)
+		}
+		a.files[hdr.Name] = data
+	}
+
+	if a.Manifest.FormatVersion == 0 {
+		return nil, fmt.Errorf("archive has no manifest")
+	}
+	for name := range listed {
+		if _, ok := a.files[name]; !ok {
+			return nil, fmt.Errorf("%s is missing from the archive", name)
+		}
+	}
+	store, ok := a.files[dataPrefix+"proxies.json"]
+	if !ok {
+		return nil, fmt.Errorf("archive has no plan store")
+	}
+	if err := json.Unmarshal(store, &a.Entries); err != nil {
+		return nil, fmt.Errorf("plan store in archive is not valid: %w", err)
+	}
+	return a, nil
+}
+
+// validPath accepts only plain file names in the archive's known directories
+func validPath(p string) bool {
+	for _, prefix := range []string{dataPrefix, proxyPrefix, nginxPrefix} {
+		if name, ok := strings.CutPrefix(p, prefix); ok {
+			return name != "" && name == path.Base(name) && name != ".." && name != "."
+		}
+	}
+	return false
+}
+
+// Restore writes the archive's files to this host: the plan store to
+// config.ProxyLogPath, state files to config.DataDir and configs to
+// config.ProxyConfigDir and config.NginxStreamDir
+func (a *Archive) Restore() error {
+	names := make([]string, 0, len(a.files))
+	for name := range a.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var dst string
+		var mode os.FileMode = 0644
+		switch {
+		case name == dataPrefix+"proxies.json":
+			// Through the store lock, so no writer saves over the restored plans
+			if err := proxy.UpdateProxyLog(func([]proxy.Entry) ([]proxy.Entry, error) { return a.Entries, nil }); err != nil {
+				return fmt.Errorf("restore %s: %w", name, err)
+			}
+			continue
+		case strings.HasPrefix(name, dataPrefix):
+			dst = filepath.Join(config.DataDir, strings.TrimPrefix(name, dataPrefix))
+			mode = 0600
+		case strings.HasPrefix(name, proxyPrefix):
+			dst = filepath.Join(config.ProxyConfigDir, strings.TrimPrefix(name, proxyPrefix))
+		case strings.HasPrefix(name, nginxPrefix):
+			dst = filepath.Join(config.NginxStreamDir, strings.TrimPrefix(name, nginxPrefix))
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(dst, a.files[name], mode); err != nil {
+			return fmt.Errorf("restore %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
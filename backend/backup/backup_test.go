@@ -0,0 +1,147 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"oceanproxy-api/config"
+)
+
+func setupHost(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	config.DataDir = filepath.Join(root, "data")
+	config.ProxyLogPath = filepath.Join(config.DataDir, "proxies.json")
+	config.ProxyConfigDir = filepath.Join(root, "3proxy")
+	config.NginxStreamDir = filepath.Join(root, "nginx")
+	for _, dir := range []string{config.DataDir, config.ProxyConfigDir, config.NginxStreamDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func write(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateVerifyRestore(t *testing.T) {
+	setupHost(t)
+	write(t, config.ProxyLogPath, `[{"plan_id":"p1","subdomain":"usa","local_port":10001},{"plan_id":"p1","subdomain":"eu","local_port":12001}]`)
+	write(t, filepath.Join(config.DataDir, "policies.json"), `{"global":[]}`)
+	write(t, filepath.Join(config.ProxyConfigDir, "p1_usa.cfg"), "proxy -p10001\n")
+	write(t, filepath.Join(config.NginxStreamDir, "upstreams.conf"), "upstream usa_proxies {}\n")
+
+	name, m, err := Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Plans != 1 || m.Listeners != 2 || len(m.Files) != 4 || m.Ports["usa"][0] != 10001 {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+
+	data, err := os.ReadFile(filepath.Join(Dir(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := Verify(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(a.Entries) != 2 {
+		t.Errorf("got %d entries, want 2", len(a.Entries))
+	}
+
+	// Restore onto a fresh host
+	setupHost(t)
+	if err := a.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		config.ProxyLogPath: "\"local_port\": 12001",
+		filepath.Join(config.ProxyConfigDir, "p1_usa.cfg"):     "proxy -p10001",
+		filepath.Join(config.NginxStreamDir, "upstreams.conf"): "usa_proxies",
+		filepath.Join(config.DataDir, "policies.json"):         "global",
+	} {
+		got, err := os.ReadFile(path)
+		if err != nil || !strings.Contains(string(got), want) {
+			t.Errorf("%s = %q (%v), want it to contain %q", path, got, err, want)
+		}
+	}
+}
+
+// rewrite copies an archive, changing file contents with edit
+func rewrite(t *testing.T, data []byte, edit func(name string, body []byte) []byte) []byte {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		body, _ := io.ReadAll(tr)
+		body = edit(hdr.Name, body)
+		hdr.Size = int64(len(body))
+		tw.WriteHeader(hdr)
+		tw.Write(body)
+	}
+	tw.Close()
+	gw.Close()
+	return out.Bytes()
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	setupHost(t)
+	write(t, config.ProxyLogPath, `[{"plan_id":"p1","subdomain":"usa","local_port":10001}]`)
+	name, _, err := Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(Dir(), name))
+
+	tests := map[string]func(name string, body []byte) []byte{
+		"changed file": func(name string, body []byte) []byte {
+			if name == "data/proxies.json" {
+				return bytes.Replace(body, []byte("10001"), []byte("10002"), 1)
+			}
+			return body
+		},
+		"newer format": func(name string, body []byte) []byte {
+			if name == manifestName {
+				return bytes.Replace(body, []byte(`"format_version": 1`), []byte(`"format_version": 9`), 1)
+			}
+			return body
+		},
+		"path escape": func(name string, body []byte) []byte {
+			if name == manifestName {
+				return bytes.Replace(body, []byte(`"data/proxies.json"`), []byte(`"data/../../etc/passwd"`), 1)
+			}
+			return body
+		},
+	}
+	for name, edit := range tests {
+		if _, err := Verify(bytes.NewReader(rewrite(t, data, edit))); err == nil {
+			t.Errorf("%s: expected verification to fail", name)
+		}
+	}
+	if _, err := Verify(strings.NewReader("not an archive")); err == nil {
+		t.Error("expected an error for garbage input")
+	}
+}
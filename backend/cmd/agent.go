@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+
+	"oceanproxy-api/accesslog"
+	"oceanproxy-api/config"
+	"oceanproxy-api/handlers"
+	"oceanproxy-api/tlsproxy"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// runAgent serves the edge agent RPC. Plans, providers, probing and failover
+// stay on the control plane; the agent only runs the listeners it is sent.
+func runAgent() {
+	log.Printf("🛰️ Edge agent mode - AGENT_TOKEN: %s, DOMAIN: %s", config.MaskString(config.Get().AgentToken), config.Get().BaseDomain)
+
+	accesslog.Start()
+	tlsproxy.Start()
+
+	r := chi.NewRouter()
+	r.Use(middleware.Logger)
+	r.Use(middleware.Recoverer)
+
+	r.Get("/health", healthHandler)
+	r.Group(func(r chi.Router) {
+		r.Use(handlers.AgentAuthMiddleware)
+		r.Post("/agent/spawn", handlers.AgentSpawnHandler)
+		r.Post("/agent/stop", handlers.AgentStopHandler)
+		r.Get("/agent/status", handlers.AgentStatusHandler)
+		r.Get("/agent/usage", handlers.AgentUsageHandler)
+		r.Get("/agent/violations", handlers.PolicyViolationsHandler)
+	})
+
+	serve(r)
+}
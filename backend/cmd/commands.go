@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/policy"
+	"oceanproxy-api/providers"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// runCommand handles one-shot subcommands and returns the process exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "import-proxiesfo":
+		return importProxiesFO(args[1:])
+	case "policy":
+		if len(args) == 2 && args[1] == "defaults" {
+			return policyDefaults()
+		}
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", strings.Join(args, " "), usage)
+		return 2
+	case "config":
+		if len(args) > 1 && args[1] == "check" {
+			return configCheck(args[2:])
+		}
+		fallthrough
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", strings.Join(args, " "), usage)
+		return 2
+	}
+}
+
+const usage = `Usage:
+  oceanproxy-api                      start the API server
+  oceanproxy-api import-proxiesfo     ask the running API to import missing proxies.fo plans
+  oceanproxy-api config check         validate the config file, .env and environment
+  oceanproxy-api policy defaults      print the default destination policy as 3proxy ACL lines
+`
+
+// policyDefaults prints the safe default rules the way listeners get them,
+// for create_proxy_plan.sh when it is run by hand
+func policyDefaults() int {
+	config.LoadEnv()
+	fmt.Print(policy.RenderRules(policy.Defaults()))
+	return 0
+}
+
+// configCheck validates the configuration the server would start with and
+// prints every problem, without starting anything
+func configCheck(args []string) int {
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	path := fs.String("config", config.ConfigPath(), "config file to check")
+	show := fs.Bool("print", false, "print the effective configuration with secrets masked")
+	_ = fs.Parse(args)
+
+	_ = godotenv.Load()
+	f, warnings, err := config.Read(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	for _, w := range warnings {
+		fmt.Printf("⚠️ %s\n", w)
+	}
+
+	if f.Paths.TopologyFile != "" {
+		if err := providers.LoadTopology(f.Paths.TopologyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 1
+		}
+	}
+
+	if *show {
+		out, _ := yaml.Marshal(f.Masked())
+		fmt.Print(string(out))
+	}
+	fmt.Println("✅ Configuration OK")
+	return 0
+}
+
+// importProxiesFO asks the running API to import the proxies.fo reseller
+// plans it does not know about yet, so listeners and proxies.json are only
+// ever changed by the server that owns them
+func importProxiesFO(args []string) int {
+	fs := flag.NewFlagSet("import-proxiesfo", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report which plans would be imported")
+	_ = fs.Parse(args)
+
+	config.LoadEnv()
+	form := url.Values{}
+	if *dryRun {
+		form.Set("dry_run", "true")
+	}
+	base := localAPI()
+	req, err := http.NewRequest(http.MethodPost, base+"/proxiesfo/import", strings.NewReader(form.Encode()))
+	if err != nil {
+		log.Printf("❌ Import failed: %v", err)
+		return 1
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+config.Get().BearerToken)
+
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("❌ Import failed, is the API running on %s? %v", base, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("❌ Import failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		return 1
+	}
+
+	var out bytes.Buffer
+	if json.Indent(&out, body, "", "  ") != nil {
+		out.Reset()
+		out.Write(body)
+	}
+	fmt.Println(out.String())
+	return 0
+}
+
+// localAPI is the base URL of the API server on this host, from LISTEN_ADDR
+func localAPI() string {
+	host, port, err := net.SplitHostPort(config.ListenAddr)
+	if err != nil {
+		return "http://127.0.0.1:9090"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
@@ -3,21 +3,44 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 	"time"
 
+	"oceanproxy-api/accesslog"
 	"oceanproxy-api/config"
+	"oceanproxy-api/events"
+	"oceanproxy-api/failover"
 	"oceanproxy-api/handlers"
+	"oceanproxy-api/nodes"
+	"oceanproxy-api/orders"
+	"oceanproxy-api/prober"
+	"oceanproxy-api/providers"
 	"oceanproxy-api/proxy"
+	"oceanproxy-api/reconcile"
+	"oceanproxy-api/tlsproxy"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	log.Println("🚀 Starting OceanProxy API Server...")
 
 	config.LoadEnv()
 
+	if err := providers.LoadTopology(config.Get().TopologyFile); err != nil {
+		log.Fatalf("❌ Failed to load plan topology: %v", err)
+	}
+
+	if config.NodeRole == config.RoleAgent {
+		runAgent()
+		return
+	}
+
 	// Initialize port manager
 	if err := proxy.InitializePortManager(); err != nil {
 		log.Printf("⚠️ Failed to initialize port manager: %v", err)
@@ -25,35 +48,121 @@ func main() {
 		log.Println("✅ Port manager initialized")
 	}
 
+	cfg := config.Get()
 	log.Printf("🔧 Config loaded - API_KEY: %s, BEARER_TOKEN: %s, DOMAIN: %s",
-		config.MaskString(config.APIKey),
-		config.MaskString(config.BearerToken),
-		config.BaseDomain)
+		config.MaskString(cfg.APIKey),
+		config.MaskString(cfg.BearerToken),
+		cfg.BaseDomain)
+
+	// Start probing upstream providers in the background
+	prober.Start()
+	failover.Start()
+	reconcile.Start()
+	accesslog.Start()
+	tlsproxy.Start()
+	nodes.Start()
+	events.Start()
+	orders.Start()
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		handlers.JSON(w, map[string]string{
-			"status":    "healthy",
-			"timestamp": time.Now().Format(time.RFC3339),
-		})
-	})
+	r.Get("/health", healthHandler)
+	r.Post("/payments/webhook/{processor}", handlers.PaymentWebhookHandler)
 
 	r.Group(func(r chi.Router) {
 		r.Use(handlers.AuthMiddleware)
-		r.Post("/plan", handlers.CreatePlanHandler)
-		r.Post("/nettify/plan", handlers.CreateNettifyPlanHandler)
+		r.With(handlers.IdempotencyMiddleware).Post("/plan", handlers.CreatePlanHandler)
+		r.With(handlers.IdempotencyMiddleware).Post("/nettify/plan", handlers.CreateNettifyPlanHandler)
 		r.Get("/ports", handlers.PortsInUseHandler)
 		r.Get("/proxies", handlers.GetProxiesHandler)
 		r.Post("/restore", handlers.RestoreHandler)
+		r.Get("/plans", handlers.ListPlansHandler)
+		r.Get("/plans/{id}", handlers.GetPlanHandler)
+		r.Delete("/plans/{id}", handlers.DeletePlanHandler)
+		r.With(handlers.IdempotencyMiddleware).Post("/plans/{id}/extend", handlers.ExtendPlanHandler)
+		r.Get("/plans/{id}/connections", handlers.PlanConnectionsHandler)
+		r.Get("/plans/{id}/export", handlers.ExportPlanHandler)
+		r.Get("/customers/{customer}/export", handlers.ExportCustomerHandler)
+		r.Get("/plans/{id}/usage", handlers.PlanUsageHandler)
+		r.Post("/plans/{id}/limits", handlers.SetPlanLimitsHandler)
+		r.Post("/plans/{id}/tls", handlers.SetPlanTLSHandler)
+		r.Get("/plans/{id}/policy", handlers.GetPlanPolicyHandler)
+		r.Put("/plans/{id}/policy", handlers.SetPlanPolicyHandler)
+		r.Delete("/plans/{id}/policy", handlers.DeletePlanPolicyHandler)
+		r.Get("/policies", handlers.GetPoliciesHandler)
+		r.Put("/policies/global", handlers.SetGlobalPolicyHandler)
+		r.Get("/policies/check", handlers.CheckPolicyHandler)
+		r.Get("/policies/violations", handlers.PolicyViolationsHandler)
+		r.Get("/metrics", handlers.MetricsHandler)
+		r.Get("/failover", handlers.FailoverStatusHandler)
+		r.Get("/nettify/reconcile", handlers.NettifyReconcileHandler)
+		r.Post("/nettify/reconcile", handlers.NettifyReconcileHandler)
+		r.Get("/nettify/reconcile/last", handlers.NettifyReconcileLastHandler)
+		r.Get("/proxiesfo/reconcile", handlers.ProxiesFOReconcileHandler)
+		r.Post("/proxiesfo/reconcile", handlers.ProxiesFOReconcileHandler)
+		r.Get("/proxiesfo/reconcile/last", handlers.ProxiesFOReconcileLastHandler)
+		r.Post("/proxiesfo/import", handlers.ProxiesFOImportHandler)
+		r.Post("/admin/backup", handlers.CreateBackupHandler)
+		r.Post("/admin/backup/restore", handlers.RestoreBackupHandler)
+		r.Get("/admin/backups", handlers.ListBackupsHandler)
+		r.Get("/admin/backups/{name}", handlers.DownloadBackupHandler)
+		r.Get("/nodes", handlers.ListNodesHandler)
+		r.Post("/nodes", handlers.AddNodeHandler)
+		r.Delete("/nodes/{id}", handlers.RemoveNodeHandler)
+		r.Get("/webhooks", handlers.ListWebhooksHandler)
+		r.Post("/webhooks", handlers.CreateWebhookHandler)
+		r.Get("/webhooks/deliveries", handlers.WebhookDeliveriesHandler)
+		r.Get("/webhooks/dead-letters", handlers.WebhookDeadLettersHandler)
+		r.Post("/webhooks/dead-letters/{id}/retry", handlers.RetryDeadLetterHandler)
+		r.Delete("/webhooks/{id}", handlers.DeleteWebhookHandler)
+		r.Post("/webhooks/{id}/ping", handlers.PingWebhookHandler)
+		r.Get("/orders", handlers.ListOrdersHandler)
+		r.With(handlers.IdempotencyMiddleware).Post("/orders", handlers.CreateOrderHandler)
+		r.Get("/orders/products", handlers.OrderProductsHandler)
+		r.Get("/orders/{id}", handlers.GetOrderHandler)
+		r.Post("/orders/{id}/paid", handlers.PayOrderHandler)
+		r.Post("/orders/{id}/cancel", handlers.CancelOrderHandler)
+		r.Post("/orders/{id}/retry", handlers.RetryOrderHandler)
+		r.Get("/payments", handlers.ListPaymentsHandler)
+		r.Get("/customers/{customer}/balance", handlers.BalanceHandler)
+		r.Get("/customers/{customer}/statement", handlers.StatementHandler)
+		r.With(handlers.IdempotencyMiddleware).Post("/customers/{customer}/deposits", handlers.DepositHandler)
+		r.Post("/customers/{customer}/adjustments", handlers.AdjustmentHandler)
+		r.Get("/ledger/accounts", handlers.LedgerAccountsHandler)
+		r.Post("/ledger/{id}/refund", handlers.RefundHandler)
+		r.Get("/catalog", handlers.GetCatalogHandler)
+		r.Put("/catalog/prices", handlers.SetPriceHandler)
+		r.Delete("/catalog/prices", handlers.DeletePriceHandler)
+		r.Post("/quote", handlers.QuoteHandler)
+		r.Get("/margins", handlers.MarginsHandler)
 	})
 
 	// Monitoring routes
 	r.Get("/monitoring", handlers.MonitoringPanelHandler)
 	r.Get("/monitoring/api", handlers.MonitoringAPIHandler)
 
-	log.Println("🌐 Listening on http://0.0.0.0:9090")
-	log.Fatal(http.ListenAndServe(":9090", r))
+	serve(r)
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	handlers.JSON(w, map[string]string{
+		"status":    "healthy",
+		"role":      config.NodeRole,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// serve runs the API until SIGTERM or SIGINT
+func serve(h http.Handler) {
+	srv := &http.Server{Addr: config.ListenAddr, Handler: h}
+	go func() {
+		log.Printf("🌐 Listening on %s", config.ListenAddr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	waitForSignals(srv)
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"oceanproxy-api/backup"
+)
+
+func backupCreate(args []string) error {
+	fs := newFlags("backup")
+	out := fs.String("out", "", "where to save the archive, default its name in the current directory")
+	_ = fs.Parse(args)
+
+	var resp struct {
+		Name     string          `json:"name"`
+		Manifest backup.Manifest `json:"manifest"`
+	}
+	if err := call("POST", "/admin/backup", url.Values{}, nil, &resp); err != nil {
+		return err
+	}
+	var archive []byte
+	if err := call("GET", "/admin/backups/"+url.PathEscape(resp.Name), nil, nil, &archive); err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = resp.Name
+	}
+	if err := os.WriteFile(*out, archive, 0600); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	fmt.Printf("💾 Saved %s: %d plan(s), %d listener(s), %d file(s)\n", *out, resp.Manifest.Plans, resp.Manifest.Listeners, len(resp.Manifest.Files))
+	return nil
+}
+
+// restoreBackup verifies an archive locally, then uploads it to the API,
+// which writes it over its state and respawns the listeners
+func restoreBackup(args []string) error {
+	fs := newFlags("restore-backup")
+	dryRun := fs.Bool("dry-run", false, "only verify the archive, locally and on the API host")
+	force := fs.Bool("force", false, "replace the state of a host that already has plans")
+	file, err := parseWithArg(fs, args, "archive")
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	archive, err := backup.Verify(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s failed verification: %w", file, err)
+	}
+	m := archive.Manifest
+	if output != "json" {
+		fmt.Printf("🔎 %s verified: taken %s on %s, %d plan(s), %d listener(s), %d file(s)\n",
+			file, m.CreatedAt.Local().Format("2006-01-02 15:04"), m.Host, m.Plans, m.Listeners, len(m.Files))
+	}
+
+	q := url.Values{}
+	if *dryRun {
+		q.Set("dry_run", "true")
+	}
+	if *force {
+		q.Set("force", "true")
+	}
+	var resp struct {
+		Verified       bool     `json:"verified"`
+		Restored       []string `json:"restored"`
+		Failed         []string `json:"failed"`
+		SkippedExpired int      `json:"skipped_expired"`
+		Stopped        []string `json:"stopped"`
+	}
+	header := http.Header{"Content-Type": {"application/gzip"}}
+	if err := send("POST", "/admin/backup/restore?"+q.Encode(), bytes.NewReader(data), header, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	if *dryRun {
+		fmt.Println("✅ API verified the archive, nothing was changed")
+		return nil
+	}
+	fmt.Printf("✅ Restored state and respawned %d listener(s), %d expired skipped\n", len(resp.Restored), resp.SkippedExpired)
+	for _, s := range resp.Stopped {
+		fmt.Printf("🛑 Stopped, not in the archive: %s\n", s)
+	}
+	for _, f := range resp.Failed {
+		fmt.Printf("❌ Failed: %s\n", f)
+	}
+	if len(resp.Failed) > 0 {
+		return errUnhealthy
+	}
+	return nil
+}
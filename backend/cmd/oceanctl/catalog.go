@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"oceanproxy-api/handlers"
+	"oceanproxy-api/orders"
+)
+
+func catalogShow(args []string) error {
+	_ = newFlags("catalog show").Parse(args)
+	var resp struct {
+		Currency string         `json:"currency"`
+		Prices   []orders.Price `json:"prices"`
+	}
+	if err := call("GET", "/catalog", nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	var rows [][]string
+	for _, p := range resp.Prices {
+		tier := "-"
+		if p.Threads > 0 {
+			tier = strconv.Itoa(p.Threads)
+		}
+		var discounts []string
+		for _, d := range p.Discounts {
+			discounts = append(discounts, fmt.Sprintf("%g%% from %d", d.Percent, d.MinQuantity))
+		}
+		rows = append(rows, []string{
+			p.Product, tier, orders.FormatAmount(p.CostCents), orders.FormatAmount(p.PriceCents),
+			orDash(strings.Join(discounts, ", ")),
+		})
+	}
+	printTable([]string{"PRODUCT", "THREADS", "COST/UNIT " + resp.Currency, "PRICE/UNIT " + resp.Currency, "DISCOUNTS"}, rows)
+	return nil
+}
+
+func catalogSet(args []string) error {
+	fs := newFlags("catalog set")
+	cost := fs.String("cost", "", "provider cost per unit, e.g. 1.80")
+	price := fs.String("price", "", "sell price per unit, e.g. 3.50")
+	threads := fs.Int("threads", 0, "thread tier (proxiesfo/datacenter)")
+	discounts := fs.String("discounts", "", "min_quantity:percent,... e.g. 10:5,50:12")
+	product, err := parseWithArg(fs, args, "product")
+	if err != nil {
+		return err
+	}
+	if *cost == "" || *price == "" {
+		return fmt.Errorf("catalog set: --cost and --price are required")
+	}
+	form := url.Values{"product": {product}, "cost": {*cost}, "price": {*price}, "discounts": {*discounts}}
+	if *threads > 0 {
+		form.Set("threads", strconv.Itoa(*threads))
+	}
+	if err := call("PUT", "/catalog/prices", form, nil, nil); err != nil {
+		return err
+	}
+	fmt.Printf("🏷️ Priced %s\n", product)
+	return nil
+}
+
+func catalogRemove(args []string) error {
+	fs := newFlags("catalog remove")
+	threads := fs.Int("threads", 0, "thread tier (proxiesfo/datacenter)")
+	product, err := parseWithArg(fs, args, "product")
+	if err != nil {
+		return err
+	}
+	q := url.Values{"product": {product}}
+	if *threads > 0 {
+		q.Set("threads", strconv.Itoa(*threads))
+	}
+	if err := call("DELETE", "/catalog/prices?"+q.Encode(), nil, nil, nil); err != nil {
+		return err
+	}
+	fmt.Printf("🗑️ Removed the price of %s\n", product)
+	return nil
+}
+
+func quote(args []string) error {
+	fs := newFlags("quote")
+	quantity := fs.Float64("quantity", 0, "GB, days or hours")
+	threads := fs.Int("threads", 0, "concurrent connections (proxiesfo/datacenter)")
+	product, err := parseWithArg(fs, args, "product")
+	if err != nil {
+		return err
+	}
+	form := url.Values{"product": {product}, "quantity": {strconv.FormatFloat(*quantity, 'f', -1, 64)}}
+	if *threads > 0 {
+		form.Set("threads", strconv.Itoa(*threads))
+	}
+	var resp struct {
+		Quote orders.Quote `json:"quote"`
+	}
+	if err := call("POST", "/quote", form, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	q := resp.Quote
+	fields := [][2]string{
+		{"Product", q.Product},
+		{"Quantity", fmt.Sprintf("%g %s", q.Quantity, q.Unit)},
+	}
+	if q.Threads > 0 {
+		fields = append(fields, [2]string{"Thread tier", strconv.Itoa(q.Threads)})
+	}
+	fields = append(fields,
+		[2]string{"Unit price", orders.FormatAmount(q.UnitPriceCents) + " " + q.Currency},
+		[2]string{"Subtotal", orders.FormatAmount(q.SubtotalCents)},
+		[2]string{"Discount", fmt.Sprintf("%s (%g%%)", orders.FormatAmount(q.DiscountCents), q.DiscountPercent)},
+		[2]string{"Total", orders.FormatAmount(q.TotalCents)},
+		[2]string{"Cost", orders.FormatAmount(q.CostCents)},
+		[2]string{"Margin", orders.FormatAmount(q.MarginCents)},
+	)
+	printFields(fields)
+	return nil
+}
+
+func margins(args []string) error {
+	fs := newFlags("margins")
+	by := fs.String("by", "product", "product, provider, customer or month")
+	since := fs.String("since", "", "plans created from (unix or RFC3339)")
+	until := fs.String("until", "", "plans created before (unix or RFC3339)")
+	_ = fs.Parse(args)
+
+	q := url.Values{"by": {*by}, "since": {*since}, "until": {*until}}
+	var resp struct {
+		Currency string               `json:"currency"`
+		Rows     []handlers.MarginRow `json:"rows"`
+		Total    handlers.MarginRow   `json:"total"`
+	}
+	if err := call("GET", "/margins?"+q.Encode(), nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	var rows [][]string
+	for _, r := range append(resp.Rows, resp.Total) {
+		rows = append(rows, []string{
+			r.Key, strconv.Itoa(r.Plans), strconv.Itoa(r.Unpriced),
+			orders.FormatAmount(r.RevenueCents), orders.FormatAmount(r.CostCents),
+			orders.FormatAmount(r.MarginCents), fmt.Sprintf("%.1f%%", r.MarginPercent),
+		})
+	}
+	printTable([]string{strings.ToUpper(*by), "PLANS", "UNPRICED", "REVENUE " + resp.Currency, "COST", "MARGIN", "MARGIN %"}, rows)
+	return nil
+}
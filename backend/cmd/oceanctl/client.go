@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Plan creation waits on the provider and on spawning listeners
+var httpClient = &http.Client{Timeout: 3 * time.Minute}
+
+// apiError is a non-2xx response from the API
+type apiError struct {
+	Status int
+	Body   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("API returned %d: %s", e.Status, strings.TrimSpace(e.Body))
+}
+
+// call sends a request to the API and decodes the JSON response into out, or
+// stores the raw body if out is a *[]byte. A non-nil form is sent
+// url-encoded, as the API's POST handlers expect.
+func call(method, path string, form url.Values, header http.Header, out interface{}) error {
+	if form == nil {
+		return send(method, path, nil, header, out)
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return send(method, path, strings.NewReader(form.Encode()), header, out)
+}
+
+// send is call with a raw request body, whose Content-Type is set in header
+func send(method, path string, body io.Reader, header http.Header, out interface{}) error {
+	req, err := http.NewRequest(method, strings.TrimRight(apiURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+apiToken)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach API at %s: %w", apiURL, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &apiError{Status: resp.StatusCode, Body: string(data)}
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw = data
+		return nil
+	}
+	if out == nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("unexpected response from %s: %w", path, err)
+	}
+	return nil
+}
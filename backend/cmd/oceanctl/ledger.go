@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"oceanproxy-api/ledger"
+	"oceanproxy-api/orders"
+)
+
+func balanceShow(args []string) error {
+	customer, err := parseWithArg(newFlags("balance show"), args, "customer")
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Customer     string `json:"customer"`
+		BalanceCents int64  `json:"balance_cents"`
+		Currency     string `json:"currency"`
+	}
+	if err := call("GET", "/customers/"+url.PathEscape(customer)+"/balance", nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	fmt.Printf("💰 %s has %s %s\n", resp.Customer, orders.FormatAmount(resp.BalanceCents), resp.Currency)
+	return nil
+}
+
+func balanceDeposit(args []string) error {
+	fs := newFlags("balance deposit")
+	amount := fs.String("amount", "", "credit bought, e.g. 25.00")
+	reference := fs.String("reference", "", "payment reference")
+	memo := fs.String("memo", "", "note on the statement")
+	customer, err := parseWithArg(fs, args, "customer")
+	if err != nil {
+		return err
+	}
+	form := url.Values{"amount": {*amount}, "reference": {*reference}, "memo": {*memo}}
+	return transactionAction("/customers/"+url.PathEscape(customer)+"/deposits", form)
+}
+
+func balanceAdjust(args []string) error {
+	fs := newFlags("balance adjust")
+	amount := fs.String("amount", "", "change of the balance, negative to take credit away")
+	memo := fs.String("memo", "", "why the balance is adjusted (required)")
+	customer, err := parseWithArg(fs, args, "customer")
+	if err != nil {
+		return err
+	}
+	form := url.Values{"amount": {*amount}, "memo": {*memo}}
+	return transactionAction("/customers/"+url.PathEscape(customer)+"/adjustments", form)
+}
+
+func balanceRefund(args []string) error {
+	fs := newFlags("balance refund")
+	amount := fs.String("amount", "", "amount to give back, all that is left of the charge if empty")
+	memo := fs.String("memo", "", "note on the statement")
+	id, err := parseWithArg(fs, args, "charge ID")
+	if err != nil {
+		return err
+	}
+	form := url.Values{"amount": {*amount}, "memo": {*memo}}
+	return transactionAction("/ledger/"+url.PathEscape(id)+"/refund", form)
+}
+
+// transactionAction posts a transaction and prints it with the new balance
+func transactionAction(path string, form url.Values) error {
+	var resp struct {
+		Transaction  ledger.Transaction `json:"transaction"`
+		BalanceCents int64              `json:"balance_cents"`
+	}
+	if err := call("POST", path, form, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	t := resp.Transaction
+	printFields([][2]string{
+		{"Transaction", t.ID},
+		{"Kind", t.Kind},
+		{"Customer", t.Customer},
+		{"Amount", orders.FormatAmount(t.AmountCents) + " " + t.Currency},
+		{"Reference", orDash(t.Reference)},
+		{"Memo", orDash(t.Memo)},
+		{"Balance", orders.FormatAmount(resp.BalanceCents) + " " + t.Currency},
+	})
+	return nil
+}
+
+func balanceStatement(args []string) error {
+	fs := newFlags("balance statement")
+	since := fs.String("since", "", "transactions from (unix or RFC3339)")
+	until := fs.String("until", "", "transactions before (unix or RFC3339)")
+	customer, err := parseWithArg(fs, args, "customer")
+	if err != nil {
+		return err
+	}
+	q := url.Values{"since": {*since}, "until": {*until}}
+	var s ledger.Statement
+	if err := call("GET", "/customers/"+url.PathEscape(customer)+"/statement?"+q.Encode(), nil, nil, &s); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(s)
+	}
+	rows := [][]string{{"", "opening", "", "", "", orders.FormatAmount(s.OpeningCents)}}
+	for _, l := range s.Lines {
+		rows = append(rows, []string{
+			l.CreatedAt.Local().Format("2006-01-02 15:04"), l.Kind, l.ID, orDash(l.Reference),
+			orders.FormatAmount(l.AmountCents), orders.FormatAmount(l.BalanceCents),
+		})
+	}
+	printTable([]string{"DATE", "KIND", "TRANSACTION", "REFERENCE", "AMOUNT " + s.Currency, "BALANCE"}, rows)
+	return nil
+}
+
+func balanceAccounts(args []string) error {
+	_ = newFlags("balance accounts").Parse(args)
+	var resp struct {
+		Accounts   map[string]int64 `json:"accounts"`
+		TotalCents int64            `json:"total_cents"`
+		Currency   string           `json:"currency"`
+	}
+	if err := call("GET", "/ledger/accounts", nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	names := make([]string, 0, len(resp.Accounts))
+	for name := range resp.Accounts {
+		names = append(names, name)
+	}
+	// System accounts first, then customers
+	sort.Slice(names, func(i, j int) bool {
+		ci, cj := strings.HasPrefix(names[i], "customer:"), strings.HasPrefix(names[j], "customer:")
+		if ci != cj {
+			return cj
+		}
+		return names[i] < names[j]
+	})
+	var rows [][]string
+	for _, name := range names {
+		rows = append(rows, []string{name, orders.FormatAmount(resp.Accounts[name])})
+	}
+	rows = append(rows, []string{"total", orders.FormatAmount(resp.TotalCents)})
+	printTable([]string{"ACCOUNT", "DEBIT BALANCE " + resp.Currency}, rows)
+	return nil
+}
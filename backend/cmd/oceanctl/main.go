@@ -0,0 +1,330 @@
+// oceanctl is the operator command line for the OceanProxy API. It replaces the
+// curl snippets and the jq scripts that read proxies.json directly.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultAPIURL = "http://127.0.0.1:9090"
+
+var (
+	apiURL   string
+	apiToken string
+	output   string // table or json
+)
+
+const usage = `Usage: oceanctl [--api URL] [--token TOKEN] [-o table|json] <command> [args]
+
+Commands:
+  plans list [--all] [--subdomain S] [--local] [--raw]   list plans
+  plans show <plan-id>                                   show one plan
+  plans create --provider proxiesfo|nettify --type T --username U --password P [--from-balance] [...]
+  plans delete <plan-id> [--yes]                         stop listeners and remove the plan
+  plans extend <plan-id> --days N | --until DATE [--from-balance]
+                                                         move the local expiry, or buy the time
+  plans limits <plan-id> [--up B/s] [--down B/s] [--conn-rate N]  change rate limits, 0 removes
+  plans usage <plan-id> [--window 5m]                    limits, current rates and totals
+  plans tls <plan-id> [--off]                            hand out https:// proxy URLs, or stop
+  plans export <plan-id> | --customer C [--format F] [--out FILE]
+                                                         proxy list as url, hostport, userpass, csv,
+                                                         json, curl, clash or switchyomega
+  plans connections <plan-id> [--since T] [--client-ip IP] [--result R] [--limit N]
+                                                         recent connections through the plan
+  restore [--plan ID,...] [--node ID|local]              respawn listeners from the proxy log
+  backup [--out FILE]                                    archive plans, state and generated configs
+  restore-backup <archive> [--dry-run] [--force]         verify an archive, restore it and respawn
+  nodes list [--status]                                  edge nodes, their plans and free ports
+  nodes add <id> --url URL --token T [--domain D] [--regions R,...] [--insecure]
+                                                         register an edge agent
+  nodes remove <id> [--force]                            unregister an edge agent
+  webhooks list | add <url> [--events T,...] [--description D] [--secret S]
+                                                         webhook subscriptions, secret shown once
+  webhooks remove <id> | ping <id>                       unsubscribe, or send a webhook.ping
+  webhooks pending | dead [--retry ID]                   queued deliveries and dead letters
+  orders products | list [--customer C] [--status S]     what can be ordered, and orders
+  orders create <product> --quantity N [--customer C] [--threads N] [--tls]
+  orders show <id> | pay <id> [--ref R]                  order status, or mark it paid and fulfil it
+  orders cancel <id> | retry <id> [--checked]            cancel unpaid, or retry a failed order
+  payments list [--outcome O] [--order ID]               payment webhooks received and what they paid
+  payments simulate <order> --secret S [--processor stripe|heleket] [--amount A] [--id ID] [--pending]
+                                                         send a signed test payment for an order
+  balance show <customer> | statement <customer> [--since T] [--until T]
+                                                         prepaid balance, and its transactions
+  balance deposit <customer> --amount A [--reference R] [--memo M]
+  balance adjust <customer> --amount A --memo M          add credit, or correct it either way
+  balance refund <charge-id> [--amount A] [--memo M]     give back part or all of a charge
+  balance accounts                                       every ledger account, summing to zero
+  catalog [show] | set <product> --cost C --price P [--threads N] [--discounts Q:%,...]
+  catalog remove <product> [--threads N]                 prices per unit and volume discounts
+  quote <product> --quantity N [--threads N]             price, cost and margin of an order
+  margins [--by product|provider|customer|month] [--since T] [--until T]
+                                                         revenue, cost and margin of created plans
+  ports                                                  listening ports on the API host
+  health [--restart]                                     check every active listener
+  monitoring snapshot                                    system, plan and upstream stats
+
+The API URL and token are read from --api/--token, then OCEANCTL_API_URL and
+OCEANCTL_TOKEN (or BEARER_TOKEN), then ~/.config/oceanctl/config.yaml with
+api_url and token keys. The URL defaults to ` + defaultAPIURL + `.
+`
+
+func main() {
+	global := flag.NewFlagSet("oceanctl", flag.ExitOnError)
+	global.StringVar(&apiURL, "api", "", "API base URL")
+	global.StringVar(&apiToken, "token", "", "API bearer token")
+	global.StringVar(&output, "o", "table", "output format: table or json")
+	global.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	_ = global.Parse(os.Args[1:])
+
+	if err := loadSettings(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	args := global.Args()
+	if len(args) == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+	os.Exit(run(args))
+}
+
+func run(args []string) int {
+	var err error
+	switch args[0] {
+	case "plans":
+		if len(args) < 2 {
+			break
+		}
+		switch args[1] {
+		case "list":
+			err = plansList(args[2:])
+		case "show":
+			err = plansShow(args[2:])
+		case "create":
+			err = plansCreate(args[2:])
+		case "delete":
+			err = plansDelete(args[2:])
+		case "extend":
+			err = plansExtend(args[2:])
+		case "limits":
+			err = plansLimits(args[2:])
+		case "usage":
+			err = plansUsage(args[2:])
+		case "tls":
+			err = plansTLS(args[2:])
+		case "export":
+			err = plansExport(args[2:])
+		case "connections":
+			err = plansConnections(args[2:])
+		default:
+			return badUsage(args)
+		}
+		return exitCode(err)
+	case "restore":
+		return exitCode(restore(args[1:]))
+	case "backup":
+		return exitCode(backupCreate(args[1:]))
+	case "restore-backup":
+		return exitCode(restoreBackup(args[1:]))
+	case "nodes":
+		if len(args) < 2 {
+			break
+		}
+		switch args[1] {
+		case "list":
+			err = nodesList(args[2:])
+		case "add":
+			err = nodesAdd(args[2:])
+		case "remove":
+			err = nodesRemove(args[2:])
+		default:
+			return badUsage(args)
+		}
+		return exitCode(err)
+	case "webhooks":
+		if len(args) < 2 {
+			break
+		}
+		switch args[1] {
+		case "list":
+			err = webhooksList(args[2:])
+		case "add":
+			err = webhooksAdd(args[2:])
+		case "remove":
+			err = webhooksRemove(args[2:])
+		case "ping":
+			err = webhooksPing(args[2:])
+		case "pending":
+			err = webhooksDeliveries(args[2:], false)
+		case "dead":
+			err = webhooksDeliveries(args[2:], true)
+		default:
+			return badUsage(args)
+		}
+		return exitCode(err)
+	case "orders":
+		if len(args) < 2 {
+			break
+		}
+		switch args[1] {
+		case "products":
+			err = ordersProducts(args[2:])
+		case "list":
+			err = ordersList(args[2:])
+		case "create":
+			err = ordersCreate(args[2:])
+		case "show":
+			err = ordersShow(args[2:])
+		case "pay":
+			err = ordersPay(args[2:])
+		case "cancel", "retry":
+			err = ordersChange(args[2:], args[1])
+		default:
+			return badUsage(args)
+		}
+		return exitCode(err)
+	case "payments":
+		if len(args) < 2 {
+			break
+		}
+		switch args[1] {
+		case "list":
+			err = paymentsList(args[2:])
+		case "simulate":
+			err = paymentsSimulate(args[2:])
+		default:
+			return badUsage(args)
+		}
+		return exitCode(err)
+	case "balance":
+		if len(args) < 2 {
+			break
+		}
+		switch args[1] {
+		case "show":
+			err = balanceShow(args[2:])
+		case "statement":
+			err = balanceStatement(args[2:])
+		case "deposit":
+			err = balanceDeposit(args[2:])
+		case "adjust":
+			err = balanceAdjust(args[2:])
+		case "refund":
+			err = balanceRefund(args[2:])
+		case "accounts":
+			err = balanceAccounts(args[2:])
+		default:
+			return badUsage(args)
+		}
+		return exitCode(err)
+	case "catalog":
+		if len(args) < 2 || args[1] == "show" {
+			return exitCode(catalogShow(args[min(2, len(args)):]))
+		}
+		switch args[1] {
+		case "set":
+			err = catalogSet(args[2:])
+		case "remove":
+			err = catalogRemove(args[2:])
+		default:
+			return badUsage(args)
+		}
+		return exitCode(err)
+	case "quote":
+		return exitCode(quote(args[1:]))
+	case "margins":
+		return exitCode(margins(args[1:]))
+	case "ports":
+		return exitCode(ports(args[1:]))
+	case "health":
+		return exitCode(health(args[1:]))
+	case "monitoring":
+		if len(args) > 1 && args[1] == "snapshot" {
+			return exitCode(monitoringSnapshot(args[2:]))
+		}
+	}
+	return badUsage(args)
+}
+
+func badUsage(args []string) int {
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args, usage)
+	return 2
+}
+
+// errUnhealthy makes a command exit 1 after it already printed why
+var errUnhealthy = fmt.Errorf("unhealthy")
+
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case err == errUnhealthy:
+		return 1
+	default:
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+}
+
+// loadSettings fills in the API URL and token not given as flags
+func loadSettings() error {
+	var file struct {
+		APIURL string `yaml:"api_url"`
+		Token  string `yaml:"token"`
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		path := filepath.Join(home, ".config", "oceanctl", "config.yaml")
+		if data, err := os.ReadFile(path); err == nil {
+			if err := yaml.Unmarshal(data, &file); err != nil {
+				return fmt.Errorf("invalid %s: %w", path, err)
+			}
+		}
+	}
+
+	apiURL = firstNonEmpty(apiURL, os.Getenv("OCEANCTL_API_URL"), file.APIURL, defaultAPIURL)
+	apiToken = firstNonEmpty(apiToken, os.Getenv("OCEANCTL_TOKEN"), os.Getenv("BEARER_TOKEN"), file.Token)
+
+	if output != "table" && output != "json" {
+		return fmt.Errorf("unknown output format %q, use table or json", output)
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// newFlags returns a flag set for a subcommand that also accepts -o
+func newFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&output, "o", output, "output format: table or json")
+	return fs
+}
+
+// parseWithArg parses flags that may come before or after a single positional
+// argument, e.g. "show <id> -o json"
+func parseWithArg(fs *flag.FlagSet, args []string, argName string) (string, error) {
+	_ = fs.Parse(args)
+	rest := fs.Args()
+	if len(rest) == 0 {
+		return "", fmt.Errorf("%s: missing %s", fs.Name(), argName)
+	}
+	arg := rest[0]
+	_ = fs.Parse(rest[1:])
+	if fs.NArg() > 0 {
+		return "", fmt.Errorf("%s: unexpected arguments %v", fs.Name(), fs.Args())
+	}
+	return arg, nil
+}
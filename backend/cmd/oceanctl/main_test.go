@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// request is what the fake API saw
+type request struct {
+	Method string
+	Path   string
+	Form   map[string][]string
+	Auth   string
+}
+
+// fakeAPI points oceanctl at a server that records each request and answers
+// with reply, and returns the recorded requests
+func fakeAPI(t *testing.T, status int, reply interface{}) *[]request {
+	t.Helper()
+	var seen []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		seen = append(seen, request{Method: r.Method, Path: r.URL.Path, Form: r.PostForm, Auth: r.Header.Get("Authorization")})
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(reply)
+	}))
+	t.Cleanup(srv.Close)
+
+	oldURL, oldToken, oldOutput := apiURL, apiToken, output
+	apiURL, apiToken, output = srv.URL, "secret", "json"
+	t.Cleanup(func() { apiURL, apiToken, output = oldURL, oldToken, oldOutput })
+	return &seen
+}
+
+// quiet discards what a command prints
+func quiet(t *testing.T) {
+	t.Helper()
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = devnull, devnull
+	t.Cleanup(func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		devnull.Close()
+	})
+}
+
+func TestParseWithArg(t *testing.T) {
+	for _, tc := range []struct {
+		args    []string
+		want    string
+		days    int
+		wantErr bool
+	}{
+		{args: []string{"p1"}, want: "p1"},
+		{args: []string{"--days", "3", "p1"}, want: "p1", days: 3},
+		{args: []string{"p1", "--days", "3"}, want: "p1", days: 3},
+		{args: []string{"--days", "3"}, days: 3, wantErr: true},
+		{args: []string{"p1", "p2"}, wantErr: true},
+	} {
+		fs := newFlags("test")
+		days := fs.Int("days", 0, "")
+		got, err := parseWithArg(fs, tc.args, "plan ID")
+		if (err != nil) != tc.wantErr || got != tc.want || *days != tc.days {
+			t.Errorf("parseWithArg(%v) = %q, days %d, err %v", tc.args, got, *days, err)
+		}
+	}
+}
+
+func TestLoadSettings(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("OCEANCTL_API_URL", "")
+	t.Setenv("OCEANCTL_TOKEN", "")
+	t.Setenv("BEARER_TOKEN", "")
+	oldURL, oldToken, oldOutput := apiURL, apiToken, output
+	t.Cleanup(func() { apiURL, apiToken, output = oldURL, oldToken, oldOutput })
+
+	load := func() {
+		t.Helper()
+		apiURL, apiToken, output = "", "", "table"
+		if err := loadSettings(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	load()
+	if apiURL != defaultAPIURL || apiToken != "" {
+		t.Errorf("defaults: url %q token %q", apiURL, apiToken)
+	}
+
+	dir := filepath.Join(home, ".config", "oceanctl")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("api_url: http://file:1\ntoken: filetoken\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	load()
+	if apiURL != "http://file:1" || apiToken != "filetoken" {
+		t.Errorf("config file: url %q token %q", apiURL, apiToken)
+	}
+
+	t.Setenv("BEARER_TOKEN", "bearer")
+	t.Setenv("OCEANCTL_API_URL", "http://env:1")
+	load()
+	if apiURL != "http://env:1" || apiToken != "bearer" {
+		t.Errorf("environment: url %q token %q", apiURL, apiToken)
+	}
+
+	t.Setenv("OCEANCTL_TOKEN", "ctl")
+	load()
+	if apiToken != "ctl" {
+		t.Errorf("OCEANCTL_TOKEN should win over BEARER_TOKEN, got %q", apiToken)
+	}
+
+	apiURL, apiToken, output = "http://flag:1", "flag", "yaml"
+	if err := loadSettings(); err == nil {
+		t.Error("unknown output format accepted")
+	}
+	if apiURL != "http://flag:1" || apiToken != "flag" {
+		t.Errorf("flags: url %q token %q", apiURL, apiToken)
+	}
+}
+
+func TestCall(t *testing.T) {
+	seen := fakeAPI(t, http.StatusOK, map[string]string{"plan_id": "p1"})
+
+	var out struct {
+		PlanID string `json:"plan_id"`
+	}
+	if err := call("POST", "/plans/p1/tls", map[string][]string{"tls": {"true"}}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.PlanID != "p1" {
+		t.Errorf("decoded %+v", out)
+	}
+	got := (*seen)[0]
+	if got.Method != "POST" || got.Path != "/plans/p1/tls" || got.Auth != "Bearer secret" || got.Form["tls"][0] != "true" {
+		t.Errorf("request %+v", got)
+	}
+
+	var raw []byte
+	if err := call("GET", "/proxies", nil, nil, &raw); err != nil || !strings.Contains(string(raw), "p1") {
+		t.Errorf("raw body %q, err %v", raw, err)
+	}
+}
+
+func TestCallAPIError(t *testing.T) {
+	fakeAPI(t, http.StatusNotFound, "Plan not found")
+
+	err := call("GET", "/plans/nope", nil, nil, nil)
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound || !strings.Contains(apiErr.Error(), "Plan not found") {
+		t.Errorf("err = %v, want a 404 apiError", err)
+	}
+}
+
+func TestRunCommands(t *testing.T) {
+	quiet(t)
+	for _, tc := range []struct {
+		args   []string
+		method string
+		path   string
+		form   map[string]string
+	}{
+		{[]string{"plans", "delete", "p1", "--yes"}, "DELETE", "/plans/p1", nil},
+		{[]string{"plans", "extend", "p1", "--days", "7"}, "POST", "/plans/p1/extend", map[string]string{"days": "7"}},
+		{[]string{"plans", "limits", "p1", "--up", "1000", "--conn-rate", "0"}, "POST", "/plans/p1/limits", map[string]string{"limit_up": "1000", "limit_conn_rate": "0"}},
+		{[]string{"plans", "tls", "p1", "--off"}, "POST", "/plans/p1/tls", map[string]string{"tls": "false"}},
+		{[]string{"nodes", "remove", "edge1"}, "DELETE", "/nodes/edge1", nil},
+	} {
+		seen := fakeAPI(t, http.StatusOK, map[string]interface{}{})
+		if code := run(tc.args); code != 0 {
+			t.Errorf("%v exited %d", tc.args, code)
+			continue
+		}
+		if len(*seen) != 1 {
+			t.Errorf("%v sent %d requests, want 1", tc.args, len(*seen))
+			continue
+		}
+		got := (*seen)[0]
+		if got.Method != tc.method || got.Path != tc.path {
+			t.Errorf("%v sent %s %s, want %s %s", tc.args, got.Method, got.Path, tc.method, tc.path)
+		}
+		for k, v := range tc.form {
+			if len(got.Form[k]) == 0 || got.Form[k][0] != v {
+				t.Errorf("%v sent %s=%v, want %q", tc.args, k, got.Form[k], v)
+			}
+		}
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	quiet(t)
+	fakeAPI(t, http.StatusNotFound, "Plan not found")
+
+	if code := run([]string{"plans", "delete", "nope", "--yes"}); code != 1 {
+		t.Errorf("API error exited %d, want 1", code)
+	}
+	if code := run([]string{"plans", "extend", "p1"}); code != 1 {
+		t.Errorf("missing --days exited %d, want 1", code)
+	}
+	for _, args := range [][]string{{"frobnicate"}, {"plans"}, {"plans", "frobnicate"}} {
+		if code := run(args); code != 2 {
+			t.Errorf("%v exited %d, want 2", args, code)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"oceanproxy-api/nodes"
+)
+
+type nodeRow struct {
+	nodes.Node
+	Listeners int            `json:"listeners"`
+	FreePorts map[string]int `json:"free_ports"`
+	Reachable *bool          `json:"reachable"`
+	Running   *int           `json:"running"`
+	Error     string         `json:"error"`
+}
+
+func nodesList(args []string) error {
+	fs := newFlags("nodes list")
+	status := fs.Bool("status", false, "ask every agent what it is running")
+	_ = fs.Parse(args)
+
+	path := "/nodes"
+	if *status {
+		path += "?status=true"
+	}
+	var resp struct {
+		Role  string    `json:"role"`
+		Local int       `json:"local"`
+		Nodes []nodeRow `json:"nodes"`
+	}
+	if err := call("GET", path, nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+
+	fmt.Printf("Role: %s, %d listener(s) on the API host\n", resp.Role, resp.Local)
+	headers := []string{"ID", "URL", "DOMAIN", "REGIONS", "LISTENERS", "MIN FREE"}
+	if *status {
+		headers = append(headers, "STATUS")
+	}
+	var rows [][]string
+	for _, n := range resp.Nodes {
+		regions := "all"
+		if len(n.Regions) > 0 {
+			regions = strings.Join(n.Regions, ",")
+		}
+		row := []string{n.ID, n.URL, orDash(n.Domain), regions, strconv.Itoa(n.Listeners), minFree(n.FreePorts)}
+		if *status {
+			switch {
+			case n.Reachable != nil && *n.Reachable && n.Running != nil:
+				row = append(row, fmt.Sprintf("✅ %d running", *n.Running))
+			default:
+				row = append(row, "❌ "+n.Error)
+			}
+		}
+		rows = append(rows, row)
+	}
+	printTable(headers, rows)
+	return nil
+}
+
+// minFree shows the region with the fewest free ports, which limits placement
+func minFree(free map[string]int) string {
+	if len(free) == 0 {
+		return "-"
+	}
+	subs := make([]string, 0, len(free))
+	for sub := range free {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+	least := subs[0]
+	for _, sub := range subs[1:] {
+		if free[sub] < free[least] {
+			least = sub
+		}
+	}
+	return fmt.Sprintf("%d (%s)", free[least], least)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func nodesAdd(args []string) error {
+	fs := newFlags("nodes add")
+	agentURL := fs.String("url", "", "agent API base URL, e.g. https://fra1.example.com:9090")
+	token := fs.String("token", "", "the agent's AGENT_TOKEN")
+	domain := fs.String("domain", "", "public domain of the node's endpoints, default the API's DOMAIN")
+	regions := fs.String("regions", "", "comma separated subdomains it serves, all if empty")
+	noVerify := fs.Bool("no-verify", false, "register without contacting the agent")
+	insecure := fs.Bool("insecure", false, "allow an http:// URL, only for agents on a private network")
+	id, err := parseWithArg(fs, args, "node ID")
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{
+		"id":      {id},
+		"url":     {*agentURL},
+		"token":   {*token},
+		"domain":  {*domain},
+		"regions": {*regions},
+	}
+	if *noVerify {
+		form.Set("verify", "false")
+	}
+	if *insecure {
+		form.Set("insecure", "true")
+	}
+	var resp struct {
+		Node nodes.Node `json:"node"`
+	}
+	if err := call("POST", "/nodes", form, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	fmt.Printf("🛰️ Registered node %s at %s\n", resp.Node.ID, resp.Node.URL)
+	return nil
+}
+
+func nodesRemove(args []string) error {
+	fs := newFlags("nodes remove")
+	force := fs.Bool("force", false, "remove even if plans are still placed on it")
+	id, err := parseWithArg(fs, args, "node ID")
+	if err != nil {
+		return err
+	}
+
+	path := "/nodes/" + url.PathEscape(id)
+	if *force {
+		path += "?force=true"
+	}
+	var resp struct {
+		Orphaned int `json:"orphaned"`
+	}
+	if err := call("DELETE", path, nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	fmt.Printf("🗑️ Removed node %s\n", id)
+	if resp.Orphaned > 0 {
+		fmt.Printf("⚠️ %d listener(s) in the plan store still point at it\n", resp.Orphaned)
+	}
+	return nil
+}
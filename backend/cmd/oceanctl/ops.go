@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"oceanproxy-api/handlers"
+)
+
+func restore(args []string) error {
+	fs := newFlags("restore")
+	plans := fs.String("plan", "", "comma separated plan IDs to restore, all plans if empty")
+	node := fs.String("node", "", "only listeners on this edge node, local for the API host")
+	_ = fs.Parse(args)
+
+	path := "/restore"
+	q := url.Values{}
+	for _, id := range strings.Split(*plans, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			q.Add("plan_id", id)
+		}
+	}
+	if *node != "" {
+		q.Set("node", *node)
+	}
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	var resp struct {
+		Restored []string `json:"restored"`
+		Failed   []string `json:"failed"`
+	}
+	if err := call("POST", path, url.Values{}, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	fmt.Printf("✅ Restored %d listener(s)\n", len(resp.Restored))
+	for _, f := range resp.Failed {
+		fmt.Printf("❌ Failed: %s\n", f)
+	}
+	if len(resp.Failed) > 0 {
+		return errUnhealthy
+	}
+	return nil
+}
+
+type listeningPort struct {
+	Command string `json:"command"`
+	PID     string `json:"pid"`
+	User    string `json:"user"`
+	Port    string `json:"port"` // lsof NAME column, e.g. *:10000
+}
+
+func fetchPorts() ([]listeningPort, error) {
+	var resp struct {
+		Ports []listeningPort `json:"ports_in_use"`
+	}
+	if err := call("GET", "/ports", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Ports, nil
+}
+
+// portNumber extracts the port from an lsof address such as *:10000 or [::1]:9090
+func portNumber(addr string) int {
+	i := strings.LastIndex(addr, ":")
+	n, _ := strconv.Atoi(addr[i+1:])
+	return n
+}
+
+func ports(args []string) error {
+	_ = newFlags("ports").Parse(args)
+
+	list, err := fetchPorts()
+	if err != nil {
+		return err
+	}
+	sort.Slice(list, func(i, j int) bool { return portNumber(list[i].Port) < portNumber(list[j].Port) })
+	if output == "json" {
+		return printJSON(list)
+	}
+
+	rows := make([][]string, 0, len(list))
+	for _, p := range list {
+		rows = append(rows, []string{strconv.Itoa(portNumber(p.Port)), p.Command, p.PID, p.User, p.Port})
+	}
+	printTable([]string{"PORT", "COMMAND", "PID", "USER", "ADDRESS"}, rows)
+	return nil
+}
+
+type listenerHealth struct {
+	PlanID    string `json:"plan_id"`
+	Subdomain string `json:"subdomain"`
+	LocalPort int    `json:"local_port"`
+	Listening bool   `json:"listening"`
+	Restarted bool   `json:"restarted,omitempty"`
+}
+
+// health checks that the API is up and that every active plan has a listener,
+// optionally restoring the plans that do not
+func health(args []string) error {
+	fs := newFlags("health")
+	restart := fs.Bool("restart", false, "restore plans whose listeners are down")
+	quiet := fs.Bool("q", false, "only show unhealthy listeners")
+	_ = fs.Parse(args)
+
+	var api struct {
+		Status string `json:"status"`
+	}
+	if err := call("GET", "/health", nil, nil, &api); err != nil {
+		return err
+	}
+
+	checks, err := checkListeners()
+	if err != nil {
+		return err
+	}
+
+	if *restart {
+		down := make(map[string]bool)
+		for _, c := range checks {
+			if !c.Listening {
+				down[c.PlanID] = true
+			}
+		}
+		if len(down) > 0 {
+			q := url.Values{}
+			for id := range down {
+				q.Add("plan_id", id)
+			}
+			if err := call("POST", "/restore?"+q.Encode(), url.Values{}, nil, nil); err != nil {
+				return fmt.Errorf("restore failed: %w", err)
+			}
+			time.Sleep(2 * time.Second)
+
+			after, err := checkListeners()
+			if err != nil {
+				return err
+			}
+			for i := range after {
+				after[i].Restarted = down[after[i].PlanID] && after[i].Listening
+			}
+			checks = after
+		}
+	}
+
+	unhealthy := 0
+	for _, c := range checks {
+		if !c.Listening {
+			unhealthy++
+		}
+	}
+
+	if output == "json" {
+		if err := printJSON(map[string]interface{}{"api": api.Status, "listeners": checks, "unhealthy": unhealthy}); err != nil {
+			return err
+		}
+	} else {
+		var rows [][]string
+		for _, c := range checks {
+			if *quiet && c.Listening {
+				continue
+			}
+			status := "✅ listening"
+			switch {
+			case c.Restarted:
+				status = "🔄 restarted"
+			case !c.Listening:
+				status = "❌ down"
+			}
+			rows = append(rows, []string{c.PlanID, c.Subdomain, strconv.Itoa(c.LocalPort), status})
+		}
+		fmt.Printf("API: %s\n\n", api.Status)
+		if len(rows) > 0 {
+			printTable([]string{"PLAN ID", "SUBDOMAIN", "LOCAL PORT", "STATUS"}, rows)
+			fmt.Println()
+		}
+		fmt.Printf("%d listener(s), %d down\n", len(checks), unhealthy)
+		if unhealthy > 0 && !*restart {
+			fmt.Println("💡 Run with --restart to restore them")
+		}
+	}
+
+	if unhealthy > 0 {
+		return errUnhealthy
+	}
+	return nil
+}
+
+func checkListeners() ([]listenerHealth, error) {
+	entries, err := fetchPlans()
+	if err != nil {
+		return nil, err
+	}
+	list, err := fetchPorts()
+	if err != nil {
+		return nil, err
+	}
+	listening := make(map[int]bool)
+	for _, p := range list {
+		listening[portNumber(p.Port)] = true
+	}
+
+	now := time.Now().Unix()
+	var checks []listenerHealth
+	for _, e := range entries {
+		// Listeners on edge nodes are checked by nodes list --status
+		if !isActive(e, now) || e.Node != "" {
+			continue
+		}
+		checks = append(checks, listenerHealth{
+			PlanID:    e.PlanID,
+			Subdomain: e.Subdomain,
+			LocalPort: e.LocalPort,
+			Listening: listening[e.LocalPort],
+		})
+	}
+	return checks, nil
+}
+
+func monitoringSnapshot(args []string) error {
+	_ = newFlags("monitoring snapshot").Parse(args)
+
+	var data handlers.MonitoringData
+	if err := call("GET", "/monitoring/api", nil, nil, &data); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(data)
+	}
+
+	s := data.System
+	fmt.Println("System")
+	printFields([][2]string{
+		{"  CPU", fmt.Sprintf("%.1f%% of %d cores", s.CPUUsage, s.CPUCores)},
+		{"  Memory", fmt.Sprintf("%s / %s (%.1f%%)", formatBytes(int64(s.MemoryUsed)), formatBytes(int64(s.MemoryTotal)), s.MemoryPercent)},
+		{"  Disk", fmt.Sprintf("%s / %s (%.1f%%)", formatBytes(int64(s.DiskUsed)), formatBytes(int64(s.DiskTotal)), s.DiskPercent)},
+		{"  Load", s.LoadAverage},
+		{"  API uptime", (time.Duration(s.UptimeSeconds) * time.Second).String()},
+	})
+
+	p := data.Proxies
+	fmt.Printf("\nPlans: %d entries, %d active, %d expired\n\n", p.TotalPlans, p.ActiveProxies, p.ExpiredProxies)
+	var subs []string
+	for sub := range p.PortUsage {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+	var rows [][]string
+	for _, sub := range subs {
+		u := p.PortUsage[sub]
+		rows = append(rows, []string{sub, strconv.Itoa(p.ProxiesByType[sub]), fmt.Sprintf("%d/%d", u.Used, u.Total), fmt.Sprintf("%.1f%%", u.Percentage)})
+	}
+	printTable([]string{"SUBDOMAIN", "PLANS", "PORTS USED", "USAGE"}, rows)
+
+	if len(data.Upstreams) > 0 {
+		fmt.Println()
+		rows = rows[:0]
+		for _, u := range data.Upstreams {
+			last := "✅"
+			if !u.LastOK {
+				last = "❌ " + u.LastError
+			}
+			rows = append(rows, []string{
+				u.Upstream,
+				fmt.Sprintf("%.1f%%", u.SuccessRate*100),
+				fmt.Sprintf("%.0fms", u.LatencyP50),
+				fmt.Sprintf("%.0fms", u.LatencyP99),
+				last,
+			})
+		}
+		printTable([]string{"UPSTREAM", "SUCCESS", "P50", "P99", "LAST PROBE"}, rows)
+	}
+
+	rows = rows[:0]
+	for _, c := range p.Connections {
+		if c.Connections == 0 || len(rows) == 10 {
+			continue
+		}
+		limit := ""
+		if c.AtLimit {
+			limit = "⛔ at limit"
+		}
+		rows = append(rows, []string{c.PlanID, c.Subdomain, c.Username, fmt.Sprintf("%d/%d", c.Connections, c.MaxConn), limit})
+	}
+	if len(rows) > 0 {
+		fmt.Println()
+		printTable([]string{"PLAN ID", "SUBDOMAIN", "USERNAME", "CONNECTIONS", ""}, rows)
+	}
+	return nil
+}
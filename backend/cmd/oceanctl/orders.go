@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"oceanproxy-api/handlers"
+	"oceanproxy-api/orders"
+)
+
+func ordersList(args []string) error {
+	fs := newFlags("orders list")
+	customer := fs.String("customer", "", "only this customer's orders")
+	status := fs.String("status", "", "pending, paid, fulfilling, fulfilled, failed or cancelled")
+	_ = fs.Parse(args)
+
+	q := url.Values{}
+	if *customer != "" {
+		q.Set("customer", *customer)
+	}
+	if *status != "" {
+		q.Set("status", *status)
+	}
+	var resp struct {
+		Orders []handlers.OrderView `json:"orders"`
+	}
+	if err := call("GET", "/orders?"+q.Encode(), nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	var rows [][]string
+	for _, o := range resp.Orders {
+		rows = append(rows, []string{
+			o.ID, o.Status, o.Product, fmt.Sprintf("%d %s", o.Quantity, o.Unit),
+			orders.FormatAmount(o.Quote.TotalCents), orDash(o.Customer), orDash(o.PlanID), time.Unix(o.CreatedAt, 0).Format("2006-01-02 15:04"),
+		})
+	}
+	printTable([]string{"ID", "STATUS", "PRODUCT", "QUANTITY", "TOTAL", "CUSTOMER", "PLAN", "CREATED"}, rows)
+	return nil
+}
+
+func ordersProducts(args []string) error {
+	_ = newFlags("orders products").Parse(args)
+	var resp struct {
+		Products []orders.Product `json:"products"`
+	}
+	if err := call("GET", "/orders/products", nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	var rows [][]string
+	for _, p := range resp.Products {
+		rows = append(rows, []string{p.Name, p.Provider, p.Unit})
+	}
+	printTable([]string{"PRODUCT", "PROVIDER", "UNIT"}, rows)
+	return nil
+}
+
+func ordersCreate(args []string) error {
+	fs := newFlags("orders create")
+	quantity := fs.Int("quantity", 0, "GB, days or hours, see orders products")
+	customer := fs.String("customer", "", "customer reference")
+	threads := fs.Int("threads", 0, "concurrent connections (proxiesfo/datacenter)")
+	useTLS := fs.Bool("tls", false, "hand out https:// proxy URLs")
+	product, err := parseWithArg(fs, args, "product")
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{"product": {product}, "quantity": {strconv.Itoa(*quantity)}, "customer": {*customer}}
+	if *threads > 0 {
+		form.Set("threads", strconv.Itoa(*threads))
+	}
+	if *useTLS {
+		form.Set("tls", "true")
+	}
+	return orderAction("POST", "/orders", form)
+}
+
+func ordersShow(args []string) error {
+	id, err := parseWithArg(newFlags("orders show"), args, "order ID")
+	if err != nil {
+		return err
+	}
+	return orderAction("GET", "/orders/"+url.PathEscape(id), nil)
+}
+
+func ordersPay(args []string) error {
+	fs := newFlags("orders pay")
+	ref := fs.String("ref", "", "payment reference")
+	id, err := parseWithArg(fs, args, "order ID")
+	if err != nil {
+		return err
+	}
+	return orderAction("POST", "/orders/"+url.PathEscape(id)+"/paid", url.Values{"payment_ref": {*ref}})
+}
+
+// ordersChange cancels or retries an order
+func ordersChange(args []string, action string) error {
+	fs := newFlags("orders " + action)
+	var checked *bool
+	if action == "retry" {
+		checked = fs.Bool("checked", false, "the provider was checked and has no plan for the order")
+	}
+	id, err := parseWithArg(fs, args, "order ID")
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	if checked != nil && *checked {
+		form.Set("checked", "true")
+	}
+	return orderAction("POST", "/orders/"+url.PathEscape(id)+"/"+action, form)
+}
+
+// orderAction makes the call and prints the order it answers with
+func orderAction(method, path string, form url.Values) error {
+	var resp struct {
+		Order handlers.OrderView `json:"order"`
+	}
+	if err := call(method, path, form, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	o := resp.Order
+	printFields([][2]string{
+		{"Order", o.ID},
+		{"Status", o.Status},
+		{"Product", o.Product},
+		{"Quantity", fmt.Sprintf("%d %s", o.Quantity, o.Unit)},
+		{"Total", orders.FormatAmount(o.Quote.TotalCents) + " " + o.Quote.Currency},
+		{"Customer", orDash(o.Customer)},
+		{"Payment", orDash(o.PaymentRef)},
+		{"Plan", orDash(o.PlanID)},
+		{"Username", orDash(o.Username)},
+		{"Password", orDash(o.Password)},
+		{"Error", orDash(o.Error)},
+		{"Check provider", strconv.FormatBool(o.CheckProvider)},
+	})
+	if len(o.Proxies) > 0 {
+		fmt.Println()
+		fmt.Println(strings.Join(o.Proxies, "\n"))
+	}
+	return nil
+}
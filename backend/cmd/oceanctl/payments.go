@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"time"
+
+	"oceanproxy-api/handlers"
+	"oceanproxy-api/orders"
+	"oceanproxy-api/payments"
+)
+
+func paymentsList(args []string) error {
+	fs := newFlags("payments list")
+	outcome := fs.String("outcome", "", "matched, ignored, unmatched, mismatch, duplicate or rejected")
+	order := fs.String("order", "", "only payments of this order")
+	_ = fs.Parse(args)
+
+	q := url.Values{}
+	if *outcome != "" {
+		q.Set("outcome", *outcome)
+	}
+	if *order != "" {
+		q.Set("order", *order)
+	}
+	var resp struct {
+		Payments   []payments.Record `json:"payments"`
+		Processors map[string]bool   `json:"processors"`
+	}
+	if err := call("GET", "/payments?"+q.Encode(), nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	var rows [][]string
+	for _, p := range resp.Payments {
+		rows = append(rows, []string{
+			p.ReceivedAt.Local().Format("2006-01-02 15:04"), p.Processor, p.ID, orDash(p.OrderID),
+			orders.FormatAmount(p.AmountCents) + " " + p.Currency, p.Outcome, orDash(p.Detail),
+		})
+	}
+	printTable([]string{"RECEIVED", "PROCESSOR", "PAYMENT", "ORDER", "AMOUNT", "OUTCOME", "DETAIL"}, rows)
+	return nil
+}
+
+// paymentsSimulate stands in for a processor: it signs a callback paying an
+// order with the processor's secret and posts it to the webhook endpoint
+func paymentsSimulate(args []string) error {
+	fs := newFlags("payments simulate")
+	processor := fs.String("processor", "stripe", "stripe or heleket")
+	secret := fs.String("secret", "", "the processor's webhook secret or API key configured on the API")
+	amount := fs.String("amount", "", "amount paid, the order total if empty")
+	id := fs.String("id", "", "payment ID, generated if empty")
+	pending := fs.Bool("pending", false, "report the payment as not completed yet")
+	orderID, err := parseWithArg(fs, args, "order ID")
+	if err != nil {
+		return err
+	}
+	if *secret == "" {
+		return fmt.Errorf("payments simulate: --secret is required")
+	}
+
+	var order struct {
+		Order handlers.OrderView `json:"order"`
+	}
+	if err := call("GET", "/orders/"+url.PathEscape(orderID), nil, nil, &order); err != nil {
+		return err
+	}
+	p := payments.Payment{
+		ID:          *id,
+		OrderID:     orderID,
+		AmountCents: order.Order.Quote.TotalCents,
+		Currency:    order.Order.Quote.Currency,
+		Completed:   !*pending,
+	}
+	if p.ID == "" {
+		p.ID = fmt.Sprintf("sim_%d", time.Now().UnixNano())
+	}
+	if *amount != "" {
+		if p.AmountCents, err = orders.ParseAmount(*amount); err != nil {
+			return err
+		}
+	}
+	header, body, err := payments.Callback(*processor, *secret, p)
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Payment payments.Record `json:"payment"`
+	}
+	if err := send("POST", "/payments/webhook/"+url.PathEscape(*processor), bytes.NewReader(body), header, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	r := resp.Payment
+	printFields([][2]string{
+		{"Payment", r.Processor + ":" + r.ID},
+		{"Order", r.OrderID},
+		{"Amount", orders.FormatAmount(r.AmountCents) + " " + r.Currency},
+		{"Outcome", r.Outcome},
+		{"Detail", orDash(r.Detail)},
+		{"Deliveries", fmt.Sprint(r.Deliveries)},
+	})
+	return nil
+}
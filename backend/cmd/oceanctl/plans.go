@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"oceanproxy-api/accesslog"
+	"oceanproxy-api/handlers"
+	"oceanproxy-api/ledger"
+	"oceanproxy-api/orders"
+	"oceanproxy-api/proxy"
+)
+
+// fetchPlans returns every entry in the proxy log, sorted by plan and subdomain
+func fetchPlans() ([]proxy.Entry, error) {
+	var entries []proxy.Entry
+	if err := call("GET", "/proxies", nil, nil, &entries); err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].PlanID != entries[j].PlanID {
+			return entries[i].PlanID < entries[j].PlanID
+		}
+		return entries[i].Subdomain < entries[j].Subdomain
+	})
+	return entries, nil
+}
+
+func isActive(e proxy.Entry, now int64) bool {
+	return e.ExpiresAt == 0 || e.ExpiresAt >= now
+}
+
+func endpoint(e proxy.Entry, local bool) (string, int) {
+	if local {
+		return "127.0.0.1", e.LocalPort
+	}
+	return e.LocalHost, e.PublicPort
+}
+
+func plansList(args []string) error {
+	fs := newFlags("plans list")
+	all := fs.Bool("all", false, "include expired plans")
+	subdomain := fs.String("subdomain", "", "only plans on this subdomain")
+	local := fs.Bool("local", false, "show local 127.0.0.1 endpoints instead of public ones")
+	raw := fs.Bool("raw", false, "print host:port:user:pass lines only")
+	_ = fs.Parse(args)
+
+	entries, err := fetchPlans()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	var shown []proxy.Entry
+	for _, e := range entries {
+		if e.PlanID == "" || e.Username == "" {
+			continue
+		}
+		if (!*all && !isActive(e, now)) || (*subdomain != "" && e.Subdomain != *subdomain) {
+			continue
+		}
+		shown = append(shown, e)
+	}
+
+	if *raw {
+		for _, e := range shown {
+			host, port := endpoint(e, *local)
+			fmt.Printf("%s:%d:%s:%s\n", host, port, e.Username, e.Password)
+		}
+		return nil
+	}
+	if output == "json" {
+		return printJSON(shown)
+	}
+
+	rows := make([][]string, 0, len(shown))
+	for _, e := range shown {
+		host, port := endpoint(e, *local)
+		rows = append(rows, []string{
+			e.PlanID, e.Subdomain, fmt.Sprintf("%s:%d", host, port), e.Username, e.Password, formatExpiry(e.ExpiresAt), e.Product,
+		})
+	}
+	printTable([]string{"PLAN ID", "SUBDOMAIN", "ENDPOINT", "USERNAME", "PASSWORD", "EXPIRES", "PRODUCT"}, rows)
+	fmt.Printf("\n%d listener(s)\n", len(shown))
+	return nil
+}
+
+func plansShow(args []string) error {
+	fs := newFlags("plans show")
+	planID, err := parseWithArg(fs, args, "plan ID")
+	if err != nil {
+		return err
+	}
+
+	var plan handlers.Plan
+	if err := call("GET", "/plans/"+url.PathEscape(planID), nil, nil, &plan); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(plan)
+	}
+
+	printFields([][2]string{
+		{"Plan ID", plan.PlanID},
+		{"Status", plan.Status},
+		{"Username", plan.Username},
+		{"Password", plan.Password},
+		{"Provider", plan.Provider},
+		{"Product", plan.Product},
+		{"Customer", plan.Customer},
+		{"Created", time.Unix(plan.CreatedAt, 0).Format("2006-01-02 15:04")},
+		{"Expires", formatExpiry(plan.ExpiresAt)},
+		{"Quota", formatBytes(plan.QuotaBytes)},
+		{"Max connections", strconv.Itoa(plan.MaxConn)},
+		{"TLS", strconv.FormatBool(plan.TLS)},
+	})
+	fmt.Println()
+
+	rows := make([][]string, 0, len(plan.Endpoints))
+	for _, ep := range plan.Endpoints {
+		tlsPort := "-"
+		if ep.TLSPort != 0 {
+			tlsPort = strconv.Itoa(ep.TLSPort)
+		}
+		rows = append(rows, []string{ep.Subdomain, fmt.Sprintf("%s:%d", ep.Host, ep.Port), tlsPort, strconv.Itoa(ep.LocalPort), ep.Upstream})
+	}
+	printTable([]string{"SUBDOMAIN", "PUBLIC ENDPOINT", "TLS PORT", "LOCAL PORT", "UPSTREAM"}, rows)
+	return nil
+}
+
+func plansCreate(args []string) error {
+	fs := newFlags("plans create")
+	provider := fs.String("provider", "proxiesfo", "proxiesfo or nettify")
+	planType := fs.String("type", "", "proxies.fo: residential, isp, datacenter; Nettify: residential, datacenter, mobile, unlimited")
+	username := fs.String("username", "", "plan username")
+	password := fs.String("password", "", "plan password")
+	bandwidth := fs.String("bandwidth", "", "bandwidth in GB")
+	duration := fs.String("duration", "", "proxies.fo duration in days")
+	threads := fs.String("threads", "", "proxies.fo datacenter threads")
+	hours := fs.String("hours", "", "Nettify unlimited plan hours")
+	customer := fs.String("customer", "", "customer reference stored on the plan")
+	limitUp := fs.String("limit-up", "", "upload limit in bytes per second")
+	limitDown := fs.String("limit-down", "", "download limit in bytes per second")
+	connRate := fs.String("conn-rate", "", "new connections per second")
+	useTLS := fs.Bool("tls", false, "hand out https:// proxy URLs on the regions' TLS endpoints")
+	fromBalance := fs.Bool("from-balance", false, "charge the customer's prepaid balance")
+	key := fs.String("idempotency-key", "", "Idempotency-Key header, random by default so a retry with the same key is safe")
+	_ = fs.Parse(args)
+
+	if *planType == "" || *username == "" || *password == "" {
+		return fmt.Errorf("plans create: --type, --username and --password are required")
+	}
+
+	form := url.Values{}
+	form.Set("username", *username)
+	form.Set("password", *password)
+	for name, v := range map[string]string{"bandwidth": *bandwidth, "duration": *duration, "threads": *threads, "hours": *hours, "customer": *customer,
+		"limit_up": *limitUp, "limit_down": *limitDown, "limit_conn_rate": *connRate,
+	} {
+		if v != "" {
+			form.Set(name, v)
+		}
+	}
+
+	if *useTLS {
+		form.Set("tls", "true")
+	}
+	if *fromBalance {
+		form.Set("from_balance", "true")
+	}
+
+	var path string
+	switch *provider {
+	case "proxiesfo":
+		path = "/plan"
+		form.Set("reseller", *planType)
+	case "nettify":
+		path = "/nettify/plan"
+		form.Set("plan_type", *planType)
+	default:
+		return fmt.Errorf("plans create: unknown provider %q", *provider)
+	}
+
+	if *key == "" {
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		*key = hex.EncodeToString(b)
+	}
+
+	var resp struct {
+		PlanID    string   `json:"plan_id"`
+		Username  string   `json:"username"`
+		Password  string   `json:"password"`
+		ExpiresAt int64    `json:"expires_at"`
+		Proxies   []string `json:"proxies"`
+	}
+	if err := call("POST", path, form, http.Header{"Idempotency-Key": {*key}}, &resp); err != nil {
+		return fmt.Errorf("%w (retry with --idempotency-key %s to avoid buying twice)", err, *key)
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+
+	printFields([][2]string{
+		{"Plan ID", resp.PlanID},
+		{"Username", resp.Username},
+		{"Password", resp.Password},
+		{"Expires", formatExpiry(resp.ExpiresAt)},
+	})
+	for _, p := range resp.Proxies {
+		fmt.Println("  " + p)
+	}
+	return nil
+}
+
+func plansDelete(args []string) error {
+	fs := newFlags("plans delete")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	planID, err := parseWithArg(fs, args, "plan ID")
+	if err != nil {
+		return err
+	}
+
+	if !*yes {
+		fmt.Printf("Stop every listener of plan %s and remove it? The provider plan is not cancelled. [y/N] ", planID)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	var resp struct {
+		PlanID  string   `json:"plan_id"`
+		Removed []string `json:"removed"`
+	}
+	if err := call("DELETE", "/plans/"+url.PathEscape(planID), nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	fmt.Printf("🗑️ Deleted plan %s (%s)\n", resp.PlanID, strings.Join(resp.Removed, ", "))
+	return nil
+}
+
+func plansExtend(args []string) error {
+	fs := newFlags("plans extend")
+	days := fs.Int("days", 0, "days to add to the current expiry")
+	until := fs.String("until", "", "new expiry as YYYY-MM-DD, RFC3339 or unix time")
+	fromBalance := fs.Bool("from-balance", false, "buy the added time from the provider and charge the plan customer's prepaid balance")
+	planID, err := parseWithArg(fs, args, "plan ID")
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	switch {
+	case *until != "":
+		t, err := parseTime(*until)
+		if err != nil {
+			return err
+		}
+		form.Set("expires_at", strconv.FormatInt(t.Unix(), 10))
+	case *days > 0:
+		form.Set("days", strconv.Itoa(*days))
+	default:
+		return fmt.Errorf("plans extend: --days or --until is required")
+	}
+	if *fromBalance {
+		form.Set("from_balance", "true")
+	}
+
+	var resp struct {
+		PlanID    string              `json:"plan_id"`
+		ExpiresAt int64               `json:"expires_at"`
+		Restarted []string            `json:"restarted"`
+		Charge    *ledger.Transaction `json:"charge"`
+	}
+	if err := call("POST", "/plans/"+url.PathEscape(planID)+"/extend", form, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	fmt.Printf("📅 Plan %s now expires %s\n", resp.PlanID, formatExpiry(resp.ExpiresAt))
+	if resp.Charge != nil {
+		fmt.Printf("💳 Charged %s %s to %s (%s)\n", orders.FormatAmount(-resp.Charge.AmountCents), resp.Charge.Currency, resp.Charge.Customer, resp.Charge.ID)
+	}
+	if len(resp.Restarted) > 0 {
+		fmt.Printf("🔄 Restarted listeners: %s\n", strings.Join(resp.Restarted, ", "))
+	}
+	return nil
+}
+
+func plansLimits(args []string) error {
+	fs := newFlags("plans limits")
+	up := fs.String("up", "", "upload limit in bytes per second, 0 for unlimited")
+	down := fs.String("down", "", "download limit in bytes per second, 0 for unlimited")
+	connRate := fs.String("conn-rate", "", "new connections per second, 0 for unlimited")
+	planID, err := parseWithArg(fs, args, "plan ID")
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	for name, v := range map[string]string{"limit_up": *up, "limit_down": *down, "limit_conn_rate": *connRate} {
+		if v != "" {
+			form.Set(name, v)
+		}
+	}
+	if len(form) == 0 {
+		return fmt.Errorf("plans limits: --up, --down or --conn-rate is required")
+	}
+
+	var resp struct {
+		PlanID     string           `json:"plan_id"`
+		Limits     proxy.RateLimits `json:"limits"`
+		Restarting int              `json:"restarting"`
+	}
+	if err := call("POST", "/plans/"+url.PathEscape(planID)+"/limits", form, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	fmt.Printf("🚦 Plan %s limits: %s, restarting %d listener(s)\n", resp.PlanID, formatLimits(resp.Limits), resp.Restarting)
+	return nil
+}
+
+func plansUsage(args []string) error {
+	fs := newFlags("plans usage")
+	window := fs.String("window", "1m", "window for current rates")
+	planID, err := parseWithArg(fs, args, "plan ID")
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		PlanID  string           `json:"plan_id"`
+		Limits  proxy.RateLimits `json:"limits"`
+		MaxConn int              `json:"max_conn"`
+		Current struct {
+			Window          string  `json:"window"`
+			UpBytesPerSec   float64 `json:"up_bytes_per_sec"`
+			DownBytesPerSec float64 `json:"down_bytes_per_sec"`
+			ConnsPerSec     float64 `json:"conns_per_sec"`
+			OpenConnections int     `json:"open_connections"`
+		} `json:"current"`
+		Total      accesslog.Summary `json:"total"`
+		QuotaBytes int64             `json:"quota_bytes"`
+	}
+	if err := call("GET", "/plans/"+url.PathEscape(planID)+"/usage?window="+url.QueryEscape(*window), nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+
+	c := resp.Current
+	printFields([][2]string{
+		{"Plan ID", resp.PlanID},
+		{"Limits", formatLimits(resp.Limits)},
+		{"Connections", fmt.Sprintf("%d open of %d", c.OpenConnections, resp.MaxConn)},
+		{"Rates (" + c.Window + ")", fmt.Sprintf("up %s/s, down %s/s, %.2f conn/s",
+			formatBytes(int64(c.UpBytesPerSec)), formatBytes(int64(c.DownBytesPerSec)), c.ConnsPerSec)},
+		{"Total", fmt.Sprintf("%d connections, up %s, down %s",
+			resp.Total.Connections, formatBytes(resp.Total.BytesOut), formatBytes(resp.Total.BytesIn))},
+		{"Quota", formatBytes(resp.QuotaBytes)},
+	})
+	return nil
+}
+
+func plansTLS(args []string) error {
+	fs := newFlags("plans tls")
+	off := fs.Bool("off", false, "go back to http:// proxy URLs")
+	planID, err := parseWithArg(fs, args, "plan ID")
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{"tls": {strconv.FormatBool(!*off)}}
+	var resp struct {
+		PlanID  string   `json:"plan_id"`
+		TLS     bool     `json:"tls"`
+		Proxies []string `json:"proxies"`
+	}
+	if err := call("POST", "/plans/"+url.PathEscape(planID)+"/tls", form, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	fmt.Printf("🔒 Plan %s TLS: %t\n", resp.PlanID, resp.TLS)
+	for _, p := range resp.Proxies {
+		fmt.Println("  " + p)
+	}
+	return nil
+}
+
+func plansExport(args []string) error {
+	fs := newFlags("plans export")
+	format := fs.String("format", "url", "url, hostport, userpass, csv, json, curl, clash or switchyomega")
+	customer := fs.String("customer", "", "export every active plan of this customer instead of one plan")
+	all := fs.Bool("all", false, "with --customer, include expired plans")
+	out := fs.String("out", "", "write to this file instead of stdout")
+
+	var path string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		planID, err := parseWithArg(fs, args, "plan ID")
+		if err != nil {
+			return err
+		}
+		path = "/plans/" + url.PathEscape(planID) + "/export"
+	} else {
+		_ = fs.Parse(args)
+		if *customer == "" {
+			return fmt.Errorf("plans export: a plan ID or --customer is required")
+		}
+		path = "/customers/" + url.PathEscape(*customer) + "/export"
+	}
+
+	q := url.Values{"format": {*format}}
+	if *all {
+		q.Set("status", "all")
+	}
+	var body []byte
+	if err := call("GET", path+"?"+q.Encode(), nil, nil, &body); err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err := os.Stdout.Write(body)
+		return err
+	}
+	if err := os.WriteFile(*out, body, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("📦 Wrote %s\n", *out)
+	return nil
+}
+
+func formatLimits(l proxy.RateLimits) string {
+	rate := func(n int64) string {
+		if n == 0 {
+			return "unlimited"
+		}
+		return formatBytes(n) + "/s"
+	}
+	conns := "unlimited"
+	if l.ConnsPerSec > 0 {
+		conns = strconv.Itoa(l.ConnsPerSec)
+	}
+	return fmt.Sprintf("up %s, down %s, %s new conn/s", rate(l.UpBytesPerSec), rate(l.DownBytesPerSec), conns)
+}
+
+func plansConnections(args []string) error {
+	fs := newFlags("plans connections")
+	since := fs.String("since", "", "start as YYYY-MM-DD, RFC3339 or unix time (default 24 hours ago)")
+	clientIP := fs.String("client-ip", "", "only connections from this client IP")
+	target := fs.String("target", "", "only targets containing this text")
+	result := fs.String("result", "", "ok, denied, aborted or failed")
+	limit := fs.Int("limit", 100, "maximum number of connections")
+	planID, err := parseWithArg(fs, args, "plan ID")
+	if err != nil {
+		return err
+	}
+
+	q := url.Values{"limit": {strconv.Itoa(*limit)}}
+	if *since != "" {
+		t, err := parseTime(*since)
+		if err != nil {
+			return err
+		}
+		q.Set("since", strconv.FormatInt(t.Unix(), 10))
+	}
+	for name, v := range map[string]string{"client_ip": *clientIP, "target": *target, "result": *result} {
+		if v != "" {
+			q.Set(name, v)
+		}
+	}
+
+	var resp struct {
+		Connections []accesslog.Record `json:"connections"`
+		NextUntil   string             `json:"next_until"`
+	}
+	if err := call("GET", "/plans/"+url.PathEscape(planID)+"/connections?"+q.Encode(), nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+
+	rows := make([][]string, 0, len(resp.Connections))
+	for _, c := range resp.Connections {
+		rows = append(rows, []string{
+			c.Time.Local().Format("2006-01-02 15:04:05"), c.ClientIP, c.Target,
+			formatBytes(c.BytesIn), formatBytes(c.BytesOut), fmt.Sprintf("%dms", c.DurationMs), c.Result,
+		})
+	}
+	printTable([]string{"TIME", "CLIENT", "TARGET", "IN", "OUT", "DURATION", "RESULT"}, rows)
+	if resp.NextUntil != "" {
+		fmt.Printf("\nmore connections before %s\n", resp.NextUntil)
+	}
+	return nil
+}
+
+func parseTime(v string) (time.Time, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q, use YYYY-MM-DD, RFC3339 or unix time", v)
+}
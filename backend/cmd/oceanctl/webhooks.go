@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"oceanproxy-api/events"
+)
+
+func webhooksList(args []string) error {
+	_ = newFlags("webhooks list").Parse(args)
+
+	var resp struct {
+		Webhooks []events.Subscription `json:"webhooks"`
+	}
+	if err := call("GET", "/webhooks", nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	var rows [][]string
+	for _, s := range resp.Webhooks {
+		types := "all"
+		if len(s.Events) > 0 {
+			types = strings.Join(s.Events, ",")
+		}
+		rows = append(rows, []string{s.ID, s.URL, types, orDash(s.Description)})
+	}
+	printTable([]string{"ID", "URL", "EVENTS", "DESCRIPTION"}, rows)
+	return nil
+}
+
+func webhooksAdd(args []string) error {
+	fs := newFlags("webhooks add")
+	types := fs.String("events", "", "comma separated event types, all if empty")
+	description := fs.String("description", "", "what the endpoint is for")
+	secret := fs.String("secret", "", "signing secret, generated if empty")
+	endpoint, err := parseWithArg(fs, args, "URL")
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Webhook events.Subscription `json:"webhook"`
+	}
+	form := url.Values{"url": {endpoint}, "events": {*types}, "description": {*description}, "secret": {*secret}}
+	if err := call("POST", "/webhooks", form, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	fmt.Printf("📣 Subscribed %s as %s\n", resp.Webhook.URL, resp.Webhook.ID)
+	fmt.Printf("🔑 Signing secret (shown once): %s\n", resp.Webhook.Secret)
+	return nil
+}
+
+func webhooksRemove(args []string) error {
+	id, err := parseWithArg(newFlags("webhooks remove"), args, "webhook ID")
+	if err != nil {
+		return err
+	}
+	if err := call("DELETE", "/webhooks/"+url.PathEscape(id), nil, nil, nil); err != nil {
+		return err
+	}
+	fmt.Printf("🗑️ Removed webhook %s\n", id)
+	return nil
+}
+
+func webhooksPing(args []string) error {
+	id, err := parseWithArg(newFlags("webhooks ping"), args, "webhook ID")
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Event events.Event `json:"event"`
+	}
+	if err := call("POST", "/webhooks/"+url.PathEscape(id)+"/ping", url.Values{}, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	fmt.Printf("📨 Queued %s to %s, see webhooks pending and webhooks dead\n", resp.Event.ID, id)
+	return nil
+}
+
+func webhooksDeliveries(args []string, dead bool) error {
+	name, path, key := "webhooks pending", "/webhooks/deliveries", "deliveries"
+	if dead {
+		name, path, key = "webhooks dead", "/webhooks/dead-letters", "dead_letters"
+	}
+	fs := newFlags(name)
+	retry := ""
+	if dead {
+		fs.StringVar(&retry, "retry", "", "queue this dead letter again")
+	}
+	_ = fs.Parse(args)
+
+	if retry != "" {
+		if err := call("POST", "/webhooks/dead-letters/"+url.PathEscape(retry)+"/retry", url.Values{}, nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("🔁 Queued %s again\n", retry)
+		return nil
+	}
+
+	var resp map[string][]events.Delivery
+	if err := call("GET", path, nil, nil, &resp); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJSON(resp)
+	}
+	var rows [][]string
+	for _, d := range resp[key] {
+		when := d.NextAttempt.Local().Format("2006-01-02 15:04:05")
+		if d.DeadAt != nil {
+			when = d.DeadAt.Local().Format("2006-01-02 15:04:05")
+		}
+		rows = append(rows, []string{d.ID, d.Event.Type, d.URL, strconv.Itoa(d.Attempts), when, orDash(d.LastError)})
+	}
+	column := "NEXT ATTEMPT"
+	if dead {
+		column = "DEAD SINCE"
+	}
+	printTable([]string{"ID", "EVENT", "URL", "ATTEMPTS", column, "LAST ERROR"}, rows)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/failover"
+	"oceanproxy-api/jobs"
+	"oceanproxy-api/providers"
+	"oceanproxy-api/tlsproxy"
+)
+
+// waitForSignals blocks until SIGTERM or SIGINT and then shuts the API down.
+// SIGHUP reloads the configuration in place. Running 3proxy listeners are left
+// alone in both cases.
+func waitForSignals(srv *http.Server) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+	for sig := range sigs {
+		if sig == syscall.SIGHUP {
+			reloadConfig()
+			continue
+		}
+		shutdown(srv, sig)
+		return
+	}
+}
+
+func reloadConfig() {
+	log.Println("🔄 SIGHUP received, reloading configuration...")
+
+	if err := config.Reload(); err != nil {
+		log.Printf("❌ Config reload failed, keeping current configuration: %v", err)
+		return
+	}
+	if err := providers.LoadTopology(config.Get().TopologyFile); err != nil {
+		log.Printf("❌ Topology reload failed, keeping current topology: %v", err)
+	}
+	providers.ResetClients()
+	failover.Reload()
+	if tlsproxy.Enabled() {
+		if err := tlsproxy.Reload(); err != nil {
+			log.Printf("❌ TLS certificate reload failed, keeping current certificate: %v", err)
+		}
+	}
+
+	cfg := config.Get()
+	log.Printf("✅ Configuration reloaded - API_KEY: %s, BEARER_TOKEN: %s, DOMAIN: %s",
+		config.MaskString(cfg.APIKey),
+		config.MaskString(cfg.BearerToken),
+		cfg.BaseDomain)
+}
+
+// shutdown stops accepting connections, then waits up to ShutdownTimeout for
+// in-flight requests and background jobs to finish.
+func shutdown(srv *http.Server, sig os.Signal) {
+	log.Printf("🛑 %s received, draining requests and background jobs (timeout %s)...", sig, config.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("⚠️ HTTP requests still running at deadline, closing connections: %v", err)
+		srv.Close()
+	}
+	if err := jobs.Shutdown(ctx); err != nil {
+		log.Printf("⚠️ Background jobs still running at deadline: %v", err)
+	}
+
+	log.Println("👋 OceanProxy API stopped, proxy listeners left running")
+}
@@ -2,40 +2,288 @@ package config
 
 import (
 	"log"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Settings set once at startup. SIGHUP does not change them: they decide
+// where state and generated configs live and how the process runs, so a
+// change needs a restart.
 var (
+	// Address the API listens on
+	ListenAddr string
+
+	// Where the JSON state files live, and the plan store itself
+	DataDir      string
+	ProxyLogPath string
+
+	// Scripts the API shells out to
+	ScriptsDir        string
+	NginxUpdateScript string
+
+	// Where the scripts write generated 3proxy and nginx configs, and where
+	// 3proxy writes its per-connection access logs
+	ProxyConfigDir string
+	NginxStreamDir string
+	AccessLogDir   string
+
+	// standalone, control or agent
+	NodeRole string
+
+	// How long SIGTERM waits for requests and background jobs to finish
+	ShutdownTimeout time.Duration
+)
+
+// Settings are the values SIGHUP may replace while the API runs. They are
+// published as one snapshot: read them with Get and never modify the result.
+type Settings struct {
 	APIKey        string
 	BearerToken   string
 	BaseDomain    string
 	NettifyAPIKey string
-)
 
+	// proxies.fo plan type -> reseller UUID
+	ProxiesFOResellers map[string]string
+
+	// Upstream prober settings
+	ProbeTargetURL     string
+	ProbeInterval      time.Duration
+	ProbeCanaries      []string // host:port:user:pass
+	ProbeCustomerPlans bool     // fall back to probing through customer plans
+
+	// How long Idempotency-Key responses are kept for replay
+	IdempotencyTTL time.Duration
+
+	// Upstream failover: region -> standby host:port:user:pass
+	FailoverStandby   map[string]string
+	FailoverThreshold int
+
+	// Provider HTTP client
+	ProviderTimeout    time.Duration
+	ProviderMaxRetries int
+	ProviderRateLimit  float64 // requests per second per provider
+
+	// Provider API base URLs, overridable to point at a fake server
+	ProxiesFOBaseURL string
+	NettifyBaseURL   string
+
+	// Optional JSON file overriding the per-product endpoint topology
+	TopologyFile string
+
+	// How often the reconcilers produce a dry-run drift report, 0 to disable
+	NettifyReconcileInterval   time.Duration
+	ProxiesFOReconcileInterval time.Duration
+
+	// How often access logs are collected and how long records are kept
+	AccessLogInterval  time.Duration
+	AccessLogRetention time.Duration
+
+	// Concurrent connection cap for listeners of plans without purchased threads
+	DefaultMaxConn int
+
+	// TLS-wrapped customer endpoints: certificate and key files, region ->
+	// TLS port, and how often the files are checked for changes
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSPorts          map[string]int
+	TLSReloadInterval time.Duration
+
+	// The token agents accept on their RPC
+	AgentToken string
+
+	// Outbound webhooks: timeout per attempt, attempts before a delivery is
+	// dead-lettered, and how often plans are checked for lifecycle events
+	WebhookTimeout     time.Duration
+	WebhookMaxAttempts int
+	EventWatchInterval time.Duration
+
+	// Currency of catalog prices, quotes and orders
+	Currency string
+
+	// Secrets that authenticate inbound payment webhooks, empty disables a processor
+	StripeWebhookSecret string
+	HeleketAPIKey       string
+}
+
+var current atomic.Pointer[Settings]
+
+// Get returns the current settings. Keep the pointer for the duration of one
+// operation when several values must agree.
+func Get() *Settings {
+	return current.Load()
+}
+
+// Set publishes a copy of the current settings changed by fn, e.g. in tests
+func Set(fn func(s *Settings)) {
+	s := *current.Load()
+	fn(&s)
+	current.Store(&s)
+}
+
+// Defaults apply until LoadEnv runs, e.g. in tests
+func init() {
+	f := Defaults()
+	f.Paths.ProxyLog = filepath.Join(f.Paths.DataDir, "proxies.json")
+	f.applyStartup()
+	current.Store(f.settings())
+}
+
+// LoadEnv reads the configuration at startup and exits with the list of
+// problems if it is invalid
 func LoadEnv() {
-	if err := godotenv.Load(); err != nil {
+	f, err := read(godotenv.Load)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	f.applyStartup()
+	current.Store(f.settings())
+}
+
+// Reload re-reads the config file, .env and environment. Values in .env take
+// precedence over those already in the environment so edits apply without a
+// restart. Startup settings keep their values, with a warning if they were
+// changed. On error the current configuration is kept.
+func Reload() error {
+	f, err := read(godotenv.Overload)
+	if err != nil {
+		return err
+	}
+	for name, changed := range map[string]bool{
+		"server.listen":             f.Server.Listen != ListenAddr,
+		"server.shutdown_timeout":   f.Server.ShutdownTimeout != ShutdownTimeout,
+		"paths.data_dir":            f.Paths.DataDir != DataDir,
+		"paths.proxy_log":           f.Paths.ProxyLog != ProxyLogPath,
+		"paths.scripts_dir":         f.Paths.ScriptsDir != ScriptsDir,
+		"paths.nginx_update_script": f.Paths.NginxUpdateScript != NginxUpdateScript,
+		"paths.proxy_config_dir":    f.Paths.ProxyConfigDir != ProxyConfigDir,
+		"paths.nginx_stream_dir":    f.Paths.NginxStreamDir != NginxStreamDir,
+		"access_log.dir":            f.AccessLog.Dir != AccessLogDir,
+		"node.role":                 f.Node.Role != NodeRole,
+	} {
+		if changed {
+			log.Printf("⚠️ %s changed, restart the API to apply it", name)
+		}
+	}
+	current.Store(f.settings())
+	return nil
+}
+
+func read(readDotenv func(...string) error) (*File, error) {
+	if err := readDotenv(); err != nil {
 		log.Println("⚠️ No .env file found, using system environment variables")
 	}
-	APIKey = os.Getenv("API_KEY")
-	BearerToken = os.Getenv("BEARER_TOKEN")
-	BaseDomain = os.Getenv("DOMAIN")
-	NettifyAPIKey = os.Getenv("NETTIFY_API_KEY")
 
-	if APIKey == "" || BearerToken == "" || BaseDomain == "" {
-		log.Fatal("❌ Missing API_KEY, BEARER_TOKEN or DOMAIN in .env")
+	f, warnings, err := Read(ConfigPath())
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range warnings {
+		log.Printf("⚠️ %s", w)
 	}
+	return f, nil
+}
+
+// applyStartup sets the startup settings; only called before the API starts
+func (f *File) applyStartup() {
+	ListenAddr = f.Server.Listen
+	ShutdownTimeout = f.Server.ShutdownTimeout
+
+	DataDir = f.Paths.DataDir
+	ProxyLogPath = f.Paths.ProxyLog
+	ScriptsDir = f.Paths.ScriptsDir
+	NginxUpdateScript = f.Paths.NginxUpdateScript
+	ProxyConfigDir = f.Paths.ProxyConfigDir
+	NginxStreamDir = f.Paths.NginxStreamDir
+	AccessLogDir = f.AccessLog.Dir
+
+	NodeRole = f.Node.Role
+}
+
+// settings returns the reloadable part of f
+func (f *File) settings() *Settings {
+	return &Settings{
+		APIKey:        f.Providers.ProxiesFO.APIKey,
+		BearerToken:   f.Server.BearerToken,
+		BaseDomain:    f.Server.Domain,
+		NettifyAPIKey: f.Providers.Nettify.APIKey,
+
+		ProviderTimeout:            f.Providers.Timeout,
+		ProviderMaxRetries:         f.Providers.MaxRetries,
+		ProviderRateLimit:          f.Providers.RateLimit,
+		ProxiesFOBaseURL:           strings.TrimRight(f.Providers.ProxiesFO.BaseURL, "/"),
+		ProxiesFOReconcileInterval: f.Providers.ProxiesFO.ReconcileInterval,
+		ProxiesFOResellers:         f.Providers.ProxiesFO.Resellers,
+		NettifyBaseURL:             strings.TrimRight(f.Providers.Nettify.BaseURL, "/"),
+		NettifyReconcileInterval:   f.Providers.Nettify.ReconcileInterval,
+		TopologyFile:               f.Paths.TopologyFile,
+
+		ProbeTargetURL:     f.Prober.TargetURL,
+		ProbeInterval:      f.Prober.Interval,
+		ProbeCanaries:      f.Prober.Canaries,
+		ProbeCustomerPlans: f.Prober.CustomerPlans,
 
-	if NettifyAPIKey == "" {
-		log.Println("⚠️ NETTIFY_API_KEY not found in .env - Nettify provider will not work")
+		FailoverStandby:   f.Failover.Standby,
+		FailoverThreshold: f.Failover.Threshold,
+
+		IdempotencyTTL: f.Idempotency.TTL,
+
+		AccessLogInterval:  f.AccessLog.Interval,
+		AccessLogRetention: f.AccessLog.Retention,
+
+		DefaultMaxConn: f.Limits.DefaultMaxConn,
+
+		TLSCertFile:       f.TLS.CertFile,
+		TLSKeyFile:        f.TLS.KeyFile,
+		TLSPorts:          f.TLS.Ports,
+		TLSReloadInterval: f.TLS.ReloadInterval,
+
+		AgentToken: f.Node.AgentToken,
+
+		WebhookTimeout:     f.Webhooks.Timeout,
+		WebhookMaxAttempts: f.Webhooks.MaxAttempts,
+		EventWatchInterval: f.Webhooks.WatchInterval,
+
+		Currency:            f.Billing.Currency,
+		StripeWebhookSecret: f.Billing.StripeWebhookSecret,
+		HeleketAPIKey:       f.Billing.HeleketAPIKey,
 	}
 }
 
+// ListenPort returns the port part of ListenAddr, or 0 if it has none
+func ListenPort() int {
+	_, port, _ := net.SplitHostPort(ListenAddr)
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
 func MaskString(s string) string {
 	if len(s) <= 4 {
 		return strings.Repeat("*", len(s))
 	}
 	return s[:2] + strings.Repeat("*", len(s)-4) + s[len(s)-2:]
 }
+
+func getEnvDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// splitList splits a comma separated env value, dropping empty items
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,424 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigPath is read when CONFIG_FILE is not set. It is optional.
+const DefaultConfigPath = "/etc/oceanproxy/config.yaml"
+
+// File is the typed config file. Every field can be overridden by the env var
+// listed in envOverrides, so existing .env deployments keep working.
+type File struct {
+	Server      ServerConfig      `yaml:"server"`
+	Paths       PathsConfig       `yaml:"paths"`
+	Providers   ProvidersConfig   `yaml:"providers"`
+	Prober      ProberConfig      `yaml:"prober"`
+	Failover    FailoverConfig    `yaml:"failover"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	AccessLog   AccessLogConfig   `yaml:"access_log"`
+	Limits      LimitsConfig      `yaml:"limits"`
+	TLS         TLSConfig         `yaml:"tls"`
+	Node        NodeConfig        `yaml:"node"`
+	Webhooks    WebhooksConfig    `yaml:"webhooks"`
+	Billing     BillingConfig     `yaml:"billing"`
+}
+
+type ServerConfig struct {
+	Listen          string        `yaml:"listen"`
+	Domain          string        `yaml:"domain"`
+	BearerToken     string        `yaml:"bearer_token"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type PathsConfig struct {
+	DataDir           string `yaml:"data_dir"`  // JSON state files
+	ProxyLog          string `yaml:"proxy_log"` // plan store, defaults to data_dir/proxies.json
+	ScriptsDir        string `yaml:"scripts_dir"`
+	NginxUpdateScript string `yaml:"nginx_update_script"`
+	TopologyFile      string `yaml:"topology_file"`
+	ProxyConfigDir    string `yaml:"proxy_config_dir"` // generated 3proxy listener configs
+	NginxStreamDir    string `yaml:"nginx_stream_dir"` // generated nginx stream upstreams
+}
+
+type ProvidersConfig struct {
+	Timeout    time.Duration   `yaml:"timeout"`
+	MaxRetries int             `yaml:"max_retries"`
+	RateLimit  float64         `yaml:"rate_limit"` // requests per second per provider
+	ProxiesFO  ProxiesFOConfig `yaml:"proxiesfo"`
+	Nettify    NettifyConfig   `yaml:"nettify"`
+}
+
+type ProxiesFOConfig struct {
+	APIKey            string            `yaml:"api_key"`
+	BaseURL           string            `yaml:"base_url"`
+	ReconcileInterval time.Duration     `yaml:"reconcile_interval"`
+	Resellers         map[string]string `yaml:"resellers"` // plan type -> reseller UUID
+}
+
+type NettifyConfig struct {
+	APIKey            string        `yaml:"api_key"`
+	BaseURL           string        `yaml:"base_url"`
+	ReconcileInterval time.Duration `yaml:"reconcile_interval"`
+}
+
+type ProberConfig struct {
+	TargetURL     string        `yaml:"target_url"`
+	Interval      time.Duration `yaml:"interval"`
+	Canaries      []string      `yaml:"canaries"`       // host:port:user:pass
+	CustomerPlans bool          `yaml:"customer_plans"` // probe upstreams without a canary through a customer plan
+}
+
+type FailoverConfig struct {
+	Threshold int               `yaml:"threshold"`
+	Standby   map[string]string `yaml:"standby"` // region -> host:port:user:pass
+}
+
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl"`
+}
+
+type AccessLogConfig struct {
+	Dir       string        `yaml:"dir"`       // where 3proxy writes raw per-listener logs
+	Interval  time.Duration `yaml:"interval"`  // how often they are collected into the store
+	Retention time.Duration `yaml:"retention"` // how long connection records are kept
+}
+
+type LimitsConfig struct {
+	DefaultMaxConn int `yaml:"default_max_conn"` // concurrent connections per listener for plans without purchased threads
+}
+
+type TLSConfig struct {
+	CertFile       string         `yaml:"cert_file"`       // PEM certificate chain
+	KeyFile        string         `yaml:"key_file"`        // PEM private key
+	Ports          map[string]int `yaml:"ports"`           // region -> TLS port, none disables TLS endpoints
+	ReloadInterval time.Duration  `yaml:"reload_interval"` // how often the files are checked for changes
+}
+
+type NodeConfig struct {
+	Role       string `yaml:"role"`        // standalone, control or agent
+	AgentToken string `yaml:"agent_token"` // bearer token of the agent RPC, set on agents
+}
+
+type WebhooksConfig struct {
+	Timeout       time.Duration `yaml:"timeout"`        // per delivery attempt
+	MaxAttempts   int           `yaml:"max_attempts"`   // before a delivery is dead-lettered
+	WatchInterval time.Duration `yaml:"watch_interval"` // how often expiry, listeners and quotas are checked for events
+}
+
+type BillingConfig struct {
+	Currency            string `yaml:"currency"`              // ISO 4217 code of catalog prices and orders
+	StripeWebhookSecret string `yaml:"stripe_webhook_secret"` // whsec_... of the Stripe webhook endpoint, empty disables it
+	HeleketAPIKey       string `yaml:"heleket_api_key"`       // Heleket payment API key that signs its callbacks, empty disables it
+}
+
+// Node roles
+const (
+	RoleStandalone = "standalone" // runs its own listeners, the default
+	RoleControl    = "control"    // keeps the plan store and places plans on agents
+	RoleAgent      = "agent"      // runs listeners for a control plane
+)
+
+// Defaults returns the configuration used for anything the file and env leave unset
+func Defaults() File {
+	return File{
+		Server: ServerConfig{
+			Listen:          ":9090",
+			ShutdownTimeout: 25 * time.Second,
+		},
+		Paths: PathsConfig{
+			DataDir:           "/var/log/oceanproxy",
+			ScriptsDir:        defaultScriptsDir(),
+			NginxUpdateScript: "/opt/oceanproxy/scripts/update_nginx_upstreams.sh",
+			ProxyConfigDir:    "/etc/3proxy/plans",
+			NginxStreamDir:    "/etc/nginx/stream.d",
+		},
+		Providers: ProvidersConfig{
+			Timeout:    30 * time.Second,
+			MaxRetries: 2,
+			RateLimit:  5,
+			ProxiesFO: ProxiesFOConfig{
+				BaseURL:           "https://app.proxies.fo",
+				ReconcileInterval: time.Hour,
+				Resellers: map[string]string{
+					"residential": "7c9ea873-63f9-4013-9147-3807cc6f0553",
+					"isp":         "3471aa35-7922-488a-a7a9-b92a5510080e",
+					"datacenter":  "b3fd0f3c-693d-4ec5-b49f-c77feaab0b72",
+				},
+			},
+			Nettify: NettifyConfig{
+				BaseURL:           "https://api.nettify.xyz",
+				ReconcileInterval: time.Hour,
+			},
+		},
+		Prober: ProberConfig{
+			TargetURL: "http://httpbin.org/ip",
+			Interval:  60 * time.Second,
+		},
+		Failover: FailoverConfig{
+			Threshold: 3,
+			Standby:   map[string]string{},
+		},
+		Idempotency: IdempotencyConfig{
+			TTL: 24 * time.Hour,
+		},
+		AccessLog: AccessLogConfig{
+			Dir:       "/var/log/oceanproxy/access",
+			Interval:  30 * time.Second,
+			Retention: 14 * 24 * time.Hour,
+		},
+		Limits: LimitsConfig{
+			DefaultMaxConn: 2000,
+		},
+		TLS: TLSConfig{
+			Ports:          map[string]int{},
+			ReloadInterval: time.Minute,
+		},
+		Node: NodeConfig{
+			Role: RoleStandalone,
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:       10 * time.Second,
+			MaxAttempts:   8,
+			WatchInterval: time.Minute,
+		},
+		Billing: BillingConfig{
+			Currency: "USD",
+		},
+	}
+}
+
+// The scripts live next to the exec directory the binary is installed in
+func defaultScriptsDir() string {
+	execPath, err := os.Executable()
+	if err != nil {
+		return "scripts"
+	}
+	return filepath.Join(filepath.Dir(filepath.Dir(execPath)), "scripts")
+}
+
+// ConfigPath returns the config file named by CONFIG_FILE, or the default path
+func ConfigPath() string {
+	return getEnvDefault("CONFIG_FILE", DefaultConfigPath)
+}
+
+// ValidationError lists every problem found in the configuration
+type ValidationError struct {
+	Source   string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration (%s):\n  - %s", e.Source, strings.Join(e.Problems, "\n  - "))
+}
+
+// Read builds the configuration from the defaults, the config file at path and
+// env overrides, and validates the result. A missing file is only an error when
+// path is not DefaultConfigPath, i.e. it was asked for explicitly. Warnings are problems that
+// do not stop the API from starting, such as a missing script.
+func Read(path string) (*File, []string, error) {
+	f := Defaults()
+	source := "defaults and environment"
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+			return nil, nil, &ValidationError{Source: path, Problems: []string{err.Error()}}
+		}
+		source = path + " and environment"
+	case !os.IsNotExist(err) || path != DefaultConfigPath:
+		return nil, nil, fmt.Errorf("cannot read config file %s: %w", path, err)
+	}
+
+	var problems []string
+	for _, o := range envOverrides {
+		v := os.Getenv(o.env)
+		if v == "" {
+			continue
+		}
+		if err := o.apply(&f, v); err != nil {
+			problems = append(problems, fmt.Sprintf("%s=%q: %v", o.env, v, err))
+		}
+	}
+	if f.Paths.ProxyLog == "" {
+		f.Paths.ProxyLog = filepath.Join(f.Paths.DataDir, "proxies.json")
+	}
+
+	problems = append(problems, f.validate()...)
+	if len(problems) > 0 {
+		return nil, nil, &ValidationError{Source: source, Problems: problems}
+	}
+	return &f, f.warnings(), nil
+}
+
+type envOverride struct {
+	env   string
+	apply func(f *File, v string) error
+}
+
+var envOverrides = []envOverride{
+	{"LISTEN_ADDR", func(f *File, v string) error { f.Server.Listen = v; return nil }},
+	{"DOMAIN", func(f *File, v string) error { f.Server.Domain = v; return nil }},
+	{"BEARER_TOKEN", func(f *File, v string) error { f.Server.BearerToken = v; return nil }},
+	{"SHUTDOWN_TIMEOUT", durationEnv(func(f *File) *time.Duration { return &f.Server.ShutdownTimeout })},
+
+	{"DATA_DIR", func(f *File, v string) error { f.Paths.DataDir = v; return nil }},
+	{"PROXY_LOG", func(f *File, v string) error { f.Paths.ProxyLog = v; return nil }},
+	{"SCRIPTS_DIR", func(f *File, v string) error { f.Paths.ScriptsDir = v; return nil }},
+	{"NGINX_UPDATE_SCRIPT", func(f *File, v string) error { f.Paths.NginxUpdateScript = v; return nil }},
+	{"TOPOLOGY_FILE", func(f *File, v string) error { f.Paths.TopologyFile = v; return nil }},
+	{"PROXY_CONFIG_DIR", func(f *File, v string) error { f.Paths.ProxyConfigDir = v; return nil }},
+	{"NGINX_STREAM_DIR", func(f *File, v string) error { f.Paths.NginxStreamDir = v; return nil }},
+
+	{"PROVIDER_TIMEOUT", durationEnv(func(f *File) *time.Duration { return &f.Providers.Timeout })},
+	{"PROVIDER_MAX_RETRIES", intEnv(func(f *File) *int { return &f.Providers.MaxRetries })},
+	{"PROVIDER_RATE_LIMIT", func(f *File, v string) error {
+		n, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			f.Providers.RateLimit = n
+		}
+		return err
+	}},
+
+	{"API_KEY", func(f *File, v string) error { f.Providers.ProxiesFO.APIKey = v; return nil }},
+	{"PROXIESFO_BASE_URL", func(f *File, v string) error { f.Providers.ProxiesFO.BaseURL = v; return nil }},
+	{"PROXIESFO_RECONCILE_INTERVAL", durationEnv(func(f *File) *time.Duration { return &f.Providers.ProxiesFO.ReconcileInterval })},
+	{"PROXIESFO_RESELLERS", func(f *File, v string) error {
+		return mergePairs(f.Providers.ProxiesFO.Resellers, v, "type=uuid")
+	}},
+
+	{"NETTIFY_API_KEY", func(f *File, v string) error { f.Providers.Nettify.APIKey = v; return nil }},
+	{"NETTIFY_BASE_URL", func(f *File, v string) error { f.Providers.Nettify.BaseURL = v; return nil }},
+	{"NETTIFY_RECONCILE_INTERVAL", durationEnv(func(f *File) *time.Duration { return &f.Providers.Nettify.ReconcileInterval })},
+
+	{"PROBE_TARGET_URL", func(f *File, v string) error { f.Prober.TargetURL = v; return nil }},
+	{"PROBE_INTERVAL", durationEnv(func(f *File) *time.Duration { return &f.Prober.Interval })},
+	{"PROBE_CANARIES", func(f *File, v string) error { f.Prober.Canaries = splitList(v); return nil }},
+	{"PROBE_CUSTOMER_PLANS", func(f *File, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err == nil {
+			f.Prober.CustomerPlans = b
+		}
+		return err
+	}},
+
+	{"FAILOVER_STANDBY", func(f *File, v string) error {
+		f.Failover.Standby = make(map[string]string)
+		return mergePairs(f.Failover.Standby, v, "region=host:port:user:pass")
+	}},
+	{"FAILOVER_THRESHOLD", intEnv(func(f *File) *int { return &f.Failover.Threshold })},
+
+	{"IDEMPOTENCY_TTL", durationEnv(func(f *File) *time.Duration { return &f.Idempotency.TTL })},
+
+	{"ACCESS_LOG_DIR", func(f *File, v string) error { f.AccessLog.Dir = v; return nil }},
+	{"ACCESS_LOG_INTERVAL", durationEnv(func(f *File) *time.Duration { return &f.AccessLog.Interval })},
+	{"ACCESS_LOG_RETENTION", durationEnv(func(f *File) *time.Duration { return &f.AccessLog.Retention })},
+
+	{"DEFAULT_MAX_CONN", intEnv(func(f *File) *int { return &f.Limits.DefaultMaxConn })},
+
+	{"TLS_CERT_FILE", func(f *File, v string) error { f.TLS.CertFile = v; return nil }},
+	{"TLS_KEY_FILE", func(f *File, v string) error { f.TLS.KeyFile = v; return nil }},
+	{"TLS_PORTS", func(f *File, v string) error {
+		f.TLS.Ports = make(map[string]int)
+		for _, item := range splitList(v) {
+			region, port, ok := strings.Cut(item, "=")
+			n, err := strconv.Atoi(port)
+			if !ok || region == "" || err != nil {
+				return fmt.Errorf("item %q, expected region=port", item)
+			}
+			f.TLS.Ports[region] = n
+		}
+		return nil
+	}},
+	{"TLS_RELOAD_INTERVAL", durationEnv(func(f *File) *time.Duration { return &f.TLS.ReloadInterval })},
+
+	{"NODE_ROLE", func(f *File, v string) error { f.Node.Role = v; return nil }},
+	{"AGENT_TOKEN", func(f *File, v string) error { f.Node.AgentToken = v; return nil }},
+
+	{"WEBHOOK_TIMEOUT", durationEnv(func(f *File) *time.Duration { return &f.Webhooks.Timeout })},
+	{"WEBHOOK_MAX_ATTEMPTS", intEnv(func(f *File) *int { return &f.Webhooks.MaxAttempts })},
+	{"EVENT_WATCH_INTERVAL", durationEnv(func(f *File) *time.Duration { return &f.Webhooks.WatchInterval })},
+
+	{"BILLING_CURRENCY", func(f *File, v string) error { f.Billing.Currency = v; return nil }},
+	{"STRIPE_WEBHOOK_SECRET", func(f *File, v string) error { f.Billing.StripeWebhookSecret = v; return nil }},
+	{"HELEKET_API_KEY", func(f *File, v string) error { f.Billing.HeleketAPIKey = v; return nil }},
+}
+
+func durationEnv(field func(f *File) *time.Duration) func(f *File, v string) error {
+	return func(f *File, v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			*field(f) = d
+		}
+		return err
+	}
+}
+
+func intEnv(field func(f *File) *int) func(f *File, v string) error {
+	return func(f *File, v string) error {
+		n, err := strconv.Atoi(v)
+		if err == nil {
+			*field(f) = n
+		}
+		return err
+	}
+}
+
+// mergePairs adds comma separated key=value items to m
+func mergePairs(m map[string]string, v, format string) error {
+	for _, item := range splitList(v) {
+		k, val, ok := strings.Cut(item, "=")
+		if !ok || k == "" || val == "" {
+			return fmt.Errorf("item %q, expected %s", item, format)
+		}
+		m[k] = val
+	}
+	return nil
+}
+
+// Masked returns a copy of f with keys, tokens and upstream passwords hidden,
+// safe to print
+func (f File) Masked() File {
+	f.Server.BearerToken = MaskString(f.Server.BearerToken)
+	f.Node.AgentToken = MaskString(f.Node.AgentToken)
+	f.Providers.ProxiesFO.APIKey = MaskString(f.Providers.ProxiesFO.APIKey)
+	f.Providers.Nettify.APIKey = MaskString(f.Providers.Nettify.APIKey)
+	f.Billing.StripeWebhookSecret = MaskString(f.Billing.StripeWebhookSecret)
+	f.Billing.HeleketAPIKey = MaskString(f.Billing.HeleketAPIKey)
+
+	canaries := make([]string, len(f.Prober.Canaries))
+	for i, c := range f.Prober.Canaries {
+		canaries[i] = maskCred(c)
+	}
+	f.Prober.Canaries = canaries
+
+	standby := make(map[string]string, len(f.Failover.Standby))
+	for region, c := range f.Failover.Standby {
+		standby[region] = maskCred(c)
+	}
+	f.Failover.Standby = standby
+	return f
+}
+
+// maskCred hides the password of a host:port:user:pass value
+func maskCred(raw string) string {
+	parts := strings.SplitN(raw, ":", 4)
+	if len(parts) != 4 {
+		return MaskString(raw)
+	}
+	parts[3] = MaskString(parts[3])
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv blanks every variable Read looks at so the host environment cannot leak in
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, o := range envOverrides {
+		t.Setenv(o.env, "")
+	}
+}
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const validConfig = `
+server:
+  domain: example.com
+  bearer_token: secret-token
+  listen: "127.0.0.1:9191"
+providers:
+  proxiesfo:
+    api_key: pfo-key
+  timeout: 10s
+failover:
+  standby:
+    eu: standby.example.com:8080:user:pass
+`
+
+func TestReadConfigFile(t *testing.T) {
+	clearEnv(t)
+	f, _, err := Read(writeConfig(t, validConfig))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if f.Server.Listen != "127.0.0.1:9191" || f.Providers.Timeout != 10*time.Second {
+		t.Errorf("file values not applied: listen=%q timeout=%s", f.Server.Listen, f.Providers.Timeout)
+	}
+	if f.Providers.ProxiesFO.Resellers["datacenter"] == "" {
+		t.Error("default resellers should survive a file that does not list them")
+	}
+	if f.Paths.ProxyLog != "/var/log/oceanproxy/proxies.json" {
+		t.Errorf("proxy_log = %q, want it derived from data_dir", f.Paths.ProxyLog)
+	}
+}
+
+func TestReadEnvOverridesFile(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("LISTEN_ADDR", ":9292")
+	t.Setenv("PROVIDER_TIMEOUT", "5s")
+	t.Setenv("DATA_DIR", "/srv/oceanproxy")
+
+	f, _, err := Read(writeConfig(t, validConfig))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if f.Server.Listen != ":9292" || f.Providers.Timeout != 5*time.Second {
+		t.Errorf("env not applied: listen=%q timeout=%s", f.Server.Listen, f.Providers.Timeout)
+	}
+	if f.Paths.ProxyLog != "/srv/oceanproxy/proxies.json" {
+		t.Errorf("proxy_log = %q, want it under DATA_DIR", f.Paths.ProxyLog)
+	}
+}
+
+func TestReadValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "missing required",
+			body: "",
+			want: []string{"API_KEY", "BEARER_TOKEN", "DOMAIN"},
+		},
+		{
+			name: "unknown field",
+			body: validConfig + "\nprober:\n  intervall: 5s\n",
+			want: []string{"field intervall not found"},
+		},
+		{
+			name: "bad env values",
+			body: validConfig,
+			env:  map[string]string{"PROBE_INTERVAL": "soon", "FAILOVER_THRESHOLD": "0"},
+			want: []string{`PROBE_INTERVAL="soon"`, "failover.threshold must be at least 1"},
+		},
+		{
+			name: "bad values in file",
+			body: validConfig + `
+paths:
+  data_dir: relative/dir
+prober:
+  canaries: ["host-without-port"]
+`,
+			env:  map[string]string{"PROXIESFO_RESELLERS": "isp=not-a-uuid"},
+			want: []string{"paths.data_dir", "prober.canaries[0]", "resellers.isp"},
+		},
+		{
+			name: "bad listen address",
+			body: validConfig,
+			env:  map[string]string{"LISTEN_ADDR": "9090"},
+			want: []string{"server.listen"},
+		},
+		{
+			name: "tls ports without certificate",
+			body: validConfig,
+			env:  map[string]string{"TLS_PORTS": "usa=2337,eu=99999"},
+			want: []string{"tls.cert_file", "tls.ports.eu"},
+		},
+		{
+			name: "agent without token",
+			body: validConfig,
+			env:  map[string]string{"NODE_ROLE": "agent", "AGENT_TOKEN": "short"},
+			want: []string{"node.agent_token"},
+		},
+		{
+			name: "unknown role",
+			body: validConfig,
+			env:  map[string]string{"NODE_ROLE": "edge"},
+			want: []string{"node.role"},
+		},
+		{
+			name: "webhooks never attempted",
+			body: validConfig,
+			env:  map[string]string{"WEBHOOK_MAX_ATTEMPTS": "0"},
+			want: []string{"webhooks.max_attempts"},
+		},
+		{
+			name: "lowercase currency",
+			body: validConfig,
+			env:  map[string]string{"BILLING_CURRENCY": "usd"},
+			want: []string{"billing.currency"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, _, err := Read(writeConfig(t, tt.body))
+			if err == nil {
+				t.Fatal("expected a validation error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error does not mention %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadMissingExplicitFile(t *testing.T) {
+	clearEnv(t)
+	if _, _, err := Read(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected an error for a missing config file that was asked for")
+	}
+}
+
+func TestReloadKeepsStartupSettings(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CONFIG_FILE", writeConfig(t, validConfig))
+	oldSettings, oldListen := Get(), ListenAddr
+	t.Cleanup(func() {
+		current.Store(oldSettings)
+		ListenAddr = oldListen
+	})
+
+	// Readers race with the reload under go test -race
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			s := Get()
+			_, _ = s.BearerToken, s.TLSPorts["usa"]
+		}
+	}()
+	if err := Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	<-done
+
+	if Get().BearerToken != "secret-token" || Get().FailoverStandby["eu"] == "" {
+		t.Errorf("reloaded settings not published: %+v", Get())
+	}
+	if ListenAddr != oldListen {
+		t.Errorf("listen address changed to %q, want it kept until restart", ListenAddr)
+	}
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Plan types the proxies.fo reseller map must cover
+var resellerTypes = []string{"residential", "isp", "datacenter"}
+
+// validate returns every problem that would stop the API from working
+func (f *File) validate() []string {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	switch f.Node.Role {
+	case RoleAgent:
+		// Agents only serve the RPC, providers and the public API stay on the control plane
+		if len(f.Node.AgentToken) < 16 {
+			add("node.agent_token (AGENT_TOKEN) of at least 16 characters is required on an agent")
+		}
+	case RoleStandalone, RoleControl:
+		if f.Providers.ProxiesFO.APIKey == "" {
+			add("providers.proxiesfo.api_key (API_KEY) is required")
+		}
+		if f.Server.BearerToken == "" {
+			add("server.bearer_token (BEARER_TOKEN) is required")
+		}
+	default:
+		add("node.role %q must be standalone, control or agent", f.Node.Role)
+	}
+	if f.Server.Domain == "" {
+		add("server.domain (DOMAIN) is required")
+	}
+
+	if _, port, err := net.SplitHostPort(f.Server.Listen); err != nil {
+		add("server.listen %q: %v", f.Server.Listen, err)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		add("server.listen %q: port must be 1-65535", f.Server.Listen)
+	}
+
+	positive := map[string]time.Duration{
+		"server.shutdown_timeout": f.Server.ShutdownTimeout,
+		"providers.timeout":       f.Providers.Timeout,
+		"prober.interval":         f.Prober.Interval,
+		"idempotency.ttl":         f.Idempotency.TTL,
+		"access_log.interval":     f.AccessLog.Interval,
+		"access_log.retention":    f.AccessLog.Retention,
+		"tls.reload_interval":     f.TLS.ReloadInterval,
+		"webhooks.timeout":        f.Webhooks.Timeout,
+		"webhooks.watch_interval": f.Webhooks.WatchInterval,
+	}
+	for _, name := range sortedKeys(positive) {
+		if positive[name] <= 0 {
+			add("%s must be greater than 0", name)
+		}
+	}
+	if f.Providers.ProxiesFO.ReconcileInterval < 0 {
+		add("providers.proxiesfo.reconcile_interval must be 0 (disabled) or positive")
+	}
+	if f.Providers.Nettify.ReconcileInterval < 0 {
+		add("providers.nettify.reconcile_interval must be 0 (disabled) or positive")
+	}
+	if f.Providers.MaxRetries < 0 {
+		add("providers.max_retries must not be negative")
+	}
+	if f.Providers.RateLimit <= 0 {
+		add("providers.rate_limit must be greater than 0")
+	}
+	if f.Limits.DefaultMaxConn < 1 {
+		add("limits.default_max_conn must be at least 1")
+	}
+	if len(f.TLS.Ports) > 0 {
+		if f.TLS.CertFile == "" || f.TLS.KeyFile == "" {
+			add("tls.cert_file (TLS_CERT_FILE) and tls.key_file (TLS_KEY_FILE) are required when tls.ports is set")
+		}
+		for _, region := range sortedKeys(f.TLS.Ports) {
+			if n := f.TLS.Ports[region]; n < 1 || n > 65535 {
+				add("tls.ports.%s: port must be 1-65535", region)
+			}
+		}
+	}
+	if f.Webhooks.MaxAttempts < 1 {
+		add("webhooks.max_attempts must be at least 1")
+	}
+	if !currencyPattern.MatchString(f.Billing.Currency) {
+		add("billing.currency %q must be a three letter ISO 4217 code, e.g. USD", f.Billing.Currency)
+	}
+	if f.Failover.Threshold < 1 {
+		add("failover.threshold must be at least 1")
+	}
+
+	for name, raw := range map[string]string{
+		"providers.proxiesfo.base_url": f.Providers.ProxiesFO.BaseURL,
+		"providers.nettify.base_url":   f.Providers.Nettify.BaseURL,
+		"prober.target_url":            f.Prober.TargetURL,
+	} {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("%s %q must be an http or https URL", name, raw)
+		}
+	}
+
+	for _, t := range resellerTypes {
+		if f.Providers.ProxiesFO.Resellers[t] == "" {
+			add("providers.proxiesfo.resellers.%s is required", t)
+		}
+	}
+	for _, t := range sortedKeys(f.Providers.ProxiesFO.Resellers) {
+		if id := f.Providers.ProxiesFO.Resellers[t]; !uuidPattern.MatchString(strings.ToLower(id)) {
+			add("providers.proxiesfo.resellers.%s %q is not a UUID", t, id)
+		}
+	}
+
+	for i, c := range f.Prober.Canaries {
+		if err := checkUpstreamCred(c); err != nil {
+			add("prober.canaries[%d]: %v", i, err)
+		}
+	}
+	for _, region := range sortedKeys(f.Failover.Standby) {
+		if err := checkUpstreamCred(f.Failover.Standby[region]); err != nil {
+			add("failover.standby.%s: %v", region, err)
+		}
+	}
+
+	for name, p := range map[string]string{
+		"paths.data_dir":            f.Paths.DataDir,
+		"paths.proxy_log":           f.Paths.ProxyLog,
+		"paths.nginx_update_script": f.Paths.NginxUpdateScript,
+		"paths.proxy_config_dir":    f.Paths.ProxyConfigDir,
+		"paths.nginx_stream_dir":    f.Paths.NginxStreamDir,
+		"access_log.dir":            f.AccessLog.Dir,
+	} {
+		if !filepath.IsAbs(p) {
+			add("%s %q must be an absolute path", name, p)
+		}
+	}
+
+	if f.Paths.TopologyFile != "" && !exists(f.Paths.TopologyFile) {
+		add("paths.topology_file %q does not exist", f.Paths.TopologyFile)
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// warnings returns problems that only affect some features, so the API can
+// still start: missing scripts and files.
+func (f *File) warnings() []string {
+	var warnings []string
+	if p := filepath.Join(f.Paths.ScriptsDir, "create_proxy_plan.sh"); !exists(p) {
+		warnings = append(warnings, fmt.Sprintf("script %s not found, proxy listeners cannot be spawned", p))
+	}
+	if p := f.Paths.NginxUpdateScript; !exists(p) {
+		warnings = append(warnings, fmt.Sprintf("script %s not found, nginx upstreams will not be updated", p))
+	}
+	if len(f.TLS.Ports) > 0 {
+		for _, p := range []string{f.TLS.CertFile, f.TLS.KeyFile} {
+			if p != "" && !exists(p) {
+				warnings = append(warnings, fmt.Sprintf("%s not found, TLS endpoints will not start", p))
+			}
+		}
+	}
+	if f.Providers.Nettify.APIKey == "" {
+		warnings = append(warnings, "providers.nettify.api_key (NETTIFY_API_KEY) is not set, Nettify provider will not work")
+	}
+	return warnings
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// checkUpstreamCred validates a host:port:user:pass value
+func checkUpstreamCred(raw string) error {
+	parts := strings.SplitN(raw, ":", 4)
+	if len(parts) != 4 || parts[0] == "" {
+		return fmt.Errorf("expected host:port:user:pass")
+	}
+	if n, err := strconv.Atoi(parts[1]); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", parts[1])
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
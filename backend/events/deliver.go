@@ -0,0 +1,354 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/jobs"
+)
+
+// Headers of every webhook request
+const (
+	SignatureHeader = "X-OceanProxy-Signature" // t=<unix>,v1=<hex HMAC-SHA256 of "<unix>.<body>">
+	EventHeader     = "X-OceanProxy-Event"
+	DeliveryHeader  = "X-OceanProxy-Delivery"
+)
+
+const (
+	queueFile    = "webhook_queue.json" // in config.DataDir, deliveries not yet made
+	deadFile     = "webhook_dead.json"  // in config.DataDir, deliveries that gave up
+	keepDead     = 1000
+	pollInterval = 2 * time.Second
+	maxParallel  = 8
+)
+
+// Delivery is one event on its way to one subscription
+type Delivery struct {
+	ID           string     `json:"id"`
+	Subscription string     `json:"subscription"`
+	URL          string     `json:"url"`
+	Event        Event      `json:"event"`
+	Attempts     int        `json:"attempts"`
+	NextAttempt  time.Time  `json:"next_attempt"`
+	LastStatus   int        `json:"last_status,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	DeadAt       *time.Time `json:"dead_at,omitempty"`
+}
+
+var (
+	queueMutex  sync.Mutex
+	queue       []Delivery
+	dead        []Delivery
+	inFlight    = make(map[string]bool)
+	queueLoaded bool
+	startOnce   sync.Once
+)
+
+// retryDelay is the wait after a failed attempt: 10s, 30s, 90s, ... up to an hour
+var retryDelay = func(attempts int) time.Duration {
+	d := 10 * time.Second
+	for i := 1; i < attempts && d < time.Hour; i++ {
+		d *= 3
+	}
+	if d > time.Hour {
+		d = time.Hour
+	}
+	return d
+}
+
+// Start launches webhook delivery and the lifecycle watcher
+func Start() {
+	startOnce.Do(func() {
+		jobs.Every("webhooks", func() time.Duration { return pollInterval }, deliverDue)
+		jobs.Every("event-watch", func() time.Duration { return config.Get().EventWatchInterval }, Watch)
+		log.Printf("📣 Event bus started (webhooks retried %d times, watch every %s)", config.Get().WebhookMaxAttempts, config.Get().EventWatchInterval)
+	})
+}
+
+// loadQueue reads the persisted queue once; queueMutex must be held
+func loadQueue() {
+	if queueLoaded {
+		return
+	}
+	queueLoaded = true
+	for name, list := range map[string]*[]Delivery{queueFile: &queue, deadFile: &dead} {
+		data, err := os.ReadFile(filepath.Join(config.DataDir, name))
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(data, list); err != nil {
+			log.Printf("⚠️ Ignoring invalid %s: %v", name, err)
+		}
+	}
+}
+
+// saveQueue persists the queue and dead letters; queueMutex must be held
+func saveQueue() {
+	if err := writeJSON(queueFile, queue); err != nil {
+		log.Printf("⚠️ Failed to save webhook queue: %v", err)
+	}
+	if err := writeJSON(deadFile, dead); err != nil {
+		log.Printf("⚠️ Failed to save webhook dead letters: %v", err)
+	}
+}
+
+func enqueue(e Event, subs []Subscription) {
+	if len(subs) == 0 {
+		return
+	}
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	loadQueue()
+	for _, s := range subs {
+		queue = append(queue, Delivery{
+			ID:           "dlv_" + randomHex(8),
+			Subscription: s.ID,
+			URL:          s.URL,
+			Event:        e,
+			NextAttempt:  e.CreatedAt,
+		})
+	}
+	saveQueue()
+}
+
+func dropPending(subscription string) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	loadQueue()
+	kept := queue[:0]
+	for _, d := range queue {
+		if d.Subscription != subscription {
+			kept = append(kept, d)
+		}
+	}
+	queue = kept
+	saveQueue()
+}
+
+// Pending returns the deliveries still to be made, next due first
+func Pending() []Delivery {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	loadQueue()
+	out := append([]Delivery{}, queue...)
+	sortDeliveries(out)
+	return out
+}
+
+// DeadLetters returns the deliveries that gave up, newest first
+func DeadLetters() []Delivery {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	loadQueue()
+	out := make([]Delivery, 0, len(dead))
+	for i := len(dead) - 1; i >= 0; i-- {
+		out = append(out, dead[i])
+	}
+	return out
+}
+
+// Retry moves a dead letter back into the queue with fresh attempts
+func Retry(id string) (Delivery, error) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	loadQueue()
+	for i, d := range dead {
+		if d.ID != id {
+			continue
+		}
+		dead = append(dead[:i], dead[i+1:]...)
+		d.Attempts, d.DeadAt, d.NextAttempt = 0, nil, time.Now()
+		queue = append(queue, d)
+		saveQueue()
+		return d, nil
+	}
+	return Delivery{}, fmt.Errorf("dead letter %s not found", id)
+}
+
+// deliverDue sends every delivery whose next attempt has come
+func deliverDue() {
+	now := time.Now()
+	queueMutex.Lock()
+	loadQueue()
+	var due []Delivery
+	for _, d := range queue {
+		if !inFlight[d.ID] && !d.NextAttempt.After(now) {
+			inFlight[d.ID] = true
+			due = append(due, d)
+		}
+	}
+	queueMutex.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	subs, err := Subscriptions()
+	if err != nil {
+		log.Printf("⚠️ Webhook delivery skipped: %v", err)
+		queueMutex.Lock()
+		for _, d := range due {
+			delete(inFlight, d.ID)
+		}
+		queueMutex.Unlock()
+		return
+	}
+	secrets := make(map[string]string)
+	for _, s := range subs {
+		secrets[s.ID] = s.Secret
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxParallel)
+	for _, d := range due {
+		wg.Add(1)
+		go func(d Delivery) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			secret, ok := secrets[d.Subscription]
+			if !ok {
+				finish(d, 0, errUnsubscribed)
+				return
+			}
+			status, err := send(d, secret)
+			finish(d, status, err)
+		}(d)
+	}
+	wg.Wait()
+}
+
+var errUnsubscribed = errors.New("subscription was removed")
+
+// send POSTs the event and returns the response status
+func send(d Delivery, secret string) (int, error) {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.Get().WebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "OceanProxy-Webhooks/1")
+	req.Header.Set(EventHeader, d.Event.Type)
+	req.Header.Set(DeliveryHeader, d.ID)
+	req.Header.Set(SignatureHeader, Sign(secret, time.Now(), body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// finish records the outcome of an attempt. Client errors other than 408 and
+// 429 are not retried.
+func finish(d Delivery, status int, err error) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	delete(inFlight, d.ID)
+
+	idx := -1
+	for i := range queue {
+		if queue[i].ID == d.ID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return // dropped while in flight
+	}
+	if err == nil {
+		queue = append(queue[:idx], queue[idx+1:]...)
+		saveQueue()
+		return
+	}
+
+	q := &queue[idx]
+	q.Attempts++
+	q.LastStatus = status
+	q.LastError = err.Error()
+	permanent := errors.Is(err, errUnsubscribed) ||
+		(status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests)
+	if permanent || q.Attempts >= config.Get().WebhookMaxAttempts {
+		now := time.Now().UTC()
+		q.DeadAt = &now
+		dead = append(dead, *q)
+		if len(dead) > keepDead {
+			dead = dead[len(dead)-keepDead:]
+		}
+		log.Printf("💀 Webhook %s of %s to %s dead after %d attempt(s): %v", d.ID, d.Event.Type, d.URL, q.Attempts, err)
+		queue = append(queue[:idx], queue[idx+1:]...)
+	} else {
+		q.NextAttempt = time.Now().Add(retryDelay(q.Attempts))
+		log.Printf("⚠️ Webhook %s of %s to %s failed (attempt %d), retrying at %s: %v", d.ID, d.Event.Type, d.URL, q.Attempts, q.NextAttempt.Format(time.RFC3339), err)
+	}
+	saveQueue()
+}
+
+// Sign returns the signature header for body sent at t
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a signature header against body and rejects
+// timestamps further than tolerance from now, for receivers written in Go
+func VerifySignature(secret, header string, body []byte, tolerance time.Duration) error {
+	var ts int64
+	var sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts, _ = strconv.ParseInt(v, 10, 64)
+		case "v1":
+			sig = v
+		}
+	}
+	if ts == 0 || sig == "" {
+		return errors.New("malformed signature header")
+	}
+	t := time.Unix(ts, 0)
+	if d := time.Since(t); d > tolerance || d < -tolerance {
+		return errors.New("signature timestamp outside tolerance")
+	}
+	want := Sign(secret, t, body)
+	if !hmac.Equal([]byte(want[strings.Index(want, "v1=")+3:]), []byte(sig)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// sortDeliveries orders deliveries by their next attempt
+func sortDeliveries(list []Delivery) {
+	sort.SliceStable(list, func(i, j int) bool { return list[i].NextAttempt.Before(list[j].NextAttempt) })
+}
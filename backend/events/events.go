@@ -0,0 +1,259 @@
+// Package events is the API's event bus. Plan lifecycle changes are emitted
+// as events and delivered to webhook subscriptions as HMAC-signed POSTs,
+// retried with backoff and dead-lettered after WEBHOOK_MAX_ATTEMPTS.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/proxy"
+)
+
+// Event types
+const (
+	PlanCreated    = "plan.created"
+	PlanExpired    = "plan.expired"
+	PlanDeleted    = "plan.deleted"
+	PlanRestored   = "plan.restored"
+	ListenerDown   = "listener.down"
+	QuotaExhausted = "quota.exhausted"
+
+	// Ping is only sent to the subscription it was asked for
+	Ping = "webhook.ping"
+)
+
+// Types are the events a subscription can ask for
+var Types = []string{PlanCreated, PlanExpired, PlanDeleted, PlanRestored, ListenerDown, QuotaExhausted}
+
+const subscriptionsFile = "webhooks.json" // in config.DataDir
+
+// Event is one thing that happened, as sent in a webhook body
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Subscription receives the events it lists, or every event if none
+type Subscription struct {
+	ID          string   `json:"id"`
+	URL         string   `json:"url"`
+	Secret      string   `json:"secret"` // HMAC key of the signature header
+	Events      []string `json:"events,omitempty"`
+	Description string   `json:"description,omitempty"`
+	CreatedAt   int64    `json:"created_at"`
+}
+
+// Wants reports whether the subscription receives events of type t
+func (s Subscription) Wants(t string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Masked returns the subscription with its secret hidden
+func (s Subscription) Masked() Subscription {
+	s.Secret = config.MaskString(s.Secret)
+	return s
+}
+
+var subsMutex sync.Mutex
+
+// Subscriptions returns every subscription sorted by creation
+func Subscriptions() ([]Subscription, error) {
+	subsMutex.Lock()
+	defer subsMutex.Unlock()
+	return loadSubscriptions()
+}
+
+func loadSubscriptions() ([]Subscription, error) {
+	data, err := os.ReadFile(filepath.Join(config.DataDir, subscriptionsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Subscription{}, nil
+		}
+		return nil, err
+	}
+	var subs []Subscription
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return nil, fmt.Errorf("%s is not valid: %w", subscriptionsFile, err)
+	}
+	sort.SliceStable(subs, func(i, j int) bool { return subs[i].CreatedAt < subs[j].CreatedAt })
+	return subs, nil
+}
+
+func saveSubscriptions(subs []Subscription) error {
+	return writeJSON(subscriptionsFile, subs)
+}
+
+// Subscribe validates and stores a new subscription. A secret is generated
+// when none is given.
+func Subscribe(s Subscription) (Subscription, error) {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return s, fmt.Errorf("url must be an http or https URL")
+	}
+	for _, t := range s.Events {
+		if !known(t) {
+			return s, fmt.Errorf("unknown event %q, expected one of %s", t, strings.Join(Types, ", "))
+		}
+	}
+	if s.Secret == "" {
+		s.Secret = "whsec_" + randomHex(24)
+	} else if len(s.Secret) < 16 {
+		return s, fmt.Errorf("secret must be at least 16 characters")
+	}
+	s.ID = "wh_" + randomHex(8)
+	s.CreatedAt = time.Now().Unix()
+
+	subsMutex.Lock()
+	defer subsMutex.Unlock()
+	subs, err := loadSubscriptions()
+	if err != nil {
+		return s, err
+	}
+	return s, saveSubscriptions(append(subs, s))
+}
+
+// Unsubscribe removes a subscription. Its pending deliveries are dropped.
+func Unsubscribe(id string) error {
+	subsMutex.Lock()
+	subs, err := loadSubscriptions()
+	if err != nil {
+		subsMutex.Unlock()
+		return err
+	}
+	kept := subs[:0]
+	for _, s := range subs {
+		if s.ID != id {
+			kept = append(kept, s)
+		}
+	}
+	if len(kept) == len(subs) {
+		subsMutex.Unlock()
+		return fmt.Errorf("webhook %s not found", id)
+	}
+	err = saveSubscriptions(kept)
+	subsMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	dropPending(id)
+	return nil
+}
+
+func known(t string) bool {
+	for _, k := range Types {
+		if k == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit queues an event for every subscription that wants it. It never
+// blocks on delivery.
+func Emit(t string, data interface{}) Event {
+	e := Event{ID: "evt_" + randomHex(12), Type: t, CreatedAt: time.Now().UTC(), Data: data}
+	subs, err := Subscriptions()
+	if err != nil {
+		log.Printf("⚠️ Event %s not delivered: %v", t, err)
+		return e
+	}
+	var targets []Subscription
+	for _, s := range subs {
+		if s.Wants(t) {
+			targets = append(targets, s)
+		}
+	}
+	log.Printf("📣 Event %s %s to %d webhook(s)", t, e.ID, len(targets))
+	enqueue(e, targets)
+	return e
+}
+
+// SendPing queues a webhook.ping event for one subscription
+func SendPing(id string) (Event, error) {
+	subs, err := Subscriptions()
+	if err != nil {
+		return Event{}, err
+	}
+	for _, s := range subs {
+		if s.ID == id {
+			e := Event{ID: "evt_" + randomHex(12), Type: Ping, CreatedAt: time.Now().UTC(), Data: map[string]string{"webhook": id}}
+			enqueue(e, []Subscription{s})
+			return e, nil
+		}
+	}
+	return Event{}, fmt.Errorf("webhook %s not found", id)
+}
+
+// PlanData is the payload of plan events: what identifies the plan and its
+// endpoints, without the customer's password
+func PlanData(entries []proxy.Entry) map[string]interface{} {
+	if len(entries) == 0 {
+		return map[string]interface{}{}
+	}
+	first := entries[0]
+	var endpoints []map[string]interface{}
+	for _, e := range entries {
+		ep := map[string]interface{}{
+			"subdomain": e.Subdomain,
+			"host":      e.LocalHost,
+			"port":      e.PublicPort,
+		}
+		if e.Node != "" {
+			ep["node"] = e.Node
+		}
+		endpoints = append(endpoints, ep)
+	}
+	return map[string]interface{}{
+		"plan_id":    first.PlanID,
+		"username":   first.Username,
+		"customer":   first.Customer,
+		"product":    first.Product,
+		"created_at": first.CreatedAt,
+		"expires_at": first.ExpiresAt,
+		"endpoints":  endpoints,
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// writeJSON saves state in config.DataDir, readable only by the API
+func writeJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(config.DataDir, name)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
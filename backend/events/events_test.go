@@ -0,0 +1,156 @@
+package events
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/proxy"
+)
+
+func setup(t *testing.T) {
+	t.Helper()
+	config.DataDir = t.TempDir()
+	config.ProxyLogPath = config.DataDir + "/proxies.json"
+	config.Set(func(s *config.Settings) {
+		s.WebhookTimeout = 5 * time.Second
+		s.WebhookMaxAttempts = 3
+	})
+	queueMutex.Lock()
+	queue, dead, queueLoaded = nil, nil, false
+	queueMutex.Unlock()
+	retryDelay = func(int) time.Duration { return 0 }
+}
+
+func TestDeliverySignedRetriedAndDeadLettered(t *testing.T) {
+	setup(t)
+
+	var mu sync.Mutex
+	var statuses = []int{500, 200}
+	var got []string
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, r.Header.Get(EventHeader))
+		if err := VerifySignature("0123456789abcdef", r.Header.Get(SignatureHeader), body, time.Minute); err != nil {
+			t.Errorf("signature: %v", err)
+		}
+		w.WriteHeader(statuses[0])
+		statuses = statuses[1:]
+	}))
+	defer ok.Close()
+	gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer gone.Close()
+
+	if _, err := Subscribe(Subscription{URL: ok.URL, Secret: "0123456789abcdef", Events: []string{PlanCreated}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Subscribe(Subscription{URL: gone.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Subscribe(Subscription{URL: ok.URL, Events: []string{"plan.bogus"}}); err == nil {
+		t.Error("unknown event type accepted")
+	}
+
+	Emit(PlanDeleted, map[string]string{"plan_id": "p0"}) // only the catch-all wants it
+	Emit(PlanCreated, PlanData([]proxy.Entry{{PlanID: "p1", Subdomain: "usa"}}))
+	if n := len(Pending()); n != 3 {
+		t.Fatalf("%d pending deliveries, want 3", n)
+	}
+
+	deliverDue() // 500 for the first, 410 for both of the other endpoint's
+	deliverDue() // 200
+	if p := Pending(); len(p) != 0 {
+		t.Errorf("still pending: %+v", p)
+	}
+	if len(got) != 2 || got[0] != PlanCreated || got[1] != PlanCreated {
+		t.Errorf("deliveries received %v, want two attempts of %s", got, PlanCreated)
+	}
+
+	deadLetters := DeadLetters()
+	if len(deadLetters) != 2 || deadLetters[0].LastStatus != http.StatusGone || deadLetters[0].Attempts != 1 {
+		t.Fatalf("dead letters %+v, want two 410s after one attempt", deadLetters)
+	}
+	if _, err := Retry(deadLetters[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(Pending()) != 1 || len(DeadLetters()) != 1 {
+		t.Error("retry did not move the dead letter back to the queue")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+	now := time.Now()
+	header := Sign("secret-secret-secret", now, body)
+	if err := VerifySignature("secret-secret-secret", header, body, time.Minute); err != nil {
+		t.Errorf("valid signature rejected: %v", err)
+	}
+	if err := VerifySignature("other-secret-value", header, body, time.Minute); err == nil {
+		t.Error("wrong secret accepted")
+	}
+	if err := VerifySignature("secret-secret-secret", header, []byte(`{}`), time.Minute); err == nil {
+		t.Error("changed body accepted")
+	}
+	old := Sign("secret-secret-secret", now.Add(-time.Hour), body)
+	if err := VerifySignature("secret-secret-secret", old, body, time.Minute); err == nil {
+		t.Error("stale timestamp accepted")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	setup(t)
+	if _, err := Subscribe(Subscription{URL: "http://127.0.0.1:1/hook"}); err != nil {
+		t.Fatal(err)
+	}
+	orig := listening
+	defer func() { listening = orig }()
+	down := map[int]bool{10002: true}
+	listening = func(port int) bool { return !down[port] }
+
+	past, future := time.Now().Add(-time.Hour).Unix(), time.Now().Add(time.Hour).Unix()
+	writeStore(t,
+		proxy.Entry{PlanID: "old", Subdomain: "usa", LocalPort: 10001, ExpiresAt: past},
+		proxy.Entry{PlanID: "live", Subdomain: "usa", LocalPort: 10002, ExpiresAt: future},
+	)
+
+	types := func() map[string]int {
+		n := make(map[string]int)
+		for _, d := range Pending() {
+			n[d.Event.Type]++
+		}
+		return n
+	}
+
+	Watch() // first run only records
+	if n := len(Pending()); n != 0 {
+		t.Fatalf("first run emitted %d event(s)", n)
+	}
+
+	// A plan that expires from now on is reported once
+	writeStore(t,
+		proxy.Entry{PlanID: "old", Subdomain: "usa", LocalPort: 10001, ExpiresAt: past},
+		proxy.Entry{PlanID: "live", Subdomain: "usa", LocalPort: 10002, ExpiresAt: future},
+		proxy.Entry{PlanID: "new", Subdomain: "eu", LocalPort: 12001, ExpiresAt: past},
+	)
+	Watch() // live has now failed twice
+	Watch()
+	if got := types(); got[PlanExpired] != 1 || got[ListenerDown] != 1 || len(got) != 2 {
+		t.Errorf("events %v, want one plan.expired and one listener.down", got)
+	}
+}
+
+// writeStore replaces the proxy log with entries
+func writeStore(t *testing.T, entries ...proxy.Entry) {
+	t.Helper()
+	if err := proxy.UpdateProxyLog(func([]proxy.Entry) ([]proxy.Entry, error) { return entries, nil }); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,199 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"oceanproxy-api/accesslog"
+	"oceanproxy-api/config"
+	"oceanproxy-api/nodes"
+	"oceanproxy-api/proxy"
+)
+
+const watchStateFile = "event_watch.json" // in config.DataDir
+
+// A listener must fail this many checks in a row before listener.down, so
+// restarts for failover or new limits do not count
+const downChecks = 2
+
+// watchState remembers what was already reported so events fire once
+type watchState struct {
+	Expired   map[string]bool `json:"expired"`   // plans whose plan.expired was sent
+	Exhausted map[string]bool `json:"exhausted"` // plans whose quota.exhausted was sent
+	Down      map[string]int  `json:"down"`      // plan-subdomain -> failed checks in a row
+}
+
+var watchMutex sync.Mutex
+
+// listening checks whether a local port accepts connections; replaced in tests
+var listening = func(port int) bool {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// Watch checks the plan store for plans that expired, listeners that went
+// down and quotas that were used up, and emits each once. The first run on a
+// host only records the current state.
+func Watch() {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		log.Printf("⚠️ Event watch skipped: %v", err)
+		return
+	}
+	st, seeded := loadWatchState()
+	emit := func(t string, data interface{}) {
+		if seeded {
+			Emit(t, data)
+		}
+	}
+
+	now := time.Now()
+	byPlan := make(map[string][]proxy.Entry)
+	var order []string
+	for _, e := range entries {
+		if byPlan[e.PlanID] == nil {
+			order = append(order, e.PlanID)
+		}
+		byPlan[e.PlanID] = append(byPlan[e.PlanID], e)
+	}
+
+	running := nodeListeners(entries, now)
+	for _, planID := range order {
+		plan := byPlan[planID]
+		first := plan[0]
+		if first.ExpiresAt != 0 && first.ExpiresAt < now.Unix() {
+			if !st.Expired[planID] {
+				st.Expired[planID] = true
+				emit(PlanExpired, PlanData(plan))
+			}
+			for _, e := range plan {
+				delete(st.Down, e.PlanID+"-"+e.Subdomain)
+			}
+			continue
+		}
+		delete(st.Expired, planID) // extended
+
+		for _, e := range plan {
+			key := e.PlanID + "-" + e.Subdomain
+			var up bool
+			var reason string
+			if e.Node == "" {
+				up, reason = listening(e.LocalPort), "port not listening"
+			} else if r, ok := running[e.Node]; !ok {
+				up, reason = false, "node unreachable"
+			} else {
+				up, reason = r[key], "not running on node"
+			}
+			if up {
+				delete(st.Down, key)
+				continue
+			}
+			st.Down[key]++
+			if st.Down[key] == downChecks {
+				emit(ListenerDown, map[string]interface{}{
+					"plan_id":    e.PlanID,
+					"username":   e.Username,
+					"customer":   e.Customer,
+					"subdomain":  e.Subdomain,
+					"local_port": e.LocalPort,
+					"node":       e.Node,
+					"reason":     reason,
+				})
+			}
+		}
+
+		if first.QuotaBytes > 0 {
+			s, err := nodes.PlanUsage(plan, accesslog.Query{PlanID: planID, Since: time.Unix(first.CreatedAt, 0)})
+			if err != nil {
+				log.Printf("⚠️ Quota check of plan %s skipped: %v", planID, err)
+				continue
+			}
+			used := s.BytesIn + s.BytesOut
+			switch {
+			case used >= first.QuotaBytes && !st.Exhausted[planID]:
+				st.Exhausted[planID] = true
+				data := PlanData(plan)
+				data["quota_bytes"] = first.QuotaBytes
+				data["used_bytes"] = used
+				emit(QuotaExhausted, data)
+			case used < first.QuotaBytes:
+				delete(st.Exhausted, planID) // quota raised
+			}
+		}
+	}
+
+	// Forget deleted plans
+	for planID := range st.Expired {
+		if byPlan[planID] == nil {
+			delete(st.Expired, planID)
+		}
+	}
+	for planID := range st.Exhausted {
+		if byPlan[planID] == nil {
+			delete(st.Exhausted, planID)
+		}
+	}
+	if err := writeJSON(watchStateFile, st); err != nil {
+		log.Printf("⚠️ Failed to save event watch state: %v", err)
+	}
+}
+
+// nodeListeners asks each edge node with active plans which listeners run,
+// keyed by node and then plan-subdomain. Unreachable nodes are left out.
+func nodeListeners(entries []proxy.Entry, now time.Time) map[string]map[string]bool {
+	out := make(map[string]map[string]bool)
+	asked := make(map[string]bool)
+	for _, e := range entries {
+		if e.Node == "" || asked[e.Node] || (e.ExpiresAt != 0 && e.ExpiresAt < now.Unix()) {
+			continue
+		}
+		asked[e.Node] = true
+		n, err := nodes.Get(e.Node)
+		if err != nil {
+			continue
+		}
+		s, err := n.Status()
+		if err != nil {
+			log.Printf("⚠️ Node %s did not answer: %v", n.ID, err)
+			continue
+		}
+		run := make(map[string]bool)
+		for _, l := range s.Listeners {
+			run[l.PlanID+"-"+l.Subdomain] = l.Running
+		}
+		out[n.ID] = run
+	}
+	return out
+}
+
+// loadWatchState returns the saved state and whether there was one
+func loadWatchState() (*watchState, bool) {
+	st := &watchState{}
+	seeded := false
+	if data, err := os.ReadFile(filepath.Join(config.DataDir, watchStateFile)); err == nil {
+		seeded = json.Unmarshal(data, st) == nil
+	}
+	if st.Expired == nil {
+		st.Expired = make(map[string]bool)
+	}
+	if st.Exhausted == nil {
+		st.Exhausted = make(map[string]bool)
+	}
+	if st.Down == nil {
+		st.Down = make(map[string]int)
+	}
+	return st, seeded
+}
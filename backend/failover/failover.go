@@ -0,0 +1,276 @@
+package failover
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/jobs"
+	"oceanproxy-api/prober"
+	"oceanproxy-api/proxy"
+)
+
+const (
+	stateFile = "failover.json" // in config.DataDir
+	maxEvents = 1000
+)
+
+// Event records a listener switching between its primary upstream and a standby
+type Event struct {
+	Time      int64  `json:"time"`
+	PlanID    string `json:"plan_id"`
+	Subdomain string `json:"subdomain"`
+	Direction string `json:"direction"` // "failover" or "failback"
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reason    string `json:"reason"`
+}
+
+// Policy is the standby upstream configured for a region
+type Policy struct {
+	Region  string `json:"region"`
+	Standby string `json:"standby"` // host:port, credentials are never exposed
+}
+
+type state struct {
+	// Listeners currently routed through a standby, keyed by plan_id/subdomain
+	Active map[string]string `json:"active"`
+	Events []Event           `json:"events"`
+}
+
+// Probe results and listener respawns, replaced in tests
+var (
+	hostStatus      = prober.HostStatus
+	spawn           = proxy.Spawn3proxy
+	spawnWithParent = proxy.SpawnWithParent
+)
+
+var (
+	mu        sync.Mutex
+	current   state
+	policies  map[string]prober.Canary
+	startOnce sync.Once
+)
+
+// Start loads the failover policies from config and begins watching upstream
+// health. Evaluation is a no-op while no region has a standby configured.
+func Start() {
+	startOnce.Do(func() {
+		n := loadPolicies()
+		loadState()
+		jobs.Every("failover", func() time.Duration { return config.Get().ProbeInterval }, Evaluate)
+		if n > 0 {
+			log.Printf("🔀 Upstream failover enabled for %d region(s)", n)
+		}
+	})
+}
+
+// Reload replaces the policies with the standbys currently in config. Listeners
+// already on a standby stay there until their primary recovers.
+func Reload() {
+	n := loadPolicies()
+	log.Printf("🔀 Failover policies reloaded (%d region(s))", n)
+}
+
+func loadPolicies() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	policies = make(map[string]prober.Canary)
+	for region, raw := range config.Get().FailoverStandby {
+		c, err := prober.ParseCanary(raw)
+		if err != nil {
+			log.Printf("⚠️ Ignoring failover standby for %s: %v", region, err)
+			continue
+		}
+		policies[region] = c
+		prober.Watch(c)
+	}
+	return len(policies)
+}
+
+// Evaluate switches listeners whose primary upstream has failed enough
+// consecutive probes to the region's standby, if the standby has passed as
+// many, and switches them back once the primary has recovered for the same
+// number of probes. Upstreams without probe results are never acted on.
+func Evaluate() {
+	mu.Lock()
+	idle := len(policies) == 0 && len(current.Active) == 0
+	mu.Unlock()
+	if idle {
+		return
+	}
+
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		log.Printf("⚠️ Failover check skipped, cannot read proxy log: %v", err)
+		return
+	}
+
+	threshold := config.Get().FailoverThreshold
+	now := time.Now().Unix()
+
+	for _, e := range entries {
+		if e.ExpiresAt != 0 && e.ExpiresAt < now {
+			continue
+		}
+
+		mu.Lock()
+		standby, hasPolicy := policies[e.Subdomain]
+		activeStandby, onStandby := current.Active[key(e)]
+		mu.Unlock()
+		if !hasPolicy {
+			if onStandby {
+				// The standby was removed by a config reload, go back to the primary
+				if err := spawn(e); err != nil {
+					log.Printf("❌ Failback for %s failed: %v", key(e), err)
+					continue
+				}
+				recordSwitch(e, "failback", activeStandby, upstreamOf(e), "standby removed from config")
+			}
+			continue
+		}
+
+		primaryOK, streak, known := hostStatus(e.AuthHost)
+		if !known || streak < threshold {
+			continue
+		}
+
+		switch {
+		case !primaryOK && !onStandby:
+			standbyOK, standbyStreak, standbyKnown := hostStatus(standby.Host)
+			if !standbyKnown || !standbyOK || standbyStreak < threshold {
+				continue // no point switching to an upstream not proven up
+			}
+			if err := spawnWithParent(e, standby.Host, standby.Port, standby.Username, standby.Password); err != nil {
+				log.Printf("❌ Failover for %s failed: %v", key(e), err)
+				continue
+			}
+			recordSwitch(e, "failover", upstreamOf(e), standby.Key(), "primary upstream failing probes")
+		case primaryOK && onStandby:
+			if err := spawn(e); err != nil {
+				log.Printf("❌ Failback for %s failed: %v", key(e), err)
+				continue
+			}
+			recordSwitch(e, "failback", standby.Key(), upstreamOf(e), "primary upstream recovered")
+		}
+	}
+}
+
+func recordSwitch(e proxy.Entry, direction, from, to, reason string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if direction == "failover" {
+		current.Active[key(e)] = to
+	} else {
+		delete(current.Active, key(e))
+	}
+
+	current.Events = append(current.Events, Event{
+		Time:      time.Now().Unix(),
+		PlanID:    e.PlanID,
+		Subdomain: e.Subdomain,
+		Direction: direction,
+		From:      from,
+		To:        to,
+		Reason:    reason,
+	})
+	if len(current.Events) > maxEvents {
+		current.Events = current.Events[len(current.Events)-maxEvents:]
+	}
+
+	log.Printf("🔀 %s %s: %s -> %s (%s)", direction, key(e), from, to, reason)
+	saveState()
+}
+
+// Respawn starts the listener for e on whichever upstream it is currently
+// assigned to, so a restore does not silently undo an active failover.
+func Respawn(e proxy.Entry) error {
+	mu.Lock()
+	standby, hasPolicy := policies[e.Subdomain]
+	_, onStandby := current.Active[key(e)]
+	mu.Unlock()
+
+	if hasPolicy && onStandby {
+		return spawnWithParent(e, standby.Host, standby.Port, standby.Username, standby.Password)
+	}
+	return spawn(e)
+}
+
+// Status returns the configured policies, the listeners currently on a standby
+// and the most recent switch events, newest first.
+func Status() map[string]interface{} {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var list []Policy
+	for region, c := range policies {
+		list = append(list, Policy{Region: region, Standby: c.Key()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Region < list[j].Region })
+
+	events := make([]Event, len(current.Events))
+	for i, ev := range current.Events {
+		events[len(events)-1-i] = ev
+	}
+
+	active := make(map[string]string, len(current.Active))
+	for k, v := range current.Active {
+		active[k] = v
+	}
+
+	return map[string]interface{}{
+		"policies": list,
+		"active":   active,
+		"events":   events,
+	}
+}
+
+// ReloadState re-reads the failover state from disk, e.g. after a backup
+// was restored over it
+func ReloadState() {
+	mu.Lock()
+	current = state{}
+	mu.Unlock()
+	loadState()
+}
+
+func loadState() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if data, err := os.ReadFile(filepath.Join(config.DataDir, stateFile)); err == nil {
+		if err := json.Unmarshal(data, &current); err != nil {
+			log.Printf("⚠️ Failed to parse failover state: %v", err)
+		}
+	}
+	if current.Active == nil {
+		current.Active = make(map[string]string)
+	}
+}
+
+// saveState persists the failover state. Callers hold mu.
+func saveState() {
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return
+	}
+	_ = os.MkdirAll(config.DataDir, 0755)
+	if err := os.WriteFile(filepath.Join(config.DataDir, stateFile), data, 0644); err != nil {
+		log.Printf("⚠️ Failed to save failover state: %v", err)
+	}
+}
+
+func key(e proxy.Entry) string {
+	return e.PlanID + "/" + e.Subdomain
+}
+
+func upstreamOf(e proxy.Entry) string {
+	return prober.Canary{Host: e.AuthHost, Port: e.AuthPort}.Key()
+}
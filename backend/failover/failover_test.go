@@ -0,0 +1,82 @@
+package failover
+
+import (
+	"path/filepath"
+	"testing"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/prober"
+	"oceanproxy-api/proxy"
+)
+
+type status struct {
+	ok     bool
+	streak int
+	known  bool
+}
+
+func TestEvaluate(t *testing.T) {
+	dir := t.TempDir()
+	config.DataDir = dir
+	config.ProxyLogPath = filepath.Join(dir, "proxies.json")
+	config.Set(func(s *config.Settings) { s.FailoverThreshold = 3 })
+	if err := proxy.LogProxy(proxy.Entry{PlanID: "p1", Subdomain: "usa", AuthHost: "primary.example", AuthPort: 8000}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		hostStatus, spawn, spawnWithParent = prober.HostStatus, proxy.Spawn3proxy, proxy.SpawnWithParent
+		policies, current = nil, state{}
+	})
+
+	var (
+		up      = status{ok: true, streak: 3, known: true}
+		down    = status{ok: false, streak: 3, known: true}
+		unknown = status{}
+	)
+	for _, tc := range []struct {
+		name      string
+		primary   status
+		standby   status
+		onStandby bool
+		want      string // "failover", "failback" or "" for no switch
+	}{
+		{"primary up", up, up, false, ""},
+		{"primary down, standby up", down, up, false, "failover"},
+		{"primary down, standby down", down, down, false, ""},
+		{"primary down, standby never probed", down, unknown, false, ""},
+		{"primary down, standby up too briefly", down, status{ok: true, streak: 1, known: true}, false, ""},
+		{"primary down too briefly", status{ok: false, streak: 2, known: true}, up, false, ""},
+		{"primary never probed", unknown, up, false, ""},
+		{"primary down, already on standby", down, up, true, ""},
+		{"primary recovered", up, up, true, "failback"},
+		{"primary recovered too briefly", status{ok: true, streak: 1, known: true}, up, true, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			policies = map[string]prober.Canary{"usa": {Host: "standby.example", Port: 9000}}
+			current = state{Active: map[string]string{}}
+			if tc.onStandby {
+				current.Active["p1/usa"] = "standby.example:9000"
+			}
+			hostStatus = func(host string) (bool, int, bool) {
+				s := tc.primary
+				if host == "standby.example" {
+					s = tc.standby
+				}
+				return s.ok, s.streak, s.known
+			}
+			got := ""
+			spawn = func(proxy.Entry) error { got = "failback"; return nil }
+			spawnWithParent = func(proxy.Entry, string, int, string, string) error { got = "failover"; return nil }
+
+			Evaluate()
+
+			if got != tc.want {
+				t.Errorf("switched %q, want %q", got, tc.want)
+			}
+			_, active := current.Active["p1/usa"]
+			if wantActive := tc.want == "failover" || (tc.onStandby && tc.want == ""); active != wantActive {
+				t.Errorf("on standby = %v, want %v", active, wantActive)
+			}
+		})
+	}
+}
@@ -6,3 +6,5 @@ require (
 	github.com/go-chi/chi/v5 v5.2.2
 	github.com/joho/godotenv v1.5.1
 )
+
+require gopkg.in/yaml.v3 v3.0.1
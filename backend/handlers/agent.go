@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"oceanproxy-api/accesslog"
+	"oceanproxy-api/config"
+	"oceanproxy-api/nodes"
+	"oceanproxy-api/proxy"
+)
+
+// Agent RPC, served when NODE_ROLE=agent. The control plane keeps the plan
+// store; the agent's own proxy log only lists the listeners it runs so they
+// can be restored on this host.
+
+// AgentAuthMiddleware accepts requests carrying AGENT_TOKEN as a bearer token
+func AgentAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		agentToken := config.Get().AgentToken
+		if agentToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(agentToken)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AgentSpawnHandler serves POST /agent/spawn: starts a listener with the
+// parent and destination policy sent by the control plane
+func AgentSpawnHandler(w http.ResponseWriter, r *http.Request) {
+	var req nodes.SpawnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid spawn request: %v", err), http.StatusBadRequest)
+		return
+	}
+	e := req.Entry
+	if e.PlanID == "" || e.Subdomain == "" || e.LocalPort == 0 {
+		http.Error(w, "entry needs plan_id, subdomain and local_port", http.StatusBadRequest)
+		return
+	}
+	e.Node = "" // local to this host
+
+	if err := proxy.SpawnLocal(e, req.Parent, req.ACL); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to spawn proxy: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := replaceAgentEntry(e.PlanID, e.Subdomain, &e); err != nil {
+		log.Printf("⚠️ Failed to record listener %s-%s: %v", e.PlanID, e.Subdomain, err)
+	}
+	proxy.UpdateNginxUpstreams()
+	JSON(w, map[string]interface{}{"success": true, "local_port": e.LocalPort})
+}
+
+// AgentStopHandler serves POST /agent/stop: stops a listener and forgets it
+func AgentStopHandler(w http.ResponseWriter, r *http.Request) {
+	var req nodes.StopRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.LocalPort == 0 {
+		http.Error(w, "Invalid stop request", http.StatusBadRequest)
+		return
+	}
+
+	if err := proxy.KillPort(req.LocalPort); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to stop listener: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := replaceAgentEntry(req.PlanID, req.Subdomain, nil); err != nil {
+		log.Printf("⚠️ Failed to remove listener %s-%s: %v", req.PlanID, req.Subdomain, err)
+	}
+	if err := proxy.RemoveConfig(req.PlanID, req.Subdomain); err != nil {
+		log.Printf("⚠️ Failed to remove config of %s-%s: %v", req.PlanID, req.Subdomain, err)
+	}
+	proxy.UpdateNginxUpstreams()
+	JSON(w, map[string]interface{}{"success": true})
+}
+
+// replaceAgentEntry drops the plan's listener for subdomain from the local
+// proxy log and, if e is not nil, records e in its place
+func replaceAgentEntry(planID, subdomain string, e *proxy.Entry) error {
+	return proxy.UpdateProxyLog(func(entries []proxy.Entry) ([]proxy.Entry, error) {
+		kept := entries[:0]
+		for _, old := range entries {
+			if old.PlanID != planID || old.Subdomain != subdomain {
+				kept = append(kept, old)
+			}
+		}
+		if e != nil {
+			kept = append(kept, *e)
+		}
+		return kept, nil
+	})
+}
+
+// AgentUsageHandler serves GET /agent/usage: totals of the connection
+// records on this host for plan_id between since and until
+func AgentUsageHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since, err := parseInstant(q.Get("since"), time.Time{})
+	if err != nil {
+		http.Error(w, "since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, err := parseInstant(q.Get("until"), time.Time{})
+	if err != nil {
+		http.Error(w, "until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := accesslog.Collect(); err != nil {
+		log.Printf("⚠️ Access log collection failed: %v", err)
+	}
+	s, err := accesslog.Summarize(accesslog.Query{PlanID: q.Get("plan_id"), Since: since, Until: until})
+	if err != nil {
+		http.Error(w, "Failed to read connection records: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSON(w, s)
+}
+
+// AgentStatusHandler serves GET /agent/status: the listeners this host runs
+func AgentStatusHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	host, _ := os.Hostname()
+	status := nodes.Status{Host: host, Listeners: []nodes.ListenerStatus{}, Ports: make(map[string]int)}
+	established := proxy.Established()
+	for _, e := range entries {
+		status.Listeners = append(status.Listeners, nodes.ListenerStatus{
+			PlanID:      e.PlanID,
+			Subdomain:   e.Subdomain,
+			LocalPort:   e.LocalPort,
+			Running:     portInUse(e.LocalPort),
+			Connections: established[e.LocalPort],
+		})
+		status.Ports[e.Subdomain]++
+	}
+	JSON(w, status)
+}
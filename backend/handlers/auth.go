@@ -10,7 +10,7 @@ import (
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-		if token != config.BearerToken {
+		if token != config.Get().BearerToken {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
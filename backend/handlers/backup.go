@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"oceanproxy-api/backup"
+	"oceanproxy-api/failover"
+	"oceanproxy-api/proxy"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Largest archive POST /admin/backup/restore accepts
+const maxBackupSize = 256 << 20
+
+// CreateBackupHandler serves POST /admin/backup: writes a new archive of the
+// plan store, state files and generated configs to DATA_DIR/backups
+func CreateBackupHandler(w http.ResponseWriter, r *http.Request) {
+	name, manifest, err := backup.Create()
+	if err != nil {
+		log.Printf("❌ Backup failed: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to create backup: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("💾 Backup %s written (%d plans, %d files)", name, manifest.Plans, len(manifest.Files))
+	JSON(w, map[string]interface{}{
+		"success":  true,
+		"name":     name,
+		"download": "/admin/backups/" + name,
+		"manifest": manifest,
+	})
+}
+
+// ListBackupsHandler serves GET /admin/backups, newest first
+func ListBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := backup.List()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list backups: %v", err), http.StatusInternalServerError)
+		return
+	}
+	JSON(w, map[string]interface{}{"backups": list})
+}
+
+// DownloadBackupHandler serves GET /admin/backups/{name}
+func DownloadBackupHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	f, err := backup.Open(name)
+	if err != nil {
+		http.Error(w, "Backup not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	_, _ = io.Copy(w, f)
+}
+
+// RestoreBackupHandler serves POST /admin/backup/restore with an archive as
+// the request body. The archive is verified, written over the local state and
+// every listener that has not expired is respawned. dry_run=true only
+// verifies. A host that already has plans is refused unless force=true, which
+// first stops the listeners the archive does not have.
+func RestoreBackupHandler(w http.ResponseWriter, r *http.Request) {
+	archive, err := backup.Verify(http.MaxBytesReader(w, r.Body, maxBackupSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Backup failed verification: %v", err), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("dry_run") == "true" {
+		JSON(w, map[string]interface{}{
+			"verified": true,
+			"manifest": archive.Manifest,
+		})
+		return
+	}
+
+	existing, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(existing) > 0 && r.URL.Query().Get("force") != "true" {
+		http.Error(w, fmt.Sprintf("This host already has %d listener(s), pass force=true to replace its state", len(existing)), http.StatusConflict)
+		return
+	}
+
+	if err := archive.Restore(); err != nil {
+		log.Printf("❌ Backup restore failed: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to restore backup: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// Drop state read before the restore so it is not written back over it
+	idempotencyMutex.Lock()
+	idempotencyRecords = nil
+	idempotencyMutex.Unlock()
+	failover.ReloadState()
+	if err := proxy.InitializePortManager(); err != nil {
+		log.Printf("⚠️ Failed to initialize port manager: %v", err)
+	}
+	stopped := stopReplaced(existing, archive.Entries)
+
+	now := time.Now().Unix()
+	restored, failed := []string{}, []string{}
+	skipped := 0
+	respawned := make(map[string][]proxy.Entry)
+	var order []string
+	for _, e := range archive.Entries {
+		if e.ExpiresAt != 0 && e.ExpiresAt < now {
+			skipped++
+			continue
+		}
+		if err := respawnEntry(e); err != nil {
+			failed = append(failed, e.PlanID+"-"+e.Subdomain+respawnFailure(err))
+		} else {
+			restored = append(restored, e.PlanID+"-"+e.Subdomain)
+			if respawned[e.PlanID] == nil {
+				order = append(order, e.PlanID)
+			}
+			respawned[e.PlanID] = append(respawned[e.PlanID], e)
+		}
+	}
+	if len(restored) > 0 {
+		proxy.UpdateNginxUpstreams()
+	}
+
+	announceRestored(order, respawned, "backup")
+
+	host, _ := os.Hostname()
+	log.Printf("♻️ Restored backup from %s taken %s: %d listener(s) respawned, %d failed, %d expired, %d replaced listener(s) stopped",
+		archive.Manifest.Host, archive.Manifest.CreatedAt.Format(time.RFC3339), len(restored), len(failed), skipped, len(stopped))
+	JSON(w, map[string]interface{}{
+		"success":         len(failed) == 0,
+		"host":            host,
+		"manifest":        archive.Manifest,
+		"restored":        restored,
+		"failed":          failed,
+		"skipped_expired": skipped,
+		"stopped":         stopped,
+	})
+}
+
+// stopReplaced stops the listeners of the state a forced restore replaced
+// that the archive does not bring back on the same port and node, matched on
+// plan and subdomain. Run before respawning, as a freed port may be reused.
+func stopReplaced(existing, restored []proxy.Entry) []string {
+	kept := make(map[string]proxy.Entry, len(restored))
+	for _, e := range restored {
+		kept[e.PlanID+"-"+e.Subdomain] = e
+	}
+	running := make(map[string]bool) // node/port of listeners that stay
+	for _, e := range existing {
+		if r, ok := kept[e.PlanID+"-"+e.Subdomain]; ok && r.LocalPort == e.LocalPort && r.Node == e.Node {
+			running[fmt.Sprintf("%s/%d", e.Node, e.LocalPort)] = true
+		}
+	}
+
+	stopped := []string{}
+	for _, e := range existing {
+		key := e.PlanID + "-" + e.Subdomain
+		_, inArchive := kept[key]
+		if inArchive && running[fmt.Sprintf("%s/%d", e.Node, e.LocalPort)] {
+			continue // restored unchanged
+		}
+		if !inArchive && e.Node == "" { // a restored entry keeps the config of its name
+			if err := proxy.RemoveConfig(e.PlanID, e.Subdomain); err != nil {
+				log.Printf("⚠️ Failed to remove config of %s: %v", key, err)
+			}
+		}
+		if running[fmt.Sprintf("%s/%d", e.Node, e.LocalPort)] {
+			continue // the port now belongs to a listener that stays
+		}
+		if err := proxy.StopListener(e); err != nil {
+			log.Printf("⚠️ Failed to stop replaced listener %s on port %d: %v", key, e.LocalPort, err)
+		}
+		stopped = append(stopped, key)
+	}
+	return stopped
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"oceanproxy-api/proxy"
+)
+
+// stopRecorder stands in for the edge node client and records stopped listeners
+type stopRecorder struct{ stopped []string }
+
+func (s *stopRecorder) Spawn(proxy.Entry, proxy.Parent, string) error { return nil }
+
+func (s *stopRecorder) Stop(e proxy.Entry) error {
+	s.stopped = append(s.stopped, e.PlanID+"-"+e.Subdomain)
+	return nil
+}
+
+func TestStopReplaced(t *testing.T) {
+	rec := &stopRecorder{}
+	proxy.SetRemote(rec)
+	defer proxy.SetRemote(nil)
+
+	existing := []proxy.Entry{
+		{PlanID: "kept", Subdomain: "usa", Node: "fra1", LocalPort: 10000},
+		{PlanID: "gone", Subdomain: "usa", Node: "fra1", LocalPort: 10001},
+		{PlanID: "moved", Subdomain: "eu", Node: "fra1", LocalPort: 12000},
+		{PlanID: "stale", Subdomain: "eu", Node: "fra1", LocalPort: 12005}, // port reused by kept2
+		{PlanID: "kept2", Subdomain: "eu", Node: "fra1", LocalPort: 12005},
+	}
+	restored := []proxy.Entry{
+		{PlanID: "kept", Subdomain: "usa", Node: "fra1", LocalPort: 10000},
+		{PlanID: "moved", Subdomain: "eu", Node: "fra1", LocalPort: 12001},
+		{PlanID: "kept2", Subdomain: "eu", Node: "fra1", LocalPort: 12005},
+		{PlanID: "new", Subdomain: "usa", Node: "fra1", LocalPort: 10002},
+	}
+
+	got := stopReplaced(existing, restored)
+	want := []string{"gone-usa", "moved-eu"}
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(rec.stopped, want) {
+		t.Errorf("stopped %v (node saw %v), want %v", got, rec.stopped, want)
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/orders"
+	"oceanproxy-api/proxy"
+)
+
+// GetCatalogHandler serves GET /catalog: every price and the products that
+// can be priced
+func GetCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	prices, err := orders.Catalog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read catalog: %v", err), http.StatusInternalServerError)
+		return
+	}
+	JSON(w, map[string]interface{}{
+		"currency": config.Get().Currency,
+		"prices":   prices,
+		"products": orders.Products(),
+	})
+}
+
+// SetPriceHandler serves PUT /catalog/prices with form values product,
+// threads (tier of proxiesfo/datacenter), cost and price per unit as decimal
+// amounts, and discounts as min_quantity:percent pairs, e.g. 10:5,50:12.5
+func SetPriceHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	p := orders.Price{Product: strings.TrimSpace(r.Form.Get("product"))}
+	var err error
+	if v := r.Form.Get("threads"); v != "" {
+		if p.Threads, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "threads must be a whole number", http.StatusBadRequest)
+			return
+		}
+	}
+	if p.CostCents, err = orders.ParseAmount(r.Form.Get("cost")); err != nil {
+		http.Error(w, "cost: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if p.PriceCents, err = orders.ParseAmount(r.Form.Get("price")); err != nil {
+		http.Error(w, "price: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, pair := range strings.Split(r.Form.Get("discounts"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		qty, pct, _ := strings.Cut(pair, ":")
+		var d orders.Discount
+		var err1, err2 error
+		d.MinQuantity, err1 = strconv.Atoi(qty)
+		d.Percent, err2 = strconv.ParseFloat(pct, 64)
+		if err1 != nil || err2 != nil {
+			http.Error(w, fmt.Sprintf("discount %q, expected min_quantity:percent", pair), http.StatusBadRequest)
+			return
+		}
+		p.Discounts = append(p.Discounts, d)
+	}
+
+	if err := orders.SetPrice(p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("🏷️ Price of %s set: cost %s, price %s %s", p.Product, orders.FormatAmount(p.CostCents), orders.FormatAmount(p.PriceCents), config.Get().Currency)
+	JSON(w, map[string]interface{}{"success": true, "price": p})
+}
+
+// DeletePriceHandler serves DELETE /catalog/prices?product=&threads=
+func DeletePriceHandler(w http.ResponseWriter, r *http.Request) {
+	threads := 0
+	if v := r.URL.Query().Get("threads"); v != "" {
+		var err error
+		if threads, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "threads must be a whole number", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := orders.RemovePrice(r.URL.Query().Get("product"), threads); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, orders.ErrNoPrice) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	JSON(w, map[string]interface{}{"success": true})
+}
+
+// QuoteHandler serves POST /quote with form values product, quantity and
+// threads (proxiesfo/datacenter)
+func QuoteHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	quantity, err := strconv.ParseFloat(r.Form.Get("quantity"), 64)
+	if err != nil {
+		http.Error(w, "quantity must be a number", http.StatusBadRequest)
+		return
+	}
+	threads := 0
+	if v := r.Form.Get("threads"); v != "" {
+		if threads, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "threads must be a whole number", http.StatusBadRequest)
+			return
+		}
+	}
+	q, err := orders.QuoteFor(strings.TrimSpace(r.Form.Get("product")), quantity, threads)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, orders.ErrNoPrice) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	JSON(w, map[string]interface{}{"quote": q})
+}
+
+// MarginRow sums the plans of one group
+type MarginRow struct {
+	Key           string  `json:"key"`
+	Plans         int     `json:"plans"`
+	Unpriced      int     `json:"unpriced"` // plans with neither cost nor revenue recorded
+	RevenueCents  int64   `json:"revenue_cents"`
+	CostCents     int64   `json:"cost_cents"`
+	MarginCents   int64   `json:"margin_cents"`
+	MarginPercent float64 `json:"margin_percent"` // of revenue
+}
+
+func (m *MarginRow) add(p Plan) {
+	m.Plans++
+	if p.RevenueCents == 0 && p.CostCents == 0 {
+		m.Unpriced++
+	}
+	m.RevenueCents += p.RevenueCents
+	m.CostCents += p.CostCents
+	m.MarginCents = m.RevenueCents - m.CostCents
+	if m.RevenueCents > 0 {
+		m.MarginPercent = float64(m.MarginCents*10000/m.RevenueCents) / 100
+	}
+}
+
+// MarginsHandler serves GET /margins: revenue, cost and margin of the plans
+// created between since and until (unix or RFC3339), grouped by product
+// (default), provider, customer or month
+func MarginsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since, err := parseTimeParam(q.Get("since"))
+	if err != nil {
+		http.Error(w, "since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, err := parseTimeParam(q.Get("until"))
+	if err != nil {
+		http.Error(w, "until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	by := q.Get("by")
+	key := map[string]func(Plan) string{
+		"":         func(p Plan) string { return p.Product },
+		"product":  func(p Plan) string { return p.Product },
+		"provider": func(p Plan) string { return p.Provider },
+		"customer": func(p Plan) string { return p.Customer },
+		"month":    func(p Plan) string { return time.Unix(p.CreatedAt, 0).UTC().Format("2006-01") },
+	}[by]
+	if key == nil {
+		http.Error(w, "by must be product, provider, customer or month", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	groups := make(map[string]*MarginRow)
+	total := MarginRow{Key: "total"}
+	for _, p := range groupPlans(entries) {
+		if (since != 0 && p.CreatedAt < since) || (until != 0 && p.CreatedAt >= until) {
+			continue
+		}
+		k := key(p)
+		if k == "" {
+			k = "-"
+		}
+		if groups[k] == nil {
+			groups[k] = &MarginRow{Key: k}
+		}
+		groups[k].add(p)
+		total.add(p)
+	}
+	rows := make([]MarginRow, 0, len(groups))
+	for _, g := range groups {
+		rows = append(rows, *g)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Key < rows[j].Key })
+
+	JSON(w, map[string]interface{}{
+		"currency": config.Get().Currency,
+		"rows":     rows,
+		"total":    total,
+	})
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"oceanproxy-api/accesslog"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultConnectionLimit = 100
+	maxConnectionLimit     = 1000
+)
+
+// PlanConnectionsHandler serves GET /plans/{id}/connections, newest first.
+// Query parameters: since and until (unix or RFC3339, default the last 24
+// hours), client_ip, target (substring of host:port), result (ok, blocked,
+// denied, aborted or failed) and limit. When more records match, next_until is the
+// until value for the following page.
+func PlanConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := accesslog.Query{
+		PlanID:   chi.URLParam(r, "id"),
+		ClientIP: q.Get("client_ip"),
+		Target:   q.Get("target"),
+		Result:   q.Get("result"),
+		Limit:    defaultConnectionLimit,
+	}
+
+	var err error
+	if query.Until, err = parseInstant(q.Get("until"), time.Now()); err != nil {
+		http.Error(w, "until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.Since, err = parseInstant(q.Get("since"), query.Until.Add(-24*time.Hour)); err != nil {
+		http.Error(w, "since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxConnectionLimit {
+			http.Error(w, "limit must be 1-"+strconv.Itoa(maxConnectionLimit), http.StatusBadRequest)
+			return
+		}
+		query.Limit = n
+	}
+
+	// Pick up lines written since the last background run
+	if err := accesslog.Collect(); err != nil {
+		log.Printf("⚠️ Access log collection failed: %v", err)
+	}
+
+	records, more, err := accesslog.Search(query)
+	if err != nil {
+		http.Error(w, "Failed to read connection records: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []accesslog.Record{}
+	}
+
+	resp := map[string]interface{}{
+		"plan_id":     query.PlanID,
+		"connections": records,
+	}
+	if more {
+		resp["next_until"] = records[len(records)-1].Time.Format(time.RFC3339Nano)
+	}
+	JSON(w, resp)
+}
+
+// parseInstant accepts unix seconds or RFC3339 with optional fractional
+// seconds, so a next_until value pages without skipping records
+func parseInstant(v string, fallback time.Time) (time.Time, error) {
+	if v == "" {
+		return fallback, nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return t, fmt.Errorf("use unix seconds or RFC3339")
+	}
+	return t, nil
+}
@@ -1,95 +1,111 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os/exec"
 
-	"oceanproxy-api/providers"
+	"oceanproxy-api/ledger"
+	"oceanproxy-api/orders"
+	"oceanproxy-api/provision"
 	"oceanproxy-api/proxy"
 )
 
 func CreateNettifyPlanHandler(w http.ResponseWriter, r *http.Request) {
+	createPlan(w, r, provision.Nettify)
+}
+
+func CreatePlanHandler(w http.ResponseWriter, r *http.Request) {
+	createPlan(w, r, provision.ProxiesFO)
+}
+
+func createPlan(w http.ResponseWriter, r *http.Request, provider string) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
 		return
 	}
+	// Checked before buying so a typo does not leave a paid plan unlimited
+	limits, _, err := parseRateLimits(r.Form, proxy.RateLimits{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	useTLS, err := parseTLS(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	proxyInfo, err := providers.CreateNettifyPlan(r.Form)
+	fromBalance, err := parseFromBalance(r.Form)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create plan: %v", err), http.StatusBadGateway)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	customer := r.Form.Get("customer")
+	if fromBalance && customer == "" {
+		http.Error(w, "customer is required to pay from_balance", http.StatusBadRequest)
 		return
 	}
 
-	var proxies []string
-	for _, p := range proxyInfo.Proxies {
-		err := proxy.Spawn3proxy(p)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to spawn proxy: %v", err), http.StatusInternalServerError)
+	// Plans of products without a catalog price are recorded without margins
+	quote, err := orders.QuoteForm(provider, r.Form)
+	if err != nil {
+		if fromBalance {
+			http.Error(w, fmt.Sprintf("Cannot charge the balance: %v", err), http.StatusBadRequest)
 			return
 		}
-		_ = proxy.LogProxy(p)
-
-		// Update nginx upstreams after proxy is created and logged
-		if err := exec.Command("/opt/oceanproxy/scripts/update_nginx_upstreams.sh").Run(); err != nil {
-			log.Printf("⚠️ Warning: Failed to update nginx upstreams: %v", err)
-		} else {
-			log.Printf("✅ nginx upstreams updated successfully")
-		}
-
-		// Return the PUBLIC port, not the local port
-		proxies = append(proxies, fmt.Sprintf("http://%s:%s@%s:%d", p.Username, p.Password, p.LocalHost, p.PublicPort))
+		log.Printf("⚠️ Plan bought without a price: %v", err)
 	}
 
-	JSON(w, map[string]interface{}{
-		"success":    true,
-		"plan_id":    proxyInfo.PlanID,
-		"username":   proxyInfo.Username,
-		"password":   proxyInfo.Password,
-		"expires_at": proxyInfo.ExpiresAt,
-		"proxies":    proxies,
+	res, err := provision.Plan(provision.Request{
+		Provider:       provider,
+		Form:           r.Form,
+		Customer:       customer,
+		IdempotencyKey: r.Header.Get(IdempotencyHeader),
+		RateLimits:     limits,
+		TLS:            useTLS,
+		Source:         "api",
+		CostCents:      quote.CostCents,
+		RevenueCents:   quote.TotalCents,
+		Product:        quote.Product,
+		FromBalance:    fromBalance,
 	})
-}
-
-func CreatePlanHandler(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
-		return
-	}
-
-	proxyInfo, err := providers.CreateProxiesFOPlan(r.Form)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create plan: %v", err), http.StatusBadGateway)
+		provisionError(w, res, err)
 		return
 	}
 
+	// Return the PUBLIC (or TLS) port, not the local port
 	var proxies []string
-	for _, p := range proxyInfo.Proxies {
-		err := proxy.Spawn3proxy(p)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to spawn proxy: %v", err), http.StatusInternalServerError)
-			return
-		}
-		_ = proxy.LogProxy(p)
-
-		// Update nginx upstreams after proxy is created and logged
-		if err := exec.Command("/opt/oceanproxy/scripts/update_nginx_upstreams.sh").Run(); err != nil {
-			log.Printf("⚠️ Warning: Failed to update nginx upstreams: %v", err)
-		} else {
-			log.Printf("✅ nginx upstreams updated successfully")
-		}
-
-		// Return the PUBLIC port, not the local port
-		proxies = append(proxies, fmt.Sprintf("http://%s:%s@%s:%d", p.Username, p.Password, p.LocalHost, p.PublicPort))
+	for _, p := range res.Entries {
+		proxies = append(proxies, proxyURL(p))
 	}
 
 	JSON(w, map[string]interface{}{
 		"success":    true,
-		"plan_id":    proxyInfo.PlanID,
-		"username":   proxyInfo.Username,
-		"password":   proxyInfo.Password,
-		"expires_at": proxyInfo.ExpiresAt,
+		"plan_id":    res.PlanID,
+		"username":   res.Username,
+		"password":   res.Password,
+		"expires_at": res.ExpiresAt,
 		"proxies":    proxies,
 	})
 }
+
+// provisionError writes a failed provision.Plan with the matching status.
+// Only failures before the purchase release the Idempotency-Key.
+func provisionError(w http.ResponseWriter, res *provision.Result, err error) {
+	switch {
+	case errors.Is(err, ledger.ErrInsufficientFunds):
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+	case errors.Is(err, provision.ErrUnavailable):
+		if res == nil {
+			allowRetry(w) // no node was ready, nothing was bought
+		}
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case res == nil:
+		providerError(w, "Failed to create plan", err)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/proxy"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// exportProxy is one endpoint of a plan as handed to a customer
+type exportProxy struct {
+	PlanID    string `json:"plan_id"`
+	Subdomain string `json:"subdomain"`
+	Scheme    string `json:"scheme"` // https on the TLS port for plans that opted in
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	URL       string `json:"url"`
+
+	// The plaintext public port, for formats that cannot carry a scheme
+	PlainPort int `json:"-"`
+}
+
+// exportFormat renders a proxy list for download
+type exportFormat struct {
+	contentType string
+	ext         string
+	write       func(w io.Writer, proxies []exportProxy) error
+}
+
+// exportFormats are the ?format= values of the export endpoints
+var exportFormats = map[string]exportFormat{
+	"url":          {"text/plain; charset=utf-8", "txt", writeURLs},
+	"hostport":     {"text/plain; charset=utf-8", "txt", writeHostPort},
+	"userpass":     {"text/plain; charset=utf-8", "txt", writeUserPass},
+	"csv":          {"text/csv; charset=utf-8", "csv", writeCSV},
+	"json":         {"application/json", "json", writeExportJSON},
+	"curl":         {"text/plain; charset=utf-8", "sh", writeCurl},
+	"clash":        {"application/yaml", "yaml", writeClash},
+	"switchyomega": {"application/json", "bak", writeSwitchyOmega},
+}
+
+func exportFormatNames() string {
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// exportProxies flattens plans into their endpoints
+func exportProxies(plans []Plan) []exportProxy {
+	var out []exportProxy
+	for _, p := range plans {
+		for _, ep := range p.Endpoints {
+			x := exportProxy{
+				PlanID:    p.PlanID,
+				Subdomain: ep.Subdomain,
+				Scheme:    "http",
+				Host:      ep.Host,
+				Port:      ep.Port,
+				Username:  p.Username,
+				Password:  p.Password,
+				URL:       ep.URL,
+				PlainPort: ep.Port,
+			}
+			if p.TLS && ep.TLSPort != 0 {
+				x.Scheme, x.Port = "https", ep.TLSPort
+			}
+			out = append(out, x)
+		}
+	}
+	return out
+}
+
+// name labels a proxy in switcher profiles
+func (x exportProxy) name() string {
+	return fmt.Sprintf("oceanproxy-%s-%s", x.Subdomain, x.PlanID)
+}
+
+func writeURLs(w io.Writer, proxies []exportProxy) error {
+	for _, x := range proxies {
+		if _, err := fmt.Fprintln(w, x.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHostPort(w io.Writer, proxies []exportProxy) error {
+	for _, x := range proxies {
+		if _, err := fmt.Fprintf(w, "%s:%d:%s:%s\n", x.Host, x.PlainPort, x.Username, x.Password); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeUserPass(w io.Writer, proxies []exportProxy) error {
+	for _, x := range proxies {
+		if _, err := fmt.Fprintf(w, "%s:%s@%s:%d\n", x.Username, x.Password, x.Host, x.PlainPort); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, proxies []exportProxy) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"plan_id", "subdomain", "scheme", "host", "port", "username", "password", "url"})
+	for _, x := range proxies {
+		_ = cw.Write([]string{x.PlanID, x.Subdomain, x.Scheme, x.Host, strconv.Itoa(x.Port), x.Username, x.Password, x.URL})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeExportJSON(w io.Writer, proxies []exportProxy) error {
+	if proxies == nil {
+		proxies = []exportProxy{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{"proxies": proxies})
+}
+
+// writeCurl lists one test command per proxy against the prober's target
+func writeCurl(w io.Writer, proxies []exportProxy) error {
+	for _, x := range proxies {
+		if _, err := fmt.Fprintf(w, "curl -x %s %s\n", shellQuote(x.URL), shellQuote(config.Get().ProbeTargetURL)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// writeClash writes a Clash config fragment: the proxies and a select group
+func writeClash(w io.Writer, proxies []exportProxy) error {
+	type clashProxy struct {
+		Name     string `yaml:"name"`
+		Type     string `yaml:"type"`
+		Server   string `yaml:"server"`
+		Port     int    `yaml:"port"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		TLS      bool   `yaml:"tls,omitempty"`
+	}
+	type clashGroup struct {
+		Name    string   `yaml:"name"`
+		Type    string   `yaml:"type"`
+		Proxies []string `yaml:"proxies"`
+	}
+
+	cps := make([]clashProxy, 0, len(proxies))
+	names := make([]string, 0, len(proxies))
+	for _, x := range proxies {
+		cps = append(cps, clashProxy{
+			Name:     x.name(),
+			Type:     "http",
+			Server:   x.Host,
+			Port:     x.Port,
+			Username: x.Username,
+			Password: x.Password,
+			TLS:      x.Scheme == "https",
+		})
+		names = append(names, x.name())
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(map[string]interface{}{
+		"proxies":      cps,
+		"proxy-groups": []clashGroup{{Name: "OceanProxy", Type: "select", Proxies: names}},
+	})
+}
+
+// writeSwitchyOmega writes a SwitchyOmega backup with one fixed profile per
+// proxy, importable from Options > Import/Export
+func writeSwitchyOmega(w io.Writer, proxies []exportProxy) error {
+	type server struct {
+		Scheme string `json:"scheme"`
+		Host   string `json:"host"`
+		Port   int    `json:"port"`
+	}
+	type credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	type bypass struct {
+		ConditionType string `json:"conditionType"`
+		Pattern       string `json:"pattern"`
+	}
+	type profile struct {
+		ProfileType   string                 `json:"profileType"`
+		Name          string                 `json:"name"`
+		Color         string                 `json:"color"`
+		FallbackProxy server                 `json:"fallbackProxy"`
+		Auth          map[string]credentials `json:"auth"`
+		BypassList    []bypass               `json:"bypassList"`
+	}
+
+	backup := map[string]interface{}{"schemaVersion": 2}
+	for _, x := range proxies {
+		backup["+"+x.name()] = profile{
+			ProfileType:   "FixedProfile",
+			Name:          x.name(),
+			Color:         "#3b82f6",
+			FallbackProxy: server{Scheme: x.Scheme, Host: x.Host, Port: x.Port},
+			Auth:          map[string]credentials{"fallbackProxy": {x.Username, x.Password}},
+			BypassList: []bypass{
+				{"BypassCondition", "127.0.0.1"},
+				{"BypassCondition", "[::1]"},
+				{"BypassCondition", "localhost"},
+			},
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(backup)
+}
+
+// writeExport sends plans in the requested ?format=, default url, as a
+// download named after base
+func writeExport(w http.ResponseWriter, r *http.Request, base string, plans []Plan) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "url"
+	}
+	f, ok := exportFormats[name]
+	if !ok {
+		http.Error(w, "format must be one of "+exportFormatNames(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", f.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-proxies.%s"`, safeFilename(base), f.ext))
+	_ = f.write(w, exportProxies(plans))
+}
+
+// safeFilename keeps letters, digits, dots, dashes and underscores
+func safeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// ExportPlanHandler serves GET /plans/{id}/export?format= with the plan's
+// proxies in one of the exportFormats
+func ExportPlanHandler(w http.ResponseWriter, r *http.Request) {
+	planID := chi.URLParam(r, "id")
+
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var own []proxy.Entry
+	for _, e := range entries {
+		if e.PlanID == planID {
+			own = append(own, e)
+		}
+	}
+	if len(own) == 0 {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	writeExport(w, r, planID, groupPlans(own))
+}
+
+// ExportCustomerHandler serves GET /customers/{customer}/export?format= with
+// the proxies of every plan created for the customer. Expired plans are left
+// out unless status=all.
+func ExportCustomerHandler(w http.ResponseWriter, r *http.Request) {
+	customer := chi.URLParam(r, "customer")
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "all" && status != "active" && status != "expired" {
+		http.Error(w, "status must be active, expired or all", http.StatusBadRequest)
+		return
+	}
+	if status == "" {
+		status = "active"
+	}
+
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var plans []Plan
+	for _, p := range groupPlans(entries) {
+		if p.Customer == customer && (status == "all" || p.Status == status) {
+			plans = append(plans, p)
+		}
+	}
+	if len(plans) == 0 {
+		http.Error(w, "No plans found for customer", http.StatusNotFound)
+		return
+	}
+	writeExport(w, r, customer, plans)
+}
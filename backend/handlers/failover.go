@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"net/http"
+
+	"oceanproxy-api/failover"
+)
+
+// FailoverStatusHandler shows failover policies, listeners on a standby and switch events
+func FailoverStatusHandler(w http.ResponseWriter, r *http.Request) {
+	JSON(w, failover.Status())
+}
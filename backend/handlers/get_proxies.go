@@ -2,14 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 
+	"oceanproxy-api/config"
 	"oceanproxy-api/proxy"
 )
 
 func GetProxiesHandler(w http.ResponseWriter, r *http.Request) {
-	data, err := os.ReadFile(proxy.LogPath)
+	data, err := os.ReadFile(config.ProxyLogPath)
 	if err != nil {
 		http.Error(w, "Read error", http.StatusInternalServerError)
 		return
@@ -32,7 +34,7 @@ func GetProxiesHandler(w http.ResponseWriter, r *http.Request) {
 	for _, entry := range entries {
 		displayEntries = append(displayEntries, ProxyDisplay{
 			Entry:          entry,
-			ClientEndpoint: entry.LocalHost + ":" + string(rune(entry.PublicPort)),
+			ClientEndpoint: fmt.Sprintf("%s:%d", entry.LocalHost, entry.PublicPort),
 		})
 	}
 
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"oceanproxy-api/config"
+)
+
+const (
+	IdempotencyHeader   = "Idempotency-Key"
+	idempotencyLogFile  = "idempotency.json" // in config.DataDir
+	maxIdempotencyKey   = 255
+	maxIdempotentBody   = 1 << 20
+	idempotencyWaitTime = 2 * time.Minute
+)
+
+// idempotencyRecord is the stored outcome of a request made with an Idempotency-Key
+type idempotencyRecord struct {
+	Key         string `json:"key"`
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	BodyHash    string `json:"body_hash,omitempty"` // SHA-256 of the request body
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+	PlanID      string `json:"plan_id,omitempty"`
+	CreatedAt   int64  `json:"created_at"`
+
+	done chan struct{} // closed once the first request has finished
+}
+
+var (
+	idempotencyMutex   sync.Mutex
+	idempotencyRecords map[string]*idempotencyRecord
+)
+
+// IdempotencyMiddleware replays the original response when a request is repeated
+// with the same Idempotency-Key inside the retention window. Repeats that arrive
+// while the first request is still running wait for it and get the same response.
+// Only final outcomes are kept: after a status that may change on a retry
+// (see finalStatus), or a failure the handler marked with allowRetry because
+// nothing reached the provider, the key is forgotten so the client can try
+// again. Reusing a key with a different body is refused with 422.
+func IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		bodyHash := hex.EncodeToString(sum[:])
+
+		idempotencyMutex.Lock()
+		loadIdempotencyRecords()
+		rec, exists := idempotencyRecords[key]
+		if !exists {
+			rec = &idempotencyRecord{
+				Key:       key,
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				BodyHash:  bodyHash,
+				CreatedAt: time.Now().Unix(),
+				done:      make(chan struct{}),
+			}
+			idempotencyRecords[key] = rec
+		}
+		idempotencyMutex.Unlock()
+
+		if exists {
+			replayIdempotent(w, r, rec, bodyHash)
+			return
+		}
+
+		rw := &capturingWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			idempotencyMutex.Lock()
+			if p := recover(); p != nil {
+				// Forget the key so the client can retry; waiters see StatusCode 0
+				delete(idempotencyRecords, key)
+				close(rec.done)
+				idempotencyMutex.Unlock()
+				panic(p)
+			}
+			rec.StatusCode = rw.status
+			rec.ContentType = rw.Header().Get("Content-Type")
+			rec.Body = rw.body.Bytes()
+			rec.PlanID = planIDFromBody(rec.Body)
+			close(rec.done) // requests already waiting get this response either way
+			if finalStatus(rec.StatusCode) && !rw.retry {
+				saveIdempotencyRecords()
+			} else {
+				delete(idempotencyRecords, key)
+			}
+			idempotencyMutex.Unlock()
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// finalStatus reports whether a response is kept for replay. 402 (funds may
+// be added), 408, 425 and 429 may go differently on a retry. A 5xx is kept:
+// the plan may have been bought before it failed, so a retry could buy it
+// twice.
+func finalStatus(status int) bool {
+	switch status {
+	case http.StatusPaymentRequired, http.StatusRequestTimeout,
+		http.StatusTooEarly, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= 200
+}
+
+// allowRetry releases the Idempotency-Key of a failed request when nothing
+// reached the provider, so the same key can be sent again
+func allowRetry(w http.ResponseWriter) {
+	if cw, ok := w.(*capturingWriter); ok {
+		cw.retry = true
+	}
+}
+
+func replayIdempotent(w http.ResponseWriter, r *http.Request, rec *idempotencyRecord, bodyHash string) {
+	if rec.Method != r.Method || rec.Path != r.URL.Path || (rec.BodyHash != "" && rec.BodyHash != bodyHash) {
+		http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	select {
+	case <-rec.done:
+	case <-r.Context().Done():
+		return
+	case <-time.After(idempotencyWaitTime):
+		http.Error(w, "Original request with this Idempotency-Key is still in progress", http.StatusConflict)
+		return
+	}
+
+	idempotencyMutex.Lock()
+	status, contentType, body := rec.StatusCode, rec.ContentType, rec.Body
+	idempotencyMutex.Unlock()
+
+	if status == 0 {
+		http.Error(w, "Original request with this Idempotency-Key failed, please retry", http.StatusConflict)
+		return
+	}
+
+	log.Printf("🔁 Replaying response for Idempotency-Key %s (plan %s)", rec.Key, rec.PlanID)
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// loadIdempotencyRecords reads completed records from disk on first use and
+// drops anything older than the retention window. Callers hold idempotencyMutex.
+func loadIdempotencyRecords() {
+	if idempotencyRecords == nil {
+		idempotencyRecords = make(map[string]*idempotencyRecord)
+		if data, err := os.ReadFile(filepath.Join(config.DataDir, idempotencyLogFile)); err == nil {
+			var stored []*idempotencyRecord
+			if err := json.Unmarshal(data, &stored); err != nil {
+				log.Printf("⚠️ Failed to parse idempotency log: %v", err)
+			}
+			for _, rec := range stored {
+				rec.done = make(chan struct{})
+				close(rec.done)
+				idempotencyRecords[rec.Key] = rec
+			}
+		}
+	}
+
+	cutoff := time.Now().Add(-config.Get().IdempotencyTTL).Unix()
+	for key, rec := range idempotencyRecords {
+		if rec.CreatedAt < cutoff && isClosed(rec.done) {
+			delete(idempotencyRecords, key)
+		}
+	}
+}
+
+// saveIdempotencyRecords persists completed records. Callers hold idempotencyMutex.
+func saveIdempotencyRecords() {
+	var stored []*idempotencyRecord
+	for _, rec := range idempotencyRecords {
+		if isClosed(rec.done) {
+			stored = append(stored, rec)
+		}
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return
+	}
+	_ = os.MkdirAll(config.DataDir, 0755)
+	path := filepath.Join(config.DataDir, idempotencyLogFile)
+	err = os.WriteFile(path+".tmp", data, 0600)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		log.Printf("⚠️ Failed to save idempotency log: %v", err)
+	}
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func planIDFromBody(body []byte) string {
+	var payload struct {
+		PlanID string `json:"plan_id"`
+	}
+	_ = json.Unmarshal(body, &payload)
+	return payload.PlanID
+}
+
+// capturingWriter passes the response through while keeping a copy for replay
+type capturingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	retry       bool // set by allowRetry
+}
+
+func (cw *capturingWriter) WriteHeader(status int) {
+	if !cw.wroteHeader {
+		cw.status = status
+		cw.wroteHeader = true
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *capturingWriter) Write(b []byte) (int, error) {
+	cw.wroteHeader = true
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
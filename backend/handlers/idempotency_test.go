@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/providers"
+)
+
+// idempotent wraps a handler that answers status after release is closed and
+// counts how often it ran
+func idempotent(t *testing.T, status int, release chan struct{}) (http.Handler, *int32) {
+	t.Helper()
+	config.DataDir = t.TempDir()
+	if config.Get().IdempotencyTTL <= 0 {
+		config.Set(func(s *config.Settings) { s.IdempotencyTTL = time.Hour })
+	}
+	idempotencyMutex.Lock()
+	idempotencyRecords = nil
+	idempotencyMutex.Unlock()
+
+	var calls int32
+	h := IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"plan_id":"p` + string(rune('0'+n)) + `"}`))
+	}))
+	return h, &calls
+}
+
+func sendKeyed(h http.Handler, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/plans", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set(IdempotencyHeader, key)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	h, calls := idempotent(t, http.StatusOK, release)
+
+	first := sendKeyed(h, "k1", "plan=a")
+	again := sendKeyed(h, "k1", "plan=a")
+	if *calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", *calls)
+	}
+	if again.Code != http.StatusOK || again.Body.String() != first.Body.String() || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay = %d %q %v, want %d %q", again.Code, again.Body, again.Header(), first.Code, first.Body)
+	}
+
+	if w := sendKeyed(h, "k1", "plan=b"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("different body = %d, want 422", w.Code)
+	}
+
+	// Records survive a restart
+	idempotencyMutex.Lock()
+	idempotencyRecords = nil
+	idempotencyMutex.Unlock()
+	if w := sendKeyed(h, "k1", "plan=a"); w.Body.String() != first.Body.String() || *calls != 1 {
+		t.Errorf("after reload: %q, %d calls", w.Body, *calls)
+	}
+}
+
+func TestIdempotencyConcurrentWaiters(t *testing.T) {
+	release := make(chan struct{})
+	h, calls := idempotent(t, http.StatusOK, release)
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = sendKeyed(h, "k1", "plan=a").Body.String()
+		}(i)
+	}
+	// Let every request reach the middleware before the first one answers
+	for atomic.LoadInt32(calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if *calls != 1 {
+		t.Errorf("handler ran %d times, want 1", *calls)
+	}
+	for i, b := range bodies {
+		if b != bodies[0] {
+			t.Errorf("request %d got %q, want %q", i, b, bodies[0])
+		}
+	}
+}
+
+func TestIdempotencyRetryableStatus(t *testing.T) {
+	for _, status := range []int{http.StatusPaymentRequired, http.StatusTooManyRequests} {
+		release := make(chan struct{})
+		close(release)
+		h, calls := idempotent(t, status, release)
+
+		sendKeyed(h, "k1", "plan=a")
+		if w := sendKeyed(h, "k1", "plan=a"); w.Header().Get("Idempotent-Replayed") != "" || *calls != 2 {
+			t.Errorf("%d: retry was replayed, handler ran %d times", status, *calls)
+		}
+	}
+
+	// The plan may have been bought before a 5xx, so a retry must not buy again
+	for _, status := range []int{http.StatusBadRequest, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		release := make(chan struct{})
+		close(release)
+		h, calls := idempotent(t, status, release)
+
+		sendKeyed(h, "k1", "plan=a")
+		if w := sendKeyed(h, "k1", "plan=a"); w.Code != status || *calls != 1 {
+			t.Errorf("%d was not kept: replay %d, handler ran %d times", status, w.Code, *calls)
+		}
+	}
+}
+
+func TestIdempotencyNotSentRetries(t *testing.T) {
+	config.DataDir = t.TempDir()
+	idempotencyMutex.Lock()
+	idempotencyRecords = nil
+	idempotencyMutex.Unlock()
+
+	var calls int32
+	h := IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		providerError(w, "Failed to create plan", &providers.ProviderError{
+			Provider: "proxies.fo", Kind: providers.ErrUpstreamDown, Message: "connection refused", NotSent: true,
+		})
+	}))
+
+	sendKeyed(h, "k1", "plan=a")
+	w := sendKeyed(h, "k1", "plan=a")
+	if calls != 2 || w.Header().Get("Retry-After") == "" {
+		t.Errorf("unsent purchase: handler ran %d times, Retry-After %q", calls, w.Header().Get("Retry-After"))
+	}
+}
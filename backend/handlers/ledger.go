@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/ledger"
+	"oceanproxy-api/orders"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// parseFromBalance reads from_balance=true, which pays a plan or extension
+// from the customer's prepaid balance
+func parseFromBalance(form url.Values) (bool, error) {
+	v := form.Get("from_balance")
+	if v == "" {
+		return false, nil
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("from_balance must be true or false")
+	}
+	return on, nil
+}
+
+// ledgerError writes a ledger error with the matching status
+func ledgerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ledger.ErrInsufficientFunds):
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+	case errors.Is(err, ledger.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ledger.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fmt.Sprintf("Failed to update ledger: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// writeTransaction answers with a transaction and the customer's balance after it
+func writeTransaction(w http.ResponseWriter, t ledger.Transaction) {
+	balance, err := ledger.Balance(t.Customer)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read ledger: %v", err), http.StatusInternalServerError)
+		return
+	}
+	JSON(w, map[string]interface{}{"transaction": t, "balance_cents": balance})
+}
+
+// BalanceHandler serves GET /customers/{customer}/balance
+func BalanceHandler(w http.ResponseWriter, r *http.Request) {
+	customer := chi.URLParam(r, "customer")
+	balance, err := ledger.Balance(customer)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read ledger: %v", err), http.StatusInternalServerError)
+		return
+	}
+	JSON(w, map[string]interface{}{"customer": customer, "balance_cents": balance, "currency": config.Get().Currency})
+}
+
+// StatementHandler serves GET /customers/{customer}/statement: the
+// customer's transactions between since and until (unix or RFC3339), oldest
+// first, with the balance after each
+func StatementHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since, err := parseTimeParam(q.Get("since"))
+	if err != nil {
+		http.Error(w, "since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, err := parseTimeParam(q.Get("until"))
+	if err != nil {
+		http.Error(w, "until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, err := ledger.StatementFor(chi.URLParam(r, "customer"), since, until)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read ledger: %v", err), http.StatusInternalServerError)
+		return
+	}
+	JSON(w, s)
+}
+
+// DepositHandler serves POST /customers/{customer}/deposits with form values
+// amount, reference (e.g. the payment) and memo
+func DepositHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	cents, err := orders.ParseAmount(r.Form.Get("amount"))
+	if err != nil || cents == 0 {
+		http.Error(w, "amount must be a positive amount, e.g. 25.00", http.StatusBadRequest)
+		return
+	}
+	customer := chi.URLParam(r, "customer")
+	t, err := ledger.PostDeposit(customer, cents, r.Form.Get("reference"), r.Form.Get("memo"))
+	if err != nil {
+		ledgerError(w, err)
+		return
+	}
+	log.Printf("💰 Deposited %s %s for %s", orders.FormatAmount(cents), config.Get().Currency, customer)
+	writeTransaction(w, t)
+}
+
+// AdjustmentHandler serves POST /customers/{customer}/adjustments with form
+// values amount, negative to take credit away, and memo, which is required
+func AdjustmentHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	amount := strings.TrimSpace(r.Form.Get("amount"))
+	negative := strings.HasPrefix(amount, "-")
+	cents, err := orders.ParseAmount(strings.TrimPrefix(amount, "-"))
+	if err != nil || cents == 0 {
+		http.Error(w, "amount must be a non-zero amount, e.g. -5.00", http.StatusBadRequest)
+		return
+	}
+	if negative {
+		cents = -cents
+	}
+	memo := strings.TrimSpace(r.Form.Get("memo"))
+	if memo == "" {
+		http.Error(w, "memo is required to say why the balance is adjusted", http.StatusBadRequest)
+		return
+	}
+	customer := chi.URLParam(r, "customer")
+	t, err := ledger.PostAdjustment(customer, cents, memo)
+	if err != nil {
+		ledgerError(w, err)
+		return
+	}
+	log.Printf("🧾 Adjusted the balance of %s by %s: %s", customer, orders.FormatAmount(cents), memo)
+	writeTransaction(w, t)
+}
+
+// RefundHandler serves POST /ledger/{id}/refund: gives back amount of a
+// charge to the customer's balance, all that is left of it if empty
+func RefundHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	var cents int64
+	if v := r.Form.Get("amount"); v != "" {
+		var err error
+		if cents, err = orders.ParseAmount(v); err != nil || cents == 0 {
+			http.Error(w, "amount must be a positive amount, e.g. 2.50", http.StatusBadRequest)
+			return
+		}
+	}
+	t, err := ledger.PostRefund(chi.URLParam(r, "id"), cents, r.Form.Get("memo"))
+	if err != nil {
+		ledgerError(w, err)
+		return
+	}
+	log.Printf("↩️ Refunded %s of charge %s to %s", orders.FormatAmount(t.AmountCents), t.Reverses, t.Customer)
+	writeTransaction(w, t)
+}
+
+// LedgerAccountsHandler serves GET /ledger/accounts: the debit balance of
+// every account, which sum to zero
+func LedgerAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	accounts, err := ledger.Accounts()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read ledger: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var total int64
+	for _, cents := range accounts {
+		total += cents
+	}
+	JSON(w, map[string]interface{}{"accounts": accounts, "total_cents": total, "currency": config.Get().Currency})
+}
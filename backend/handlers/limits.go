@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"oceanproxy-api/accesslog"
+	"oceanproxy-api/nodes"
+	"oceanproxy-api/proxy"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Form values that set rate limits, at plan creation or on /plans/{id}/limits
+const (
+	limitUpField   = "limit_up"        // bytes per second, client to target
+	limitDownField = "limit_down"      // bytes per second, target to client
+	limitConnField = "limit_conn_rate" // new connections per second
+)
+
+// parseRateLimits applies the limit form values present in form to l. 0
+// removes a limit. It reports whether any value was given.
+func parseRateLimits(form url.Values, l proxy.RateLimits) (proxy.RateLimits, bool, error) {
+	given := false
+	for _, f := range []struct {
+		name string
+		set  func(n int64)
+	}{
+		{limitUpField, func(n int64) { l.UpBytesPerSec = n }},
+		{limitDownField, func(n int64) { l.DownBytesPerSec = n }},
+		{limitConnField, func(n int64) { l.ConnsPerSec = int(n) }},
+	} {
+		v := form.Get(f.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n < 0 {
+			return l, false, fmt.Errorf("%s must be a non-negative integer", f.name)
+		}
+		f.set(n)
+		given = true
+	}
+	return l, given, nil
+}
+
+// SetPlanLimitsHandler serves POST /plans/{id}/limits. Form values limit_up
+// and limit_down (bytes per second) and limit_conn_rate (new connections per
+// second) change only the limits given; 0 removes one. The plan's listeners
+// are restarted with the new limits in the background.
+func SetPlanLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	planID := chi.URLParam(r, "id")
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var limits proxy.RateLimits
+	var formErr error
+	err := proxy.UpdateProxyLog(func(entries []proxy.Entry) ([]proxy.Entry, error) {
+		found := false
+		for i := range entries {
+			if entries[i].PlanID != planID {
+				continue
+			}
+			l, given, err := parseRateLimits(r.Form, entries[i].RateLimits)
+			if err == nil && !given {
+				err = errors.New("limit_up, limit_down or limit_conn_rate is required")
+			}
+			if err != nil {
+				formErr = err
+				return nil, err
+			}
+			entries[i].RateLimits = l
+			limits, found = l, true
+		}
+		if !found {
+			return nil, errPlanNotFound
+		}
+		return entries, nil
+	})
+	switch {
+	case formErr != nil:
+		http.Error(w, formErr.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errPlanNotFound):
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to save proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("🚦 Plan %s limits set: up %d B/s, down %d B/s, %d conn/s", planID, limits.UpBytesPerSec, limits.DownBytesPerSec, limits.ConnsPerSec)
+	JSON(w, map[string]interface{}{
+		"success":    true,
+		"plan_id":    planID,
+		"limits":     limits,
+		"restarting": restartListeners(planID),
+	})
+}
+
+// PlanUsageHandler serves GET /plans/{id}/usage: configured limits, current
+// rates over a recent window (?window=, default 1m) and totals over the
+// retained connection log, including listeners on edge nodes. Traffic is
+// counted when a connection closes, so rates lag for long-lived connections.
+func PlanUsageHandler(w http.ResponseWriter, r *http.Request) {
+	planID := chi.URLParam(r, "id")
+
+	window := time.Minute
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "window must be a positive duration like 5m", http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var own []proxy.Entry
+	for _, e := range entries {
+		if e.PlanID == planID {
+			own = append(own, e)
+		}
+	}
+	if len(own) == 0 {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+
+	if err := accesslog.Collect(); err != nil {
+		log.Printf("⚠️ Access log collection failed: %v", err)
+	}
+	now := time.Now()
+	recent, err := nodes.PlanUsage(own, accesslog.Query{PlanID: planID, Since: now.Add(-window), Until: now})
+	if err != nil {
+		http.Error(w, "Failed to read connection records: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	total, err := nodes.PlanUsage(own, accesslog.Query{PlanID: planID})
+	if err != nil {
+		http.Error(w, "Failed to read connection records: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	open, err := nodes.PlanConnections(own, now.Unix())
+	if err != nil {
+		http.Error(w, "Failed to count open connections: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	secs := window.Seconds()
+	JSON(w, map[string]interface{}{
+		"plan_id":  planID,
+		"limits":   own[0].RateLimits,
+		"max_conn": own[0].MaxConn(),
+		"current": map[string]interface{}{
+			"window":             window.String(),
+			"up_bytes_per_sec":   float64(recent.BytesOut) / secs,
+			"down_bytes_per_sec": float64(recent.BytesIn) / secs,
+			"conns_per_sec":      float64(recent.Connections) / secs,
+			"open_connections":   open,
+		},
+		"total":       total,
+		"quota_bytes": own[0].QuotaBytes,
+	})
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"oceanproxy-api/prober"
+	"oceanproxy-api/proxy"
+)
+
+// MetricsHandler exposes internal stats in the Prometheus text format
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+
+	fmt.Fprintln(&b, "# HELP oceanproxy_uptime_seconds Seconds since the API started.")
+	fmt.Fprintln(&b, "# TYPE oceanproxy_uptime_seconds gauge")
+	fmt.Fprintf(&b, "oceanproxy_uptime_seconds %d\n", int64(time.Since(startTime).Seconds()))
+
+	upstreams := prober.Snapshot()
+
+	fmt.Fprintln(&b, "# HELP oceanproxy_upstream_probe_success_ratio Share of successful probes in the recent window.")
+	fmt.Fprintln(&b, "# TYPE oceanproxy_upstream_probe_success_ratio gauge")
+	for _, u := range upstreams {
+		fmt.Fprintf(&b, "oceanproxy_upstream_probe_success_ratio{upstream=%q} %g\n", u.Upstream, u.SuccessRate)
+	}
+
+	fmt.Fprintln(&b, "# HELP oceanproxy_upstream_probe_latency_ms Probe latency percentiles over the recent window.")
+	fmt.Fprintln(&b, "# TYPE oceanproxy_upstream_probe_latency_ms gauge")
+	for _, u := range upstreams {
+		fmt.Fprintf(&b, "oceanproxy_upstream_probe_latency_ms{upstream=%q,quantile=\"0.5\"} %g\n", u.Upstream, u.LatencyP50)
+		fmt.Fprintf(&b, "oceanproxy_upstream_probe_latency_ms{upstream=%q,quantile=\"0.9\"} %g\n", u.Upstream, u.LatencyP90)
+		fmt.Fprintf(&b, "oceanproxy_upstream_probe_latency_ms{upstream=%q,quantile=\"0.99\"} %g\n", u.Upstream, u.LatencyP99)
+	}
+
+	fmt.Fprintln(&b, "# HELP oceanproxy_upstream_probes_total Probes sent through each upstream.")
+	fmt.Fprintln(&b, "# TYPE oceanproxy_upstream_probes_total counter")
+	for _, u := range upstreams {
+		fmt.Fprintf(&b, "oceanproxy_upstream_probes_total{upstream=%q} %d\n", u.Upstream, u.TotalProbes)
+	}
+
+	fmt.Fprintln(&b, "# HELP oceanproxy_upstream_probe_failures_total Failed probes for each upstream.")
+	fmt.Fprintln(&b, "# TYPE oceanproxy_upstream_probe_failures_total counter")
+	for _, u := range upstreams {
+		fmt.Fprintf(&b, "oceanproxy_upstream_probe_failures_total{upstream=%q} %d\n", u.Upstream, u.TotalFailed)
+	}
+
+	if entries, err := proxy.LoadProxyLog(); err == nil {
+		conns := proxy.Concurrency(entries, time.Now().Unix())
+
+		fmt.Fprintln(&b, "# HELP oceanproxy_listener_connections Established client connections per listener.")
+		fmt.Fprintln(&b, "# TYPE oceanproxy_listener_connections gauge")
+		for _, c := range conns {
+			fmt.Fprintf(&b, "oceanproxy_listener_connections{plan_id=%q,subdomain=%q} %d\n", c.PlanID, c.Subdomain, c.Connections)
+		}
+
+		fmt.Fprintln(&b, "# HELP oceanproxy_listener_max_connections Concurrent connection cap per listener.")
+		fmt.Fprintln(&b, "# TYPE oceanproxy_listener_max_connections gauge")
+		for _, c := range conns {
+			fmt.Fprintf(&b, "oceanproxy_listener_max_connections{plan_id=%q,subdomain=%q} %d\n", c.PlanID, c.Subdomain, c.MaxConn)
+		}
+
+		// New connections to a listener at its cap wait unanswered, see create_proxy_plan.sh
+		fmt.Fprintln(&b, "# HELP oceanproxy_listener_at_limit 1 while a listener is at its connection cap and new clients are kept waiting.")
+		fmt.Fprintln(&b, "# TYPE oceanproxy_listener_at_limit gauge")
+		for _, c := range conns {
+			atLimit := 0
+			if c.AtLimit {
+				atLimit = 1
+			}
+			fmt.Fprintf(&b, "oceanproxy_listener_at_limit{plan_id=%q,subdomain=%q} %d\n", c.PlanID, c.Subdomain, atLimit)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(b.String()))
+}
@@ -11,11 +11,13 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"oceanproxy-api/config"
+	"oceanproxy-api/prober"
 	"oceanproxy-api/proxy"
 )
 
@@ -42,6 +44,9 @@ type ProxyStats struct {
 	ProxiesByType  map[string]int           `json:"proxies_by_type"`
 	PortUsage      map[string]PortUsageInfo `json:"port_usage"`
 	RecentProxies  []proxy.Entry            `json:"recent_proxies"`
+
+	// Active listeners, busiest relative to their cap first
+	Connections []proxy.ListenerConnections `json:"connections"`
 }
 
 type PortUsageInfo struct {
@@ -72,10 +77,11 @@ type DomainStatus struct {
 }
 
 type MonitoringData struct {
-	System      SystemStats  `json:"system"`
-	Proxies     ProxyStats   `json:"proxies"`
-	Network     NetworkStats `json:"network"`
-	LastUpdated time.Time    `json:"last_updated"`
+	System      SystemStats             `json:"system"`
+	Proxies     ProxyStats              `json:"proxies"`
+	Network     NetworkStats            `json:"network"`
+	Upstreams   []prober.UpstreamHealth `json:"upstreams"`
+	LastUpdated time.Time               `json:"last_updated"`
 }
 
 // MonitoringAPIHandler returns JSON monitoring data
@@ -90,7 +96,7 @@ func MonitoringAPIHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if token != config.BearerToken {
+	if token != config.Get().BearerToken {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -112,7 +118,7 @@ func MonitoringPanelHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if token != config.BearerToken {
+	if token != config.Get().BearerToken {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -122,7 +128,7 @@ func MonitoringPanelHandler(w http.ResponseWriter, r *http.Request) {
 
 	templateData := map[string]interface{}{
 		"Token":   token,
-		"Domain":  config.BaseDomain,
+		"Domain":  config.Get().BaseDomain,
 		"ApiPort": os.Getenv("PORT"),
 	}
 
@@ -137,6 +143,7 @@ func collectMonitoringData() MonitoringData {
 		System:      getSystemStats(),
 		Proxies:     getProxyStats(),
 		Network:     getNetworkStats(),
+		Upstreams:   prober.Snapshot(),
 		LastUpdated: time.Now(),
 	}
 }
@@ -216,7 +223,7 @@ func getProxyStats() ProxyStats {
 	}
 
 	// Read proxy log
-	data, err := os.ReadFile(proxy.LogPath)
+	data, err := os.ReadFile(config.ProxyLogPath)
 	if err != nil {
 		return stats
 	}
@@ -274,6 +281,12 @@ func getProxyStats() ProxyStats {
 		}
 	}
 
+	stats.Connections = proxy.Concurrency(entries, now)
+	sort.SliceStable(stats.Connections, func(i, j int) bool {
+		a, b := stats.Connections[i], stats.Connections[j]
+		return a.Connections*b.MaxConn > b.Connections*a.MaxConn
+	})
+
 	// Get recent proxies (last 10)
 	if len(entries) > 0 {
 		start := 0
@@ -314,8 +327,8 @@ func getNetworkStats() NetworkStats {
 		3210: "epsilon",
 		2109: "zeta",
 		1098: "eta",
-		9090: "api",
 	}
+	proxyPorts[config.ListenPort()] = "api"
 
 	// Check open ports
 	for port, service := range proxyPorts {
@@ -346,7 +359,7 @@ func getNetworkStats() NetworkStats {
 		}
 
 		// Check DNS resolution
-		fullDomain := fmt.Sprintf("%s.%s", subdomain, config.BaseDomain)
+		fullDomain := fmt.Sprintf("%s.%s", subdomain, config.Get().BaseDomain)
 		if ips, err := net.LookupHost(fullDomain); err == nil && len(ips) > 0 {
 			status.Resolves = true
 			status.ResolvedIP = ips[0]
@@ -384,7 +397,7 @@ func reversePortMap() map[string]int {
 		"epsilon":    3210,
 		"zeta":       2109,
 		"eta":        1098,
-		"api":        9090,
+		"api":        config.ListenPort(),
 	}
 }
 
@@ -692,6 +705,12 @@ var monitoringHTML = template.Must(template.New("monitoring").Parse(`
             border: 1px solid rgba(255, 71, 87, 0.3);
         }
 
+        .status-badge.warning {
+            background: rgba(255, 184, 0, 0.2);
+            color: #ffb800;
+            border: 1px solid rgba(255, 184, 0, 0.3);
+        }
+
         /* Recent Proxies */
         .proxy-list {
             max-height: 400px;
@@ -977,6 +996,56 @@ var monitoringHTML = template.Must(template.New("monitoring").Parse(`
                     </div>
                 </div>
 
+                <!-- Upstream Health -->
+                <div class="card">
+                    <h2>
+                        <span class="icon">🩺</span>
+                        Upstream Health
+                    </h2>
+                    <div class="domain-grid">
+                        ${data.upstreams && data.upstreams.length > 0 ?
+                            data.upstreams.map(u => ` + "`" + `
+                                <div class="domain-item">
+                                    <div class="domain-name">${u.upstream}</div>
+                                    <div class="status-badge ${u.success_rate >= 0.9 ? 'success' : 'danger'}">
+                                        ${(u.success_rate * 100).toFixed(0)}% ok
+                                    </div>
+                                    <div class="status-badge ${u.last_ok ? 'success' : 'danger'}">
+                                        p50 ${u.latency_p50_ms}ms • p99 ${u.latency_p99_ms}ms
+                                    </div>
+                                </div>
+                            ` + "`" + `).join('') :
+                            '<p style="text-align: center; color: #8892b0;">No probe results yet</p>'
+                        }
+                    </div>
+                </div>
+
+                <!-- Concurrent Connections -->
+                <div class="card">
+                    <h2>
+                        <span class="icon">🔗</span>
+                        Concurrent Connections
+                    </h2>
+                    <div class="proxy-list">
+                        ${data.proxies.connections && data.proxies.connections.some(c => c.connections > 0) ?
+                            data.proxies.connections.filter(c => c.connections > 0).slice(0, 10).map(c => ` + "`" + `
+                                <div class="proxy-item">
+                                    <div class="proxy-info">
+                                        <div class="proxy-username">${c.username}</div>
+                                        <div class="proxy-details">
+                                            ${c.subdomain} • Plan: ${c.plan_id} • Local: ${c.local_port}
+                                        </div>
+                                    </div>
+                                    <div class="status-badge ${c.at_limit ? 'danger' : (c.connections * 10 >= c.max_conn * 8 ? 'warning' : 'success')}">
+                                        ${c.connections} / ${c.max_conn}
+                                    </div>
+                                </div>
+                            ` + "`" + `).join('') :
+                            '<p style="text-align: center; color: #8892b0;">No open connections</p>'
+                        }
+                    </div>
+                </div>
+
                 <!-- Recent Proxies -->
                 <div class="card">
                     <h2>
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/nodes"
+	"oceanproxy-api/proxy"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// nodeView is a registered node as listed by GET /nodes
+type nodeView struct {
+	nodes.Node
+	Listeners int            `json:"listeners"`           // entries placed on it
+	FreePorts map[string]int `json:"free_ports"`          // per region it serves
+	Reachable *bool          `json:"reachable,omitempty"` // with status=true
+	Running   *int           `json:"running,omitempty"`   // listeners up, with status=true
+	Error     string         `json:"error,omitempty"`
+}
+
+// requireControl refuses node management on a host that is not a control plane
+func requireControl(w http.ResponseWriter) bool {
+	if config.NodeRole != config.RoleControl {
+		http.Error(w, "This host is not a control plane (NODE_ROLE=control)", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// ListNodesHandler serves GET /nodes. status=true also asks every agent
+// what it is running.
+func ListNodesHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := nodes.Load()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read node registry: %v", err), http.StatusInternalServerError)
+		return
+	}
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	placed := make(map[string]int)
+	for _, e := range entries {
+		placed[e.Node]++
+	}
+
+	var regions []string
+	for sub := range proxy.GetPortUsage() {
+		regions = append(regions, sub)
+	}
+	sort.Strings(regions)
+
+	views := make([]nodeView, 0, len(list))
+	for _, n := range list {
+		v := nodeView{Node: n.Masked(), Listeners: placed[n.ID], FreePorts: make(map[string]int)}
+		for _, sub := range regions {
+			if n.Serves(sub) {
+				v.FreePorts[sub] = proxy.FreePorts(n.ID, sub)
+			}
+		}
+		if r.URL.Query().Get("status") == "true" {
+			s, err := n.Status()
+			ok := err == nil
+			v.Reachable = &ok
+			if err != nil {
+				v.Error = err.Error()
+			} else {
+				running := 0
+				for _, l := range s.Listeners {
+					if l.Running {
+						running++
+					}
+				}
+				v.Running = &running
+			}
+		}
+		views = append(views, v)
+	}
+	JSON(w, map[string]interface{}{
+		"role":  config.NodeRole,
+		"local": placed[""],
+		"nodes": views,
+	})
+}
+
+// AddNodeHandler serves POST /nodes with form values id, url, token, domain
+// and regions (comma separated, empty for all). The url must be https://
+// unless insecure=true. The agent must answer a status call with the token
+// unless verify=false.
+func AddNodeHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireControl(w) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	n := nodes.Node{
+		ID:       strings.TrimSpace(r.Form.Get("id")),
+		URL:      strings.TrimSpace(r.Form.Get("url")),
+		Token:    r.Form.Get("token"),
+		Domain:   strings.TrimSpace(r.Form.Get("domain")),
+		AddedAt:  time.Now().Unix(),
+		Insecure: r.Form.Get("insecure") == "true",
+	}
+	for _, region := range strings.Split(r.Form.Get("regions"), ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			n.Regions = append(n.Regions, region)
+		}
+	}
+	if err := nodes.Validate(n); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("verify") != "false" {
+		if _, err := n.Status(); err != nil {
+			http.Error(w, fmt.Sprintf("Agent did not answer: %v", err), http.StatusBadGateway)
+			return
+		}
+	}
+	if err := nodes.Add(n); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to register node: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("🛰️ Registered node %s at %s", n.ID, n.URL)
+	JSON(w, map[string]interface{}{"success": true, "node": n.Masked()})
+}
+
+// RemoveNodeHandler serves DELETE /nodes/{id}. A node that still has plans
+// is refused unless force=true, which leaves those plans in the store
+// without a reachable listener.
+func RemoveNodeHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireControl(w) {
+		return
+	}
+	id := chi.URLParam(r, "id")
+
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	remaining := 0
+	for _, e := range entries {
+		if e.Node == id {
+			remaining++
+		}
+	}
+	if remaining > 0 && r.URL.Query().Get("force") != "true" {
+		http.Error(w, fmt.Sprintf("Node %s still runs %d listener(s), delete or move its plans first or pass force=true", id, remaining), http.StatusConflict)
+		return
+	}
+
+	if err := nodes.Remove(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("🛰️ Removed node %s (%d listener(s) left behind)", id, remaining)
+	JSON(w, map[string]interface{}{"success": true, "orphaned": remaining})
+}
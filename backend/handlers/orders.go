@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"oceanproxy-api/orders"
+	"oceanproxy-api/proxy"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// OrderView is an order with the plan's credentials and proxy URLs once it
+// is fulfilled
+type OrderView struct {
+	orders.Order
+	Password  string   `json:"password,omitempty"`
+	ExpiresAt int64    `json:"expires_at,omitempty"`
+	Proxies   []string `json:"proxies,omitempty"`
+}
+
+func viewOrder(o orders.Order, entries []proxy.Entry) OrderView {
+	v := OrderView{Order: o}
+	if o.PlanID == "" {
+		return v
+	}
+	for _, e := range entries {
+		if e.PlanID == o.PlanID {
+			v.Password = e.Password
+			v.ExpiresAt = e.ExpiresAt
+			v.Proxies = append(v.Proxies, proxyURL(e))
+		}
+	}
+	return v
+}
+
+// orderError writes an orders error with the matching status
+func orderError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, orders.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, orders.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fmt.Sprintf("Failed to update order: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// writeOrder answers with one order
+func writeOrder(w http.ResponseWriter, o orders.Order) {
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	JSON(w, map[string]interface{}{"order": viewOrder(o, entries)})
+}
+
+// ListOrdersHandler serves GET /orders, newest first, filtered by customer
+// and status
+func ListOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := orders.List(r.URL.Query().Get("customer"), r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read orders: %v", err), http.StatusInternalServerError)
+		return
+	}
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	views := make([]OrderView, 0, len(list))
+	for _, o := range list {
+		views = append(views, viewOrder(o, entries))
+	}
+	JSON(w, map[string]interface{}{"orders": views, "count": len(views)})
+}
+
+// OrderProductsHandler serves GET /orders/products: what can be ordered and
+// the unit its quantity is counted in
+func OrderProductsHandler(w http.ResponseWriter, r *http.Request) {
+	JSON(w, map[string]interface{}{"products": orders.Products()})
+}
+
+// CreateOrderHandler serves POST /orders with form values product, quantity,
+// customer, threads (proxiesfo/datacenter) and tls. The order waits for
+// payment.
+func CreateOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	o := orders.Order{
+		Product:  strings.TrimSpace(r.Form.Get("product")),
+		Customer: r.Form.Get("customer"),
+	}
+	var err error
+	if o.Quantity, err = strconv.Atoi(r.Form.Get("quantity")); err != nil {
+		http.Error(w, "quantity must be a whole number", http.StatusBadRequest)
+		return
+	}
+	if v := r.Form.Get("threads"); v != "" {
+		if o.Threads, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "threads must be a whole number", http.StatusBadRequest)
+			return
+		}
+	}
+	if o.TLS, err = parseTLS(r.Form); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	o, err = orders.Create(o)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("🛒 Order %s created: %d %s of %s for %q", o.ID, o.Quantity, o.Unit, o.Product, o.Customer)
+	JSON(w, map[string]interface{}{"order": viewOrder(o, nil)})
+}
+
+// GetOrderHandler serves GET /orders/{id}
+func GetOrderHandler(w http.ResponseWriter, r *http.Request) {
+	o, err := orders.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		orderError(w, err)
+		return
+	}
+	writeOrder(w, o)
+}
+
+// PayOrderHandler serves POST /orders/{id}/paid with form value payment_ref.
+// The plan is bought in the background; poll GET /orders/{id} until the
+// status is fulfilled or failed.
+func PayOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	o, err := orders.MarkPaid(chi.URLParam(r, "id"), r.Form.Get("payment_ref"))
+	if err != nil {
+		orderError(w, err)
+		return
+	}
+	log.Printf("💳 Order %s paid (%s)", o.ID, o.PaymentRef)
+	writeOrder(w, o)
+}
+
+// CancelOrderHandler serves POST /orders/{id}/cancel for unpaid orders
+func CancelOrderHandler(w http.ResponseWriter, r *http.Request) {
+	o, err := orders.Cancel(chi.URLParam(r, "id"))
+	if err != nil {
+		orderError(w, err)
+		return
+	}
+	writeOrder(w, o)
+}
+
+// RetryOrderHandler serves POST /orders/{id}/retry for failed orders whose
+// plan was never bought. Orders flagged check_provider need checked=true,
+// once the provider has been checked for a plan bought for the order.
+func RetryOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	o, err := orders.Retry(chi.URLParam(r, "id"), r.Form.Get("checked") == "true")
+	if err != nil {
+		orderError(w, err)
+		return
+	}
+	writeOrder(w, o)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"oceanproxy-api/payments"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Largest callback POST /payments/webhook/{processor} accepts
+const maxPaymentCallback = 1 << 20
+
+// PaymentWebhookHandler serves POST /payments/webhook/{processor}. It is not
+// behind the bearer token: the processor's signature authenticates the call.
+// Every verified callback is answered 200, whatever its outcome, so the
+// processor does not retry payments that will never match; only a failure
+// to record the payment asks for a retry.
+func PaymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPaymentCallback))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid callback body: %v", err), http.StatusBadRequest)
+		return
+	}
+	rec, err := payments.Receive(chi.URLParam(r, "processor"), r.Header, body)
+	switch {
+	case errors.Is(err, payments.ErrUnknownProcessor):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, payments.ErrSignature):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to record payment: %v", err), http.StatusInternalServerError)
+		return
+	}
+	JSON(w, map[string]interface{}{"payment": rec})
+}
+
+// ListPaymentsHandler serves GET /payments, newest first, filtered by
+// outcome and order
+func ListPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := payments.Records(r.URL.Query().Get("outcome"), r.URL.Query().Get("order"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read payments: %v", err), http.StatusInternalServerError)
+		return
+	}
+	JSON(w, map[string]interface{}{"payments": list, "count": len(list), "processors": payments.Processors()})
+}
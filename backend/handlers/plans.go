@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"oceanproxy-api/events"
+	"oceanproxy-api/failover"
+	"oceanproxy-api/jobs"
+	"oceanproxy-api/ledger"
+	"oceanproxy-api/orders"
+	"oceanproxy-api/policy"
+	"oceanproxy-api/provision"
+	"oceanproxy-api/proxy"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var errPlanNotFound = errors.New("plan not found")
+
+// DeletePlanHandler removes a plan from the proxy log, then stops its
+// listeners and deletes their 3proxy configs. The plan is not cancelled at
+// the upstream provider.
+func DeletePlanHandler(w http.ResponseWriter, r *http.Request) {
+	planID := chi.URLParam(r, "id")
+
+	var gone []proxy.Entry
+	err := proxy.UpdateProxyLog(func(entries []proxy.Entry) ([]proxy.Entry, error) {
+		var kept []proxy.Entry
+		gone = nil
+		for _, e := range entries {
+			if e.PlanID == planID {
+				gone = append(gone, e)
+			} else {
+				kept = append(kept, e)
+			}
+		}
+		if len(gone) == 0 {
+			return nil, errPlanNotFound
+		}
+		return kept, nil
+	})
+	if errors.Is(err, errPlanNotFound) {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Stopped only once the plan is out of the log, so nothing respawns it
+	var removed []string
+	for _, e := range gone {
+		if err := proxy.StopListener(e); err != nil {
+			log.Printf("⚠️ Failed to stop listener on port %d for plan %s: %v", e.LocalPort, planID, err)
+		}
+		if e.Node == "" { // edge nodes remove their own when stopped
+			if err := proxy.RemoveConfig(planID, e.Subdomain); err != nil {
+				log.Printf("⚠️ Failed to remove config of plan %s on %s: %v", planID, e.Subdomain, err)
+			}
+		}
+		proxy.ReleaseEntryPort(e)
+		removed = append(removed, e.Subdomain)
+	}
+	proxy.UpdateNginxUpstreams()
+	if err := policy.SetPlan(planID, nil); err != nil {
+		log.Printf("⚠️ Failed to remove destination policy of plan %s: %v", planID, err)
+	}
+
+	log.Printf("🗑️ Deleted plan %s (%d listener(s))", planID, len(removed))
+	events.Emit(events.PlanDeleted, events.PlanData(gone))
+	JSON(w, map[string]interface{}{
+		"success": true,
+		"plan_id": planID,
+		"removed": removed,
+	})
+}
+
+// ExtendPlanHandler moves a plan's expiry. Form values: days, added to the
+// current expiry (or now if already expired), or expires_at as a unix time.
+// Listeners that are no longer running are started again. Only the local
+// expiry changes; the upstream plan must be extended with the provider.
+// With from_balance=true the added time is bought from the provider instead
+// and charged to the plan customer's balance at the catalog price; the charge
+// is reversed if the provider call fails.
+func ExtendPlanHandler(w http.ResponseWriter, r *http.Request) {
+	planID := chi.URLParam(r, "id")
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	fromBalance, err := parseFromBalance(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	days, _ := strconv.Atoi(r.Form.Get("days"))
+	expiresAt, _ := strconv.ParseInt(r.Form.Get("expires_at"), 10, 64)
+	if days <= 0 && expiresAt <= 0 {
+		http.Error(w, "days or expires_at is required", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().Unix()
+	var plan *proxy.Entry
+	for i := range entries {
+		if entries[i].PlanID == planID {
+			plan = &entries[i]
+			break
+		}
+	}
+	if plan == nil {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+
+	current := plan.ExpiresAt
+	if current == 0 {
+		http.Error(w, "Plan does not expire", http.StatusConflict)
+		return
+	}
+	if expiresAt <= 0 {
+		expiresAt = max(current, now) + int64(days)*86400
+	}
+	if expiresAt <= now {
+		http.Error(w, "New expiry is in the past", http.StatusBadRequest)
+		return
+	}
+
+	var charge ledger.Transaction
+	var quote orders.Quote
+	if fromBalance {
+		if plan.Customer == "" {
+			http.Error(w, "Plan has no customer whose balance could pay", http.StatusBadRequest)
+			return
+		}
+		if quote, err = orders.QuoteExtension(plan.Product, plan.Threads, expiresAt-max(current, now)); err != nil {
+			http.Error(w, fmt.Sprintf("Cannot charge the balance: %v", err), http.StatusBadRequest)
+			return
+		}
+		if quote.Quantity != math.Trunc(quote.Quantity) {
+			http.Error(w, fmt.Sprintf("%s is bought by whole %s, use days=", plan.Product, quote.Unit), http.StatusBadRequest)
+			return
+		}
+		charge, _, err = provision.Extend(provision.ExtendRequest{
+			PlanID:       planID,
+			Product:      plan.Product,
+			Quantity:     int(quote.Quantity),
+			Unit:         quote.Unit,
+			Customer:     plan.Customer,
+			RevenueCents: quote.TotalCents,
+			FromBalance:  true,
+		})
+		if errors.Is(err, ledger.ErrInsufficientFunds) {
+			ledgerError(w, err)
+			return
+		}
+		if err != nil {
+			providerError(w, "Failed to extend plan", err)
+			return
+		}
+	}
+
+	var extended []proxy.Entry
+	err = proxy.UpdateProxyLog(func(entries []proxy.Entry) ([]proxy.Entry, error) {
+		extended = nil
+		for i := range entries {
+			if entries[i].PlanID != planID {
+				continue
+			}
+			entries[i].ExpiresAt = expiresAt
+			entries[i].ExtendedAt = now
+			entries[i].CostCents += quote.CostCents
+			entries[i].RevenueCents += quote.TotalCents
+			extended = append(extended, entries[i])
+		}
+		if len(extended) == 0 {
+			return nil, errPlanNotFound
+		}
+		return entries, nil
+	})
+	if err != nil {
+		if charge.ID != "" {
+			// The time was bought upstream, so the charge stands
+			log.Printf("❌ Plan %s was extended with the provider and charged (%s) but could not be saved: %v", planID, charge.ID, err)
+		}
+		if errors.Is(err, errPlanNotFound) {
+			http.Error(w, "Plan not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to save proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var restarted []string
+	for _, e := range extended {
+		if e.Node == "" && portInUse(e.LocalPort) {
+			continue
+		}
+		if err := failover.Respawn(e); err != nil {
+			log.Printf("⚠️ Failed to restart listener for plan %s on %s: %v", planID, e.Subdomain, err)
+			continue
+		}
+		restarted = append(restarted, e.Subdomain)
+	}
+
+	log.Printf("📅 Extended plan %s to %s", planID, time.Unix(expiresAt, 0).Format(time.RFC3339))
+	resp := map[string]interface{}{
+		"success":    true,
+		"plan_id":    planID,
+		"expires_at": expiresAt,
+		"restarted":  restarted,
+	}
+	if charge.ID != "" {
+		resp["charge"] = charge
+	}
+	JSON(w, resp)
+}
+
+// ListPlansHandler serves GET /plans. Query parameters: subdomain, provider,
+// status (active or expired), username, customer, created_after and
+// created_before (unix or RFC3339), sort (created_at, expires_at, plan_id or
+// username, - prefix for descending, default -created_at), limit and cursor.
+func ListPlansHandler(w http.ResponseWriter, r *http.Request) {
+	pq, err := parsePlanQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	plans, next := pq.page(groupPlans(entries))
+	if plans == nil {
+		plans = []Plan{}
+	}
+	JSON(w, map[string]interface{}{
+		"plans":       plans,
+		"next_cursor": next,
+	})
+}
+
+// GetPlanHandler serves GET /plans/{id} with every endpoint of the plan
+func GetPlanHandler(w http.ResponseWriter, r *http.Request) {
+	planID := chi.URLParam(r, "id")
+
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var own []proxy.Entry
+	for _, e := range entries {
+		if e.PlanID == planID {
+			own = append(own, e)
+		}
+	}
+	if len(own) == 0 {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	JSON(w, groupPlans(own)[0])
+}
+
+// Serialises background restarts when settings change in quick succession
+var restartMu sync.Mutex
+
+// restartListeners restarts the active listeners of one plan, or of every plan
+// if planID is empty, so 3proxy picks up new settings from their config. It
+// returns how many will be restarted; the restarts run in the background.
+func restartListeners(planID string) int {
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		log.Printf("⚠️ Failed to read proxy log to restart listeners: %v", err)
+		return 0
+	}
+
+	now := time.Now().Unix()
+	var targets []proxy.Entry
+	for _, e := range entries {
+		if (planID == "" || e.PlanID == planID) && (e.ExpiresAt == 0 || e.ExpiresAt >= now) {
+			targets = append(targets, e)
+		}
+	}
+	if len(targets) == 0 {
+		return 0
+	}
+
+	jobs.After("listener-restart", 0, func() {
+		restartMu.Lock()
+		defer restartMu.Unlock()
+
+		failed := 0
+		for _, e := range targets {
+			if jobs.Stopping() {
+				return
+			}
+			if err := failover.Respawn(e); err != nil {
+				log.Printf("⚠️ Failed to restart listener for plan %s on %s: %v", e.PlanID, e.Subdomain, err)
+				failed++
+			}
+		}
+		log.Printf("🔄 Restarted %d listener(s) with new settings, %d failed", len(targets)-failed, failed)
+	})
+	return len(targets)
+}
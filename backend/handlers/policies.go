@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"oceanproxy-api/accesslog"
+	"oceanproxy-api/config"
+	"oceanproxy-api/nodes"
+	"oceanproxy-api/policy"
+	"oceanproxy-api/proxy"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GetPoliciesHandler serves GET /policies: the global rules and every plan's
+// own rules
+func GetPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := policy.Load()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read policies: %v", err), http.StatusInternalServerError)
+		return
+	}
+	JSON(w, s)
+}
+
+// SetGlobalPolicyHandler serves PUT /policies/global. Form values: one rule
+// per "rule" value in the form "<allow|deny> <targets|*> <ports|*>", or
+// defaults=true to restore the safe defaults. Every active listener is
+// restarted with the new rules in the background.
+func SetGlobalPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	rules := policy.Defaults()
+	if r.Form.Get("defaults") != "true" {
+		var err error
+		if rules, err = parseRules(r.Form["rule"]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(rules) == 0 {
+			http.Error(w, "at least one rule is required, use defaults=true to restore the safe defaults", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := policy.SetGlobal(rules); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save policies: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("🛡️ Global destination policy set to %d rule(s)", len(rules))
+	JSON(w, map[string]interface{}{
+		"success":    true,
+		"rules":      rules,
+		"restarting": restartListeners(""),
+	})
+}
+
+// GetPlanPolicyHandler serves GET /plans/{id}/policy with the plan's own
+// rules and the effective rules in evaluation order
+func GetPlanPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	planID := chi.URLParam(r, "id")
+
+	s, err := policy.Load()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read policies: %v", err), http.StatusInternalServerError)
+		return
+	}
+	effective, _ := policy.Effective(planID)
+	JSON(w, map[string]interface{}{
+		"plan_id":   planID,
+		"rules":     nonNil(s.Plans[planID]),
+		"effective": effective,
+	})
+}
+
+// SetPlanPolicyHandler serves PUT /plans/{id}/policy with "rule" form values
+// like SetGlobalPolicyHandler. Plan rules are evaluated before global rules.
+func SetPlanPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	planID := chi.URLParam(r, "id")
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	rules, err := parseRules(r.Form["rule"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(rules) == 0 {
+		http.Error(w, "at least one rule is required, use DELETE to remove the plan's rules", http.StatusBadRequest)
+		return
+	}
+	if !planExists(planID) {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	if err := policy.SetPlan(planID, rules); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save policies: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("🛡️ Destination policy of plan %s set to %d rule(s)", planID, len(rules))
+	JSON(w, map[string]interface{}{
+		"success":    true,
+		"plan_id":    planID,
+		"rules":      rules,
+		"restarting": restartListeners(planID),
+	})
+}
+
+// DeletePlanPolicyHandler serves DELETE /plans/{id}/policy, leaving the plan
+// with the global rules only
+func DeletePlanPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	planID := chi.URLParam(r, "id")
+	if err := policy.SetPlan(planID, nil); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save policies: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("🛡️ Removed destination policy of plan %s", planID)
+	JSON(w, map[string]interface{}{
+		"success":    true,
+		"plan_id":    planID,
+		"restarting": restartListeners(planID),
+	})
+}
+
+// CheckPolicyHandler serves GET /policies/check?plan_id=&target=host:port and
+// reports whether the plan may reach the target and which rule decided it
+func CheckPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	planID := r.URL.Query().Get("plan_id")
+	host, portStr, err := net.SplitHostPort(r.URL.Query().Get("target"))
+	port, perr := strconv.Atoi(portStr)
+	if err != nil || perr != nil {
+		http.Error(w, "target must be host:port", http.StatusBadRequest)
+		return
+	}
+
+	allowed, rule, err := policy.Check(planID, host, port)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read policies: %v", err), http.StatusInternalServerError)
+		return
+	}
+	resp := map[string]interface{}{
+		"plan_id": planID,
+		"target":  net.JoinHostPort(host, portStr),
+		"allowed": allowed,
+	}
+	if rule != nil {
+		resp["rule"] = rule.String()
+	}
+	JSON(w, resp)
+}
+
+// PolicyViolationsHandler serves GET /policies/violations: connections blocked
+// by the destination policy, newest first. Query parameters: plan_id, since
+// (default the last 24 hours) and limit. A control plane adds the records of
+// every edge node and lists the nodes that did not answer.
+func PolicyViolationsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	now := time.Now()
+	since, err := parseInstant(q.Get("since"), now.Add(-24*time.Hour))
+	if err != nil {
+		http.Error(w, "since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultConnectionLimit
+	if l := q.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > maxConnectionLimit {
+			http.Error(w, "limit must be 1-"+strconv.Itoa(maxConnectionLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := accesslog.Collect(); err != nil {
+		log.Printf("⚠️ Access log collection failed: %v", err)
+	}
+	query := accesslog.Query{
+		PlanID: q.Get("plan_id"),
+		Since:  since,
+		Until:  now,
+		Result: "blocked",
+		Limit:  limit,
+	}
+	records, _, err := accesslog.Search(query)
+	if err != nil {
+		http.Error(w, "Failed to read connection records: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []accesslog.Record{}
+	}
+	if config.NodeRole != config.RoleControl {
+		JSON(w, map[string]interface{}{"violations": records})
+		return
+	}
+
+	list, err := nodes.Load()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read node registry: %v", err), http.StatusInternalServerError)
+		return
+	}
+	unreachable := []string{}
+	for _, n := range list {
+		more, err := n.Violations(query)
+		if err != nil {
+			log.Printf("⚠️ Node %s did not return violations: %v", n.ID, err)
+			unreachable = append(unreachable, n.ID)
+			continue
+		}
+		records = append(records, more...)
+	}
+	sort.SliceStable(records, func(a, b int) bool { return records[a].Time.After(records[b].Time) })
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	JSON(w, map[string]interface{}{"violations": records, "unreachable": unreachable})
+}
+
+func parseRules(values []string) ([]policy.Rule, error) {
+	rules := make([]policy.Rule, 0, len(values))
+	for _, v := range values {
+		rule, err := policy.ParseRule(v)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func nonNil(rules []policy.Rule) []policy.Rule {
+	if rules == nil {
+		return []policy.Rule{}
+	}
+	return rules
+}
+
+func planExists(planID string) bool {
+	entries, _ := proxy.LoadProxyLog()
+	for _, e := range entries {
+		if e.PlanID == planID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"oceanproxy-api/providers"
+)
+
+// providerErrorStatus maps a typed provider error to the status we return to our own clients
+func providerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, providers.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, providers.ErrQuota):
+		return http.StatusPaymentRequired
+	case errors.Is(err, providers.ErrUpstreamDown):
+		return http.StatusServiceUnavailable
+	default:
+		// ErrAuth is our credential problem, not the caller's, so it stays a gateway error
+		return http.StatusBadGateway
+	}
+}
+
+// providerError writes a provider failure with the mapped status code.
+// Retry-After and the Idempotency-Key are only released when the call never
+// reached the provider; a provider that did get the call may have created
+// the plan.
+func providerError(w http.ResponseWriter, prefix string, err error) {
+	status := providerErrorStatus(err)
+	if errors.Is(err, providers.ErrNotSent) {
+		allowRetry(w)
+		if status == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "30")
+		}
+	}
+	http.Error(w, fmt.Sprintf("%s: %v", prefix, err), status)
+}
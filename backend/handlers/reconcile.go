@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"oceanproxy-api/proxy"
+	"oceanproxy-api/reconcile"
+)
+
+type reconcileFunc func(apply bool, kinds map[string]bool) (*reconcile.Report, error)
+
+// NettifyReconcileHandler compares Nettify's plans with the local proxy log.
+// GET always returns a dry-run diff; POST applies fixes only with apply=true,
+// optionally limited to a comma separated list of drift kinds.
+func NettifyReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	serveReconcile(w, r, reconcile.Nettify)
+}
+
+// ProxiesFOReconcileHandler does the same for proxies.fo reseller plans
+func ProxiesFOReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	serveReconcile(w, r, reconcile.ProxiesFO)
+}
+
+// ProxiesFOImportHandler imports proxies.fo plans that only exist upstream,
+// e.g. ones created from the proxies.fo dashboard. Pass dry_run=true to preview.
+func ProxiesFOImportHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	apply := r.Form.Get("dry_run") != "true"
+	runReconcile(w, reconcile.ProxiesFO, apply, map[string]bool{reconcile.MissingLocally: true})
+}
+
+// NettifyReconcileLastHandler returns the report from the most recent run, including scheduled ones
+func NettifyReconcileLastHandler(w http.ResponseWriter, r *http.Request) {
+	serveLastReport(w, "nettify")
+}
+
+// ProxiesFOReconcileLastHandler returns the most recent proxies.fo report
+func ProxiesFOReconcileLastHandler(w http.ResponseWriter, r *http.Request) {
+	serveLastReport(w, "proxies.fo")
+}
+
+func serveReconcile(w http.ResponseWriter, r *http.Request, run reconcileFunc) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	apply := r.Method == http.MethodPost && r.Form.Get("apply") == "true"
+
+	kinds := make(map[string]bool)
+	for _, k := range strings.Split(r.Form.Get("kinds"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			kinds[k] = true
+		}
+	}
+
+	runReconcile(w, run, apply, kinds)
+}
+
+func runReconcile(w http.ResponseWriter, run reconcileFunc, apply bool, kinds map[string]bool) {
+	report, err := run(apply, kinds)
+	if err != nil && report == nil {
+		providerError(w, "Failed to reconcile plans", err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if apply {
+		proxy.UpdateNginxUpstreams()
+	}
+	JSON(w, report)
+}
+
+func serveLastReport(w http.ResponseWriter, provider string) {
+	report := reconcile.LastReport(provider)
+	if report == nil {
+		http.Error(w, "No reconciliation has run yet", http.StatusNotFound)
+		return
+	}
+	JSON(w, report)
+}
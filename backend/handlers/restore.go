@@ -1,15 +1,17 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
-	"os"
-	"os/exec"
 	"time"
 
+	"oceanproxy-api/events"
+	"oceanproxy-api/failover"
+	"oceanproxy-api/nodes"
+	"oceanproxy-api/providers"
 	"oceanproxy-api/proxy"
 )
 
@@ -24,94 +26,139 @@ func portInUse(port int) bool {
 	return true // already bound
 }
 
+var errKillFailed = errors.New("kill failed")
+
+// respawnEntry starts the listener of e, killing whatever holds its port
+// first, and keeps any active failover
+func respawnEntry(e proxy.Entry) error {
+	// If the port is in use, assume it's stale and force kill it. Edge nodes
+	// do this themselves.
+	if e.Node == "" && portInUse(e.LocalPort) {
+		if err := proxy.KillPort(e.LocalPort); err != nil {
+			return errKillFailed
+		}
+		time.Sleep(1 * time.Second) // brief pause after killing
+	}
+	return failover.Respawn(e)
+}
+
+func respawnFailure(err error) string {
+	if errors.Is(err, errKillFailed) {
+		return " (kill failed)"
+	}
+	return ""
+}
+
+// announceRestored emits plan.restored for every plan with respawned listeners
+func announceRestored(order []string, respawned map[string][]proxy.Entry, source string) {
+	for _, planID := range order {
+		if len(respawned[planID]) == 0 {
+			continue
+		}
+		data := events.PlanData(respawned[planID])
+		data["source"] = source
+		events.Emit(events.PlanRestored, data)
+	}
+}
+
 func RestoreHandler(w http.ResponseWriter, r *http.Request) {
-	data, err := os.ReadFile(proxy.LogPath)
+	entries, err := proxy.LoadProxyLog()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to read proxy log: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	var entries []proxy.Entry
-	if err := json.Unmarshal(data, &entries); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse proxy log: %v", err), http.StatusInternalServerError)
-		return
+	// Optional plan_id query values restrict the restore to those plans, and
+	// node to the listeners of one edge node ("local" for this host)
+	only := make(map[string]bool)
+	for _, id := range r.URL.Query()["plan_id"] {
+		only[id] = true
+	}
+	node := r.URL.Query().Get("node")
+	if node == nodes.LocalID {
+		node = ""
+	}
+	selected := func(e proxy.Entry) bool {
+		return (len(only) == 0 || only[e.PlanID]) && (!r.URL.Query().Has("node") || e.Node == node)
 	}
 
-	existing := make(map[string]map[string]bool)
+	// Group entries by plan so each plan can be converged to its declared topology
+	byPlan := make(map[string][]proxy.Entry)
+	var planOrder []string
 	for _, e := range entries {
-		if existing[e.PlanID] == nil {
-			existing[e.PlanID] = make(map[string]bool)
+		if !selected(e) {
+			continue
+		}
+		if byPlan[e.PlanID] == nil {
+			planOrder = append(planOrder, e.PlanID)
 		}
-		existing[e.PlanID][e.Subdomain] = true
+		byPlan[e.PlanID] = append(byPlan[e.PlanID], e)
 	}
 
 	var restored, failed []string
 	var newEntries []proxy.Entry
+	respawned := make(map[string][]proxy.Entry)
 
 	for _, e := range entries {
-		if e.ExpiresAt < time.Now().Unix() {
-			continue // skip expired proxies
+		if (e.ExpiresAt != 0 && e.ExpiresAt < time.Now().Unix()) || !selected(e) {
+			continue // skip expired proxies and plans not asked for
 		}
 
-		// If the port is in use, assume it's stale and force kill it
-		if portInUse(e.LocalPort) {
-			if err := proxy.KillPort(e.LocalPort); err != nil {
-				failed = append(failed, e.PlanID+"-"+e.Subdomain+" (kill failed)")
-				continue
-			}
-			time.Sleep(1 * time.Second) // brief pause after killing
-		}
-
-		// Attempt to start 3proxy for this entry
-		err := proxy.Spawn3proxy(e)
-		if err != nil {
-			failed = append(failed, e.PlanID+"-"+e.Subdomain)
+		if err := respawnEntry(e); err != nil {
+			failed = append(failed, e.PlanID+"-"+e.Subdomain+respawnFailure(err))
 		} else {
 			restored = append(restored, e.PlanID+"-"+e.Subdomain)
+			respawned[e.PlanID] = append(respawned[e.PlanID], e)
 		}
+	}
 
-		// Check and restore missing counterpart region (EU/USA)
-		if e.Subdomain == "eu" && !existing[e.PlanID]["usa"] {
-			usaEntry := proxy.NewEntry(e.PlanID, e.Username, e.Password, "pr-us.proxies.fo", 1337, "usa", e.AuthPort, e.ExpiresAt)
-			if portInUse(usaEntry.LocalPort) {
-				_ = proxy.KillPort(usaEntry.LocalPort)
-			}
-			if err := proxy.Spawn3proxy(usaEntry); err == nil {
-				newEntries = append(newEntries, usaEntry)
-				restored = append(restored, e.PlanID+"-usa")
-			} else {
-				failed = append(failed, e.PlanID+"-usa")
-			}
+	// Create any endpoints a plan's product declares but the plan does not have yet
+	for _, planID := range planOrder {
+		have := byPlan[planID]
+		first := have[0]
+		if first.ExpiresAt != 0 && first.ExpiresAt < time.Now().Unix() {
+			continue
+		}
+		product, ok := providers.ProductFor(first)
+		if !ok {
+			continue
 		}
 
-		if e.Subdomain == "usa" && !existing[e.PlanID]["eu"] {
-			euEntry := proxy.NewEntry(e.PlanID, e.Username, e.Password, "pr-eu.proxies.fo", 1338, "eu", e.AuthPort, e.ExpiresAt)
-			if portInUse(euEntry.LocalPort) {
-				_ = proxy.KillPort(euEntry.LocalPort)
+		for _, ep := range providers.MissingEndpoints(product, have) {
+			newEntry := providers.PlanEndpoint(product, ep, first)
+			if first.Node != "" {
+				// Keep the plan together on its edge node
+				if err := nodes.Assign(&newEntry, first.Node); err != nil {
+					log.Printf("❌ Failed to place %s-%s on node %s: %v", planID, ep.Subdomain, first.Node, err)
+					failed = append(failed, planID+"-"+ep.Subdomain)
+					continue
+				}
+			} else if portInUse(newEntry.LocalPort) {
+				_ = proxy.KillPort(newEntry.LocalPort)
 			}
-			if err := proxy.Spawn3proxy(euEntry); err == nil {
-				newEntries = append(newEntries, euEntry)
-				restored = append(restored, e.PlanID+"-eu")
+			if err := proxy.Spawn3proxy(newEntry); err == nil {
+				newEntries = append(newEntries, newEntry)
+				restored = append(restored, planID+"-"+ep.Subdomain)
+				respawned[planID] = append(respawned[planID], newEntry)
 			} else {
-				failed = append(failed, e.PlanID+"-eu")
+				proxy.ReleaseEntryPort(newEntry)
+				failed = append(failed, planID+"-"+ep.Subdomain)
 			}
 		}
 	}
 
 	if len(newEntries) > 0 {
-		entries = append(entries, newEntries...)
-		_ = proxy.SaveProxyLog(entries)
+		if err := proxy.LogProxy(newEntries...); err != nil {
+			log.Printf("❌ Failed to record %d restored listener(s): %v", len(newEntries), err)
+		}
 	}
 
-	// Update nginx upstreams after restore
 	if len(restored) > 0 {
-		if err := exec.Command("/opt/oceanproxy/scripts/update_nginx_upstreams.sh").Run(); err != nil {
-			log.Printf("⚠️ Warning: Failed to update nginx upstreams after restore: %v", err)
-		} else {
-			log.Printf("✅ nginx upstreams updated successfully after restore")
-		}
+		proxy.UpdateNginxUpstreams()
 	}
 
+	announceRestored(planOrder, respawned, "restore")
+
 	JSON(w, map[string]interface{}{
 		"restored": restored,
 		"failed":   failed,
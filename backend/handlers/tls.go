@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"oceanproxy-api/proxy"
+	"oceanproxy-api/tlsproxy"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// parseTLS reads the tls form value that opts a plan into https:// proxy URLs
+func parseTLS(form url.Values) (bool, error) {
+	v := form.Get("tls")
+	if v == "" {
+		return false, nil
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("tls must be true or false")
+	}
+	if on && !tlsproxy.Enabled() {
+		return false, fmt.Errorf("tls is not available, no region has a TLS endpoint configured")
+	}
+	return on, nil
+}
+
+// SetPlanTLSHandler serves POST /plans/{id}/tls with form value tls=true or
+// false and returns the plan's proxy URLs. Listeners are not restarted: the
+// TLS endpoints serve every plan, the flag only selects the URLs handed out.
+func SetPlanTLSHandler(w http.ResponseWriter, r *http.Request) {
+	planID := chi.URLParam(r, "id")
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("tls") == "" {
+		http.Error(w, "tls is required", http.StatusBadRequest)
+		return
+	}
+	on, err := parseTLS(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var proxies []string
+	err = proxy.UpdateProxyLog(func(entries []proxy.Entry) ([]proxy.Entry, error) {
+		proxies = nil
+		for i := range entries {
+			if entries[i].PlanID == planID {
+				entries[i].TLS = on
+				proxies = append(proxies, proxyURL(entries[i]))
+			}
+		}
+		if proxies == nil {
+			return nil, errPlanNotFound
+		}
+		return entries, nil
+	})
+	if errors.Is(err, errPlanNotFound) {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save proxy log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("🔒 Plan %s TLS URLs set to %t", planID, on)
+	JSON(w, map[string]interface{}{
+		"success": true,
+		"plan_id": planID,
+		"tls":     on,
+		"proxies": proxies,
+	})
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"oceanproxy-api/events"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListWebhooksHandler serves GET /webhooks with secrets masked
+func ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	subs, err := events.Subscriptions()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read webhooks: %v", err), http.StatusInternalServerError)
+		return
+	}
+	masked := make([]events.Subscription, 0, len(subs))
+	for _, s := range subs {
+		masked = append(masked, s.Masked())
+	}
+	JSON(w, map[string]interface{}{
+		"webhooks":    masked,
+		"event_types": events.Types,
+	})
+}
+
+// CreateWebhookHandler serves POST /webhooks with form values url, events
+// (comma separated, empty for all), description and secret. The secret is
+// generated when not given and only returned here.
+func CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid form data: %v", err), http.StatusBadRequest)
+		return
+	}
+	s := events.Subscription{
+		URL:         strings.TrimSpace(r.Form.Get("url")),
+		Secret:      r.Form.Get("secret"),
+		Description: r.Form.Get("description"),
+	}
+	for _, t := range strings.Split(r.Form.Get("events"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			s.Events = append(s.Events, t)
+		}
+	}
+
+	s, err := events.Subscribe(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("📣 Webhook %s subscribed %s", s.ID, s.URL)
+	JSON(w, map[string]interface{}{"success": true, "webhook": s})
+}
+
+// DeleteWebhookHandler serves DELETE /webhooks/{id}
+func DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := events.Unsubscribe(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("📣 Webhook %s removed", id)
+	JSON(w, map[string]interface{}{"success": true})
+}
+
+// PingWebhookHandler serves POST /webhooks/{id}/ping: queues a webhook.ping
+// event for that subscription only
+func PingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	e, err := events.SendPing(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	JSON(w, map[string]interface{}{"success": true, "event": e})
+}
+
+// WebhookDeliveriesHandler serves GET /webhooks/deliveries: deliveries
+// waiting for their next attempt
+func WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	JSON(w, map[string]interface{}{"deliveries": events.Pending()})
+}
+
+// WebhookDeadLettersHandler serves GET /webhooks/dead-letters: deliveries
+// that gave up, newest first
+func WebhookDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	JSON(w, map[string]interface{}{"dead_letters": events.DeadLetters()})
+}
+
+// RetryDeadLetterHandler serves POST /webhooks/dead-letters/{id}/retry
+func RetryDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	d, err := events.Retry(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	JSON(w, map[string]interface{}{"success": true, "delivery": d})
+}
@@ -0,0 +1,71 @@
+// Package jobs runs the API's background loops so they can be stopped and
+// drained together on shutdown.
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ctx, cancel = context.WithCancel(context.Background())
+	wg          sync.WaitGroup
+)
+
+// Every runs fn immediately and then after each interval until shutdown. The
+// interval is re-read on every iteration so configuration reloads apply.
+// A run that is in progress when shutdown starts is allowed to finish.
+func Every(name string, interval func() time.Duration, fn func()) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			fn()
+			select {
+			case <-ctx.Done():
+				log.Printf("🛑 Background job %s stopped", name)
+				return
+			case <-time.After(interval()):
+			}
+		}
+	}()
+}
+
+// After runs fn once after delay unless shutdown starts first
+func After(name string, delay time.Duration, fn func()) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+			fn()
+		}
+	}()
+}
+
+// Stopping reports whether shutdown has started
+func Stopping() bool {
+	return ctx.Err() != nil
+}
+
+// Shutdown stops scheduling new runs and waits for in-flight runs to finish or
+// for shutdownCtx to expire, whichever comes first.
+func Shutdown(shutdownCtx context.Context) error {
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-shutdownCtx.Done():
+		return shutdownCtx.Err()
+	}
+}
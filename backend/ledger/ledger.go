@@ -0,0 +1,347 @@
+// Package ledger keeps customers' prepaid balances as a double-entry ledger.
+// Every transaction moves money between accounts with postings that sum to
+// zero: deposits from cash into the customer's account, plan charges from the
+// customer's account into revenue, and refunds and reversals back. A
+// customer's balance is what their account holds; it never goes below zero.
+package ledger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"oceanproxy-api/config"
+)
+
+const ledgerFile = "ledger.json" // in config.DataDir
+
+// Transaction kinds
+const (
+	Deposit    = "deposit"    // credit bought by the customer
+	Charge     = "charge"     // a plan created or extended from the balance
+	Reversal   = "reversal"   // a charge given back because provisioning failed
+	Refund     = "refund"     // part or all of a charge given back by an operator
+	Adjustment = "adjustment" // an operator's correction, either way
+)
+
+// System accounts; customers each have CustomerAccount(customer)
+const (
+	Cash        = "cash"        // money received for deposits
+	Revenue     = "revenue"     // plans sold from balances
+	Adjustments = "adjustments" // operator corrections
+)
+
+var (
+	// ErrInsufficientFunds is returned when a charge exceeds the balance
+	ErrInsufficientFunds = errors.New("insufficient balance")
+	// ErrNotFound is returned for unknown transactions
+	ErrNotFound = errors.New("transaction not found")
+	// ErrConflict is returned when a charge has nothing left to give back
+	ErrConflict = errors.New("transaction cannot be changed")
+)
+
+// Posting moves money into (positive, a debit) or out of (negative, a
+// credit) one account
+type Posting struct {
+	Account     string `json:"account"`
+	AmountCents int64  `json:"amount_cents"`
+}
+
+// Transaction is one balanced set of postings. Transactions are never
+// removed and, apart from linking a charge to its plan, never changed; they
+// are only given back by later ones.
+type Transaction struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	Customer    string    `json:"customer"`
+	AmountCents int64     `json:"amount_cents"` // change of the customer's balance, negative for charges
+	Currency    string    `json:"currency"`
+	Postings    []Posting `json:"postings"`
+	Reference   string    `json:"reference,omitempty"` // plan ID, payment reference, ...
+	Reverses    string    `json:"reverses,omitempty"`  // charge given back by a reversal or refund
+	Memo        string    `json:"memo,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CustomerAccount is the account holding a customer's balance
+func CustomerAccount(customer string) string {
+	return "customer:" + customer
+}
+
+var mu sync.Mutex
+
+// PostDeposit adds credit bought by the customer
+func PostDeposit(customer string, cents int64, reference, memo string) (Transaction, error) {
+	if cents <= 0 {
+		return Transaction{}, fmt.Errorf("a deposit must be positive")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return post(Transaction{Kind: Deposit, Customer: customer, AmountCents: cents, Reference: reference, Memo: memo}, Cash)
+}
+
+// PostCharge takes cents from the customer's balance, or fails with
+// ErrInsufficientFunds without changing it
+func PostCharge(customer string, cents int64, reference, memo string) (Transaction, error) {
+	if cents <= 0 {
+		return Transaction{}, fmt.Errorf("a charge must be positive")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return post(Transaction{Kind: Charge, Customer: customer, AmountCents: -cents, Reference: reference, Memo: memo}, Revenue)
+}
+
+// PostAdjustment corrects a balance by cents, which may be negative but not
+// below zero
+func PostAdjustment(customer string, cents int64, memo string) (Transaction, error) {
+	if cents == 0 {
+		return Transaction{}, fmt.Errorf("an adjustment must not be zero")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return post(Transaction{Kind: Adjustment, Customer: customer, AmountCents: cents, Memo: memo}, Adjustments)
+}
+
+// PostReversal gives back what is left of a charge after provisioning failed
+func PostReversal(chargeID, memo string) (Transaction, error) {
+	return giveBack(Reversal, chargeID, 0, memo)
+}
+
+// PostRefund gives back cents of a charge, all that is left of it if 0
+func PostRefund(chargeID string, cents int64, memo string) (Transaction, error) {
+	if cents < 0 {
+		return Transaction{}, fmt.Errorf("a refund must be positive")
+	}
+	return giveBack(Refund, chargeID, cents, memo)
+}
+
+func giveBack(kind, chargeID string, cents int64, memo string) (Transaction, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	list, err := load()
+	if err != nil {
+		return Transaction{}, err
+	}
+	var charge *Transaction
+	left := int64(0)
+	for i := range list {
+		t := &list[i]
+		switch {
+		case t.ID == chargeID:
+			charge = t
+			left -= t.AmountCents
+		case t.Reverses == chargeID:
+			left -= t.AmountCents
+		}
+	}
+	if charge == nil {
+		return Transaction{}, fmt.Errorf("%w: %s", ErrNotFound, chargeID)
+	}
+	if charge.Kind != Charge {
+		return Transaction{}, fmt.Errorf("%w: %s is a %s, only charges are given back", ErrConflict, chargeID, charge.Kind)
+	}
+	if cents == 0 {
+		cents = left
+	}
+	if left <= 0 || cents > left {
+		return Transaction{}, fmt.Errorf("%w: %s of charge %s is left to give back", ErrConflict, formatCents(left), chargeID)
+	}
+	return post(Transaction{Kind: kind, Customer: charge.Customer, AmountCents: cents, Reference: charge.Reference, Reverses: chargeID, Memo: memo}, Revenue)
+}
+
+// post books t against the contra account; mu is held
+func post(t Transaction, contra string) (Transaction, error) {
+	if t.Customer == "" {
+		return Transaction{}, fmt.Errorf("a customer is required")
+	}
+	list, err := load()
+	if err != nil {
+		return Transaction{}, err
+	}
+	account := CustomerAccount(t.Customer)
+	if t.AmountCents < 0 {
+		if balance := -balances(list)[account]; balance+t.AmountCents < 0 {
+			return Transaction{}, fmt.Errorf("%w: %s has %s, %s needed", ErrInsufficientFunds, t.Customer, formatCents(balance), formatCents(-t.AmountCents))
+		}
+	}
+
+	t.ID = "txn_" + randomHex(12)
+	t.Currency = config.Get().Currency
+	t.CreatedAt = time.Now().UTC()
+	// The customer's balance is a liability, so credit raises it
+	t.Postings = []Posting{
+		{Account: account, AmountCents: -t.AmountCents},
+		{Account: contra, AmountCents: t.AmountCents},
+	}
+	if err := save(append(list, t)); err != nil {
+		return Transaction{}, err
+	}
+	return t, nil
+}
+
+// Link records the plan a charge paid for once it is known
+func Link(id, reference string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	list, err := load()
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		if list[i].ID == id {
+			if list[i].Reference == "" {
+				list[i].Reference = reference
+				return save(list)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// Balance returns what the customer's account holds
+func Balance(customer string) (int64, error) {
+	mu.Lock()
+	list, err := load()
+	mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return -balances(list)[CustomerAccount(customer)], nil
+}
+
+// Accounts returns the debit balance of every account. They sum to zero;
+// customer accounts are negative by what they hold.
+func Accounts() (map[string]int64, error) {
+	mu.Lock()
+	list, err := load()
+	mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return balances(list), nil
+}
+
+func balances(list []Transaction) map[string]int64 {
+	out := make(map[string]int64)
+	for _, t := range list {
+		for _, p := range t.Postings {
+			out[p.Account] += p.AmountCents
+		}
+	}
+	return out
+}
+
+// Line is a transaction on a statement with the balance after it
+type Line struct {
+	Transaction
+	BalanceCents int64 `json:"balance_cents"`
+}
+
+// Statement is a customer's transactions between since and until (unix,
+// 0 for no bound), oldest first
+type Statement struct {
+	Customer     string `json:"customer"`
+	Currency     string `json:"currency"`
+	OpeningCents int64  `json:"opening_cents"`
+	ClosingCents int64  `json:"closing_cents"`
+	Lines        []Line `json:"lines"`
+}
+
+// StatementFor builds the statement of a customer
+func StatementFor(customer string, since, until int64) (Statement, error) {
+	mu.Lock()
+	list, err := load()
+	mu.Unlock()
+	if err != nil {
+		return Statement{}, err
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+
+	s := Statement{Customer: customer, Currency: config.Get().Currency, Lines: []Line{}}
+	balance := int64(0)
+	for _, t := range list {
+		if t.Customer != customer {
+			continue
+		}
+		at := t.CreatedAt.Unix()
+		if until != 0 && at >= until {
+			break
+		}
+		balance += t.AmountCents
+		if since != 0 && at < since {
+			s.OpeningCents = balance
+			continue
+		}
+		s.Lines = append(s.Lines, Line{Transaction: t, BalanceCents: balance})
+	}
+	s.ClosingCents = balance
+	return s, nil
+}
+
+// Get returns one transaction
+func Get(id string) (Transaction, error) {
+	mu.Lock()
+	list, err := load()
+	mu.Unlock()
+	if err != nil {
+		return Transaction{}, err
+	}
+	for _, t := range list {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return Transaction{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+func load() ([]Transaction, error) {
+	data, err := os.ReadFile(filepath.Join(config.DataDir, ledgerFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Transaction{}, nil
+		}
+		return nil, err
+	}
+	var list []Transaction
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s is not valid: %w", ledgerFile, err)
+	}
+	return list, nil
+}
+
+func save(list []Transaction) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(config.DataDir, ledgerFile)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func formatCents(c int64) string {
+	sign := ""
+	if c < 0 {
+		sign, c = "-", -c
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, c/100, c%100)
+}
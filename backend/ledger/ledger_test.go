@@ -0,0 +1,82 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"oceanproxy-api/config"
+)
+
+func TestLedger(t *testing.T) {
+	config.DataDir = t.TempDir()
+	config.Set(func(s *config.Settings) { s.Currency = "USD" })
+
+	if _, err := PostDeposit("tg:42", 2000, "pay1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PostCharge("tg:42", 2500, "", "plan"); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("overdraft: %v", err)
+	}
+	charge, err := PostCharge("tg:42", 1500, "", "plan proxiesfo/residential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Link(charge.ID, "plan1"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := Balance("tg:42"); b != 500 {
+		t.Errorf("balance after a charge %d", b)
+	}
+
+	if _, err := PostRefund(charge.ID, 400, "slow proxies"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PostRefund(charge.ID, 1200, ""); !errors.Is(err, ErrConflict) {
+		t.Errorf("refund above what is left: %v", err)
+	}
+	rev, err := PostReversal(charge.ID, "provider down")
+	if err != nil || rev.AmountCents != 1100 || rev.Reference != "plan1" {
+		t.Fatalf("reversal %+v, %v", rev, err)
+	}
+	if _, err := PostReversal(charge.ID, ""); !errors.Is(err, ErrConflict) {
+		t.Errorf("second reversal: %v", err)
+	}
+	if _, err := PostRefund(rev.ID, 0, ""); !errors.Is(err, ErrConflict) {
+		t.Errorf("refund of a reversal: %v", err)
+	}
+	if _, err := PostAdjustment("tg:42", -2500, "goodwill"); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("adjustment below zero: %v", err)
+	}
+	if _, err := PostAdjustment("tg:42", -500, "chargeback"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PostDeposit("web:7", 300, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, _ := Accounts()
+	var total int64
+	for _, cents := range accounts {
+		total += cents
+	}
+	if total != 0 || accounts[CustomerAccount("tg:42")] != -1500 || accounts[Cash] != 2300 || accounts[Revenue] != 0 || accounts[Adjustments] != -500 {
+		t.Errorf("accounts %v", accounts)
+	}
+
+	s, err := StatementFor("tg:42", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := ""
+	for _, l := range s.Lines {
+		kinds += l.Kind + " "
+	}
+	if kinds != "deposit charge refund reversal adjustment " || s.ClosingCents != 1500 || s.Lines[1].BalanceCents != 500 {
+		t.Errorf("statement %s closing %d", kinds, s.ClosingCents)
+	}
+	future := time.Now().Add(time.Hour).Unix()
+	if s, _ := StatementFor("tg:42", future, 0); len(s.Lines) != 0 || s.OpeningCents != 1500 {
+		t.Errorf("statement from the future %+v", s)
+	}
+}
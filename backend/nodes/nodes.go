@@ -0,0 +1,302 @@
+// Package nodes is the control plane's registry of edge agents. New plans are
+// placed on the agent that serves their regions and has the most free ports,
+// and their listeners are spawned and stopped through the agent RPC.
+package nodes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/proxy"
+)
+
+const registryFile = "nodes.json" // in config.DataDir
+
+// LocalID names this host where a node ID is expected, e.g. restore?node=local
+const LocalID = "local"
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// ErrNoCapacity is returned when no node can take a plan
+var ErrNoCapacity = errors.New("no edge node serves these regions with free ports")
+
+// Node is a registered edge agent
+type Node struct {
+	ID      string   `json:"id"`
+	URL     string   `json:"url"`               // agent API base URL
+	Token   string   `json:"token"`             // the agent's AGENT_TOKEN
+	Domain  string   `json:"domain,omitempty"`  // public hostnames are <subdomain>.<domain>, default DOMAIN
+	Regions []string `json:"regions,omitempty"` // subdomains it serves, empty for all
+	AddedAt int64    `json:"added_at"`
+
+	// Insecure allows an http:// URL, which sends the token and customer
+	// credentials in the clear; only for agents on a private network
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// Serves reports whether the node runs listeners for the subdomain
+func (n Node) Serves(subdomain string) bool {
+	if len(n.Regions) == 0 {
+		return true
+	}
+	for _, r := range n.Regions {
+		if r == subdomain {
+			return true
+		}
+	}
+	return false
+}
+
+// Masked returns the node with its token hidden
+func (n Node) Masked() Node {
+	n.Token = config.MaskString(n.Token)
+	return n
+}
+
+var mu sync.Mutex
+
+// Load returns the registered nodes sorted by ID. A missing registry is empty.
+func Load() ([]Node, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return load()
+}
+
+func load() ([]Node, error) {
+	data, err := os.ReadFile(filepath.Join(config.DataDir, registryFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Node{}, nil
+		}
+		return nil, err
+	}
+	var list []Node
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s is not valid: %w", registryFile, err)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func save(list []Node) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return err
+	}
+	// The registry holds agent tokens
+	return os.WriteFile(filepath.Join(config.DataDir, registryFile), data, 0600)
+}
+
+// Get returns a registered node by ID
+func Get(id string) (Node, error) {
+	list, err := Load()
+	if err != nil {
+		return Node{}, err
+	}
+	for _, n := range list {
+		if n.ID == id {
+			return n, nil
+		}
+	}
+	return Node{}, fmt.Errorf("node %s is not registered", id)
+}
+
+// Validate checks a node before it is registered
+func Validate(n Node) error {
+	if !validID.MatchString(n.ID) || n.ID == LocalID {
+		return fmt.Errorf("node id must be 1-32 lowercase letters, digits or dashes and not %q", LocalID)
+	}
+	if err := n.checkScheme(); err != nil {
+		return err
+	}
+	if len(n.Token) < 16 {
+		return fmt.Errorf("node token must be at least 16 characters")
+	}
+	for _, r := range n.Regions {
+		if proxy.FreePorts(n.ID, r) < 0 {
+			return fmt.Errorf("unknown region %q", r)
+		}
+	}
+	return nil
+}
+
+// checkScheme requires https unless the node was registered as insecure
+func (n Node) checkScheme() error {
+	switch {
+	case strings.HasPrefix(n.URL, "https://"):
+		return nil
+	case strings.HasPrefix(n.URL, "http://"):
+		if n.Insecure {
+			return nil
+		}
+		return fmt.Errorf("node url must use https://, or register the node as insecure to allow http:// on a private network")
+	}
+	return fmt.Errorf("node url must start with https://")
+}
+
+// Add registers a node, replacing one with the same ID
+func Add(n Node) error {
+	if err := Validate(n); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	list, err := load()
+	if err != nil {
+		return err
+	}
+	kept := list[:0]
+	for _, old := range list {
+		if old.ID != n.ID {
+			kept = append(kept, old)
+		}
+	}
+	return save(append(kept, n))
+}
+
+// Remove unregisters a node. Its plans are left in the store.
+func Remove(id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list, err := load()
+	if err != nil {
+		return err
+	}
+	kept := list[:0]
+	for _, n := range list {
+		if n.ID != id {
+			kept = append(kept, n)
+		}
+	}
+	if len(kept) == len(list) {
+		return fmt.Errorf("node %s is not registered", id)
+	}
+	return save(kept)
+}
+
+// Ready fails on a control plane without edge nodes, so a plan is not bought
+// that could not be placed
+func Ready() error {
+	if config.NodeRole != config.RoleControl {
+		return nil
+	}
+	list, err := Load()
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return ErrNoCapacity
+	}
+	return nil
+}
+
+// Place moves the entries of one new plan, allocated on this host by
+// proxy.NewEntry, to an edge node when this host is a control plane. On
+// failure the entries' ports are released.
+func Place(entries []proxy.Entry) error {
+	if config.NodeRole != config.RoleControl || len(entries) == 0 {
+		return nil
+	}
+	list, err := Load()
+	if err != nil {
+		return err
+	}
+	if err := place(list, entries); err != nil {
+		for _, e := range entries {
+			proxy.ReleaseEntryPort(e)
+		}
+		return err
+	}
+	return nil
+}
+
+// place picks the node that serves all of the entries' subdomains and has
+// the most free ports in the fullest of them. Ties go to the lowest ID.
+func place(list []Node, entries []proxy.Entry) error {
+	var best *Node
+	bestFree := 0
+	for i, n := range list {
+		free := -1
+		for _, e := range entries {
+			if !n.Serves(e.Subdomain) {
+				free = -1
+				break
+			}
+			if f := proxy.FreePorts(n.ID, e.Subdomain); free < 0 || f < free {
+				free = f
+			}
+		}
+		if free > bestFree {
+			best, bestFree = &list[i], free
+		}
+	}
+	if best == nil {
+		return ErrNoCapacity
+	}
+
+	for i := range entries {
+		if err := assign(&entries[i], *best); err != nil {
+			return err
+		}
+	}
+	log.Printf("📍 Placed plan %s on node %s (%d free ports)", entries[0].PlanID, best.ID, bestFree)
+	return nil
+}
+
+// Assign moves an entry allocated on this host to a registered node
+func Assign(e *proxy.Entry, id string) error {
+	n, err := Get(id)
+	if err != nil {
+		return err
+	}
+	return assign(e, n)
+}
+
+func assign(e *proxy.Entry, n Node) error {
+	port, err := proxy.AllocatePort(n.ID, e.Subdomain)
+	if err != nil {
+		return err
+	}
+	proxy.ReleaseEntryPort(*e)
+	e.Node = n.ID
+	e.LocalPort = port
+	domain := n.Domain
+	if domain == "" {
+		domain = config.Get().BaseDomain
+	}
+	e.LocalHost = e.Subdomain + "." + domain
+	return nil
+}
+
+// Start installs the agent client when this host is a control plane
+func Start() {
+	if config.NodeRole != config.RoleControl {
+		return
+	}
+	proxy.SetRemote(client{})
+	list, err := Load()
+	if err != nil {
+		log.Printf("⚠️ Failed to load node registry: %v", err)
+		return
+	}
+	for _, n := range list {
+		if err := n.checkScheme(); err != nil {
+			log.Printf("⚠️ Node %s is not used until it is registered again: %v", n.ID, err)
+		}
+	}
+	log.Printf("🛰️ Control plane with %d edge node(s)", len(list))
+}
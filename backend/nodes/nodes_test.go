@@ -0,0 +1,141 @@
+package nodes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oceanproxy-api/accesslog"
+	"oceanproxy-api/config"
+	"oceanproxy-api/proxy"
+)
+
+func TestPlace(t *testing.T) {
+	config.DataDir = t.TempDir()
+	config.NodeRole = config.RoleControl
+	config.Set(func(s *config.Settings) { s.BaseDomain = "example.com" })
+	defer func() { config.NodeRole = config.RoleStandalone }()
+
+	for _, n := range []Node{
+		{ID: "ams1", URL: "https://ams1:9090", Token: "0123456789abcdef", Regions: []string{"eu"}},
+		{ID: "fra1", URL: "https://fra1:9090", Token: "0123456789abcdef", Domain: "fra1.example.com"},
+		{ID: "nyc1", URL: "https://nyc1:9090", Token: "0123456789abcdef"},
+	} {
+		if err := Add(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// nyc1 is busier than fra1 in usa
+	if _, err := proxy.AllocatePort("nyc1", "usa"); err != nil {
+		t.Fatal(err)
+	}
+
+	newPlan := func(subs ...string) []proxy.Entry {
+		var entries []proxy.Entry
+		for _, sub := range subs {
+			entries = append(entries, proxy.Entry{PlanID: "p1", Subdomain: sub, LocalPort: 1})
+		}
+		return entries
+	}
+
+	entries := newPlan("usa", "eu")
+	if err := Place(entries); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Node != "fra1" || e.LocalHost != e.Subdomain+".fra1.example.com" {
+			t.Errorf("%s placed on %q as %s, want fra1", e.Subdomain, e.Node, e.LocalHost)
+		}
+	}
+
+	// ams1 has the most free eu ports once the others have taken some
+	for i := 0; i < 2; i++ {
+		_, _ = proxy.AllocatePort("fra1", "eu")
+		_, _ = proxy.AllocatePort("nyc1", "eu")
+	}
+	entries = newPlan("eu")
+	if err := Place(entries); err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].Node != "ams1" || entries[0].LocalHost != "eu.example.com" {
+		t.Errorf("eu placed on %q as %s, want ams1", entries[0].Node, entries[0].LocalHost)
+	}
+
+	if err := Place(newPlan("nowhere")); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("unknown region: got %v, want ErrNoCapacity", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ok := Node{ID: "fra1", URL: "https://fra1:9090", Token: "0123456789abcdef"}
+	if err := Validate(ok); err != nil {
+		t.Errorf("valid node rejected: %v", err)
+	}
+	if err := Validate(Node{ID: "fra1", URL: "http://10.0.0.2:9090", Token: ok.Token, Insecure: true}); err != nil {
+		t.Errorf("insecure node rejected: %v", err)
+	}
+	for name, n := range map[string]Node{
+		"reserved id":    {ID: LocalID, URL: ok.URL, Token: ok.Token},
+		"bad id":         {ID: "Fra 1", URL: ok.URL, Token: ok.Token},
+		"bad url":        {ID: "fra1", URL: "fra1:9090", Token: ok.Token},
+		"plain http":     {ID: "fra1", URL: "http://fra1:9090", Token: ok.Token},
+		"short token":    {ID: "fra1", URL: ok.URL, Token: "short"},
+		"unknown region": {ID: "fra1", URL: ok.URL, Token: ok.Token, Regions: []string{"mars"}},
+	} {
+		if err := Validate(n); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPlanUsage(t *testing.T) {
+	config.DataDir = t.TempDir()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer 0123456789abcdef" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/agent/usage":
+			if r.URL.Query().Get("plan_id") != "p1" || r.URL.Query().Get("since") == "" {
+				t.Errorf("usage query %s", r.URL.RawQuery)
+			}
+			_ = json.NewEncoder(w).Encode(accesslog.Summary{Connections: 2, BytesIn: 100, BytesOut: 10})
+		case "/agent/status":
+			_ = json.NewEncoder(w).Encode(Status{Listeners: []ListenerStatus{
+				{PlanID: "p1", Subdomain: "usa", Connections: 3},
+				{PlanID: "p2", Subdomain: "usa", Connections: 5},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	oldClient := httpClient
+	httpClient = srv.Client()
+	defer func() { httpClient = oldClient }()
+
+	if err := Add(Node{ID: "fra1", URL: srv.URL, Token: "0123456789abcdef"}); err != nil {
+		t.Fatal(err)
+	}
+	entries := []proxy.Entry{{PlanID: "p1", Subdomain: "usa", Node: "fra1"}}
+
+	s, err := PlanUsage(entries, accesslog.Query{PlanID: "p1", Since: time.Unix(1, 0)})
+	if err != nil || s.Connections != 2 || s.BytesIn != 100 || s.BytesOut != 10 {
+		t.Errorf("PlanUsage = %+v, %v", s, err)
+	}
+	if open, err := PlanConnections(entries, time.Now().Unix()); err != nil || open != 3 {
+		t.Errorf("PlanConnections = %d, %v, want 3", open, err)
+	}
+
+	// A node registered before https was required is not called
+	if err := save([]Node{{ID: "fra1", URL: "http://fra1:9090", Token: "0123456789abcdef"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PlanUsage(entries, accesslog.Query{PlanID: "p1"}); err == nil {
+		t.Error("plain http node was called")
+	}
+}
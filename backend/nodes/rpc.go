@@ -0,0 +1,122 @@
+package nodes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"oceanproxy-api/proxy"
+)
+
+// Agent RPC: JSON over HTTP with the agent's token as a bearer token
+
+// SpawnRequest is the body of POST /agent/spawn
+type SpawnRequest struct {
+	Entry  proxy.Entry  `json:"entry"`
+	Parent proxy.Parent `json:"parent"`
+	ACL    string       `json:"acl"` // rendered destination policy
+}
+
+// StopRequest is the body of POST /agent/stop
+type StopRequest struct {
+	PlanID    string `json:"plan_id"`
+	Subdomain string `json:"subdomain"`
+	LocalPort int    `json:"local_port"`
+}
+
+// Status is the reply of GET /agent/status
+type Status struct {
+	Host      string           `json:"host"`
+	Listeners []ListenerStatus `json:"listeners"`
+	Ports     map[string]int   `json:"ports_used"` // subdomain -> allocated ports
+}
+
+// ListenerStatus is one listener on an agent
+type ListenerStatus struct {
+	PlanID      string `json:"plan_id"`
+	Subdomain   string `json:"subdomain"`
+	LocalPort   int    `json:"local_port"`
+	Running     bool   `json:"running"`
+	Connections int    `json:"connections"`
+}
+
+// Spawn and stop wait for create_proxy_plan.sh on the agent
+const (
+	spawnTimeout  = 2 * time.Minute
+	statusTimeout = 5 * time.Second
+)
+
+var httpClient = &http.Client{}
+
+// client implements proxy.Remote against the registered agents
+type client struct{}
+
+func (client) Spawn(e proxy.Entry, parent proxy.Parent, acl string) error {
+	n, err := Get(e.Node)
+	if err != nil {
+		return err
+	}
+	return n.call(http.MethodPost, "/agent/spawn", SpawnRequest{Entry: e, Parent: parent, ACL: acl}, nil, spawnTimeout)
+}
+
+func (client) Stop(e proxy.Entry) error {
+	n, err := Get(e.Node)
+	if err != nil {
+		return err
+	}
+	req := StopRequest{PlanID: e.PlanID, Subdomain: e.Subdomain, LocalPort: e.LocalPort}
+	return n.call(http.MethodPost, "/agent/stop", req, nil, spawnTimeout)
+}
+
+// Status asks the agent what it is running
+func (n Node) Status() (*Status, error) {
+	var s Status
+	if err := n.call(http.MethodGet, "/agent/status", nil, &s, statusTimeout); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (n Node) call(method, path string, body, out interface{}, timeout time.Duration) error {
+	if err := n.checkScheme(); err != nil {
+		return fmt.Errorf("node %s: %w", n.ID, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(n.URL, "/")+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("node %s: %w", n.ID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("node %s: %s: %s", n.ID, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package nodes
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"oceanproxy-api/accesslog"
+	"oceanproxy-api/proxy"
+)
+
+// Usage totals the agent's connection records matching q.PlanID, q.Since and q.Until
+func (n Node) Usage(q accesslog.Query) (accesslog.Summary, error) {
+	var s accesslog.Summary
+	err := n.call(http.MethodGet, "/agent/usage?"+recordQuery(q).Encode(), nil, &s, statusTimeout)
+	return s, err
+}
+
+// Violations returns the agent's newest blocked connections matching
+// q.PlanID and q.Since, at most q.Limit of them
+func (n Node) Violations(q accesslog.Query) ([]accesslog.Record, error) {
+	var resp struct {
+		Violations []accesslog.Record `json:"violations"`
+	}
+	err := n.call(http.MethodGet, "/agent/violations?"+recordQuery(q).Encode(), nil, &resp, statusTimeout)
+	return resp.Violations, err
+}
+
+func recordQuery(q accesslog.Query) url.Values {
+	v := url.Values{}
+	if q.PlanID != "" {
+		v.Set("plan_id", q.PlanID)
+	}
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.Format(time.RFC3339Nano))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", q.Until.Format(time.RFC3339Nano))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// PlanUsage totals the connection records matching q on this host and on
+// every node the plan's entries run on
+func PlanUsage(entries []proxy.Entry, q accesslog.Query) (accesslog.Summary, error) {
+	total, err := accesslog.Summarize(q)
+	if err != nil {
+		return total, err
+	}
+	for _, id := range nodesOf(entries) {
+		n, err := Get(id)
+		if err != nil {
+			return total, err
+		}
+		s, err := n.Usage(q)
+		if err != nil {
+			return total, err
+		}
+		total.Connections += s.Connections
+		total.BytesIn += s.BytesIn
+		total.BytesOut += s.BytesOut
+	}
+	return total, nil
+}
+
+// PlanConnections counts the open connections of the entries that have not
+// expired, asking the nodes for the ones that run there
+func PlanConnections(entries []proxy.Entry, now int64) (int, error) {
+	open := 0
+	var local, remote []proxy.Entry
+	listeners := make(map[string]bool)
+	for _, e := range entries {
+		if e.Node == "" {
+			local = append(local, e)
+		} else if e.ExpiresAt == 0 || e.ExpiresAt >= now {
+			remote = append(remote, e)
+			listeners[e.Node+"/"+e.PlanID+"/"+e.Subdomain] = true
+		}
+	}
+	for _, c := range proxy.Concurrency(local, now) {
+		open += c.Connections
+	}
+	for _, id := range nodesOf(remote) {
+		n, err := Get(id)
+		if err != nil {
+			return open, err
+		}
+		s, err := n.Status()
+		if err != nil {
+			return open, err
+		}
+		for _, l := range s.Listeners {
+			if listeners[id+"/"+l.PlanID+"/"+l.Subdomain] {
+				open += l.Connections
+			}
+		}
+	}
+	return open, nil
+}
+
+// nodesOf lists the nodes the entries run on, in order of first appearance
+func nodesOf(entries []proxy.Entry) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if e.Node != "" && !seen[e.Node] {
+			seen[e.Node] = true
+			ids = append(ids, e.Node)
+		}
+	}
+	return ids
+}
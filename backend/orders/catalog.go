@@ -0,0 +1,283 @@
+package orders
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/provision"
+)
+
+const catalogFile = "catalog.json" // in config.DataDir
+
+// proxies.fo datacenter plans bought without threads get this many
+const defaultThreads = 500
+
+// ErrNoPrice is returned when the catalog has no price for what is quoted
+var ErrNoPrice = errors.New("no catalog price")
+
+// Price is what one unit of a product costs us and the customer, in cents
+// of the configured currency
+type Price struct {
+	Product    string     `json:"product"`
+	Threads    int        `json:"threads,omitempty"` // thread tier of proxiesfo/datacenter, 0 for any
+	CostCents  int64      `json:"cost_cents"`        // provider cost per unit
+	PriceCents int64      `json:"price_cents"`       // sell price per unit
+	Discounts  []Discount `json:"discounts,omitempty"`
+}
+
+// Discount takes Percent off the sell price of orders of at least MinQuantity units
+type Discount struct {
+	MinQuantity int     `json:"min_quantity"`
+	Percent     float64 `json:"percent"`
+}
+
+// Quote is the price of a quantity of a product
+type Quote struct {
+	Product         string  `json:"product"`
+	Quantity        float64 `json:"quantity"`
+	Unit            string  `json:"unit"`
+	Threads         int     `json:"threads,omitempty"` // tier the price was taken from
+	Currency        string  `json:"currency"`
+	UnitPriceCents  int64   `json:"unit_price_cents"`
+	SubtotalCents   int64   `json:"subtotal_cents"`
+	DiscountPercent float64 `json:"discount_percent,omitempty"`
+	DiscountCents   int64   `json:"discount_cents,omitempty"`
+	TotalCents      int64   `json:"total_cents"`
+	CostCents       int64   `json:"cost_cents"`
+	MarginCents     int64   `json:"margin_cents"`
+}
+
+var catalogMu sync.Mutex
+
+// Catalog returns the prices sorted by product and tier
+func Catalog() ([]Price, error) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	return loadCatalog()
+}
+
+func loadCatalog() ([]Price, error) {
+	data, err := os.ReadFile(filepath.Join(config.DataDir, catalogFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Price{}, nil
+		}
+		return nil, err
+	}
+	var list []Price
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s is not valid: %w", catalogFile, err)
+	}
+	return list, nil
+}
+
+func saveCatalog(list []Price) error {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Product != list[j].Product {
+			return list[i].Product < list[j].Product
+		}
+		return list[i].Threads < list[j].Threads
+	})
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(catalogFile, data, 0644)
+}
+
+// SetPrice adds a price or replaces the one of the same product and tier
+func SetPrice(p Price) error {
+	if _, ok := lookup(p.Product); !ok {
+		return fmt.Errorf("unknown product %q", p.Product)
+	}
+	if p.Threads < 0 || (p.Threads > 0 && p.Product != "proxiesfo/datacenter") {
+		return fmt.Errorf("thread tiers are only priced for proxiesfo/datacenter")
+	}
+	if p.CostCents < 0 || p.PriceCents < 0 {
+		return fmt.Errorf("cost and price cannot be negative")
+	}
+	seen := make(map[int]bool)
+	for _, d := range p.Discounts {
+		if d.MinQuantity < 1 || d.Percent <= 0 || d.Percent >= 100 || seen[d.MinQuantity] {
+			return fmt.Errorf("discounts need distinct minimum quantities of at least 1 and a percentage between 0 and 100")
+		}
+		seen[d.MinQuantity] = true
+	}
+	sort.Slice(p.Discounts, func(i, j int) bool { return p.Discounts[i].MinQuantity < p.Discounts[j].MinQuantity })
+
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	list, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+	kept := list[:0]
+	for _, existing := range list {
+		if existing.Product != p.Product || existing.Threads != p.Threads {
+			kept = append(kept, existing)
+		}
+	}
+	return saveCatalog(append(kept, p))
+}
+
+// RemovePrice removes the price of a product tier
+func RemovePrice(product string, threads int) error {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	list, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+	kept := list[:0]
+	for _, p := range list {
+		if p.Product != product || p.Threads != threads {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == len(list) {
+		return fmt.Errorf("%w for %s", ErrNoPrice, tierName(product, threads))
+	}
+	return saveCatalog(kept)
+}
+
+// QuoteFor prices quantity units of a product. Thread tiers are matched with
+// the smallest tier that covers threads (500 if 0).
+func QuoteFor(product string, quantity float64, threads int) (Quote, error) {
+	of, ok := lookup(product)
+	if !ok {
+		return Quote{}, fmt.Errorf("unknown product %q", product)
+	}
+	if quantity <= 0 {
+		return Quote{}, fmt.Errorf("quantity must be a positive number of %s", of.Unit)
+	}
+	if product == "proxiesfo/datacenter" && threads == 0 {
+		threads = defaultThreads
+	}
+	list, err := Catalog()
+	if err != nil {
+		return Quote{}, err
+	}
+
+	var price *Price
+	for i, p := range list {
+		if p.Product != product {
+			continue
+		}
+		switch {
+		case p.Threads == 0 && price == nil:
+			price = &list[i]
+		case p.Threads >= threads && (price == nil || price.Threads == 0 || p.Threads < price.Threads):
+			price = &list[i]
+		}
+	}
+	if price == nil {
+		return Quote{}, fmt.Errorf("%w for %s", ErrNoPrice, tierName(product, threads))
+	}
+
+	q := Quote{
+		Product:        product,
+		Quantity:       quantity,
+		Unit:           of.Unit,
+		Threads:        price.Threads,
+		Currency:       config.Get().Currency,
+		UnitPriceCents: price.PriceCents,
+		SubtotalCents:  cents(float64(price.PriceCents) * quantity),
+		CostCents:      cents(float64(price.CostCents) * quantity),
+	}
+	for _, d := range price.Discounts {
+		if quantity >= float64(d.MinQuantity) {
+			q.DiscountPercent = d.Percent
+		}
+	}
+	q.DiscountCents = cents(float64(q.SubtotalCents) * q.DiscountPercent / 100)
+	q.TotalCents = q.SubtotalCents - q.DiscountCents
+	q.MarginCents = q.TotalCents - q.CostCents
+	return q, nil
+}
+
+// QuoteExtension prices extending a plan of product by seconds. Only
+// products sold by time have a price for more of it.
+func QuoteExtension(product string, threads int, seconds int64) (Quote, error) {
+	of, ok := lookup(product)
+	if !ok {
+		return Quote{}, fmt.Errorf("unknown product %q", product)
+	}
+	switch of.Unit {
+	case UnitDays:
+		return QuoteFor(product, float64(seconds)/86400, threads)
+	case UnitHours:
+		return QuoteFor(product, float64(seconds)/3600, threads)
+	}
+	return Quote{}, fmt.Errorf("%s is sold by %s, extending it has no price", product, of.Unit)
+}
+
+// QuoteForm prices a plan bought through the create endpoints from the same
+// form values and defaults the providers use
+func QuoteForm(provider string, form url.Values) (Quote, error) {
+	number := func(key string, def float64) float64 {
+		if n, err := strconv.ParseFloat(form.Get(key), 64); err == nil {
+			return n
+		}
+		return def
+	}
+	switch provider {
+	case provision.ProxiesFO:
+		if form.Get("reseller") == "datacenter" {
+			return QuoteFor("proxiesfo/datacenter", number("duration", 1), int(number("threads", defaultThreads)))
+		}
+		return QuoteFor("proxiesfo/"+form.Get("reseller"), number("bandwidth", 1), 0)
+	case provision.Nettify:
+		planType := form.Get("plan_type")
+		if planType == "" {
+			planType = "residential"
+		}
+		if planType == "unlimited" {
+			return QuoteFor("nettify/unlimited", number("hours", 1), 0)
+		}
+		return QuoteFor("nettify/"+planType, number("bandwidth", 1), 0)
+	}
+	return Quote{}, fmt.Errorf("unknown provider %q", provider)
+}
+
+func tierName(product string, threads int) string {
+	if threads > 0 {
+		return fmt.Sprintf("%s at %d threads", product, threads)
+	}
+	return product
+}
+
+func cents(v float64) int64 {
+	return int64(math.Round(v))
+}
+
+// ParseAmount reads a decimal amount such as 12.5 into cents
+func ParseAmount(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if _, frac, ok := strings.Cut(s, "."); ok && len(frac) > 2 && strings.Trim(frac[2:], "0") != "" {
+		return 0, fmt.Errorf("amount %q has fractions of a cent", s)
+	}
+	return cents(f * 100), nil
+}
+
+// FormatAmount renders cents as a decimal amount
+func FormatAmount(c int64) string {
+	sign := ""
+	if c < 0 {
+		sign, c = "-", -c
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, c/100, c%100)
+}
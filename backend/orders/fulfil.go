@@ -0,0 +1,162 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"oceanproxy-api/jobs"
+	"oceanproxy-api/providers"
+	"oceanproxy-api/provision"
+)
+
+const (
+	sweepInterval = time.Minute
+	// Paid orders are retried this many times while the provider cannot be
+	// reached or no node has room, before they fail
+	maxAttempts = 5
+)
+
+var (
+	inFlightMu sync.Mutex
+	inFlight   = make(map[string]bool)
+	startOnce  sync.Once
+)
+
+// provisionPlan buys the plan; replaced in tests
+var provisionPlan = provision.Plan
+
+// Start fulfils paid orders left over from before a restart and retries paid
+// orders whose provider was down. Orders that were being fulfilled when the
+// API stopped fail, since their plan may have been bought.
+func Start() {
+	startOnce.Do(func() {
+		interrupted()
+		jobs.Every("orders", func() time.Duration { return sweepInterval }, sweep)
+	})
+}
+
+func interrupted() {
+	list, err := List("", Fulfilling)
+	if err != nil {
+		log.Printf("⚠️ Failed to read orders: %v", err)
+		return
+	}
+	for _, o := range list {
+		_, _ = update(o.ID, func(o *Order) error {
+			o.Status = Failed
+			o.CheckProvider = true
+			o.Error = "interrupted by a restart, check the provider for a plan before retrying"
+			return nil
+		})
+		log.Printf("⚠️ Order %s was interrupted while being fulfilled", o.ID)
+	}
+}
+
+func sweep() {
+	list, err := List("", Paid)
+	if err != nil {
+		log.Printf("⚠️ Failed to read orders: %v", err)
+		return
+	}
+	for i := len(list) - 1; i >= 0; i-- { // oldest first
+		fulfil(list[i].ID)
+	}
+}
+
+// kick fulfils an order in the background. Shutdown waits for it, so a
+// purchase that has started is not cut off; one that has not stays paid for
+// the sweep after the restart.
+func kick(id string) {
+	jobs.After("order-"+id, 0, func() { fulfil(id) })
+}
+
+// fulfil buys the plan of a paid order. Only failures where the purchase
+// provably never reached the provider (its circuit breaker was open, the
+// connection could not be made or no node had room) leave the order paid for
+// the next sweep. Any other failure may have bought a plan, so the order fails
+// with CheckProvider set instead of being sent again.
+func fulfil(id string) {
+	inFlightMu.Lock()
+	if inFlight[id] {
+		inFlightMu.Unlock()
+		return
+	}
+	inFlight[id] = true
+	inFlightMu.Unlock()
+	defer func() {
+		inFlightMu.Lock()
+		delete(inFlight, id)
+		inFlightMu.Unlock()
+	}()
+
+	o, err := update(id, func(o *Order) error {
+		if o.Status != Paid {
+			return ErrConflict
+		}
+		o.Status = Fulfilling
+		o.Attempts++
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	of, ok := lookup(o.Product)
+	if !ok {
+		_, _ = update(id, func(o *Order) error {
+			o.Status = Failed
+			o.Error = fmt.Sprintf("product %s is no longer offered", o.Product)
+			return nil
+		})
+		log.Printf("❌ Order %s failed: product %s is no longer offered", o.ID, o.Product)
+		return
+	}
+
+	log.Printf("🛒 Fulfilling order %s: %d %s of %s", o.ID, o.Quantity, o.Unit, o.Product)
+	var res *provision.Result
+	form, err := of.form(o)
+	sent := false
+	if err == nil {
+		res, err = provisionPlan(provision.Request{
+			Provider:     of.Provider,
+			Form:         form,
+			Customer:     o.Customer,
+			Order:        o.ID,
+			TLS:          o.TLS,
+			Source:       "order",
+			CostCents:    o.Quote.CostCents,
+			RevenueCents: o.Quote.TotalCents,
+		})
+		sent = err != nil && (res != nil || !(errors.Is(err, providers.ErrNotSent) || errors.Is(err, provision.ErrUnavailable)))
+	}
+
+	_, _ = update(id, func(o *Order) error {
+		if res != nil {
+			o.PlanID = res.PlanID
+			o.Username = res.Username
+		}
+		switch {
+		case err == nil:
+			o.Status = Fulfilled
+			o.Error = ""
+			o.FulfilledAt = time.Now().Unix()
+			log.Printf("✅ Order %s fulfilled with plan %s", o.ID, o.PlanID)
+		case !sent && o.Attempts < maxAttempts:
+			o.Status = Paid
+			o.Error = err.Error()
+			log.Printf("⚠️ Order %s not fulfilled yet (attempt %d): %v", o.ID, o.Attempts, err)
+		default:
+			o.Status = Failed
+			o.Error = err.Error()
+			o.CheckProvider = sent
+			if sent {
+				log.Printf("❌ Order %s failed after reaching the provider, check it for a plan: %v", o.ID, err)
+			} else {
+				log.Printf("❌ Order %s failed: %v", o.ID, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,342 @@
+// Package orders sells plans. An order names a product and a quantity, is
+// marked paid by the storefront, and is then fulfilled by buying the plan
+// from the product's provider.
+package orders
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/providers"
+	"oceanproxy-api/provision"
+)
+
+const ordersFile = "orders.json" // in config.DataDir
+
+// Order statuses
+const (
+	Pending    = "pending"    // waiting for payment
+	Paid       = "paid"       // paid, waiting to be fulfilled
+	Fulfilling = "fulfilling" // the plan is being bought
+	Fulfilled  = "fulfilled"
+	Failed     = "failed"
+	Cancelled  = "cancelled"
+)
+
+// Units an order quantity is counted in
+const (
+	UnitGB    = "gb"
+	UnitDays  = "days"
+	UnitHours = "hours"
+)
+
+var (
+	ErrNotFound = errors.New("order not found")
+	ErrConflict = errors.New("order cannot change from its status")
+)
+
+// Order is one purchase of a plan
+type Order struct {
+	ID         string `json:"id"`
+	Product    string `json:"product"` // e.g. proxiesfo/residential
+	Quantity   int    `json:"quantity"`
+	Unit       string `json:"unit"`
+	Threads    int    `json:"threads,omitempty"` // proxiesfo/datacenter only, provider default if 0
+	Customer   string `json:"customer,omitempty"`
+	TLS        bool   `json:"tls,omitempty"`
+	Status     string `json:"status"`
+	Quote      Quote  `json:"quote"` // price locked in when the order was created
+	PaymentRef string `json:"payment_ref,omitempty"`
+	PlanID     string `json:"plan_id,omitempty"` // set once the plan is bought
+	Username   string `json:"username,omitempty"`
+	Attempts   int    `json:"attempts,omitempty"`
+	Error      string `json:"error,omitempty"` // last fulfilment error
+	// CheckProvider is set when fulfilment failed after the purchase may have
+	// reached the provider; look for the plan there before retrying
+	CheckProvider bool  `json:"check_provider,omitempty"`
+	CreatedAt     int64 `json:"created_at"`
+	PaidAt        int64 `json:"paid_at,omitempty"`
+	FulfilledAt   int64 `json:"fulfilled_at,omitempty"`
+}
+
+// Product is something that can be ordered
+type Product struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Unit     string `json:"unit"`
+}
+
+// offer says how an order of a product is bought
+type offer struct {
+	Product
+	form func(o Order) (url.Values, error)
+}
+
+var offers = []offer{
+	proxiesFOBandwidth("proxiesfo/residential", "residential"),
+	proxiesFOBandwidth("proxiesfo/isp", "isp"),
+	{Product{"proxiesfo/datacenter", provision.ProxiesFO, UnitDays}, func(o Order) (url.Values, error) {
+		form := url.Values{"reseller": {"datacenter"}, "duration": {strconv.Itoa(o.Quantity)}}
+		if o.Threads > 0 {
+			form.Set("threads", strconv.Itoa(o.Threads))
+		}
+		return form, nil
+	}},
+	nettifyBandwidth("nettify/residential", "residential"),
+	nettifyBandwidth("nettify/datacenter", "datacenter"),
+	nettifyBandwidth("nettify/mobile", "mobile"),
+	{Product{"nettify/unlimited", provision.Nettify, UnitHours}, func(o Order) (url.Values, error) {
+		return nettifyForm(url.Values{"plan_type": {"unlimited"}, "hours": {strconv.Itoa(o.Quantity)}})
+	}},
+}
+
+func proxiesFOBandwidth(name, reseller string) offer {
+	return offer{Product{name, provision.ProxiesFO, UnitGB}, func(o Order) (url.Values, error) {
+		return url.Values{"reseller": {reseller}, "bandwidth": {strconv.Itoa(o.Quantity)}}, nil
+	}}
+}
+
+func nettifyBandwidth(name, planType string) offer {
+	return offer{Product{name, provision.Nettify, UnitGB}, func(o Order) (url.Values, error) {
+		return nettifyForm(url.Values{"plan_type": {planType}, "bandwidth": {strconv.Itoa(o.Quantity)}})
+	}}
+}
+
+// nettifyForm adds a fresh plan password to form
+func nettifyForm(form url.Values) (url.Values, error) {
+	password, err := providers.GeneratePassword(16)
+	if err != nil {
+		return nil, err
+	}
+	form.Set("password", password)
+	return form, nil
+}
+
+// Products lists what can be ordered
+func Products() []Product {
+	out := make([]Product, 0, len(offers))
+	for _, o := range offers {
+		out = append(out, o.Product)
+	}
+	return out
+}
+
+func lookup(name string) (offer, bool) {
+	for _, o := range offers {
+		if o.Name == name {
+			return o, true
+		}
+	}
+	return offer{}, false
+}
+
+var mu sync.Mutex
+
+func load() ([]Order, error) {
+	data, err := os.ReadFile(filepath.Join(config.DataDir, ordersFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Order{}, nil
+		}
+		return nil, err
+	}
+	var list []Order
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s is not valid: %w", ordersFile, err)
+	}
+	return list, nil
+}
+
+func save(list []Order) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(ordersFile, data, 0600)
+}
+
+// writeFile replaces name in DATA_DIR through a temporary file, so a crash
+// never leaves it half written
+func writeFile(name string, data []byte, mode os.FileMode) error {
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(config.DataDir, name)
+	if err := os.WriteFile(path+".tmp", data, mode); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// update applies fn to the order and saves it; mu must not be held
+func update(id string, fn func(o *Order) error) (Order, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	list, err := load()
+	if err != nil {
+		return Order{}, err
+	}
+	for i := range list {
+		if list[i].ID != id {
+			continue
+		}
+		if err := fn(&list[i]); err != nil {
+			return list[i], err
+		}
+		return list[i], save(list)
+	}
+	return Order{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// Create validates, prices and stores a new pending order
+func Create(o Order) (Order, error) {
+	of, ok := lookup(o.Product)
+	if !ok {
+		return Order{}, fmt.Errorf("unknown product %q", o.Product)
+	}
+	if o.Quantity < 1 {
+		return Order{}, fmt.Errorf("quantity must be a positive number of %s", of.Unit)
+	}
+	if o.Threads < 0 || (o.Threads > 0 && o.Product != "proxiesfo/datacenter") {
+		return Order{}, fmt.Errorf("threads can only be ordered with proxiesfo/datacenter")
+	}
+	q, err := QuoteFor(o.Product, float64(o.Quantity), o.Threads)
+	if err != nil {
+		return Order{}, err
+	}
+	o.Quote = q
+	o.ID = "ord_" + randomHex(12)
+	o.Unit = of.Unit
+	o.Status = Pending
+	o.CreatedAt = time.Now().Unix()
+	o.PaymentRef, o.PlanID, o.Username, o.Error = "", "", "", ""
+	o.Attempts, o.PaidAt, o.FulfilledAt = 0, 0, 0
+
+	mu.Lock()
+	defer mu.Unlock()
+	list, err := load()
+	if err != nil {
+		return Order{}, err
+	}
+	if err := save(append(list, o)); err != nil {
+		return Order{}, err
+	}
+	return o, nil
+}
+
+// Get returns an order by ID
+func Get(id string) (Order, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	list, err := load()
+	if err != nil {
+		return Order{}, err
+	}
+	for _, o := range list {
+		if o.ID == id {
+			return o, nil
+		}
+	}
+	return Order{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// List returns orders newest first, filtered by customer and status when given
+func List(customer, status string) ([]Order, error) {
+	mu.Lock()
+	list, err := load()
+	mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	out := []Order{}
+	for _, o := range list {
+		if (customer == "" || o.Customer == customer) && (status == "" || o.Status == status) {
+			out = append(out, o)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt > out[j].CreatedAt })
+	return out, nil
+}
+
+// MarkPaid records the payment of a pending order and starts fulfilling it.
+// Marking an order paid again with the same reference, or none, returns it
+// unchanged.
+func MarkPaid(id, ref string) (Order, error) {
+	o, err := update(id, func(o *Order) error {
+		switch {
+		case o.Status == Pending:
+			o.Status = Paid
+			o.PaymentRef = ref
+			o.PaidAt = time.Now().Unix()
+			return nil
+		case o.Status == Cancelled:
+			return fmt.Errorf("%w: %s is cancelled", ErrConflict, o.ID)
+		case ref != "" && o.PaymentRef != "" && ref != o.PaymentRef:
+			return fmt.Errorf("%w: %s was already paid with %s", ErrConflict, o.ID, o.PaymentRef)
+		}
+		return nil
+	})
+	if err != nil {
+		return o, err
+	}
+	if o.Status == Paid {
+		kick(o.ID)
+	}
+	return o, nil
+}
+
+// Cancel cancels an order that has not been paid
+func Cancel(id string) (Order, error) {
+	return update(id, func(o *Order) error {
+		if o.Status != Pending {
+			return fmt.Errorf("%w: %s is %s, only pending orders can be cancelled", ErrConflict, o.ID, o.Status)
+		}
+		o.Status = Cancelled
+		return nil
+	})
+}
+
+// Retry queues a failed order for fulfilment again. Orders whose plan was
+// bought are not bought twice; their listeners are brought back with
+// POST /restore or the provider reconciler instead. An order flagged with
+// CheckProvider is only retried once checked says the provider has no plan
+// for it.
+func Retry(id string, checked bool) (Order, error) {
+	o, err := update(id, func(o *Order) error {
+		if o.Status != Failed {
+			return fmt.Errorf("%w: %s is %s, only failed orders can be retried", ErrConflict, o.ID, o.Status)
+		}
+		if o.PlanID != "" {
+			return fmt.Errorf("%w: plan %s was already bought for %s", ErrConflict, o.PlanID, o.ID)
+		}
+		if o.CheckProvider && !checked {
+			return fmt.Errorf("%w: %s may have bought a plan, check the provider and retry with checked=true", ErrConflict, o.ID)
+		}
+		o.Status = Paid
+		o.Attempts = 0
+		o.CheckProvider = false
+		return nil
+	})
+	if err != nil {
+		return o, err
+	}
+	kick(o.ID)
+	return o, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,210 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/providers"
+	"oceanproxy-api/provision"
+)
+
+func TestOrderLifecycle(t *testing.T) {
+	config.DataDir = t.TempDir()
+	orig := provisionPlan
+	defer func() { provisionPlan = orig }()
+
+	var got []provision.Request
+	fail := []error{&providers.ProviderError{Provider: "proxies.fo", Kind: providers.ErrUpstreamDown, NotSent: true}}
+	provisionPlan = func(req provision.Request) (*provision.Result, error) {
+		got = append(got, req)
+		if len(fail) > 0 {
+			err := fail[0]
+			fail = fail[1:]
+			return nil, err
+		}
+		return &provision.Result{PlanID: "plan1", Username: "u1"}, nil
+	}
+
+	for _, p := range []Price{
+		{Product: "proxiesfo/datacenter", Threads: 1000, CostCents: 20, PriceCents: 50},
+		{Product: "nettify/unlimited", CostCents: 10, PriceCents: 30},
+	} {
+		if err := SetPrice(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Create(Order{Product: "nettify/residential", Quantity: 1}); !errors.Is(err, ErrNoPrice) {
+		t.Errorf("unpriced product: %v", err)
+	}
+	if _, err := Create(Order{Product: "proxiesfo/residential", Quantity: 0}); err == nil {
+		t.Error("zero quantity accepted")
+	}
+	if _, err := Create(Order{Product: "nettify/residential", Quantity: 1, Threads: 100}); err == nil {
+		t.Error("threads accepted on a bandwidth product")
+	}
+	o, err := Create(Order{Product: "proxiesfo/datacenter", Quantity: 30, Threads: 1000, Customer: "tg:42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != Pending || o.Unit != UnitDays || o.Quote.TotalCents != 1500 {
+		t.Fatalf("new order %+v", o)
+	}
+	if _, err := Retry(o.ID, false); !errors.Is(err, ErrConflict) {
+		t.Errorf("retry of a pending order: %v", err)
+	}
+
+	if _, err := update(o.ID, func(o *Order) error { o.Status, o.PaymentRef = Paid, "pay1"; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	fulfil(o.ID) // provider unreachable, stays paid
+	if o, _ = Get(o.ID); o.Status != Paid || o.Attempts != 1 || o.Error == "" {
+		t.Fatalf("after a provider outage %+v", o)
+	}
+	sweep()
+	if o, _ = Get(o.ID); o.Status != Fulfilled || o.PlanID != "plan1" {
+		t.Fatalf("after the sweep %+v", o)
+	}
+	form := got[1].Form
+	if got[1].Provider != provision.ProxiesFO || form.Get("reseller") != "datacenter" || form.Get("duration") != "30" || form.Get("threads") != "1000" || got[1].Order != o.ID || got[1].RevenueCents != 1500 || got[1].CostCents != 600 {
+		t.Errorf("provision request %+v", got[1])
+	}
+
+	// Paying again is a no-op, with another reference a conflict
+	if _, err := MarkPaid(o.ID, "pay1"); err != nil {
+		t.Errorf("repeated payment: %v", err)
+	}
+	if _, err := MarkPaid(o.ID, "pay2"); !errors.Is(err, ErrConflict) {
+		t.Errorf("second payment: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("plan bought %d times", len(got))
+	}
+
+	// A plan bought but not served fails and is not bought again
+	o2, _ := Create(Order{Product: "nettify/unlimited", Quantity: 24})
+	provisionPlan = func(req provision.Request) (*provision.Result, error) {
+		if req.Form.Get("hours") != "24" || req.Form.Get("password") == "" {
+			t.Errorf("nettify form %v", req.Form)
+		}
+		return &provision.Result{PlanID: "plan2"}, fmt.Errorf("place: %w", provision.ErrUnavailable)
+	}
+	_, _ = update(o2.ID, func(o *Order) error { o.Status = Paid; return nil })
+	fulfil(o2.ID)
+	if o2, _ = Get(o2.ID); o2.Status != Failed || o2.PlanID != "plan2" {
+		t.Fatalf("after placement failure %+v", o2)
+	}
+	if _, err := Retry(o2.ID, false); !errors.Is(err, ErrConflict) {
+		t.Errorf("retry of a bought plan: %v", err)
+	}
+
+	// A purchase that may have reached the provider is never sent again
+	o3, _ := Create(Order{Product: "nettify/unlimited", Quantity: 1})
+	provisionPlan = func(req provision.Request) (*provision.Result, error) {
+		return nil, &providers.ProviderError{Provider: "nettify", Kind: providers.ErrUpstreamDown, Message: "timeout"}
+	}
+	_, _ = update(o3.ID, func(o *Order) error { o.Status = Paid; return nil })
+	fulfil(o3.ID)
+	if o3, _ = Get(o3.ID); o3.Status != Failed || !o3.CheckProvider || o3.Attempts != 1 {
+		t.Fatalf("after a timeout %+v", o3)
+	}
+	if _, err := Retry(o3.ID, false); !errors.Is(err, ErrConflict) {
+		t.Errorf("retry before checking the provider: %v", err)
+	}
+	provisionPlan = func(req provision.Request) (*provision.Result, error) {
+		return &provision.Result{PlanID: "plan3"}, nil
+	}
+	if o3, err = Retry(o3.ID, true); err != nil || o3.CheckProvider {
+		t.Fatalf("retry after checking %+v, %v", o3, err)
+	}
+	for i := 0; i < 100 && o3.Status != Fulfilled; i++ {
+		time.Sleep(10 * time.Millisecond)
+		o3, _ = Get(o3.ID)
+	}
+	if o3.Status != Fulfilled || o3.PlanID != "plan3" {
+		t.Errorf("after the checked retry %+v", o3)
+	}
+
+	// An order of a product that is no longer offered fails without buying
+	o4, _ := Create(Order{Product: "nettify/unlimited", Quantity: 1})
+	_, _ = update(o4.ID, func(o *Order) error { o.Status, o.Product = Paid, "nettify/retired"; return nil })
+	fulfil(o4.ID)
+	if o4, _ = Get(o4.ID); o4.Status != Failed || o4.CheckProvider || !strings.Contains(o4.Error, "no longer offered") {
+		t.Fatalf("after a removed product %+v", o4)
+	}
+
+	if list, _ := List("tg:42", ""); len(list) != 1 || list[0].ID != o.ID {
+		t.Errorf("customer orders %+v", list)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	config.DataDir = t.TempDir()
+	config.Set(func(s *config.Settings) { s.Currency = "USD" })
+	for _, p := range []Price{
+		{Product: "proxiesfo/residential", CostCents: 150, PriceCents: 300, Discounts: []Discount{{50, 20}, {10, 10}}},
+		{Product: "proxiesfo/datacenter", CostCents: 5, PriceCents: 10},
+		{Product: "proxiesfo/datacenter", Threads: 500, CostCents: 8, PriceCents: 20},
+		{Product: "proxiesfo/datacenter", Threads: 2000, CostCents: 30, PriceCents: 60},
+	} {
+		if err := SetPrice(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SetPrice(Price{Product: "nettify/mobile", Threads: 500}); err == nil {
+		t.Error("thread tier accepted on a bandwidth product")
+	}
+
+	for _, c := range []struct {
+		product         string
+		quantity        float64
+		threads         int
+		tier            int
+		total, cost     int64
+		discountPercent float64
+	}{
+		{"proxiesfo/residential", 5, 0, 0, 1500, 750, 0},
+		{"proxiesfo/residential", 10, 0, 0, 2700, 1500, 10},
+		{"proxiesfo/residential", 60, 0, 0, 14400, 9000, 20},
+		{"proxiesfo/residential", 2.5, 0, 0, 750, 375, 0},
+		{"proxiesfo/datacenter", 3, 0, 500, 60, 24, 0},      // default 500 threads
+		{"proxiesfo/datacenter", 3, 1000, 2000, 180, 90, 0}, // next tier up
+		{"proxiesfo/datacenter", 3, 5000, 0, 30, 15, 0},     // above every tier
+	} {
+		q, err := QuoteFor(c.product, c.quantity, c.threads)
+		if err != nil {
+			t.Errorf("%s x%v: %v", c.product, c.quantity, err)
+			continue
+		}
+		if q.Threads != c.tier || q.TotalCents != c.total || q.CostCents != c.cost || q.DiscountPercent != c.discountPercent || q.MarginCents != c.total-c.cost {
+			t.Errorf("%s x%v at %d threads: %+v", c.product, c.quantity, c.threads, q)
+		}
+	}
+
+	form := url.Values{"reseller": {"datacenter"}, "duration": {"3"}, "threads": {"1000"}}
+	if q, err := QuoteForm(provision.ProxiesFO, form); err != nil || q.TotalCents != 180 {
+		t.Errorf("form quote %+v, %v", q, err)
+	}
+
+	if q, err := QuoteExtension("proxiesfo/datacenter", 1000, 3*86400); err != nil || q.TotalCents != 180 {
+		t.Errorf("extension quote %+v, %v", q, err)
+	}
+	if _, err := QuoteExtension("proxiesfo/residential", 0, 86400); err == nil {
+		t.Error("extension of a bandwidth product priced")
+	}
+
+	for in, want := range map[string]int64{"12": 1200, "0.5": 50, "1.25": 125, "3.100": 310} {
+		if got, err := ParseAmount(in); err != nil || got != want {
+			t.Errorf("ParseAmount(%q) = %d, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"-1", "1.234", "abc"} {
+		if _, err := ParseAmount(in); err == nil {
+			t.Errorf("ParseAmount(%q) accepted", in)
+		}
+	}
+}
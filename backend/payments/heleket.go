@@ -0,0 +1,84 @@
+package payments
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/orders"
+)
+
+// heleket verifies Heleket crypto payment callbacks. The callback carries its
+// signature in the body: sign = md5(base64(body without sign) + API key).
+type heleket struct{}
+
+func (heleket) Name() string  { return "heleket" }
+func (heleket) Enabled() bool { return config.Get().HeleketAPIKey != "" }
+
+type heleketCallback struct {
+	Type     string `json:"type"`
+	UUID     string `json:"uuid"`
+	OrderID  string `json:"order_id"`
+	Amount   string `json:"amount"` // in the invoice currency
+	Currency string `json:"currency"`
+	Status   string `json:"status"`
+	IsFinal  bool   `json:"is_final"`
+	Sign     string `json:"sign,omitempty"`
+}
+
+func (heleket) Verify(_ http.Header, body []byte) (Payment, error) {
+	var c heleketCallback
+	if err := json.Unmarshal(body, &c); err != nil {
+		return Payment{}, fmt.Errorf("invalid Heleket callback: %w", err)
+	}
+	if c.Sign == "" {
+		return Payment{}, fmt.Errorf("%w: no sign field", ErrSignature)
+	}
+	want := heleketSignature(config.Get().HeleketAPIKey, unsigned(body))
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(c.Sign)), []byte(want)) != 1 {
+		return Payment{}, fmt.Errorf("%w: signature mismatch", ErrSignature)
+	}
+
+	p := Payment{
+		ID:        c.UUID,
+		OrderID:   c.OrderID,
+		Currency:  strings.ToUpper(c.Currency),
+		Status:    c.Status,
+		Completed: c.Status == "paid" || c.Status == "paid_over",
+	}
+	if p.ID == "" {
+		return Payment{}, fmt.Errorf("Heleket callback without uuid")
+	}
+	// Crypto amounts carry up to 8 decimals, whole cents are what orders are priced in
+	amount := c.Amount
+	if whole, frac, ok := strings.Cut(amount, "."); ok && len(frac) > 2 {
+		amount = whole + "." + frac[:2]
+	}
+	if amount != "" {
+		cents, err := orders.ParseAmount(amount)
+		if err != nil {
+			return Payment{}, fmt.Errorf("Heleket callback: %w", err)
+		}
+		p.AmountCents = cents
+	}
+	return p, nil
+}
+
+var signField = regexp.MustCompile(`,\s*"sign"\s*:\s*"[^"]*"|"sign"\s*:\s*"[^"]*"\s*,?`)
+
+// unsigned is the callback body as it was signed, before sign was added to it
+func unsigned(body []byte) []byte {
+	return signField.ReplaceAll(body, nil)
+}
+
+func heleketSignature(key string, unsigned []byte) string {
+	sum := md5.Sum([]byte(base64.StdEncoding.EncodeToString(unsigned) + key))
+	return hex.EncodeToString(sum[:])
+}
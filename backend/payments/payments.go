@@ -0,0 +1,240 @@
+// Package payments receives payment processor webhooks. A verifier per
+// processor authenticates the callback and reads the payment from it, and
+// every payment is matched once to the order it pays, which is then marked
+// paid and fulfilled.
+package payments
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/orders"
+)
+
+const (
+	recordsFile = "payments.json" // in config.DataDir
+	keepRecords = 5000
+)
+
+// Outcomes of a received payment
+const (
+	Matched   = "matched"   // the order was marked paid
+	Ignored   = "ignored"   // not a completed payment, e.g. pending or failed
+	Unmatched = "unmatched" // no order with that ID
+	Mismatch  = "mismatch"  // currency differs or less than the order total was paid
+	Duplicate = "duplicate" // the order was already paid by another payment, refund it
+	Rejected  = "rejected"  // the order cannot be paid, e.g. it was cancelled
+)
+
+var (
+	// ErrUnknownProcessor is returned for processors that are not registered or not configured
+	ErrUnknownProcessor = errors.New("unknown or unconfigured payment processor")
+	// ErrSignature is returned when a callback is not authentic
+	ErrSignature = errors.New("invalid webhook signature")
+)
+
+// Payment is what a processor reports about one payment
+type Payment struct {
+	Processor   string `json:"processor"`
+	ID          string `json:"id"` // the processor's payment ID, the same across retries
+	OrderID     string `json:"order_id"`
+	AmountCents int64  `json:"amount_cents"`
+	Currency    string `json:"currency"`
+	Completed   bool   `json:"completed"` // money was received
+	Status      string `json:"status"`    // the processor's own status or event type
+}
+
+// Verifier authenticates one processor's webhooks
+type Verifier interface {
+	// Name is the processor name in /payments/webhook/{name}
+	Name() string
+	// Enabled reports whether the processor's secret is configured
+	Enabled() bool
+	// Verify checks the signature of a callback and returns the payment it reports
+	Verify(header http.Header, body []byte) (Payment, error)
+}
+
+var (
+	verifiersMu sync.RWMutex
+	verifiers   = make(map[string]Verifier)
+)
+
+// Register adds a verifier, replacing one of the same name
+func Register(v Verifier) {
+	verifiersMu.Lock()
+	defer verifiersMu.Unlock()
+	verifiers[v.Name()] = v
+}
+
+func init() {
+	Register(stripe{})
+	Register(heleket{})
+}
+
+// Processors lists the registered processors and whether each is enabled
+func Processors() map[string]bool {
+	verifiersMu.RLock()
+	defer verifiersMu.RUnlock()
+	out := make(map[string]bool)
+	for name, v := range verifiers {
+		out[name] = v.Enabled()
+	}
+	return out
+}
+
+// Record is a received payment and what was done with it
+type Record struct {
+	Payment
+	Outcome    string    `json:"outcome"`
+	Detail     string    `json:"detail,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+	Deliveries int       `json:"deliveries"` // times the processor sent it
+}
+
+var mu sync.Mutex
+
+// markPaid pays the order and starts its fulfilment; replaced in tests
+var markPaid = orders.MarkPaid
+
+// Receive verifies a callback of the named processor and applies the payment.
+// A payment already received returns its first record, so processor retries
+// never pay an order twice.
+func Receive(processor string, header http.Header, body []byte) (Record, error) {
+	verifiersMu.RLock()
+	v, ok := verifiers[processor]
+	verifiersMu.RUnlock()
+	if !ok || !v.Enabled() {
+		return Record{}, fmt.Errorf("%w: %s", ErrUnknownProcessor, processor)
+	}
+	p, err := v.Verify(header, body)
+	if err != nil {
+		return Record{}, err
+	}
+	p.Processor = processor
+	return Apply(p)
+}
+
+// Apply matches a verified payment to its order
+func Apply(p Payment) (Record, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list, err := load()
+	if err != nil {
+		return Record{}, err
+	}
+	for i := range list {
+		r := &list[i]
+		// A pending payment may be reported again once it completes
+		if r.Processor == p.Processor && r.ID == p.ID && (r.Completed || !p.Completed) {
+			r.Deliveries++
+			_ = save(list)
+			return *r, nil
+		}
+	}
+
+	rec := Record{Payment: p, ReceivedAt: time.Now().UTC(), Deliveries: 1}
+	rec.Outcome, rec.Detail = match(p)
+	if rec.Detail != "" {
+		log.Printf("💳 %s payment %s for order %s: %s, %s", p.Processor, p.ID, p.OrderID, rec.Outcome, rec.Detail)
+	} else {
+		log.Printf("💳 %s payment %s for order %s: %s", p.Processor, p.ID, p.OrderID, rec.Outcome)
+	}
+
+	kept := list[:0]
+	for _, r := range list {
+		if r.Processor != p.Processor || r.ID != p.ID {
+			kept = append(kept, r)
+		}
+	}
+	kept = append(kept, rec)
+	if len(kept) > keepRecords {
+		kept = kept[len(kept)-keepRecords:]
+	}
+	return rec, save(kept)
+}
+
+// match marks the order paid when the payment covers it
+func match(p Payment) (string, string) {
+	if !p.Completed {
+		return Ignored, "status " + p.Status
+	}
+	o, err := orders.Get(p.OrderID)
+	if err != nil {
+		return Unmatched, err.Error()
+	}
+	if !strings.EqualFold(p.Currency, o.Quote.Currency) {
+		return Mismatch, fmt.Sprintf("paid in %s, order is in %s", strings.ToUpper(p.Currency), o.Quote.Currency)
+	}
+	if p.AmountCents < o.Quote.TotalCents {
+		return Mismatch, fmt.Sprintf("paid %s of %s", orders.FormatAmount(p.AmountCents), orders.FormatAmount(o.Quote.TotalCents))
+	}
+	if _, err := markPaid(o.ID, p.Processor+":"+p.ID); err != nil {
+		if !errors.Is(err, orders.ErrConflict) {
+			return Rejected, err.Error()
+		}
+		if o, _ = orders.Get(o.ID); o.Status == orders.Cancelled {
+			return Rejected, err.Error()
+		}
+		return Duplicate, err.Error()
+	}
+	return Matched, ""
+}
+
+// Records returns received payments newest first, filtered by outcome and order
+func Records(outcome, orderID string) ([]Record, error) {
+	mu.Lock()
+	list, err := load()
+	mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	out := []Record{}
+	for _, r := range list {
+		if (outcome == "" || r.Outcome == outcome) && (orderID == "" || r.OrderID == orderID) {
+			out = append(out, r)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].ReceivedAt.After(out[j].ReceivedAt) })
+	return out, nil
+}
+
+func load() ([]Record, error) {
+	data, err := os.ReadFile(filepath.Join(config.DataDir, recordsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Record{}, nil
+		}
+		return nil, err
+	}
+	var list []Record
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s is not valid: %w", recordsFile, err)
+	}
+	return list, nil
+}
+
+func save(list []Record) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(config.DataDir, recordsFile)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,133 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/orders"
+)
+
+func TestVerify(t *testing.T) {
+	config.Set(func(s *config.Settings) {
+		s.StripeWebhookSecret = "whsec_test"
+		s.HeleketAPIKey = "heleket-key"
+	})
+	p := Payment{ID: "pay1", OrderID: "ord_1", AmountCents: 1250, Currency: "USD", Completed: true}
+
+	for _, c := range []struct {
+		processor, secret string
+	}{{"stripe", config.Get().StripeWebhookSecret}, {"heleket", config.Get().HeleketAPIKey}} {
+		v := verifiers[c.processor]
+		header, body, err := Callback(c.processor, c.secret, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := v.Verify(header, body)
+		if err != nil {
+			t.Errorf("%s: %v", c.processor, err)
+		} else if got.ID != p.ID || got.OrderID != p.OrderID || got.AmountCents != p.AmountCents || got.Currency != "USD" || !got.Completed {
+			t.Errorf("%s payment %+v", c.processor, got)
+		}
+
+		header, body, _ = Callback(c.processor, "wrong", p)
+		if _, err := v.Verify(header, body); !errors.Is(err, ErrSignature) {
+			t.Errorf("%s with the wrong secret: %v", c.processor, err)
+		}
+		header, body, _ = Callback(c.processor, c.secret, p)
+		tampered := []byte(strings.ReplaceAll(string(body), "ord_1", "ord_2"))
+		if _, err := v.Verify(header, tampered); !errors.Is(err, ErrSignature) {
+			t.Errorf("%s with a tampered order: %v", c.processor, err)
+		}
+	}
+
+	body := []byte(`{"id":"evt_1"}`)
+	old := time.Now().Add(-10 * time.Minute).Unix()
+	header := fmt.Sprintf("t=%d,v1=%s", old, stripeSignature("whsec_test", old, body))
+	if err := verifyStripe("whsec_test", header, body, time.Now()); !errors.Is(err, ErrSignature) {
+		t.Errorf("replayed Stripe event: %v", err)
+	}
+
+	// Heleket signs the body before sign is added, wherever it ends up
+	signed := `{"uuid":"u1","order_id":"ord_1","amount":"12.50000000","currency":"USD","status":"paid"}`
+	sign := heleketSignature("heleket-key", []byte(signed))
+	body = []byte(`{"sign":"` + sign + `",` + signed[1:])
+	if got, err := verifiers["heleket"].Verify(nil, body); err != nil || got.AmountCents != 1250 {
+		t.Errorf("sign first: %+v, %v", got, err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	config.DataDir = t.TempDir()
+	config.Set(func(s *config.Settings) {
+		s.Currency = "USD"
+		s.StripeWebhookSecret = "whsec_test"
+		s.HeleketAPIKey = ""
+	})
+	orig := markPaid
+	defer func() { markPaid = orig }()
+
+	paid := map[string]string{}
+	markPaid = func(id, ref string) (orders.Order, error) {
+		if prev, ok := paid[id]; ok && prev != ref {
+			return orders.Order{}, fmt.Errorf("%w: %s was already paid with %s", orders.ErrConflict, id, prev)
+		}
+		paid[id] = ref
+		return orders.Get(id)
+	}
+
+	if err := orders.SetPrice(orders.Price{Product: "nettify/unlimited", CostCents: 10, PriceCents: 30}); err != nil {
+		t.Fatal(err)
+	}
+	o, err := orders.Create(orders.Order{Product: "nettify/unlimited", Quantity: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive := func(p Payment) Record {
+		t.Helper()
+		header, body, err := Callback("stripe", "whsec_test", p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := Receive("stripe", header, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	pay := Payment{ID: "pi_1", OrderID: o.ID, AmountCents: 720, Currency: "USD"}
+
+	if rec := receive(pay); rec.Outcome != Ignored {
+		t.Errorf("pending payment %+v", rec)
+	}
+	pay.Completed = true
+	if rec := receive(pay); rec.Outcome != Matched || paid[o.ID] != "stripe:pi_1" {
+		t.Errorf("completed payment %+v", rec)
+	}
+	if rec := receive(pay); rec.Outcome != Matched || rec.Deliveries != 2 {
+		t.Errorf("redelivered payment %+v", rec)
+	}
+	if rec := receive(Payment{ID: "pi_2", OrderID: o.ID, AmountCents: 720, Currency: "USD", Completed: true}); rec.Outcome != Duplicate {
+		t.Errorf("second payment %+v", rec)
+	}
+	if rec := receive(Payment{ID: "pi_3", OrderID: o.ID, AmountCents: 719, Currency: "USD", Completed: true}); rec.Outcome != Mismatch {
+		t.Errorf("underpayment %+v", rec)
+	}
+	if rec := receive(Payment{ID: "pi_4", OrderID: o.ID, AmountCents: 720, Currency: "EUR", Completed: true}); rec.Outcome != Mismatch {
+		t.Errorf("other currency %+v", rec)
+	}
+	if rec := receive(Payment{ID: "pi_5", OrderID: "ord_missing", AmountCents: 720, Currency: "USD", Completed: true}); rec.Outcome != Unmatched {
+		t.Errorf("unknown order %+v", rec)
+	}
+
+	list, _ := Records("", o.ID)
+	if len(list) != 4 || list[len(list)-1].ID != "pi_1" {
+		t.Errorf("order payments %+v", list)
+	}
+	if _, err := Receive("heleket", nil, nil); !errors.Is(err, ErrUnknownProcessor) {
+		t.Errorf("unconfigured processor: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package payments
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"oceanproxy-api/orders"
+)
+
+// Callback builds the webhook a processor would send for p, signed with
+// secret. It stands in for the processor in tests and oceanctl payments
+// simulate, so the whole path from callback to plan runs without one.
+func Callback(processor, secret string, p Payment) (http.Header, []byte, error) {
+	header := http.Header{"Content-Type": {"application/json"}}
+	switch processor {
+	case "stripe":
+		body, err := stripeCallback(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		ts := time.Now().Unix()
+		header.Set(StripeSignatureHeader, fmt.Sprintf("t=%d,v1=%s", ts, stripeSignature(secret, ts, body)))
+		return header, body, nil
+	case "heleket":
+		body, err := heleketCallbackBody(secret, p)
+		return header, body, err
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrUnknownProcessor, processor)
+}
+
+// stripeCallback is a checkout.session.completed event, or a session
+// still awaiting payment when p is not completed
+func stripeCallback(p Payment) ([]byte, error) {
+	status := "unpaid"
+	if p.Completed {
+		status = "paid"
+	}
+	return json.Marshal(map[string]interface{}{
+		"id":     "evt_" + p.ID,
+		"type":   "checkout.session.completed",
+		"object": "event",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"id":                  "cs_" + p.ID,
+				"object":              "checkout.session",
+				"payment_intent":      p.ID,
+				"payment_status":      status,
+				"amount_total":        p.AmountCents,
+				"currency":            strings.ToLower(p.Currency),
+				"client_reference_id": p.OrderID,
+				"metadata":            map[string]string{"order_id": p.OrderID},
+			},
+		},
+	})
+}
+
+func heleketCallbackBody(key string, p Payment) ([]byte, error) {
+	status := p.Status
+	if status == "" {
+		status = "check"
+		if p.Completed {
+			status = "paid"
+		}
+	}
+	body, err := json.Marshal(heleketCallback{
+		Type:     "payment",
+		UUID:     p.ID,
+		OrderID:  p.OrderID,
+		Amount:   orders.FormatAmount(p.AmountCents),
+		Currency: p.Currency,
+		Status:   status,
+		IsFinal:  p.Completed,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// sign is appended last, as Heleket does
+	sign := heleketSignature(key, body)
+	return append(body[:len(body)-1], []byte(`,"sign":`+strconv.Quote(sign)+`}`)...), nil
+}
@@ -0,0 +1,121 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"oceanproxy-api/config"
+)
+
+// StripeSignatureHeader carries t=<unix>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+const StripeSignatureHeader = "Stripe-Signature"
+
+// Callbacks signed longer ago than this are replays
+const stripeTolerance = 5 * time.Minute
+
+// stripe verifies Stripe card payments: checkout.session.completed and
+// async_payment_succeeded, and payment_intent.succeeded. Both kinds are keyed
+// by the payment intent, so subscribing to both does not pay twice.
+type stripe struct{}
+
+func (stripe) Name() string  { return "stripe" }
+func (stripe) Enabled() bool { return config.Get().StripeWebhookSecret != "" }
+
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID                string            `json:"id"`
+			Object            string            `json:"object"`
+			PaymentIntent     string            `json:"payment_intent"`
+			PaymentStatus     string            `json:"payment_status"` // checkout sessions
+			Status            string            `json:"status"`         // payment intents
+			AmountTotal       int64             `json:"amount_total"`
+			AmountReceived    int64             `json:"amount_received"`
+			Currency          string            `json:"currency"`
+			ClientReferenceID string            `json:"client_reference_id"`
+			Metadata          map[string]string `json:"metadata"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+func (stripe) Verify(header http.Header, body []byte) (Payment, error) {
+	if err := verifyStripe(config.Get().StripeWebhookSecret, header.Get(StripeSignatureHeader), body, time.Now()); err != nil {
+		return Payment{}, err
+	}
+	var e stripeEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		return Payment{}, fmt.Errorf("invalid Stripe event: %w", err)
+	}
+
+	obj := e.Data.Object
+	p := Payment{
+		ID:       obj.PaymentIntent,
+		OrderID:  obj.Metadata["order_id"],
+		Currency: strings.ToUpper(obj.Currency),
+		Status:   e.Type,
+	}
+	if p.OrderID == "" {
+		p.OrderID = obj.ClientReferenceID
+	}
+	switch e.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		p.AmountCents = obj.AmountTotal
+		p.Status = obj.PaymentStatus
+		p.Completed = obj.PaymentStatus == "paid"
+	case "payment_intent.succeeded":
+		p.ID = obj.ID
+		p.AmountCents = obj.AmountReceived
+		p.Status = obj.Status
+		p.Completed = obj.Status == "succeeded"
+	}
+	if p.ID == "" {
+		p.ID = e.ID // events without a payment intent are only recorded
+		p.Completed = false
+	}
+	return p, nil
+}
+
+// verifyStripe checks a Stripe-Signature header. Stripe sends one v1 per
+// active secret while a secret is being rolled.
+func verifyStripe(secret, header string, body []byte, now time.Time) error {
+	var ts int64
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts, _ = strconv.ParseInt(v, 10, 64)
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+	if ts == 0 || len(sigs) == 0 {
+		return fmt.Errorf("%w: malformed %s header", ErrSignature, StripeSignatureHeader)
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > stripeTolerance || d < -stripeTolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrSignature)
+	}
+	want := stripeSignature(secret, ts, body)
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(sig), []byte(want)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: signature mismatch", ErrSignature)
+}
+
+func stripeSignature(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
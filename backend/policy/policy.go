@@ -0,0 +1,313 @@
+// Package policy decides which destinations customers may reach through their
+// listeners. Rules are rendered into each listener's 3proxy config; the same
+// evaluation is available in Go to check a target without a proxy.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"oceanproxy-api/config"
+)
+
+const policyFile = "policies.json" // in config.DataDir
+
+// Rule allows or denies destinations. Empty Targets or Ports match anything.
+type Rule struct {
+	Action  string   `json:"action"`            // allow or deny
+	Targets []string `json:"targets,omitempty"` // IPs, CIDRs or domain globs like *.example.com
+	Ports   []string `json:"ports,omitempty"`   // ports or ranges like 8000-8999
+}
+
+// Set is the stored policy. Plan rules are evaluated before global rules and
+// the first match wins; a destination no rule matches is allowed.
+type Set struct {
+	Global []Rule            `json:"global"`
+	Plans  map[string][]Rule `json:"plans,omitempty"`
+}
+
+var mu sync.Mutex
+
+// lookupIP resolves domain targets in Check, replaced in tests
+var lookupIP = net.LookupIP
+
+// Defaults blocks loopback, private and link-local ranges (including the API
+// on 127.0.0.1), our own domain so listeners cannot be chained, and SMTP
+func Defaults() []Rule {
+	internal := Rule{Action: "deny", Targets: []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7", "fe80::/10", "localhost",
+	}}
+	if domain := config.Get().BaseDomain; domain != "" {
+		internal.Targets = append(internal.Targets, domain, "*."+domain)
+	}
+	return []Rule{
+		internal,
+		{Action: "deny", Ports: []string{"25", "465", "587"}},
+	}
+}
+
+// ParseRule reads the compact form "<allow|deny> <targets> <ports>", where
+// targets and ports are comma separated lists or *, e.g. "deny * 25,465,587"
+func ParseRule(s string) (Rule, error) {
+	f := strings.Fields(s)
+	if len(f) != 3 {
+		return Rule{}, fmt.Errorf("rule %q: expected \"<allow|deny> <targets|*> <ports|*>\"", s)
+	}
+	r := Rule{Action: f[0]}
+	if f[1] != "*" {
+		r.Targets = strings.Split(f[1], ",")
+	}
+	if f[2] != "*" {
+		r.Ports = strings.Split(f[2], ",")
+	}
+	if err := r.Validate(); err != nil {
+		return Rule{}, fmt.Errorf("rule %q: %v", s, err)
+	}
+	return r, nil
+}
+
+// String returns the compact form accepted by ParseRule
+func (r Rule) String() string {
+	return fmt.Sprintf("%s %s %s", r.Action, list(r.Targets), list(r.Ports))
+}
+
+func list(items []string) string {
+	if len(items) == 0 {
+		return "*"
+	}
+	return strings.Join(items, ",")
+}
+
+// Validate checks a rule can be rendered for 3proxy
+func (r Rule) Validate() error {
+	if r.Action != "allow" && r.Action != "deny" {
+		return fmt.Errorf("action must be allow or deny")
+	}
+	for _, t := range r.Targets {
+		if strings.Contains(t, "/") {
+			if _, _, err := net.ParseCIDR(t); err != nil {
+				return fmt.Errorf("invalid CIDR %q", t)
+			}
+			continue
+		}
+		if net.ParseIP(t) != nil {
+			continue
+		}
+		if !validDomainGlob(t) {
+			return fmt.Errorf("invalid target %q, use an IP, CIDR or domain with * at the start or end", t)
+		}
+	}
+	for _, p := range r.Ports {
+		if _, _, err := portRange(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validDomainGlob(d string) bool {
+	core := strings.TrimSuffix(strings.TrimPrefix(d, "*"), "*")
+	if core == "" || strings.Contains(core, "*") {
+		return false
+	}
+	for _, c := range core {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func portRange(p string) (int, int, error) {
+	lo, hi, isRange := strings.Cut(p, "-")
+	if !isRange {
+		hi = lo
+	}
+	a, err1 := strconv.Atoi(lo)
+	b, err2 := strconv.Atoi(hi)
+	if err1 != nil || err2 != nil || a < 1 || b > 65535 || a > b {
+		return 0, 0, fmt.Errorf("invalid port %q", p)
+	}
+	return a, b, nil
+}
+
+// Load reads the stored policy, with the safe defaults as global rules when
+// nothing has been stored yet
+func Load() (Set, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return load()
+}
+
+func load() (Set, error) {
+	s := Set{Global: Defaults(), Plans: map[string][]Rule{}}
+	data, err := os.ReadFile(filepath.Join(config.DataDir, policyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, err
+	}
+	if s.Plans == nil {
+		s.Plans = map[string][]Rule{}
+	}
+	return s, nil
+}
+
+func save(s Set) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_ = os.MkdirAll(config.DataDir, 0755)
+	return os.WriteFile(filepath.Join(config.DataDir, policyFile), data, 0644)
+}
+
+// SetGlobal replaces the global rules
+func SetGlobal(rules []Rule) error {
+	mu.Lock()
+	defer mu.Unlock()
+	s, err := load()
+	if err != nil {
+		return err
+	}
+	s.Global = rules
+	return save(s)
+}
+
+// SetPlan replaces the rules of one plan, removing them if rules is empty
+func SetPlan(planID string, rules []Rule) error {
+	mu.Lock()
+	defer mu.Unlock()
+	s, err := load()
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		if _, ok := s.Plans[planID]; !ok {
+			return nil
+		}
+		delete(s.Plans, planID)
+	} else {
+		s.Plans[planID] = rules
+	}
+	return save(s)
+}
+
+// Effective returns the rules applied to a plan, in evaluation order
+func Effective(planID string) ([]Rule, error) {
+	s, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]Rule{}, s.Plans[planID]...), s.Global...), nil
+}
+
+// Render returns the 3proxy ACL lines for a plan. The listener config follows
+// them with an allow for the plan's user.
+func Render(planID string) (string, error) {
+	rules, err := Effective(planID)
+	if err != nil {
+		return "", err
+	}
+	return RenderRules(rules), nil
+}
+
+// RenderRules returns the 3proxy ACL lines for rules
+func RenderRules(rules []Rule) string {
+	var b strings.Builder
+	for _, r := range rules {
+		fmt.Fprintf(&b, "%s * * %s %s\n", r.Action, list(r.Targets), list(r.Ports))
+	}
+	return b.String()
+}
+
+// Check evaluates host:port against a plan's rules the way 3proxy does,
+// resolving domains so CIDR rules apply to them. It returns the matching rule,
+// or nil if the destination is allowed by default.
+func Check(planID, host string, port int) (bool, *Rule, error) {
+	rules, err := Effective(planID)
+	if err != nil {
+		return false, nil, err
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ips, _ = lookupIP(host)
+	}
+
+	for i := range rules {
+		if rules[i].matches(host, ips, port) {
+			return rules[i].Action == "allow", &rules[i], nil
+		}
+	}
+	return true, nil, nil
+}
+
+func (r Rule) matches(host string, ips []net.IP, port int) bool {
+	if len(r.Ports) > 0 {
+		found := false
+		for _, p := range r.Ports {
+			if lo, hi, err := portRange(p); err == nil && port >= lo && port <= hi {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Targets) == 0 {
+		return true
+	}
+	for _, t := range r.Targets {
+		if strings.Contains(t, "/") {
+			_, cidr, err := net.ParseCIDR(t)
+			if err != nil {
+				continue
+			}
+			for _, ip := range ips {
+				if cidr.Contains(ip) {
+					return true
+				}
+			}
+			continue
+		}
+		if ip := net.ParseIP(t); ip != nil {
+			for _, a := range ips {
+				if a.Equal(ip) {
+					return true
+				}
+			}
+			continue
+		}
+		if matchDomain(t, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDomain matches a domain glob with * at the start or end, as 3proxy does
+func matchDomain(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(strings.TrimSuffix(host, "."))
+	switch {
+	case strings.HasPrefix(pattern, "*"):
+		return strings.HasSuffix(host, pattern[1:])
+	case strings.HasSuffix(pattern, "*"):
+		return strings.HasPrefix(host, pattern[:len(pattern)-1])
+	}
+	return host == pattern
+}
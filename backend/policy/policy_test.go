@@ -0,0 +1,93 @@
+package policy
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"oceanproxy-api/config"
+)
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule("allow *.example.com,10.1.0.0/16 443,8000-8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.String() != "allow *.example.com,10.1.0.0/16 443,8000-8080" {
+		t.Errorf("round trip = %q", r.String())
+	}
+
+	for _, bad := range []string{
+		"block * 25",
+		"deny * 70000",
+		"deny 10.0.0.0/33 *",
+		"deny ex*ample.com *",
+		"deny * 90-80",
+		"deny *",
+	} {
+		if _, err := ParseRule(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	config.DataDir = t.TempDir()
+	config.Set(func(s *config.Settings) { s.BaseDomain = "oceanproxy.io" })
+	lookupIP = func(host string) ([]net.IP, error) {
+		if host == "intranet.corp" {
+			return []net.IP{net.ParseIP("192.168.1.10")}, nil
+		}
+		return []net.IP{net.ParseIP("93.184.216.34")}, nil
+	}
+	defer func() { lookupIP = net.LookupIP }()
+
+	tests := []struct {
+		plan, host string
+		port       int
+		allowed    bool
+	}{
+		{"p1", "example.com", 443, true},
+		{"p1", "127.0.0.1", 9090, false},
+		{"p1", "10.2.3.4", 80, false},
+		{"p1", "intranet.corp", 80, false}, // resolves to RFC1918
+		{"p1", "smtp.example.com", 25, false},
+		{"p1", "usa.oceanproxy.io", 1337, false},
+		{"p2", "smtp.example.com", 587, true}, // plan rule before global
+		{"p2", "example.com", 80, false},
+	}
+
+	rules := []Rule{
+		{Action: "allow", Ports: []string{"587"}},
+		{Action: "deny", Targets: []string{"example.com"}},
+	}
+	if err := SetPlan("p2", rules); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		allowed, _, err := Check(tt.plan, tt.host, tt.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != tt.allowed {
+			t.Errorf("%s %s:%d allowed = %v, want %v", tt.plan, tt.host, tt.port, allowed, tt.allowed)
+		}
+	}
+
+	acl, err := Render("p2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(acl), "\n")
+	if len(lines) != 4 || lines[0] != "allow * * * 587" || lines[3] != "deny * * * 25,465,587" {
+		t.Errorf("unexpected ACL:\n%s", acl)
+	}
+
+	if err := SetPlan("p2", nil); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _, _ := Check("p2", "smtp.example.com", 587); allowed {
+		t.Error("SMTP should be blocked once the plan rules are removed")
+	}
+}
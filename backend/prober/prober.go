@@ -0,0 +1,324 @@
+package prober
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/jobs"
+	"oceanproxy-api/proxy"
+)
+
+// Number of samples kept per upstream for success rate and percentiles
+const windowSize = 100
+
+// Canary is the credential used to send test traffic through an upstream
+type Canary struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+func (c Canary) Key() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+type sample struct {
+	ok      bool
+	latency time.Duration
+	at      time.Time
+	err     string
+}
+
+// UpstreamHealth is the public view of the probe results for one upstream
+type UpstreamHealth struct {
+	Upstream     string    `json:"upstream"`
+	Host         string    `json:"host"`
+	Port         int       `json:"port"`
+	Samples      int       `json:"samples"`
+	SuccessRate  float64   `json:"success_rate"`
+	LatencyP50   float64   `json:"latency_p50_ms"`
+	LatencyP90   float64   `json:"latency_p90_ms"`
+	LatencyP99   float64   `json:"latency_p99_ms"`
+	LastOK       bool      `json:"last_ok"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastProbedAt time.Time `json:"last_probed_at"`
+	TotalProbes  int64     `json:"total_probes"`
+	TotalFailed  int64     `json:"total_failed"`
+}
+
+type upstreamState struct {
+	canary  Canary
+	samples []sample
+	total   int64
+	failed  int64
+	streak  int // consecutive probes with the same outcome as the last one
+}
+
+var (
+	mu        sync.RWMutex
+	upstreams = make(map[string]*upstreamState)
+	watched   = make(map[string]Canary) // canaries registered by other subsystems
+	startOnce sync.Once
+)
+
+// Start launches the background probe loop
+func Start() {
+	startOnce.Do(func() {
+		jobs.Every("prober", func() time.Duration { return config.Get().ProbeInterval }, RunOnce)
+		log.Printf("🩺 Upstream prober started (interval %s, target %s)", config.Get().ProbeInterval, config.Get().ProbeTargetURL)
+	})
+}
+
+// Watch adds an upstream that should be probed even when no plan uses it,
+// such as a failover standby.
+func Watch(c Canary) {
+	mu.Lock()
+	defer mu.Unlock()
+	watched[c.Key()] = c
+}
+
+// RunOnce probes every known upstream in parallel and records the results
+func RunOnce() {
+	canaries := discoverCanaries()
+
+	var wg sync.WaitGroup
+	for _, c := range canaries {
+		wg.Add(1)
+		go func(c Canary) {
+			defer wg.Done()
+			s := probe(c)
+			record(c, s)
+			if !s.ok {
+				log.Printf("⚠️ Upstream probe failed for %s: %s", c.Key(), s.err)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// discoverCanaries returns one canary per distinct upstream host:port: the
+// configured canaries and the upstreams other subsystems watch. Customer
+// plans are only used, for upstreams with no canary, when
+// PROBE_CUSTOMER_PLANS opts in, since the probes spend their traffic.
+func discoverCanaries() []Canary {
+	canaries := make(map[string]Canary)
+
+	if config.Get().ProbeCustomerPlans {
+		for _, c := range planCanaries() {
+			canaries[c.Key()] = c
+		}
+	}
+
+	mu.RLock()
+	for key, c := range watched {
+		canaries[key] = c
+	}
+	mu.RUnlock()
+
+	for _, raw := range config.Get().ProbeCanaries {
+		c, err := ParseCanary(raw)
+		if err != nil {
+			log.Printf("⚠️ Ignoring canary %q: %v", raw, err)
+			continue
+		}
+		canaries[c.Key()] = c
+	}
+
+	out := make([]Canary, 0, len(canaries))
+	for _, c := range canaries {
+		out = append(out, c)
+	}
+	return out
+}
+
+// planCanaries returns the first active plan on each upstream
+func planCanaries() []Canary {
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var out []Canary
+	now := time.Now().Unix()
+	for _, e := range entries {
+		if e.AuthHost == "" || e.AuthHost == "blank" {
+			continue
+		}
+		if e.ExpiresAt != 0 && e.ExpiresAt < now {
+			continue
+		}
+		c := Canary{Host: e.AuthHost, Port: e.AuthPort, Username: e.Username, Password: e.Password}
+		if !seen[c.Key()] {
+			seen[c.Key()] = true
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// ParseCanary parses a host:port:user:pass credential string
+func ParseCanary(raw string) (Canary, error) {
+	parts := strings.SplitN(raw, ":", 4)
+	if len(parts) != 4 {
+		return Canary{}, fmt.Errorf("expected host:port:user:pass")
+	}
+	port, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Canary{}, fmt.Errorf("invalid port %q", parts[1])
+	}
+	return Canary{Host: parts[0], Port: port, Username: parts[2], Password: parts[3]}, nil
+}
+
+func probe(c Canary) sample {
+	proxyURL := &url.URL{
+		Scheme: "http",
+		User:   url.UserPassword(c.Username, c.Password),
+		Host:   c.Key(),
+	}
+	client := &http.Client{
+		Timeout: 15 * time.Second,
+		Transport: &http.Transport{
+			Proxy:             http.ProxyURL(proxyURL),
+			DisableKeepAlives: true,
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Get(config.Get().ProbeTargetURL)
+	if err != nil {
+		return sample{ok: false, latency: time.Since(start), at: start, err: err.Error()}
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	latency := time.Since(start)
+
+	if resp.StatusCode >= 400 {
+		return sample{ok: false, latency: latency, at: start, err: fmt.Sprintf("status %d", resp.StatusCode)}
+	}
+	return sample{ok: true, latency: latency, at: start}
+}
+
+func record(c Canary, s sample) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	st, exists := upstreams[c.Key()]
+	if !exists {
+		st = &upstreamState{}
+		upstreams[c.Key()] = st
+	}
+	st.canary = c
+	if n := len(st.samples); n > 0 && st.samples[n-1].ok == s.ok {
+		st.streak++
+	} else {
+		st.streak = 1
+	}
+	st.samples = append(st.samples, s)
+	if len(st.samples) > windowSize {
+		st.samples = st.samples[len(st.samples)-windowSize:]
+	}
+	st.total++
+	if !s.ok {
+		st.failed++
+	}
+}
+
+// Snapshot returns the current health of every probed upstream, sorted by name
+func Snapshot() []UpstreamHealth {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	out := make([]UpstreamHealth, 0, len(upstreams))
+	for key, st := range upstreams {
+		h := UpstreamHealth{
+			Upstream:    key,
+			Host:        st.canary.Host,
+			Port:        st.canary.Port,
+			Samples:     len(st.samples),
+			TotalProbes: st.total,
+			TotalFailed: st.failed,
+		}
+
+		var okCount int
+		var latencies []float64
+		for _, s := range st.samples {
+			if s.ok {
+				okCount++
+				latencies = append(latencies, float64(s.latency.Milliseconds()))
+			}
+		}
+		if len(st.samples) > 0 {
+			h.SuccessRate = float64(okCount) / float64(len(st.samples))
+			last := st.samples[len(st.samples)-1]
+			h.LastOK = last.ok
+			h.LastError = last.err
+			h.LastProbedAt = last.at
+		}
+		sort.Float64s(latencies)
+		h.LatencyP50 = percentile(latencies, 0.50)
+		h.LatencyP90 = percentile(latencies, 0.90)
+		h.LatencyP99 = percentile(latencies, 0.99)
+
+		out = append(out, h)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Upstream < out[j].Upstream })
+	return out
+}
+
+// HostStatus summarises the latest probes for every upstream on host. The host is
+// healthy when any of its upstreams last succeeded; streak is the number of
+// consecutive probes agreeing with that verdict. known is false if host was
+// never probed.
+func HostStatus(host string) (healthy bool, streak int, known bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	okStreak, failStreak := 0, -1
+	for _, st := range upstreams {
+		if st.canary.Host != host || len(st.samples) == 0 {
+			continue
+		}
+		known = true
+		if st.samples[len(st.samples)-1].ok {
+			if st.streak > okStreak {
+				okStreak = st.streak
+			}
+		} else if failStreak == -1 || st.streak < failStreak {
+			failStreak = st.streak
+		}
+	}
+
+	if okStreak > 0 {
+		return true, okStreak, known
+	}
+	if failStreak > 0 {
+		return false, failStreak, known
+	}
+	return true, 0, known
+}
+
+// percentile expects sorted input and uses nearest-rank
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted))*p+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
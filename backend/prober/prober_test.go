@@ -0,0 +1,125 @@
+package prober
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/proxy"
+)
+
+// reset clears the probe results and watched upstreams
+func reset(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	upstreams = make(map[string]*upstreamState)
+	watched = make(map[string]Canary)
+	mu.Unlock()
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	for _, tc := range []struct {
+		p    float64
+		want float64
+	}{
+		{0, 10}, {0.5, 50}, {0.9, 90}, {0.99, 100}, {1, 100},
+	} {
+		if got := percentile(sorted, tc.p); got != tc.want {
+			t.Errorf("percentile(%v) = %v, want %v", tc.p, got, tc.want)
+		}
+	}
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("percentile of no samples = %v, want 0", got)
+	}
+	if got := percentile([]float64{7}, 0.99); got != 7 {
+		t.Errorf("percentile of one sample = %v, want 7", got)
+	}
+}
+
+func TestRecordAndSnapshot(t *testing.T) {
+	reset(t)
+	a := Canary{Host: "a.example", Port: 8000}
+	b := Canary{Host: "b.example", Port: 9000}
+	now := time.Now()
+
+	for i := 1; i <= 4; i++ {
+		record(a, sample{ok: true, latency: time.Duration(i*100) * time.Millisecond, at: now})
+	}
+	record(a, sample{ok: false, latency: time.Second, at: now.Add(time.Second), err: "refused"})
+	record(b, sample{ok: true, latency: 50 * time.Millisecond, at: now})
+
+	snap := Snapshot()
+	if len(snap) != 2 || snap[0].Upstream != "a.example:8000" || snap[1].Upstream != "b.example:9000" {
+		t.Fatalf("snapshot %+v, want a then b", snap)
+	}
+	h := snap[0]
+	if h.Samples != 5 || h.TotalProbes != 5 || h.TotalFailed != 1 || h.SuccessRate != 0.8 {
+		t.Errorf("counts %+v", h)
+	}
+	if h.LastOK || h.LastError != "refused" || !h.LastProbedAt.Equal(now.Add(time.Second)) {
+		t.Errorf("last probe %+v", h)
+	}
+	// Failed probes carry no latency
+	if h.LatencyP50 != 200 || h.LatencyP99 != 400 {
+		t.Errorf("latencies p50 %v p99 %v, want 200 and 400", h.LatencyP50, h.LatencyP99)
+	}
+
+	healthy, streak, known := HostStatus("a.example")
+	if healthy || streak != 1 || !known {
+		t.Errorf("HostStatus(a) = %t %d %t, want unhealthy after 1 failure", healthy, streak, known)
+	}
+	if _, _, known := HostStatus("c.example"); known {
+		t.Error("never probed host is known")
+	}
+}
+
+func TestRecordWindow(t *testing.T) {
+	reset(t)
+	c := Canary{Host: "a.example", Port: 8000}
+	for i := 0; i < windowSize+20; i++ {
+		record(c, sample{ok: i >= 20, at: time.Now()})
+	}
+	h := Snapshot()[0]
+	if h.Samples != windowSize || h.TotalProbes != windowSize+20 || h.TotalFailed != 20 || h.SuccessRate != 1 {
+		t.Errorf("after %d probes %+v", windowSize+20, h)
+	}
+	if _, streak, _ := HostStatus("a.example"); streak != windowSize {
+		t.Errorf("streak %d, want %d", streak, windowSize)
+	}
+}
+
+func TestDiscoverCanaries(t *testing.T) {
+	reset(t)
+	config.ProxyLogPath = filepath.Join(t.TempDir(), "proxies.json")
+	config.Set(func(s *config.Settings) { s.ProbeCanaries = []string{"canary.example:8000:probe:secret"} })
+	defer config.Set(func(s *config.Settings) { s.ProbeCanaries, s.ProbeCustomerPlans = nil, false })
+	if err := proxy.LogProxy(
+		proxy.Entry{PlanID: "p1", AuthHost: "canary.example", AuthPort: 8000, Username: "customer", Password: "pw"},
+		proxy.Entry{PlanID: "p2", AuthHost: "plan.example", AuthPort: 8000, Username: "customer", Password: "pw"},
+		proxy.Entry{PlanID: "p3", AuthHost: "old.example", AuthPort: 8000, ExpiresAt: 1},
+	); err != nil {
+		t.Fatal(err)
+	}
+	Watch(Canary{Host: "standby.example", Port: 7000})
+
+	keys := func() map[string]string {
+		got := make(map[string]string)
+		for _, c := range discoverCanaries() {
+			got[c.Key()] = c.Username
+		}
+		return got
+	}
+
+	got := keys()
+	if len(got) != 2 || got["canary.example:8000"] != "probe" || got["standby.example:7000"] != "" {
+		t.Errorf("canaries %v, want only the configured canary and the watched standby", got)
+	}
+
+	config.Set(func(s *config.Settings) { s.ProbeCustomerPlans = true })
+	got = keys()
+	if len(got) != 3 || got["plan.example:8000"] != "customer" || got["canary.example:8000"] != "probe" {
+		t.Errorf("canaries with customer plans %v, want plan.example through its plan and the configured canary kept", got)
+	}
+}
@@ -0,0 +1,244 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"oceanproxy-api/config"
+)
+
+const (
+	breakerThreshold = 5                // consecutive failures before the breaker opens
+	breakerCooldown  = 30 * time.Second // how long the breaker stays open
+	maxResponseBytes = 10 << 20
+)
+
+// Client is a provider HTTP client with per-call timeouts, retries with jitter
+// for idempotent calls, a circuit breaker and a request rate limit.
+type Client struct {
+	name string
+	http *http.Client
+
+	mu          sync.Mutex
+	failures    int
+	openUntil   time.Time
+	tokens      float64
+	lastRefill  time.Time
+	ratePerSec  float64
+	burst       float64
+	halfOpenRun bool
+}
+
+// Request describes one provider call
+type Request struct {
+	Method     string
+	URL        string
+	Body       []byte
+	Header     http.Header
+	Idempotent bool // safe to retry on network errors and 5xx
+}
+
+// Response is a fully read provider response
+type Response struct {
+	Status int
+	Body   []byte
+}
+
+var (
+	clientsMu sync.Mutex
+	clients   = make(map[string]*Client)
+)
+
+// clientFor returns the shared client for a provider, creating it on first use
+func clientFor(name string) *Client {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if c, ok := clients[name]; ok {
+		return c
+	}
+	rate := config.Get().ProviderRateLimit
+	c := &Client{
+		name:       name,
+		http:       &http.Client{},
+		ratePerSec: rate,
+		burst:      rate * 2,
+		tokens:     rate * 2,
+		lastRefill: time.Now(),
+	}
+	clients[name] = c
+	return c
+}
+
+// ResetClients drops the shared clients so the next call picks up the current
+// rate limit settings. Calls already in flight finish on their old client.
+func ResetClients() {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	clients = make(map[string]*Client)
+}
+
+// Do sends the request, retrying idempotent calls, and returns the full body.
+// Failures are returned as *ProviderError with ErrUpstreamDown as their kind;
+// they also match ErrNotSent when no attempt reached the provider.
+func (c *Client) Do(req Request) (*Response, error) {
+	attempts := 1
+	if req.Idempotent {
+		attempts += config.Get().ProviderMaxRetries
+	}
+
+	var lastErr *ProviderError
+	sent := false
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff(attempt))
+		}
+
+		if err := c.allow(); err != nil {
+			err.NotSent = !sent
+			return nil, err
+		}
+		c.wait()
+
+		resp, err := c.once(req)
+		if err == nil && resp.Status < 500 && resp.Status != http.StatusTooManyRequests {
+			c.success()
+			return resp, nil
+		}
+
+		c.failure()
+		if err != nil {
+			sent = sent || !dialFailed(err)
+			lastErr = newProviderError(c.name, ErrUpstreamDown, 0, "%v", err)
+		} else {
+			sent = true
+			lastErr = newProviderError(c.name, ErrUpstreamDown, resp.Status, "%s", truncate(resp.Body))
+		}
+		lastErr.NotSent = !sent
+		log.Printf("⚠️ %s %s %s failed (attempt %d/%d): %v", c.name, req.Method, req.URL, attempt+1, attempts, lastErr)
+	}
+	return nil, lastErr
+}
+
+// dialFailed reports whether err happened while connecting, before any of
+// the request was written. Timeouts and resets after that may have reached
+// the provider.
+func dialFailed(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+func (c *Client) once(req Request) (*Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Get().ProviderTimeout)
+	defer cancel()
+
+	var body io.Reader
+	if req.Body != nil {
+		body = bytes.NewReader(req.Body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, req.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.Header {
+		httpReq.Header[k] = v
+	}
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Status: resp.StatusCode, Body: data}, nil
+}
+
+// allow checks the circuit breaker. After the cooldown a single trial call is let through.
+func (c *Client) allow() *ProviderError {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures < breakerThreshold {
+		return nil
+	}
+	if time.Now().Before(c.openUntil) || c.halfOpenRun {
+		return newProviderError(c.name, ErrUpstreamDown, 0, "circuit breaker open after %d consecutive failures", c.failures)
+	}
+	c.halfOpenRun = true
+	return nil
+}
+
+func (c *Client) success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures >= breakerThreshold {
+		log.Printf("✅ %s circuit breaker closed", c.name)
+	}
+	c.failures = 0
+	c.halfOpenRun = false
+}
+
+func (c *Client) failure() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures++
+	c.halfOpenRun = false
+	if c.failures >= breakerThreshold {
+		c.openUntil = time.Now().Add(breakerCooldown)
+		if c.failures == breakerThreshold {
+			log.Printf("🔌 %s circuit breaker opened for %s", c.name, breakerCooldown)
+		}
+	}
+}
+
+// wait blocks until the token bucket allows another request
+func (c *Client) wait() {
+	if c.ratePerSec <= 0 {
+		return
+	}
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		c.tokens += now.Sub(c.lastRefill).Seconds() * c.ratePerSec
+		if c.tokens > c.burst {
+			c.tokens = c.burst
+		}
+		c.lastRefill = now
+		if c.tokens >= 1 {
+			c.tokens--
+			c.mu.Unlock()
+			return
+		}
+		delay := time.Duration((1 - c.tokens) / c.ratePerSec * float64(time.Second))
+		c.mu.Unlock()
+		time.Sleep(delay)
+	}
+}
+
+// backoff returns an exponential delay with full jitter, capped at 5s
+func backoff(attempt int) time.Duration {
+	max := 250 * time.Millisecond << uint(attempt)
+	if max > 5*time.Second {
+		max = 5 * time.Second
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+func truncate(body []byte) string {
+	if len(body) > 200 {
+		return string(body[:200]) + "..."
+	}
+	return string(body)
+}
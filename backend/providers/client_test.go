@@ -0,0 +1,57 @@
+package providers
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"oceanproxy-api/providers/fake"
+)
+
+func TestNotSent(t *testing.T) {
+	form := func() url.Values { return url.Values{"reseller": {"residential"}} }
+
+	for _, tt := range []struct {
+		name    string
+		mode    fake.Mode
+		closed  bool
+		notSent bool
+	}{
+		{name: "server error reached the provider", mode: fake.ServerError},
+		{name: "timeout may have reached the provider", mode: fake.Slow},
+		{name: "refused connection was never sent", mode: fake.Success, closed: true, notSent: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fake.ProxiesFO(tt.mode)
+			useFakeProvider(t, srv)
+			if tt.closed {
+				srv.Close()
+			}
+
+			_, err := CreateProxiesFOPlan(form())
+			if !errors.Is(err, ErrUpstreamDown) {
+				t.Fatalf("expected ErrUpstreamDown, got %v", err)
+			}
+			if errors.Is(err, ErrNotSent) != tt.notSent {
+				t.Errorf("errors.Is(%v, ErrNotSent) = %t, want %t", err, !tt.notSent, tt.notSent)
+			}
+		})
+	}
+
+	t.Run("open circuit breaker sends nothing", func(t *testing.T) {
+		srv := fake.ProxiesFO(fake.ServerError)
+		useFakeProvider(t, srv)
+		for i := 0; i < breakerThreshold; i++ {
+			_, _ = CreateProxiesFOPlan(form())
+		}
+		sent := len(srv.Requests())
+
+		_, err := CreateProxiesFOPlan(form())
+		if !errors.Is(err, ErrNotSent) {
+			t.Errorf("expected ErrNotSent with the breaker open, got %v", err)
+		}
+		if n := len(srv.Requests()); n != sent {
+			t.Errorf("%d request(s) sent through an open breaker", n-sent)
+		}
+	})
+}
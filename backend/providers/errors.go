@@ -0,0 +1,104 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error kinds returned by provider calls. Use errors.Is to check them.
+var (
+	ErrAuth         = errors.New("provider rejected our credentials")
+	ErrQuota        = errors.New("provider quota or balance exhausted")
+	ErrValidation   = errors.New("provider rejected the request")
+	ErrUpstreamDown = errors.New("provider unavailable")
+
+	// ErrNotSent matches failures where the request provably never reached
+	// the provider: the circuit breaker was open or the connection could not
+	// be made. Only then is it safe to send a purchase again.
+	ErrNotSent = errors.New("request was not sent")
+)
+
+// ProviderError carries the provider name, HTTP status and message alongside its kind
+type ProviderError struct {
+	Provider string
+	Kind     error
+	Status   int
+	Message  string
+	NotSent  bool // no attempt of the call reached the provider
+}
+
+func (e *ProviderError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("%s API error (%d): %s", e.Provider, e.Status, e.Message)
+	}
+	return fmt.Sprintf("%s API error: %s", e.Provider, e.Message)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Kind
+}
+
+// Is lets errors.Is(err, ErrNotSent) match errors of calls that were never sent
+func (e *ProviderError) Is(target error) bool {
+	return target == ErrNotSent && e.NotSent
+}
+
+// afterCreate marks err from a call made once planID was already created,
+// so it is never taken for a purchase that was not sent. planID is empty
+// when the response did not say which plan was created.
+func afterCreate(provider, planID string, err error) error {
+	created := "plan " + planID + " was created"
+	if planID == "" {
+		created = "a plan was created"
+	}
+	var pe *ProviderError
+	if !errors.As(err, &pe) {
+		return fmt.Errorf("%s %s but: %w", provider, created, err)
+	}
+	c := *pe
+	c.NotSent = false
+	c.Message = fmt.Sprintf("%s but: %s", created, c.Message)
+	return &c
+}
+
+func newProviderError(provider string, kind error, status int, format string, args ...interface{}) *ProviderError {
+	return &ProviderError{
+		Provider: provider,
+		Kind:     kind,
+		Status:   status,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// classifyStatus maps an HTTP status and error message from a provider to an error kind
+func classifyStatus(status int, message string) error {
+	msg := strings.ToLower(message)
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrAuth
+	case status == http.StatusPaymentRequired:
+		return ErrQuota
+	case status == http.StatusTooManyRequests || status >= 500:
+		return ErrUpstreamDown
+	}
+
+	// proxies.fo reports failures with a 200 and an Error string, so fall back to the message
+	switch {
+	case containsAny(msg, "api key", "apikey", "unauthori", "forbidden", "invalid token"):
+		return ErrAuth
+	case containsAny(msg, "balance", "insufficient", "funds", "quota", "limit", "credit"):
+		return ErrQuota
+	}
+	return ErrValidation
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
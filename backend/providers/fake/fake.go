@@ -0,0 +1,236 @@
+// Package fake provides httptest servers that emulate the proxies.fo and
+// Nettify reseller APIs so provider code can be exercised offline.
+package fake
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode selects how a fake server answers
+type Mode int
+
+const (
+	Success       Mode = iota // well-formed success response
+	ErrorEnvelope             // the provider's own error format
+	MissingFields             // success response with required fields left out
+	Slow                      // success response after Delay
+	Unauthorized              // 401 with an error body
+	ServerError               // 500 with an error body
+)
+
+// Server is a fake provider API. Delay applies to Slow mode and should be set
+// before the first request; use SetMode to switch modes between requests.
+type Server struct {
+	*httptest.Server
+	Delay time.Duration
+
+	mu       sync.Mutex
+	mode     Mode
+	requests []Recorded
+}
+
+// Recorded is a request received by a fake server
+type Recorded struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   string
+	Form   url.Values
+}
+
+// Requests returns every request the server has received so far
+func (s *Server) Requests() []Recorded {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Recorded(nil), s.requests...)
+}
+
+// SetMode changes how subsequent requests are answered
+func (s *Server) SetMode(m Mode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mode = m
+}
+
+func (s *Server) record(r *http.Request) (Recorded, Mode, time.Duration) {
+	body, _ := io.ReadAll(r.Body)
+	form, _ := url.ParseQuery(string(body))
+	rec := Recorded{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header.Clone(),
+		Body:   string(body),
+		Form:   form,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, rec)
+	return rec, s.mode, s.Delay
+}
+
+func newServer(mode Mode, handler func(w http.ResponseWriter, r *http.Request, rec Recorded, mode Mode)) *Server {
+	s := &Server{mode: mode, Delay: 2 * time.Second}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec, mode, delay := s.record(r)
+		if mode == Slow {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		handler(w, r, rec, mode)
+	}))
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+// ProxiesFO fakes POST /api/plans/new, GET /api/plans, GET /api/plans/{id} and
+// POST /api/plans/{id}/extend.
+// New plans with a threads field get a dcp.proxies.fo plan like datacenter
+// orders, everything else a residential one.
+func ProxiesFO(mode Mode) *Server {
+	return newServer(mode, func(w http.ResponseWriter, r *http.Request, rec Recorded, mode Mode) {
+		if r.Header.Get("X-Api-Auth") == "" || mode == Unauthorized {
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"Success": false, "Error": "Invalid API key"})
+			return
+		}
+
+		switch mode {
+		case ErrorEnvelope:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"Success": false, "Error": "Insufficient balance"})
+			return
+		case ServerError:
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"Success": false, "Error": "internal error"})
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/plans/new":
+			if mode == MissingFields {
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"Success": true,
+					"Data":    map[string]interface{}{"ID": "fo-plan-1"},
+				})
+				return
+			}
+			host := "pr-us.proxies.fo"
+			if rec.Form.Get("threads") != "" {
+				host = "dcp.proxies.fo"
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"Success": true,
+				"Data":    proxiesFOPlan("fo-plan-1", host, "active"),
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/plans":
+			if mode == MissingFields {
+				writeJSON(w, http.StatusOK, map[string]interface{}{"Success": true})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"Success": true,
+				"Data": []map[string]interface{}{
+					proxiesFOPlan("fo-plan-1", "pr-us.proxies.fo", "active"),
+					proxiesFOPlan("fo-plan-2", "dcp.proxies.fo", "active"),
+					proxiesFOPlan("fo-plan-3", "pr-us.proxies.fo", "expired"),
+				},
+			})
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/api/plans/") && strings.HasSuffix(r.URL.Path, "/extend"):
+			id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/plans/"), "/extend")
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"Success": true,
+				"Data":    proxiesFOPlan(id, "dcp.proxies.fo", "active"),
+			})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/plans/"):
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"Success": true,
+				"Data":    proxiesFOPlan(strings.TrimPrefix(r.URL.Path, "/api/plans/"), "pr-us.proxies.fo", "active"),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func proxiesFOPlan(id, host, status string) map[string]interface{} {
+	return map[string]interface{}{
+		"ID":           id,
+		"AuthUsername": "fouser",
+		"AuthPassword": "fopass",
+		"AuthHostname": host,
+		"AuthPort":     float64(10000),
+		"EndsDate":     float64(time.Now().Add(30 * 24 * time.Hour).Unix()),
+		"Status":       status,
+	}
+}
+
+// Nettify fakes POST /plans/create, GET /plans, GET /plans/{id}, PUT /plans/{id}
+// and POST /plans/{id}/extend
+func Nettify(mode Mode) *Server {
+	return newServer(mode, func(w http.ResponseWriter, r *http.Request, rec Recorded, mode Mode) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") || mode == Unauthorized {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Invalid token"})
+			return
+		}
+
+		switch mode {
+		case ErrorEnvelope:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "username already exists"})
+			return
+		case ServerError:
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"message": "internal error"})
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/plans/create":
+			var req map[string]interface{}
+			_ = json.Unmarshal([]byte(rec.Body), &req)
+			if mode == MissingFields {
+				writeJSON(w, http.StatusOK, map[string]interface{}{"username": req["username"]})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"plan_id":  "nf-plan-1",
+				"username": req["username"],
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/plans":
+			if mode == MissingFields {
+				writeJSON(w, http.StatusOK, []map[string]interface{}{{"plan_id": "nf-plan-1"}})
+				return
+			}
+			writeJSON(w, http.StatusOK, []map[string]interface{}{
+				{"plan_id": "nf-plan-1", "username": "alice_1", "plan_type": "residential", "max_bytes": 1 << 30, "used_bytes": 1024, "enabled": true, "active": true, "last_used": "2025-01-01T00:00:00Z"},
+				{"plan_id": "nf-plan-2", "username": "bob_2", "plan_type": "datacenter", "max_bytes": 2 << 30, "used_bytes": 0, "enabled": false, "active": false, "last_used": ""},
+			})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/plans/"):
+			if mode == MissingFields {
+				writeJSON(w, http.StatusOK, map[string]interface{}{"plan_id": strings.TrimPrefix(r.URL.Path, "/plans/")})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"plan_id":  strings.TrimPrefix(r.URL.Path, "/plans/"),
+				"password": "nfpass",
+			})
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/plans/") && strings.HasSuffix(r.URL.Path, "/extend"):
+			writeJSON(w, http.StatusOK, map[string]string{"message": "extended"})
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/plans/"):
+			writeJSON(w, http.StatusOK, map[string]string{"message": "updated"})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
@@ -1,11 +1,11 @@
 package providers
 
 import (
-	"bytes"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -24,7 +24,7 @@ type NettifyPlanInfo struct {
 }
 
 func CreateNettifyPlan(form url.Values) (*NettifyPlanInfo, error) {
-	apiURL := "https://api.nettify.xyz/plans/create"
+	apiURL := config.Get().NettifyBaseURL + "/plans/create"
 
 	planType := form.Get("plan_type")
 	if planType == "" {
@@ -40,11 +40,12 @@ func CreateNettifyPlan(form url.Values) (*NettifyPlanInfo, error) {
 
 	password := form.Get("password")
 	if password == "" {
-		return nil, errors.New("password is required")
+		return nil, newProviderError("nettify", ErrValidation, 0, "password is required")
 	}
 
 	// Defaults
 	var requestData map[string]interface{}
+	var quotaBytes int64 // 0 for time-based plans
 
 	if planType == "unlimited" {
 		hours := form.Get("hours")
@@ -65,6 +66,7 @@ func CreateNettifyPlan(form url.Values) (*NettifyPlanInfo, error) {
 		}
 		bandwidth, _ := strconv.ParseFloat(form.Get("bandwidth"), 64)
 		bandwidthMB := int(bandwidth * 1024) // Convert GB to MB
+		quotaBytes = int64(bandwidthMB) << 20
 
 		requestData = map[string]interface{}{
 			"username":     username,
@@ -74,101 +76,80 @@ func CreateNettifyPlan(form url.Values) (*NettifyPlanInfo, error) {
 		}
 	}
 
-	jsonData, _ := json.Marshal(requestData)
-
-	// Debug: Print what we're sending to Nettify API
-	fmt.Printf("DEBUG: Sending to Nettify API:\n")
-	fmt.Printf("DEBUG: URL: %s\n", apiURL)
-	fmt.Printf("DEBUG: Headers: Authorization: Bearer %s\n", config.NettifyAPIKey)
-	fmt.Printf("DEBUG: Body: %s\n", string(jsonData))
-
-	req, _ := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonData))
-	req.Header.Set("Authorization", "Bearer "+config.NettifyAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
+	jsonData, err := json.Marshal(requestData)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// Debug: Print response
-	fmt.Printf("DEBUG: Response Status: %d\n", resp.StatusCode)
-
-	// Read response body for debugging
-	bodyBytes, _ := io.ReadAll(resp.Body)
-	fmt.Printf("DEBUG: Response Body: %s\n", string(bodyBytes))
+	resp, err := clientFor("nettify").Do(Request{
+		Method: "POST",
+		URL:    apiURL,
+		Body:   jsonData,
+		Header: http.Header{
+			"Authorization": {"Bearer " + config.Get().NettifyAPIKey},
+			"Content-Type":  {"application/json"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	// Reset response body for JSON decoding
-	resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	log.Printf("🔗 Nettify create plan response: %d", resp.Status)
 
 	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		return nil, newProviderError("nettify", classifyStatus(resp.Status, ""), resp.Status, "invalid JSON response: %s", truncate(resp.Body))
 	}
 
 	// Check for API errors first
-	if resp.StatusCode != 200 {
-		if message, exists := result["message"]; exists {
-			return nil, fmt.Errorf("API error (%d): %v", resp.StatusCode, message)
+	if resp.Status != 200 {
+		message := fmt.Sprintf("status code %d", resp.Status)
+		if m, exists := result["message"]; exists {
+			message = fmt.Sprint(m)
 		}
-		return nil, fmt.Errorf("API error: status code %d", resp.StatusCode)
+		return nil, newProviderError("nettify", classifyStatus(resp.Status, message), resp.Status, "%s", message)
 	}
 
-	// Extract fields safely
-	user, ok := result["username"].(string)
+	// Extract fields safely; the plan is bought from here on
+	planID, ok := result["plan_id"].(string)
 	if !ok {
-		return nil, fmt.Errorf("username field missing or invalid in response")
+		return nil, afterCreate("nettify", "", fmt.Errorf("plan_id field missing or invalid in response"))
 	}
 
-	planID, ok := result["plan_id"].(string)
+	user, ok := result["username"].(string)
 	if !ok {
-		return nil, fmt.Errorf("plan_id field missing or invalid in response")
+		return nil, afterCreate("nettify", planID, fmt.Errorf("username field missing or invalid in response"))
 	}
 
 	// Get plan details to get password
-	detailsURL := "https://api.nettify.xyz/plans/" + planID
-	detailsReq, _ := http.NewRequest("GET", detailsURL, nil)
-	detailsReq.Header.Set("Authorization", "Bearer "+config.NettifyAPIKey)
-
-	detailsResp, err := http.DefaultClient.Do(detailsReq)
+	detailsResp, err := clientFor("nettify").Do(Request{
+		Method:     "GET",
+		URL:        config.Get().NettifyBaseURL + "/plans/" + planID,
+		Header:     http.Header{"Authorization": {"Bearer " + config.Get().NettifyAPIKey}},
+		Idempotent: true,
+	})
 	if err != nil {
-		return nil, err
+		return nil, afterCreate("nettify", planID, err)
+	}
+	if detailsResp.Status != 200 {
+		return nil, afterCreate("nettify", planID, newProviderError("nettify", classifyStatus(detailsResp.Status, ""), detailsResp.Status, "%s", truncate(detailsResp.Body)))
 	}
-	defer detailsResp.Body.Close()
 
 	var details map[string]interface{}
-	if err := json.NewDecoder(detailsResp.Body).Decode(&details); err != nil {
-		return nil, err
+	if err := json.Unmarshal(detailsResp.Body, &details); err != nil {
+		return nil, afterCreate("nettify", planID, err)
 	}
 
 	pass, ok := details["password"].(string)
 	if !ok {
-		return nil, fmt.Errorf("password field missing or invalid in plan details response")
+		return nil, afterCreate("nettify", planID, fmt.Errorf("password field missing or invalid in plan details response"))
 	}
 
 	expires := int64(0) // No expiration for bandwidth-based plans
 
-	var proxies []proxy.Entry
-
-	switch planType {
-	case "residential":
-		authPort := 8080 // Default auth port for residential
-		proxies = []proxy.Entry{
-			proxy.NewEntry(planID, user, pass, "proxy.nettify.xyz", 9876, "alpha", authPort, expires),
-		}
-	case "datacenter":
-		proxies = []proxy.Entry{
-			proxy.NewEntry(planID, user, pass, "proxy.nettify.xyz", 8080, "beta", 8765, expires),
-		}
-	case "mobile":
-		proxies = []proxy.Entry{
-			proxy.NewEntry(planID, user, pass, "proxy.nettify.xyz", 8080, "mobile", 7654, expires),
-		}
-	case "unlimited":
-		proxies = []proxy.Entry{
-			proxy.NewEntry(planID, user, pass, "proxy.nettify.xyz", 8080, "unlim", 6543, expires),
-		}
+	proxies := NettifyEntries(planType, planID, user, pass, expires)
+	for i := range proxies {
+		proxies[i].QuotaBytes = quotaBytes
 	}
 
 	return &NettifyPlanInfo{
@@ -180,6 +161,16 @@ func CreateNettifyPlan(form url.Values) (*NettifyPlanInfo, error) {
 	}, nil
 }
 
+// NettifyEntries builds the local listener entries a Nettify plan of planType
+// is served on, as declared in the plan topology
+func NettifyEntries(planType, planID, user, pass string, expires int64) []proxy.Entry {
+	product, ok := LookupProduct("nettify/" + planType)
+	if !ok {
+		return nil
+	}
+	return BuildEntries(product, planID, user, pass, 0, expires)
+}
+
 type NettifyPlan struct {
 	PlanID    string `json:"plan_id"`
 	Username  string `json:"username"`
@@ -193,27 +184,84 @@ type NettifyPlan struct {
 
 // RetreiveAllPlans fetches all plans from Nettify API
 func RetreiveAllPlans() ([]NettifyPlan, error) {
-	apiURL := "https://api.nettify.xyz/plans"
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+config.NettifyAPIKey)
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := clientFor("nettify").Do(Request{
+		Method:     "GET",
+		URL:        config.Get().NettifyBaseURL + "/plans",
+		Header:     http.Header{"Authorization": {"Bearer " + config.Get().NettifyAPIKey}},
+		Idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error: %d %s", resp.StatusCode, string(body))
+	if resp.Status != 200 {
+		return nil, newProviderError("nettify", classifyStatus(resp.Status, ""), resp.Status, "%s", truncate(resp.Body))
 	}
 
 	var plans []NettifyPlan
-	if err := json.NewDecoder(resp.Body).Decode(&plans); err != nil {
+	if err := json.Unmarshal(resp.Body, &plans); err != nil {
 		return nil, err
 	}
 	return plans, nil
 }
+
+// SetNettifyPassword changes the password of an existing Nettify plan
+func SetNettifyPassword(planID, password string) error {
+	body, err := json.Marshal(map[string]string{"new_password": password})
+	if err != nil {
+		return err
+	}
+
+	resp, err := clientFor("nettify").Do(Request{
+		Method: "PUT",
+		URL:    config.Get().NettifyBaseURL + "/plans/" + planID,
+		Body:   body,
+		Header: http.Header{
+			"Authorization": {"Bearer " + config.Get().NettifyAPIKey},
+			"Content-Type":  {"application/json"},
+		},
+		Idempotent: true,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Status != 200 {
+		return newProviderError("nettify", classifyStatus(resp.Status, ""), resp.Status, "%s", truncate(resp.Body))
+	}
+	return nil
+}
+
+// GeneratePassword returns a random URL-safe password of length characters
+// for a Nettify plan
+func GeneratePassword(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate password: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)[:length], nil
+}
+
+// ExtendNettifyPlan buys hours more of an unlimited plan
+func ExtendNettifyPlan(planID string, hours int) error {
+	body, err := json.Marshal(map[string]int{"duration_hours": hours})
+	if err != nil {
+		return err
+	}
+
+	resp, err := clientFor("nettify").Do(Request{
+		Method: "POST",
+		URL:    config.Get().NettifyBaseURL + "/plans/" + planID + "/extend",
+		Body:   body,
+		Header: http.Header{
+			"Authorization": {"Bearer " + config.Get().NettifyAPIKey},
+			"Content-Type":  {"application/json"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Status != 200 {
+		return newProviderError("nettify", classifyStatus(resp.Status, ""), resp.Status, "%s", truncate(resp.Body))
+	}
+	return nil
+}
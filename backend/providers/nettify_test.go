@@ -0,0 +1,155 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"oceanproxy-api/providers/fake"
+)
+
+func TestCreateNettifyPlan(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       fake.Mode
+		form       url.Values
+		wantErr    error
+		wantAnyErr bool
+		wantSub    string
+		wantBody   map[string]interface{}
+	}{
+		{
+			name:     "residential bandwidth in MB",
+			mode:     fake.Success,
+			form:     url.Values{"plan_type": {"residential"}, "username": {"alice"}, "password": {"pw"}, "bandwidth": {"2"}},
+			wantSub:  "alpha",
+			wantBody: map[string]interface{}{"plan_type": "residential", "bandwidth_mb": float64(2048)},
+		},
+		{
+			name:     "unlimited uses hours",
+			mode:     fake.Success,
+			form:     url.Values{"plan_type": {"unlimited"}, "password": {"pw"}, "hours": {"3"}},
+			wantSub:  "unlim",
+			wantBody: map[string]interface{}{"plan_type": "unlimited", "duration_hours": float64(3)},
+		},
+		{
+			name:    "password is required",
+			mode:    fake.Success,
+			form:    url.Values{"plan_type": {"residential"}},
+			wantErr: ErrValidation,
+		},
+		{
+			name:    "error envelope",
+			mode:    fake.ErrorEnvelope,
+			form:    url.Values{"password": {"pw"}},
+			wantErr: ErrValidation,
+		},
+		{
+			name:       "missing plan_id",
+			mode:       fake.MissingFields,
+			form:       url.Values{"password": {"pw"}},
+			wantAnyErr: true,
+		},
+		{
+			name:    "unauthorized",
+			mode:    fake.Unauthorized,
+			form:    url.Values{"password": {"pw"}},
+			wantErr: ErrAuth,
+		},
+		{
+			name:    "slow response times out",
+			mode:    fake.Slow,
+			form:    url.Values{"password": {"pw"}},
+			wantErr: ErrUpstreamDown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fake.Nettify(tt.mode)
+			useFakeProvider(t, srv)
+
+			info, err := CreateNettifyPlan(tt.form)
+
+			if tt.wantErr != nil || tt.wantAnyErr {
+				if err == nil {
+					t.Fatalf("expected error, got plan %+v", info)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				if tt.mode == fake.MissingFields && (errors.Is(err, ErrNotSent) || !strings.Contains(err.Error(), "was created")) {
+					t.Errorf("a bought plan with missing fields is not marked created: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if info.PlanID != "nf-plan-1" || info.Password != "nfpass" {
+				t.Errorf("unexpected plan info: %+v", info)
+			}
+			if len(info.Proxies) != 1 || info.Proxies[0].Subdomain != tt.wantSub {
+				t.Fatalf("expected one %s proxy, got %+v", tt.wantSub, info.Proxies)
+			}
+
+			reqs := srv.Requests()
+			if len(reqs) != 2 {
+				t.Fatalf("expected create and details requests, got %d", len(reqs))
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal([]byte(reqs[0].Body), &body); err != nil {
+				t.Fatalf("create body is not JSON: %v", err)
+			}
+			for k, v := range tt.wantBody {
+				if body[k] != v {
+					t.Errorf("create body %s: expected %v, got %v", k, v, body[k])
+				}
+			}
+			if user, _ := body["username"].(string); !strings.Contains(user, "_") {
+				t.Errorf("expected timestamp suffix on username, got %q", user)
+			}
+		})
+	}
+}
+
+func TestRetreiveAllPlans(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      fake.Mode
+		wantErr   error
+		wantPlans int
+	}{
+		{name: "lists plans", mode: fake.Success, wantPlans: 2},
+		{name: "missing fields decode to zero values", mode: fake.MissingFields, wantPlans: 1},
+		{name: "unauthorized", mode: fake.Unauthorized, wantErr: ErrAuth},
+		{name: "server error", mode: fake.ServerError, wantErr: ErrUpstreamDown},
+		{name: "slow response times out", mode: fake.Slow, wantErr: ErrUpstreamDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeProvider(t, fake.Nettify(tt.mode))
+
+			plans, err := RetreiveAllPlans()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(plans) != tt.wantPlans {
+				t.Fatalf("expected %d plans, got %d", tt.wantPlans, len(plans))
+			}
+			if plans[0].PlanID != "nf-plan-1" {
+				t.Errorf("unexpected first plan: %+v", plans[0])
+			}
+		})
+	}
+}
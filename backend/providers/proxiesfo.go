@@ -2,10 +2,10 @@ package providers
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"oceanproxy-api/config"
@@ -21,17 +21,12 @@ type ProxyPlanInfo struct {
 }
 
 func CreateProxiesFOPlan(form url.Values) (*ProxyPlanInfo, error) {
-	apiURL := "https://app.proxies.fo/api/plans/new"
+	apiURL := config.Get().ProxiesFOBaseURL + "/api/plans/new"
 
-	resellerMap := map[string]string{
-		"residential": "7c9ea873-63f9-4013-9147-3807cc6f0553",
-		"isp":         "3471aa35-7922-488a-a7a9-b92a5510080e",
-		"datacenter":  "b3fd0f3c-693d-4ec5-b49f-c77feaab0b72",
-	}
 	reseller := form.Get("reseller")
-	resellerID, ok := resellerMap[reseller]
+	resellerID, ok := config.Get().ProxiesFOResellers[reseller]
 	if !ok {
-		return nil, errors.New("invalid reseller type")
+		return nil, newProviderError("proxies.fo", ErrValidation, 0, "invalid reseller type")
 	}
 
 	// Defaults
@@ -50,28 +45,28 @@ func CreateProxiesFOPlan(form url.Values) (*ProxyPlanInfo, error) {
 	}
 	form.Set("reseller", resellerID)
 
-	req, _ := http.NewRequest("POST", apiURL, strings.NewReader(form.Encode()))
-	req.Header.Set("X-Api-Auth", config.APIKey)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := clientFor("proxies.fo").Do(Request{
+		Method: "POST",
+		URL:    apiURL,
+		Body:   []byte(form.Encode()),
+		Header: http.Header{
+			"X-Api-Auth":   {config.Get().APIKey},
+			"Content-Type": {"application/x-www-form-urlencoded"},
+		},
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
 	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		return nil, newProviderError("proxies.fo", classifyStatus(resp.Status, ""), resp.Status, "invalid JSON response: %s", truncate(resp.Body))
 	}
 
-	// DEBUG: Print the actual response
-	fmt.Printf("DEBUG: Proxies.fo API Response: %+v\n", result)
-
 	// Check if the API request was successful
 	success, ok := result["Success"].(bool)
 	if !ok {
-		return nil, fmt.Errorf("unexpected response format: missing 'Success' field")
+		return nil, newProviderError("proxies.fo", classifyStatus(resp.Status, ""), resp.Status, "unexpected response format: missing 'Success' field")
 	}
 
 	if !success {
@@ -80,39 +75,39 @@ func CreateProxiesFOPlan(form url.Values) (*ProxyPlanInfo, error) {
 		if !ok {
 			errorMsg = "Unknown error from Proxies.fo API"
 		}
-		return nil, fmt.Errorf("Proxies.fo API error: %s", errorMsg)
+		return nil, newProviderError("proxies.fo", classifyStatus(resp.Status, errorMsg), resp.Status, "%s", errorMsg)
 	}
 
-	// Now safely handle the success case
+	// The plan is bought from here on, so failures must not look unsent
 	data, ok := result["Data"].(map[string]interface{})
 	if !ok {
-		return nil, fmt.Errorf("unexpected response format: 'Data' field missing or wrong type")
+		return nil, afterCreate("proxies.fo", "", fmt.Errorf("unexpected response format: 'Data' field missing or wrong type"))
 	}
 
-	user, ok := data["AuthUsername"].(string)
+	planID, ok := data["ID"].(string)
 	if !ok {
-		return nil, fmt.Errorf("AuthUsername field missing or wrong type")
+		return nil, afterCreate("proxies.fo", "", fmt.Errorf("ID field missing or wrong type"))
 	}
 
-	pass, ok := data["AuthPassword"].(string)
+	user, ok := data["AuthUsername"].(string)
 	if !ok {
-		return nil, fmt.Errorf("AuthPassword field missing or wrong type")
+		return nil, afterCreate("proxies.fo", planID, fmt.Errorf("AuthUsername field missing or wrong type"))
 	}
 
-	planID, ok := data["ID"].(string)
+	pass, ok := data["AuthPassword"].(string)
 	if !ok {
-		return nil, fmt.Errorf("ID field missing or wrong type")
+		return nil, afterCreate("proxies.fo", planID, fmt.Errorf("AuthPassword field missing or wrong type"))
 	}
 
 	authPortFloat, ok := data["AuthPort"].(float64)
 	if !ok {
-		return nil, fmt.Errorf("AuthPort field missing or wrong type")
+		return nil, afterCreate("proxies.fo", planID, fmt.Errorf("AuthPort field missing or wrong type"))
 	}
 	authPort := int(authPortFloat)
 
 	expiresFloat, ok := data["EndsDate"].(float64)
 	if !ok {
-		return nil, fmt.Errorf("EndsDate field missing or wrong type")
+		return nil, afterCreate("proxies.fo", planID, fmt.Errorf("EndsDate field missing or wrong type"))
 	}
 	expires := int64(expiresFloat)
 
@@ -122,19 +117,12 @@ func CreateProxiesFOPlan(form url.Values) (*ProxyPlanInfo, error) {
 		authHostname = "pr-us.proxies.fo" // fallback to default
 	}
 
-	// Create proxies based on the actual hostname returned
-	var proxies []proxy.Entry
-
-	if authHostname == "dcp.proxies.fo" {
-		// Datacenter proxy - single endpoint
-		proxies = []proxy.Entry{
-			proxy.NewEntry(planID, user, pass, authHostname, 1339, "datacenter", authPort, expires),
-		}
-	} else {
-		// Residential/ISP - create both EU and USA
-		proxies = []proxy.Entry{
-			proxy.NewEntry(planID, user, pass, "pr-eu.proxies.fo", 1338, "eu", authPort, expires),
-			proxy.NewEntry(planID, user, pass, "pr-us.proxies.fo", 1337, "usa", authPort, expires),
+	proxies := ProxiesFOEntries(reseller, planID, user, pass, authHostname, authPort, expires)
+	if reseller == "datacenter" {
+		// Datacenter plans are sold by concurrent threads, enforced at our listener
+		threads, _ := strconv.Atoi(form.Get("threads"))
+		for i := range proxies {
+			proxies[i].Threads = threads
 		}
 	}
 
@@ -146,3 +134,141 @@ func CreateProxiesFOPlan(form url.Values) (*ProxyPlanInfo, error) {
 		Proxies:   proxies,
 	}, nil
 }
+
+// ExtendProxiesFOPlan buys days more of a datacenter plan and returns its new
+// end date, 0 if the response has none
+func ExtendProxiesFOPlan(planID string, days int) (int64, error) {
+	form := url.Values{"duration": {strconv.Itoa(days)}}
+	resp, err := clientFor("proxies.fo").Do(Request{
+		Method: "POST",
+		URL:    config.Get().ProxiesFOBaseURL + "/api/plans/" + url.PathEscape(planID) + "/extend",
+		Body:   []byte(form.Encode()),
+		Header: http.Header{
+			"X-Api-Auth":   {config.Get().APIKey},
+			"Content-Type": {"application/x-www-form-urlencoded"},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var envelope struct {
+		Success bool          `json:"Success"`
+		Error   string        `json:"Error"`
+		Data    ProxiesFOPlan `json:"Data"`
+	}
+	if err := json.Unmarshal(resp.Body, &envelope); err != nil {
+		return 0, newProviderError("proxies.fo", classifyStatus(resp.Status, ""), resp.Status, "invalid JSON response: %s", truncate(resp.Body))
+	}
+	if !envelope.Success {
+		if envelope.Error == "" {
+			envelope.Error = "Unknown error from Proxies.fo API"
+		}
+		return 0, newProviderError("proxies.fo", classifyStatus(resp.Status, envelope.Error), resp.Status, "%s", envelope.Error)
+	}
+	return envelope.Data.EndsDate, nil
+}
+
+// ProxiesFOEntries builds the local listener entries for a proxies.fo plan as
+// declared in the plan topology. The product is picked from the upstream
+// hostname the plan was issued on, and reseller when it is known.
+func ProxiesFOEntries(reseller, planID, user, pass, authHostname string, authPort int, expires int64) []proxy.Entry {
+	name := "proxiesfo/residential"
+	switch {
+	case authHostname == "dcp.proxies.fo":
+		name = "proxiesfo/datacenter"
+	case reseller == "isp":
+		name = "proxiesfo/isp"
+	}
+	product, ok := LookupProduct(name)
+	if !ok {
+		return nil
+	}
+	return BuildEntries(product, planID, user, pass, authPort, expires)
+}
+
+// ProxiesFOPlan is a reseller plan as returned by the proxies.fo plan listing
+type ProxiesFOPlan struct {
+	ID           string `json:"ID"`
+	AuthUsername string `json:"AuthUsername"`
+	AuthPassword string `json:"AuthPassword"`
+	AuthHostname string `json:"AuthHostname"`
+	AuthPort     int    `json:"AuthPort"`
+	EndsDate     int64  `json:"EndsDate"`
+	Status       string `json:"Status"`
+	Reseller     string `json:"Reseller"`
+}
+
+// Live reports whether the plan is active upstream and not past its end date
+func (p ProxiesFOPlan) Live(now int64) bool {
+	active := p.Status == "" || strings.EqualFold(p.Status, "active")
+	return active && (p.EndsDate == 0 || p.EndsDate > now)
+}
+
+// ResellerType maps the plan's reseller UUID back to the plan type it was
+// bought as through PROXIESFO_RESELLERS. Datacenter plans are also known by
+// their upstream host. ok is false when the plan type cannot be told.
+func (p ProxiesFOPlan) ResellerType() (string, bool) {
+	for name, id := range config.Get().ProxiesFOResellers {
+		if p.Reseller != "" && id == p.Reseller {
+			return name, true
+		}
+	}
+	if p.AuthHostname == "dcp.proxies.fo" {
+		return "datacenter", true
+	}
+	return "", false
+}
+
+// ListProxiesFOPlans fetches every plan on the reseller account
+func ListProxiesFOPlans() ([]ProxiesFOPlan, error) {
+	var plans []ProxiesFOPlan
+	if err := proxiesFOGet("/api/plans", &plans); err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// GetProxiesFOPlan fetches a single plan by ID
+func GetProxiesFOPlan(planID string) (*ProxiesFOPlan, error) {
+	var plan ProxiesFOPlan
+	if err := proxiesFOGet("/api/plans/"+url.PathEscape(planID), &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// proxiesFOGet performs an idempotent GET and decodes the Data field of the response envelope
+func proxiesFOGet(path string, out interface{}) error {
+	resp, err := clientFor("proxies.fo").Do(Request{
+		Method:     "GET",
+		URL:        config.Get().ProxiesFOBaseURL + path,
+		Header:     http.Header{"X-Api-Auth": {config.Get().APIKey}},
+		Idempotent: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	var envelope struct {
+		Success bool            `json:"Success"`
+		Error   string          `json:"Error"`
+		Data    json.RawMessage `json:"Data"`
+	}
+	if err := json.Unmarshal(resp.Body, &envelope); err != nil {
+		return newProviderError("proxies.fo", classifyStatus(resp.Status, ""), resp.Status, "invalid JSON response: %s", truncate(resp.Body))
+	}
+	if !envelope.Success {
+		if envelope.Error == "" {
+			envelope.Error = "Unknown error from Proxies.fo API"
+		}
+		return newProviderError("proxies.fo", classifyStatus(resp.Status, envelope.Error), resp.Status, "%s", envelope.Error)
+	}
+	if len(envelope.Data) == 0 {
+		return fmt.Errorf("unexpected response format: 'Data' field missing")
+	}
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return fmt.Errorf("unexpected 'Data' format from Proxies.fo API: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package providers
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/providers/fake"
+	"oceanproxy-api/proxy"
+)
+
+// useFakeProvider points the providers at srv and gives each test a fresh client
+func useFakeProvider(t *testing.T, srv *fake.Server) {
+	t.Helper()
+
+	old := *config.Get()
+	config.Set(func(s *config.Settings) {
+		s.ProxiesFOBaseURL = srv.URL
+		s.NettifyBaseURL = srv.URL
+		s.ProviderTimeout = 200 * time.Millisecond
+		s.ProviderMaxRetries = 0
+		s.APIKey = "test-api-key"
+		s.NettifyAPIKey = "test-nettify-key"
+	})
+
+	clientsMu.Lock()
+	clients = make(map[string]*Client)
+	clientsMu.Unlock()
+
+	t.Cleanup(func() {
+		srv.Close()
+		config.Set(func(s *config.Settings) { *s = old })
+	})
+}
+
+func TestCreateProxiesFOPlan(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       fake.Mode
+		form       url.Values
+		wantErr    error
+		wantAnyErr bool
+		wantSubs   []string
+		threads    int // expected listener cap
+	}{
+		{
+			name:     "residential creates eu and usa",
+			mode:     fake.Success,
+			form:     url.Values{"reseller": {"residential"}, "bandwidth": {"2"}},
+			wantSubs: []string{"eu", "usa"},
+		},
+		{
+			name:     "datacenter creates single endpoint",
+			mode:     fake.Success,
+			form:     url.Values{"reseller": {"datacenter"}},
+			wantSubs: []string{"datacenter"},
+			threads:  500,
+		},
+		{
+			name:    "unknown reseller is rejected locally",
+			mode:    fake.Success,
+			form:    url.Values{"reseller": {"mobile"}},
+			wantErr: ErrValidation,
+		},
+		{
+			name:    "error envelope about balance is a quota error",
+			mode:    fake.ErrorEnvelope,
+			form:    url.Values{"reseller": {"residential"}},
+			wantErr: ErrQuota,
+		},
+		{
+			name:       "missing fields",
+			mode:       fake.MissingFields,
+			form:       url.Values{"reseller": {"residential"}},
+			wantAnyErr: true,
+		},
+		{
+			name:    "unauthorized",
+			mode:    fake.Unauthorized,
+			form:    url.Values{"reseller": {"isp"}},
+			wantErr: ErrAuth,
+		},
+		{
+			name:    "server error",
+			mode:    fake.ServerError,
+			form:    url.Values{"reseller": {"isp"}},
+			wantErr: ErrUpstreamDown,
+		},
+		{
+			name:    "slow response times out",
+			mode:    fake.Slow,
+			form:    url.Values{"reseller": {"residential"}},
+			wantErr: ErrUpstreamDown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fake.ProxiesFO(tt.mode)
+			useFakeProvider(t, srv)
+
+			reseller := tt.form.Get("reseller")
+			info, err := CreateProxiesFOPlan(tt.form)
+
+			if tt.wantErr != nil || tt.wantAnyErr {
+				if err == nil {
+					t.Fatalf("expected error, got plan %+v", info)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				if tt.mode == fake.MissingFields && (errors.Is(err, ErrNotSent) || !strings.Contains(err.Error(), "was created")) {
+					t.Errorf("a bought plan with missing fields is not marked created: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if info.PlanID != "fo-plan-1" || info.Username != "fouser" || info.Password != "fopass" {
+				t.Errorf("unexpected plan info: %+v", info)
+			}
+			if len(info.Proxies) != len(tt.wantSubs) {
+				t.Fatalf("expected %d proxies, got %d", len(tt.wantSubs), len(info.Proxies))
+			}
+			for i, sub := range tt.wantSubs {
+				if info.Proxies[i].Subdomain != sub {
+					t.Errorf("proxy %d: expected subdomain %s, got %s", i, sub, info.Proxies[i].Subdomain)
+				}
+				if info.Proxies[i].Threads != tt.threads {
+					t.Errorf("proxy %d: expected %d threads, got %d", i, tt.threads, info.Proxies[i].Threads)
+				}
+			}
+
+			reqs := srv.Requests()
+			if len(reqs) != 1 {
+				t.Fatalf("expected 1 request, got %d", len(reqs))
+			}
+			if got := reqs[0].Header.Get("X-Api-Auth"); got != "test-api-key" {
+				t.Errorf("expected X-Api-Auth header, got %q", got)
+			}
+			if got := reqs[0].Form.Get("reseller"); got == "" || got == reseller {
+				t.Errorf("reseller type was not translated to a reseller ID")
+			}
+		})
+	}
+}
+
+// TestThreadsReachMaxConn follows purchased threads from the plan form to the
+// MAX_CONN the listener is spawned with
+func TestThreadsReachMaxConn(t *testing.T) {
+	srv := fake.ProxiesFO(fake.Success)
+	useFakeProvider(t, srv)
+
+	dir := t.TempDir()
+	script := "#!/bin/bash\necho \"$MAX_CONN\" > \"" + filepath.Join(dir, "max_conn") + "\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "create_proxy_plan.sh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldScripts := config.ScriptsDir
+	config.ScriptsDir = dir
+	defer func() { config.ScriptsDir = oldScripts }()
+
+	info, err := CreateProxiesFOPlan(url.Values{"reseller": {"datacenter"}, "threads": {"50"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := info.Proxies[0]
+	if err := proxy.SpawnLocal(e, proxy.Parent{Host: e.AuthHost, Port: e.AuthPort}, ""); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "max_conn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(got)) != "50" {
+		t.Errorf("MAX_CONN = %q, want 50", got)
+	}
+}
+
+func TestListProxiesFOPlans(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       fake.Mode
+		wantErr    error
+		wantAnyErr bool
+		wantPlans  int
+		wantLive   int
+	}{
+		{name: "lists plans", mode: fake.Success, wantPlans: 3, wantLive: 2},
+		{name: "missing data", mode: fake.MissingFields, wantAnyErr: true},
+		{name: "error envelope", mode: fake.ErrorEnvelope, wantErr: ErrQuota},
+		{name: "unauthorized", mode: fake.Unauthorized, wantErr: ErrAuth},
+		{name: "slow response times out", mode: fake.Slow, wantErr: ErrUpstreamDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeProvider(t, fake.ProxiesFO(tt.mode))
+
+			plans, err := ListProxiesFOPlans()
+			if tt.wantErr != nil || tt.wantAnyErr {
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(plans) != tt.wantPlans {
+				t.Fatalf("expected %d plans, got %d", tt.wantPlans, len(plans))
+			}
+			live := 0
+			for _, p := range plans {
+				if p.Live(time.Now().Unix()) {
+					live++
+				}
+			}
+			if live != tt.wantLive {
+				t.Errorf("expected %d live plans, got %d", tt.wantLive, live)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"oceanproxy-api/proxy"
+)
+
+// Endpoint is one regional listener a plan must have
+type Endpoint struct {
+	Subdomain    string `json:"subdomain"`
+	UpstreamHost string `json:"upstream_host"`
+	PublicPort   int    `json:"public_port"`
+	AuthPort     int    `json:"auth_port,omitempty"`  // 0 uses the port the provider issued for the plan
+	PortRange    [2]int `json:"port_range,omitempty"` // local port range for subdomains the port manager does not know yet
+}
+
+// Product declares which endpoints every plan of a provider product is served on
+type Product struct {
+	Name      string     `json:"name"` // provider/product, e.g. proxiesfo/residential
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+var (
+	topologyMu sync.RWMutex
+	products   = defaultProducts()
+)
+
+func defaultProducts() []Product {
+	residential := []Endpoint{
+		{Subdomain: "eu", UpstreamHost: "pr-eu.proxies.fo", PublicPort: 1338},
+		{Subdomain: "usa", UpstreamHost: "pr-us.proxies.fo", PublicPort: 1337},
+	}
+	return []Product{
+		{Name: "proxiesfo/residential", Endpoints: residential},
+		{Name: "proxiesfo/isp", Endpoints: residential},
+		{Name: "proxiesfo/datacenter", Endpoints: []Endpoint{
+			{Subdomain: "datacenter", UpstreamHost: "dcp.proxies.fo", PublicPort: 1339},
+		}},
+		{Name: "nettify/residential", Endpoints: []Endpoint{
+			{Subdomain: "alpha", UpstreamHost: "proxy.nettify.xyz", PublicPort: 9876, AuthPort: 8080},
+		}},
+		{Name: "nettify/datacenter", Endpoints: []Endpoint{
+			{Subdomain: "beta", UpstreamHost: "proxy.nettify.xyz", PublicPort: 8080, AuthPort: 8765},
+		}},
+		{Name: "nettify/mobile", Endpoints: []Endpoint{
+			{Subdomain: "mobile", UpstreamHost: "proxy.nettify.xyz", PublicPort: 8080, AuthPort: 7654},
+		}},
+		{Name: "nettify/unlimited", Endpoints: []Endpoint{
+			{Subdomain: "unlim", UpstreamHost: "proxy.nettify.xyz", PublicPort: 8080, AuthPort: 6543},
+		}},
+	}
+}
+
+// LoadTopology replaces the built-in product topology with the products in a
+// JSON file. Products not listed in the file keep their defaults, and an empty
+// path restores the defaults.
+func LoadTopology(path string) error {
+	if path == "" {
+		topologyMu.Lock()
+		products = defaultProducts()
+		topologyMu.Unlock()
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var loaded []Product
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("invalid topology file %s: %w", path, err)
+	}
+
+	merged := defaultProducts()
+	for _, p := range loaded {
+		if p.Name == "" || len(p.Endpoints) == 0 {
+			return fmt.Errorf("invalid topology file %s: every product needs a name and endpoints", path)
+		}
+		for _, ep := range p.Endpoints {
+			if ep.Subdomain == "" || ep.UpstreamHost == "" || ep.PublicPort == 0 {
+				return fmt.Errorf("invalid topology file %s: %s endpoint needs subdomain, upstream_host and public_port", path, p.Name)
+			}
+			if ep.PortRange[0] != 0 {
+				proxy.RegisterPortRange(ep.Subdomain, ep.PortRange[0], ep.PortRange[1])
+			}
+		}
+
+		replaced := false
+		for i := range merged {
+			if merged[i].Name == p.Name {
+				merged[i] = p
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, p)
+		}
+	}
+
+	topologyMu.Lock()
+	products = merged
+	topologyMu.Unlock()
+
+	log.Printf("🗺️ Loaded plan topology from %s (%d products)", path, len(merged))
+	return nil
+}
+
+// LookupProduct returns the declared product by name
+func LookupProduct(name string) (Product, bool) {
+	topologyMu.RLock()
+	defer topologyMu.RUnlock()
+	for _, p := range products {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Product{}, false
+}
+
+// PublicPort returns the public port of a region's listeners
+func PublicPort(subdomain string) (int, bool) {
+	topologyMu.RLock()
+	defer topologyMu.RUnlock()
+	for _, p := range products {
+		for _, ep := range p.Endpoints {
+			if ep.Subdomain == subdomain {
+				return ep.PublicPort, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// ProductFor returns the product an existing entry belongs to. Entries created
+// before products were recorded are matched on subdomain and upstream host.
+func ProductFor(e proxy.Entry) (Product, bool) {
+	if e.Product != "" {
+		if p, ok := LookupProduct(e.Product); ok {
+			return p, true
+		}
+	}
+
+	topologyMu.RLock()
+	defer topologyMu.RUnlock()
+	for _, p := range products {
+		for _, ep := range p.Endpoints {
+			if ep.Subdomain == e.Subdomain && ep.UpstreamHost == e.AuthHost {
+				return p, true
+			}
+		}
+	}
+	return Product{}, false
+}
+
+// BuildEntries creates one entry per declared endpoint of product
+func BuildEntries(product Product, planID, user, pass string, authPort int, expires int64) []proxy.Entry {
+	var entries []proxy.Entry
+	for _, ep := range product.Endpoints {
+		entries = append(entries, BuildEntry(product, ep, planID, user, pass, authPort, expires))
+	}
+	return entries
+}
+
+// BuildEntry creates the entry for a single endpoint of product
+func BuildEntry(product Product, ep Endpoint, planID, user, pass string, authPort int, expires int64) proxy.Entry {
+	if ep.AuthPort != 0 {
+		authPort = ep.AuthPort
+	}
+	e := proxy.NewEntry(planID, user, pass, ep.UpstreamHost, ep.PublicPort, ep.Subdomain, authPort, expires)
+	e.Product = product.Name
+	return e
+}
+
+// PlanEndpoint creates the entry for endpoint ep of the plan that from belongs
+// to. Credentials, expiry, limits, threads, TLS, customer and billing are
+// copied from from; only the endpoint's own fields are new. Node is left
+// empty for the caller to place it.
+func PlanEndpoint(product Product, ep Endpoint, from proxy.Entry) proxy.Entry {
+	built := BuildEntry(product, ep, from.PlanID, from.Username, from.Password, from.AuthPort, from.ExpiresAt)
+	e := from
+	e.AuthHost = built.AuthHost
+	e.LocalHost = built.LocalHost
+	e.AuthPort = built.AuthPort
+	e.LocalPort = built.LocalPort
+	e.PublicPort = built.PublicPort
+	e.Subdomain = built.Subdomain
+	e.Product = built.Product
+	e.CreatedAt = built.CreatedAt
+	e.Node = ""
+	return e
+}
+
+// MissingEndpoints returns the endpoints of product that have no entry in have
+func MissingEndpoints(product Product, have []proxy.Entry) []Endpoint {
+	present := make(map[string]bool)
+	for _, e := range have {
+		present[e.Subdomain] = true
+	}
+	var missing []Endpoint
+	for _, ep := range product.Endpoints {
+		if !present[ep.Subdomain] {
+			missing = append(missing, ep)
+		}
+	}
+	return missing
+}
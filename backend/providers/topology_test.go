@@ -0,0 +1,43 @@
+package providers
+
+import (
+	"testing"
+
+	"oceanproxy-api/proxy"
+)
+
+func TestPlanEndpoint(t *testing.T) {
+	product, ok := LookupProduct("proxiesfo/residential")
+	if !ok {
+		t.Fatal("proxiesfo/residential is not declared")
+	}
+
+	from := BuildEntry(product, product.Endpoints[0], "plan1", "u", "p", 10000, 1900000000)
+	from.Node = "edge-1"
+	from.RateLimits = proxy.RateLimits{UpBytesPerSec: 1000, DownBytesPerSec: 2000, ConnsPerSec: 5}
+	from.Threads = 700
+	from.TLS = true
+	from.Customer = "tg:42"
+	from.QuotaBytes = 1 << 30
+	from.Order = "ord1"
+	from.CostCents = 180
+	from.RevenueCents = 350
+	from.IdempotencyKey = "k1"
+
+	ep := product.Endpoints[1]
+	e := PlanEndpoint(product, ep, from)
+
+	if e.Subdomain != ep.Subdomain || e.AuthHost != ep.UpstreamHost || e.PublicPort != ep.PublicPort || e.Node != "" {
+		t.Errorf("endpoint fields %+v", e)
+	}
+	if e.LocalPort == from.LocalPort {
+		t.Errorf("new endpoint reuses port %d", e.LocalPort)
+	}
+
+	// Everything that belongs to the plan rather than the endpoint is kept
+	e.Subdomain, e.AuthHost, e.LocalHost, e.AuthPort, e.LocalPort, e.PublicPort, e.CreatedAt, e.Node =
+		from.Subdomain, from.AuthHost, from.LocalHost, from.AuthPort, from.LocalPort, from.PublicPort, from.CreatedAt, from.Node
+	if e != from {
+		t.Errorf("per-plan fields lost:\n got %+v\nwant %+v", e, from)
+	}
+}
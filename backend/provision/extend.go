@@ -0,0 +1,60 @@
+package provision
+
+import (
+	"fmt"
+	"log"
+
+	"oceanproxy-api/ledger"
+	"oceanproxy-api/providers"
+)
+
+// ExtendRequest is more time bought for an existing plan
+type ExtendRequest struct {
+	PlanID       string
+	Product      string // catalog product of the plan, sold by days or hours
+	Quantity     int    // whole units to buy
+	Unit         string // days or hours, for the ledger memo
+	Customer     string
+	RevenueCents int64 // what the customer pays for the added time
+	FromBalance  bool  // charge RevenueCents to the customer's prepaid balance
+}
+
+// Extend buys the added time from the plan's provider. Like Plan, a request
+// paid from the balance is charged before the provider is called and the
+// charge is reversed if the provider call fails. It returns the charge, zero
+// without FromBalance, and the provider's new end date, 0 if unknown.
+func Extend(req ExtendRequest) (ledger.Transaction, int64, error) {
+	var charge ledger.Transaction
+	if !extendable[req.Product] {
+		return charge, 0, fmt.Errorf("%w: %s plans cannot be extended with the provider", providers.ErrValidation, req.Product)
+	}
+	if req.FromBalance {
+		var err error
+		memo := fmt.Sprintf("extend plan %s by %d %s", req.PlanID, req.Quantity, req.Unit)
+		if charge, err = ledger.PostCharge(req.Customer, req.RevenueCents, req.PlanID, memo); err != nil {
+			return charge, 0, err
+		}
+	}
+
+	endsAt, err := extend(req)
+	if err != nil && charge.ID != "" {
+		if _, rerr := ledger.PostReversal(charge.ID, err.Error()); rerr != nil {
+			log.Printf("❌ Failed to reverse charge %s of %s: %v", charge.ID, req.Customer, rerr)
+		} else {
+			log.Printf("↩️ Reversed charge %s of %s: %v", charge.ID, req.Customer, err)
+		}
+		return ledger.Transaction{}, 0, err
+	}
+	return charge, endsAt, err
+}
+
+// Products whose provider sells more time of an existing plan
+var extendable = map[string]bool{"proxiesfo/datacenter": true, "nettify/unlimited": true}
+
+// extend calls the provider of the plan's product
+func extend(req ExtendRequest) (int64, error) {
+	if req.Product == "nettify/unlimited" {
+		return 0, providers.ExtendNettifyPlan(req.PlanID, req.Quantity)
+	}
+	return providers.ExtendProxiesFOPlan(req.PlanID, req.Quantity)
+}
@@ -0,0 +1,84 @@
+package provision
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/ledger"
+	"oceanproxy-api/providers"
+	"oceanproxy-api/providers/fake"
+)
+
+func TestExtendFromBalance(t *testing.T) {
+	config.DataDir = t.TempDir()
+	srv := fake.ProxiesFO(fake.ServerError)
+	defer srv.Close()
+	old := *config.Get()
+	config.Set(func(s *config.Settings) {
+		s.ProxiesFOBaseURL = srv.URL
+		s.APIKey = "test-api-key"
+		s.ProviderTimeout = time.Second
+		s.ProviderMaxRetries = 0
+		s.Currency = "USD"
+	})
+	defer config.Set(func(s *config.Settings) { *s = old })
+	providers.ResetClients()
+
+	if _, err := ledger.PostDeposit("tg:42", 1000, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	req := ExtendRequest{
+		PlanID:       "fo-plan-1",
+		Product:      "proxiesfo/datacenter",
+		Quantity:     3,
+		Unit:         "days",
+		Customer:     "tg:42",
+		RevenueCents: 600,
+		FromBalance:  true,
+	}
+
+	// The provider fails: the charge is reversed
+	charge, _, err := Extend(req)
+	if !errors.Is(err, providers.ErrUpstreamDown) || charge.ID != "" {
+		t.Fatalf("failed extension: charge %+v, %v", charge, err)
+	}
+	if b, _ := ledger.Balance("tg:42"); b != 1000 {
+		t.Errorf("balance after a failed extension %d, want 1000", b)
+	}
+	stmt, err := ledger.StatementFor("tg:42", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := ""
+	for _, line := range stmt.Lines {
+		kinds += line.Kind + " "
+	}
+	if kinds != "deposit charge reversal " {
+		t.Errorf("transactions %q, want a charge and its reversal", kinds)
+	}
+
+	// The provider extends the plan: the charge stands
+	srv.SetMode(fake.Success)
+	charge, endsAt, err := Extend(req)
+	if err != nil || charge.AmountCents != -600 || charge.Reference != "fo-plan-1" || endsAt == 0 {
+		t.Fatalf("extension: charge %+v, ends %d, %v", charge, endsAt, err)
+	}
+	if b, _ := ledger.Balance("tg:42"); b != 400 {
+		t.Errorf("balance after an extension %d, want 400", b)
+	}
+	reqs := srv.Requests()
+	if last := reqs[len(reqs)-1]; last.Path != "/api/plans/fo-plan-1/extend" || last.Form.Get("duration") != "3" {
+		t.Errorf("provider call %s %v", last.Path, last.Form)
+	}
+
+	// Products without a provider extension are refused before charging
+	req.Product = "proxiesfo/residential"
+	if _, _, err := Extend(req); !errors.Is(err, providers.ErrValidation) {
+		t.Errorf("bandwidth product: %v", err)
+	}
+	if b, _ := ledger.Balance("tg:42"); b != 400 {
+		t.Errorf("balance after a refused extension %d, want 400", b)
+	}
+}
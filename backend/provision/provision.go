@@ -0,0 +1,154 @@
+// Package provision buys a plan from its provider and brings up its
+// listeners. It is shared by the create endpoints and order fulfilment.
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+
+	"oceanproxy-api/events"
+	"oceanproxy-api/ledger"
+	"oceanproxy-api/nodes"
+	"oceanproxy-api/providers"
+	"oceanproxy-api/proxy"
+)
+
+// Providers a plan can be bought from
+const (
+	ProxiesFO = "proxiesfo"
+	Nettify   = "nettify"
+)
+
+// ErrUnavailable wraps failures to find a node for the plan, before buying
+// (nothing was spent) or after (the plan was bought but is not served)
+var ErrUnavailable = errors.New("no capacity to serve the plan")
+
+// Request is one plan to buy
+type Request struct {
+	Provider       string
+	Form           url.Values // the provider's create form: reseller or plan_type, bandwidth, ...
+	Customer       string
+	IdempotencyKey string
+	Order          string // order being fulfilled, empty for direct creates
+	RateLimits     proxy.RateLimits
+	TLS            bool
+	Source         string // plan.created source, e.g. api or order
+	CostCents      int64  // what the plan costs us, from the catalog
+	RevenueCents   int64  // what the customer pays for it
+	Product        string // catalog product, for the ledger memo
+	FromBalance    bool   // charge RevenueCents to the customer's prepaid balance
+}
+
+// Result is a bought plan with the entries that were started
+type Result struct {
+	PlanID    string
+	Username  string
+	Password  string
+	ExpiresAt int64
+	Entries   []proxy.Entry
+}
+
+// Plan buys the plan, places it, spawns and logs its listeners and emits
+// plan.created. Provider failures are returned as typed provider errors.
+// When a listener fails to spawn the entries started before it are kept and
+// returned with the error.
+//
+// A plan bought from the customer's balance is charged before the provider
+// is called, so two plans cannot spend the same credit, and the charge is
+// reversed unless at least one listener came up.
+func Plan(req Request) (*Result, error) {
+	if err := nodes.Ready(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if !req.FromBalance {
+		return buy(req)
+	}
+
+	charge, err := ledger.PostCharge(req.Customer, req.RevenueCents, "", "plan "+req.Product)
+	if err != nil {
+		return nil, err
+	}
+	res, err := buy(req)
+	if res == nil || len(res.Entries) == 0 {
+		reason := "provisioning failed"
+		if err != nil {
+			reason = err.Error()
+		}
+		if _, rerr := ledger.PostReversal(charge.ID, reason); rerr != nil {
+			log.Printf("❌ Failed to reverse charge %s of %s: %v", charge.ID, req.Customer, rerr)
+		} else {
+			log.Printf("↩️ Reversed charge %s of %s: %s", charge.ID, req.Customer, reason)
+		}
+		return res, err
+	}
+	if lerr := ledger.Link(charge.ID, res.PlanID); lerr != nil {
+		log.Printf("⚠️ Failed to link charge %s to plan %s: %v", charge.ID, res.PlanID, lerr)
+	}
+	return res, err
+}
+
+// buy calls the provider and brings up the plan
+func buy(req Request) (*Result, error) {
+	var res Result
+	switch req.Provider {
+	case ProxiesFO:
+		info, err := providers.CreateProxiesFOPlan(req.Form)
+		if err != nil {
+			return nil, err
+		}
+		res = Result{PlanID: info.PlanID, Username: info.Username, Password: info.Password, ExpiresAt: info.ExpiresAt, Entries: info.Proxies}
+	case Nettify:
+		info, err := providers.CreateNettifyPlan(req.Form)
+		if err != nil {
+			return nil, err
+		}
+		res = Result{PlanID: info.PlanID, Username: info.Username, Password: info.Password, ExpiresAt: info.ExpiresAt, Entries: info.Proxies}
+	default:
+		return nil, fmt.Errorf("unknown provider %q", req.Provider)
+	}
+
+	planned := res.Entries
+	res.Entries = nil
+	if err := nodes.Place(planned); err != nil {
+		log.Printf("❌ Plan %s was bought but could not be placed: %v", res.PlanID, err)
+		return &res, fmt.Errorf("%w: plan %s was bought but could not be placed: %v", ErrUnavailable, res.PlanID, err)
+	}
+
+	var spawnErr error
+	for _, p := range planned {
+		p.IdempotencyKey = req.IdempotencyKey
+		p.Order = req.Order
+		p.CostCents = req.CostCents
+		p.RevenueCents = req.RevenueCents
+		p.Customer = req.Customer
+		p.RateLimits = req.RateLimits
+		p.TLS = req.TLS
+		if err := proxy.Spawn3proxy(p); err != nil {
+			spawnErr = fmt.Errorf("failed to spawn proxy: %w", err)
+			break
+		}
+		if err := proxy.LogProxy(p); err != nil {
+			// A listener missing from the log would never be stopped or restored
+			_ = proxy.StopListener(p)
+			proxy.ReleaseEntryPort(p)
+			spawnErr = fmt.Errorf("failed to record proxy: %w", err)
+			break
+		}
+		res.Entries = append(res.Entries, p)
+
+		// Update nginx upstreams after proxy is created and logged
+		proxy.UpdateNginxUpstreams()
+	}
+
+	if len(res.Entries) > 0 {
+		data := events.PlanData(res.Entries)
+		data["source"] = req.Source
+		if req.Order != "" {
+			data["order_id"] = req.Order
+		}
+		events.Emit(events.PlanCreated, data)
+	}
+	return &res, spawnErr
+}
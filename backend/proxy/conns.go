@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ListenerConnections is the current load of one listener against its cap
+type ListenerConnections struct {
+	PlanID      string `json:"plan_id"`
+	Username    string `json:"username"`
+	Subdomain   string `json:"subdomain"`
+	LocalPort   int    `json:"local_port"`
+	Connections int    `json:"connections"`
+	MaxConn     int    `json:"max_conn"`
+	AtLimit     bool   `json:"at_limit"`
+}
+
+// Established counts established TCP connections by local port, from
+// /proc/net/tcp and tcp6. It returns an empty map where those are unavailable.
+func Established() map[int]int {
+	counts := make(map[int]int)
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		countEstablished(f, counts)
+		f.Close()
+	}
+	return counts
+}
+
+// countEstablished reads the /proc/net/tcp format: local_address is hex
+// ip:port in field 2 and st is the state in field 4, 01 for ESTABLISHED
+func countEstablished(r io.Reader, counts map[int]int) {
+	sc := bufio.NewScanner(r)
+	sc.Scan() // header
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 4 || f[3] != "01" {
+			continue
+		}
+		i := strings.LastIndex(f[1], ":")
+		if i < 0 {
+			continue
+		}
+		if port, err := strconv.ParseInt(f[1][i+1:], 16, 32); err == nil {
+			counts[int(port)]++
+		}
+	}
+}
+
+// Concurrency reports the connections on each active listener in entries
+func Concurrency(entries []Entry, now int64) []ListenerConnections {
+	established := Established()
+	var out []ListenerConnections
+	for _, e := range entries {
+		if e.ExpiresAt != 0 && e.ExpiresAt < now {
+			continue
+		}
+		n := established[e.LocalPort]
+		out = append(out, ListenerConnections{
+			PlanID:      e.PlanID,
+			Username:    e.Username,
+			Subdomain:   e.Subdomain,
+			LocalPort:   e.LocalPort,
+			Connections: n,
+			MaxConn:     e.MaxConn(),
+			AtLimit:     n >= e.MaxConn(),
+		})
+	}
+	return out
+}
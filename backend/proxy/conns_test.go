@@ -0,0 +1,22 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCountEstablished(t *testing.T) {
+	// Two clients on listener 10000 (0x2710), one in TIME_WAIT, one on 22000 (0x55F0)
+	table := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:2710 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:2710 0100007F:D2A4 01 00000000:00000000 00:00000000 00000000     0        0 2 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:2710 0100007F:D2A6 01 00000000:00000000 00:00000000 00000000     0        0 3 1 0000000000000000 20 4 30 10 -1
+   3: 0100007F:2710 0100007F:D2A8 06 00000000:00000000 00:00000000 00000000     0        0 0 1 0000000000000000 20 4 30 10 -1
+   4: 0100007F:55F0 0100007F:D2AA 01 00000000:00000000 00:00000000 00000000     0        0 4 1 0000000000000000 20 4 30 10 -1
+`
+	counts := make(map[int]int)
+	countEstablished(strings.NewReader(table), counts)
+	if counts[10000] != 2 || counts[22000] != 1 || len(counts) != 2 {
+		t.Errorf("counts = %v, want 10000:2 22000:1", counts)
+	}
+}
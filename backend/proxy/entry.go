@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"oceanproxy-api/config"
@@ -19,6 +20,52 @@ type Entry struct {
 	Subdomain  string `json:"subdomain"`
 	ExpiresAt  int64  `json:"expires_at"`
 	CreatedAt  int64  `json:"created_at"`
+
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	QuotaBytes     int64  `json:"quota_bytes,omitempty"`   // purchased bandwidth, 0 if unmetered or unknown
+	Product        string `json:"product,omitempty"`       // provider/product from the plan topology
+	Customer       string `json:"customer,omitempty"`      // reseller-side customer reference given at creation
+	Threads        int    `json:"threads,omitempty"`       // purchased concurrent connections, 0 for DEFAULT_MAX_CONN
+	TLS            bool   `json:"tls,omitempty"`           // hand out https:// URLs on the region's TLS endpoint
+	Node           string `json:"node,omitempty"`          // edge node running the listener, empty for this host
+	Order          string `json:"order,omitempty"`         // order the plan was bought for
+	CostCents      int64  `json:"cost_cents,omitempty"`    // plan's provider cost, repeated on each of its entries
+	RevenueCents   int64  `json:"revenue_cents,omitempty"` // plan's sell price, repeated on each of its entries
+	ExtendedAt     int64  `json:"extended_at,omitempty"`   // last local extension via /plans/{id}/extend
+
+	// Throughput limits enforced by the listener, 0 for unlimited
+	RateLimits
+}
+
+// RateLimits are token-bucket limits on a listener's traffic
+type RateLimits struct {
+	UpBytesPerSec   int64 `json:"up_bytes_per_sec,omitempty"`   // client to target
+	DownBytesPerSec int64 `json:"down_bytes_per_sec,omitempty"` // target to client
+	ConnsPerSec     int   `json:"conns_per_sec,omitempty"`      // new connections
+}
+
+// Directives renders the limits as 3proxy bandlimin/bandlimout/connlim lines.
+// 3proxy counts bits and refills the buckets continuously.
+func (l RateLimits) Directives() string {
+	var b strings.Builder
+	if l.DownBytesPerSec > 0 {
+		fmt.Fprintf(&b, "bandlimin %d *\n", l.DownBytesPerSec*8)
+	}
+	if l.UpBytesPerSec > 0 {
+		fmt.Fprintf(&b, "bandlimout %d *\n", l.UpBytesPerSec*8)
+	}
+	if l.ConnsPerSec > 0 {
+		fmt.Fprintf(&b, "connlim %d 1 *\n", l.ConnsPerSec)
+	}
+	return b.String()
+}
+
+// MaxConn is the concurrent connection cap of the entry's listener
+func (e Entry) MaxConn() int {
+	if e.Threads > 0 {
+		return e.Threads
+	}
+	return config.Get().DefaultMaxConn
 }
 
 func NewEntry(planID, user, pass, upstreamHost string, publicPort int, subdomain string, authPort int, expires int64) Entry {
@@ -51,7 +98,7 @@ func NewEntry(planID, user, pass, upstreamHost string, publicPort int, subdomain
 		Username:   user,
 		Password:   pass,
 		AuthHost:   upstreamHost,
-		LocalHost:  fmt.Sprintf("%s.%s", subdomain, config.BaseDomain),
+		LocalHost:  fmt.Sprintf("%s.%s", subdomain, config.Get().BaseDomain),
 		AuthPort:   authPort,
 		LocalPort:  localPort,
 		PublicPort: publicPort,
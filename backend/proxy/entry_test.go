@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"testing"
+
+	"oceanproxy-api/config"
+)
+
+func TestRateLimitDirectives(t *testing.T) {
+	if got := (RateLimits{}).Directives(); got != "" {
+		t.Errorf("no limits rendered %q", got)
+	}
+
+	// 10 Mbit/s down, 1 Mbit/s up, 5 new connections per second
+	l := RateLimits{DownBytesPerSec: 1250000, UpBytesPerSec: 125000, ConnsPerSec: 5}
+	want := "bandlimin 10000000 *\nbandlimout 1000000 *\nconnlim 5 1 *\n"
+	if got := l.Directives(); got != want {
+		t.Errorf("Directives() = %q, want %q", got, want)
+	}
+}
+
+func TestMaxConn(t *testing.T) {
+	config.Set(func(s *config.Settings) { s.DefaultMaxConn = 2000 })
+	if got := (Entry{}).MaxConn(); got != 2000 {
+		t.Errorf("default MaxConn = %d, want 2000", got)
+	}
+	if got := (Entry{Threads: 500}).MaxConn(); got != 500 {
+		t.Errorf("MaxConn with threads = %d, want 500", got)
+	}
+}
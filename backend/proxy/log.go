@@ -3,24 +3,73 @@ package proxy
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"sync"
+
+	"oceanproxy-api/config"
 )
 
-const LogPath = "/var/log/oceanproxy/proxies.json"
+// storeMu serialises every change of the proxy log, so a writer never saves
+// over entries another one added since it read the log. Readers need no
+// lock: the log is replaced by rename, so they see it whole.
+var storeMu sync.Mutex
 
-func LogProxy(e Entry) error {
+// LoadProxyLog reads every entry from the proxy log. A missing log is not an error.
+func LoadProxyLog() ([]Entry, error) {
 	var entries []Entry
-	if data, err := os.ReadFile(LogPath); err == nil {
-		_ = json.Unmarshal(data, &entries)
+	data, err := os.ReadFile(config.ProxyLogPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// UpdateProxyLog changes the proxy log under the store lock: fn gets the
+// current entries and returns the entries to save, or an error to save
+// nothing. Keep fn short; slow work such as provider calls or respawns
+// belongs before or after it, so other writers are not held up.
+func UpdateProxyLog(fn func(entries []Entry) ([]Entry, error)) error {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	entries, err := LoadProxyLog()
+	if err != nil {
+		return err
 	}
-	entries = append(entries, e)
-	return SaveProxyLog(entries)
+	if entries, err = fn(entries); err != nil {
+		return err
+	}
+	return saveProxyLog(entries)
 }
 
-func SaveProxyLog(entries []Entry) error {
+// LogProxy adds entries to the proxy log
+func LogProxy(e ...Entry) error {
+	return UpdateProxyLog(func(entries []Entry) ([]Entry, error) {
+		return append(entries, e...), nil
+	})
+}
+
+// saveProxyLog writes the log through a temporary file; storeMu is held
+func saveProxyLog(entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
 	data, err := json.MarshalIndent(entries, "", "  ")
 	if err != nil {
 		return err
 	}
-	_ = os.MkdirAll("/var/log/oceanproxy", 0755)
-	return os.WriteFile(LogPath, data, 0644)
+	if err := os.MkdirAll(filepath.Dir(config.ProxyLogPath), 0755); err != nil {
+		return err
+	}
+	tmp := config.ProxyLogPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, config.ProxyLogPath)
 }
@@ -0,0 +1,17 @@
+package proxy
+
+import (
+	"log"
+	"os/exec"
+
+	"oceanproxy-api/config"
+)
+
+// UpdateNginxUpstreams regenerates the nginx stream upstreams from the proxy log
+func UpdateNginxUpstreams() {
+	if err := exec.Command(config.NginxUpdateScript).Run(); err != nil {
+		log.Printf("⚠️ Warning: Failed to update nginx upstreams: %v", err)
+	} else {
+		log.Printf("✅ nginx upstreams updated successfully")
+	}
+}
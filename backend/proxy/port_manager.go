@@ -6,13 +6,23 @@ import (
 	"os"
 	"os/exec"
 	"sync"
+
+	"oceanproxy-api/config"
 )
 
 var (
 	portMutex sync.Mutex
-	usedPorts = make(map[string]map[int]bool) // subdomain -> port -> used
+	usedPorts = make(map[string]map[int]bool) // portKey -> port -> used
 )
 
+// portKey separates the ports of each edge node; this host's use the subdomain
+func portKey(node, subdomain string) string {
+	if node == "" {
+		return subdomain
+	}
+	return node + "/" + subdomain
+}
+
 // Port ranges for each subdomain (2000 ports each)
 var portRanges = map[string]struct{ start, end int }{
 	"usa":        {10000, 11999},
@@ -29,13 +39,21 @@ var portRanges = map[string]struct{ start, end int }{
 	"eta":        {32000, 33999},
 }
 
+// RegisterPortRange adds or replaces the local port range for a subdomain,
+// e.g. for a region declared in the plan topology file
+func RegisterPortRange(subdomain string, start, end int) {
+	portMutex.Lock()
+	defer portMutex.Unlock()
+	portRanges[subdomain] = struct{ start, end int }{start, end}
+}
+
 // InitializePortManager loads used ports from the proxy log
 func InitializePortManager() error {
 	portMutex.Lock()
 	defer portMutex.Unlock()
 
 	// Read proxy log to populate used ports
-	data, err := os.ReadFile(LogPath)
+	data, err := os.ReadFile(config.ProxyLogPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil // No proxy log yet
@@ -50,10 +68,11 @@ func InitializePortManager() error {
 
 	// Populate used ports map
 	for _, entry := range entries {
-		if usedPorts[entry.Subdomain] == nil {
-			usedPorts[entry.Subdomain] = make(map[int]bool)
+		key := portKey(entry.Node, entry.Subdomain)
+		if usedPorts[key] == nil {
+			usedPorts[key] = make(map[int]bool)
 		}
-		usedPorts[entry.Subdomain][entry.LocalPort] = true
+		usedPorts[key][entry.LocalPort] = true
 	}
 
 	return nil
@@ -61,12 +80,19 @@ func InitializePortManager() error {
 
 // GetNextAvailablePort finds the next available port in the subdomain's range
 func GetNextAvailablePort(subdomain string) (int, error) {
+	return AllocatePort("", subdomain)
+}
+
+// AllocatePort finds the next available port in the subdomain's range on an
+// edge node, or on this host if node is empty
+func AllocatePort(node, subdomain string) (int, error) {
 	portMutex.Lock()
 	defer portMutex.Unlock()
 
 	// Initialize map if needed
-	if usedPorts[subdomain] == nil {
-		usedPorts[subdomain] = make(map[int]bool)
+	key := portKey(node, subdomain)
+	if usedPorts[key] == nil {
+		usedPorts[key] = make(map[int]bool)
 	}
 
 	// Get port range
@@ -75,10 +101,10 @@ func GetNextAvailablePort(subdomain string) (int, error) {
 		return 0, fmt.Errorf("unknown subdomain: %s", subdomain)
 	}
 
-	// Find next available port
+	// Find next available port. Ports on other nodes cannot be checked here.
 	for port := portRange.start; port <= portRange.end; port++ {
-		if !usedPorts[subdomain][port] && !isPortInUse(port) {
-			usedPorts[subdomain][port] = true
+		if !usedPorts[key][port] && (node != "" || !isPortInUse(port)) {
+			usedPorts[key][port] = true
 			return port, nil
 		}
 	}
@@ -104,6 +130,29 @@ func ReleasePort(subdomain string, port int) {
 	}
 }
 
+// ReleaseEntryPort marks the port of e as available on its node
+func ReleaseEntryPort(e Entry) {
+	portMutex.Lock()
+	defer portMutex.Unlock()
+
+	if used := usedPorts[portKey(e.Node, e.Subdomain)]; used != nil {
+		delete(used, e.LocalPort)
+	}
+}
+
+// FreePorts returns how many ports of the subdomain's range are unallocated
+// on a node, or -1 if the subdomain has no range
+func FreePorts(node, subdomain string) int {
+	portMutex.Lock()
+	defer portMutex.Unlock()
+
+	r, ok := portRanges[subdomain]
+	if !ok {
+		return -1
+	}
+	return r.end - r.start + 1 - len(usedPorts[portKey(node, subdomain)])
+}
+
 // GetPortUsage returns the current port usage for each subdomain
 func GetPortUsage() map[string]struct{ used, total int } {
 	portMutex.Lock()
@@ -7,43 +7,121 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/policy"
 )
 
+// Parent is the upstream proxy a listener chains to
+type Parent struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Remote runs listeners on edge nodes. A control plane sets it with SetRemote;
+// entries with a Node are then spawned and stopped through it.
+type Remote interface {
+	Spawn(e Entry, parent Parent, acl string) error
+	Stop(e Entry) error
+}
+
+var remote Remote
+
+// SetRemote installs the edge node client
+func SetRemote(r Remote) {
+	remote = r
+}
+
 func KillPort(port int) error {
 	cmd := exec.Command("bash", "-c", "lsof -ti tcp:"+strconv.Itoa(port)+" | xargs -r kill -9")
 	return cmd.Run()
 }
 
 func Spawn3proxy(e Entry) error {
-	// Get the executable path and construct script path relative to it
-	execPath, err := os.Executable()
+	return SpawnWithParent(e, e.AuthHost, e.AuthPort, e.Username, e.Password)
+}
+
+// SpawnWithParent starts the listener for e but chains it to the given parent
+// proxy instead of the entry's own upstream. Customer credentials are unchanged.
+// Listeners of entries on an edge node are started by that node.
+func SpawnWithParent(e Entry, host string, port int, user, pass string) error {
+	acl, err := policy.Render(e.PlanID)
 	if err != nil {
-		log.Printf("❌ Failed to get executable path: %v", err)
-		return fmt.Errorf("failed to get executable path: %v", err)
+		// Never start a listener without the destination policy
+		log.Printf("❌ Failed to load destination policy for PlanID=%s: %v", e.PlanID, err)
+		return err
 	}
+	parent := Parent{Host: host, Port: port, Username: user, Password: pass}
+	if e.Node != "" {
+		if remote == nil {
+			return fmt.Errorf("plan %s is on node %s but this host is not a control plane", e.PlanID, e.Node)
+		}
+		return remote.Spawn(e, parent, acl)
+	}
+	return SpawnLocal(e, parent, acl)
+}
 
-	// Get the directory containing the executable
-	execDir := filepath.Dir(execPath)
+// StopListener stops the listener of e, on this host or its edge node
+func StopListener(e Entry) error {
+	if e.Node != "" {
+		if remote == nil {
+			return fmt.Errorf("plan %s is on node %s but this host is not a control plane", e.PlanID, e.Node)
+		}
+		return remote.Stop(e)
+	}
+	return KillPort(e.LocalPort)
+}
 
-	// Go up one level from exec directory to backend directory, then into scripts
-	script := filepath.Join(filepath.Dir(execDir), "scripts", "create_proxy_plan.sh") // sanity check: does the script exist?
+// RemoveConfig deletes the 3proxy config create_proxy_plan.sh wrote for a
+// plan's listener on this host, so a deleted plan is not started again from it
+func RemoveConfig(planID, subdomain string) error {
+	if planID == "" || strings.ContainsAny(planID+subdomain, `/\`) {
+		return fmt.Errorf("invalid plan %q or subdomain %q", planID, subdomain)
+	}
+	err := os.Remove(filepath.Join(config.ProxyConfigDir, planID+"_"+subdomain+".cfg"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SpawnLocal runs create_proxy_plan.sh for e on this host with the given
+// parent and rendered destination policy
+func SpawnLocal(e Entry, parent Parent, acl string) error {
+	host, port := parent.Host, parent.Port
+	script := filepath.Join(config.ScriptsDir, "create_proxy_plan.sh") // sanity check: does the script exist?
 	if _, err := os.Stat(script); os.IsNotExist(err) {
 		log.Printf("❌ Spawn failed: script not found at %s", script)
 		return fmt.Errorf("script not found: %s", script)
 	}
 
 	log.Printf("🚀 Spawning proxy: PlanID=%s | Port=%d | Subdomain=%s | Upstream=%s:%d",
-		e.PlanID, e.LocalPort, e.Subdomain, e.AuthHost, e.AuthPort)
+		e.PlanID, e.LocalPort, e.Subdomain, host, port)
 
 	cmd := exec.Command("bash", script,
 		e.PlanID,
 		fmt.Sprintf("%d", e.LocalPort),
 		e.Username,
 		e.Password,
-		e.AuthHost,
-		fmt.Sprintf("%d", e.AuthPort),
+		host,
+		fmt.Sprintf("%d", port),
 		e.Subdomain,
+		parent.Username,
+		parent.Password,
+	)
+	cmd.Env = append(os.Environ(),
+		"ACCESS_LOG_DIR="+config.AccessLogDir,
+		"PROXY_CONFIG_DIR="+config.ProxyConfigDir,
+		"PROXY_ACL="+acl,
+		"MAX_CONN="+strconv.Itoa(e.MaxConn()),
+		"PROXY_LIMITS="+e.RateLimits.Directives(),
 	)
+	// Keep 3proxy out of the API's process group so signals aimed at the API
+	// (Ctrl-C, SIGTERM on restart) never reach running listeners
+	detach(cmd)
 
 	out, err := cmd.CombinedOutput()
 	if err != nil {
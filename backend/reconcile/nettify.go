@@ -0,0 +1,171 @@
+package reconcile
+
+import (
+	"fmt"
+	"log"
+
+	"oceanproxy-api/nodes"
+	"oceanproxy-api/providers"
+	"oceanproxy-api/proxy"
+)
+
+const nettifyHost = "proxy.nettify.xyz"
+
+func isNettifyEntry(e proxy.Entry) bool {
+	return e.AuthHost == nettifyHost
+}
+
+// Nettify compares RetreiveAllPlans against the local proxy log. With apply set
+// the fixes for the selected kinds (all kinds if empty) are carried out.
+func Nettify(apply bool, kinds map[string]bool) (*Report, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	plans, err := providers.RetreiveAllPlans()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy log: %w", err)
+	}
+
+	local := liveEntries(entries, isNettifyEntry)
+	report := newReport("nettify", apply, len(plans), len(local))
+	diffNettify(report, plans, entries, local)
+
+	if apply {
+		f := newFixes(isNettifyEntry)
+		applyNettify(report, f, entries, local, plans, kinds)
+		if err := f.save(); err != nil {
+			return report, fmt.Errorf("fixes applied but saving proxy log failed: %w", err)
+		}
+	}
+
+	lastReports[report.Provider] = report
+	return report, nil
+}
+
+// diffNettify adds the drift between plans and the live local entries to report
+func diffNettify(report *Report, plans []providers.NettifyPlan, entries []proxy.Entry, local map[string][]int) {
+	upstream := make(map[string]bool)
+	for _, plan := range plans {
+		upstream[plan.PlanID] = true
+		idx := local[plan.PlanID]
+		live := plan.Active && plan.Enabled
+
+		switch {
+		case live && len(idx) == 0:
+			report.add(Drift{
+				Kind:     MissingLocally,
+				PlanID:   plan.PlanID,
+				Username: plan.Username,
+				PlanType: plan.PlanType,
+				Upstream: "active",
+				Action:   "reset upstream password and spawn local listener",
+			})
+		case !live && len(idx) > 0:
+			report.add(Drift{
+				Kind:       DisabledUpstream,
+				PlanID:     plan.PlanID,
+				Username:   plan.Username,
+				PlanType:   plan.PlanType,
+				Subdomains: subdomainsOf(entries, idx),
+				Local:      "serving",
+				Upstream:   fmt.Sprintf("enabled=%t active=%t", plan.Enabled, plan.Active),
+				Action:     "stop local listener and mark entry expired",
+			})
+		case len(idx) > 0:
+			localQuota := entries[idx[0]].QuotaBytes
+			if localQuota != 0 && plan.MaxBytes != 0 && localQuota != plan.MaxBytes {
+				report.add(Drift{
+					Kind:       QuotaMismatch,
+					PlanID:     plan.PlanID,
+					Username:   plan.Username,
+					PlanType:   plan.PlanType,
+					Subdomains: subdomainsOf(entries, idx),
+					Local:      fmt.Sprintf("%d bytes", localQuota),
+					Upstream:   fmt.Sprintf("%d bytes (%d used)", plan.MaxBytes, plan.UsedBytes),
+					Action:     "update local quota to upstream max_bytes",
+				})
+			}
+		}
+	}
+
+	for planID, idx := range local {
+		if upstream[planID] {
+			continue
+		}
+		report.add(Drift{
+			Kind:       OrphanedLocally,
+			PlanID:     planID,
+			Username:   entries[idx[0]].Username,
+			Subdomains: subdomainsOf(entries, idx),
+			Local:      "serving",
+			Upstream:   "not found",
+			Action:     "stop local listener and mark entry expired",
+		})
+	}
+	report.sort()
+}
+
+func applyNettify(report *Report, f *fixes, entries []proxy.Entry, local map[string][]int, plans []providers.NettifyPlan, kinds map[string]bool) {
+	byID := make(map[string]providers.NettifyPlan)
+	for _, p := range plans {
+		byID[p.PlanID] = p
+	}
+
+	for i := range report.Drift {
+		d := &report.Drift[i]
+		if len(kinds) > 0 && !kinds[d.Kind] {
+			continue
+		}
+
+		switch d.Kind {
+		case MissingLocally:
+			plan := byID[d.PlanID]
+			newPass, err := providers.GeneratePassword(16)
+			if err != nil {
+				d.Error = err.Error()
+				continue
+			}
+			planEntries := providers.NettifyEntries(plan.PlanType, plan.PlanID, plan.Username, newPass, 0)
+			if err := nodes.Place(planEntries); err != nil {
+				d.Error = err.Error()
+				continue
+			}
+			if err := providers.SetNettifyPassword(plan.PlanID, newPass); err != nil {
+				d.Error = err.Error()
+				for _, e := range planEntries {
+					proxy.ReleaseEntryPort(e)
+				}
+				continue
+			}
+			var started []proxy.Entry
+			for _, e := range planEntries {
+				e.QuotaBytes = plan.MaxBytes
+				if err := proxy.Spawn3proxy(e); err != nil {
+					d.Error = err.Error()
+					proxy.ReleaseEntryPort(e)
+					continue
+				}
+				f.added = append(f.added, e)
+				started = append(started, e)
+				d.Subdomains = append(d.Subdomains, e.Subdomain)
+				log.Printf("✅ Reconciled missing Nettify plan %s on %s port %d", e.PlanID, e.Subdomain, e.LocalPort)
+			}
+			announce(started, "nettify")
+			d.Applied = d.Error == ""
+		case DisabledUpstream, OrphanedLocally:
+			if err := stopEntries(f, entries, d.PlanID, local[d.PlanID]); err != nil {
+				d.Error = err.Error()
+			}
+			d.Applied = d.Error == ""
+			log.Printf("🛑 Reconciled %s Nettify plan %s: listener stopped", d.Kind, d.PlanID)
+		case QuotaMismatch:
+			quota := byID[d.PlanID].MaxBytes
+			f.edit(d.PlanID, func(e *proxy.Entry) { e.QuotaBytes = quota })
+			d.Applied = true
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package reconcile
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"oceanproxy-api/nodes"
+	"oceanproxy-api/providers"
+	"oceanproxy-api/proxy"
+)
+
+func isProxiesFOEntry(e proxy.Entry) bool {
+	return strings.HasSuffix(e.AuthHost, ".proxies.fo")
+}
+
+// ProxiesFO compares the proxies.fo reseller plans against the local proxy log.
+// Plans created from the proxies.fo dashboard show up as missing_locally; applying
+// that kind imports them, as the product their reseller maps to. Plans whose
+// reseller is unknown are reported as product_mismatch instead. Status and
+// EndsDate differences are reported as disabled_upstream and expiry_mismatch.
+func ProxiesFO(apply bool, kinds map[string]bool) (*Report, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	plans, err := providers.ListProxiesFOPlans()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy log: %w", err)
+	}
+
+	local := liveEntries(entries, isProxiesFOEntry)
+	report := newReport("proxies.fo", apply, len(plans), len(local))
+	diffProxiesFO(report, plans, entries, local, time.Now().Unix())
+
+	if apply {
+		f := newFixes(isProxiesFOEntry)
+		applyProxiesFO(report, f, entries, local, plans, kinds)
+		if err := f.save(); err != nil {
+			return report, fmt.Errorf("fixes applied but saving proxy log failed: %w", err)
+		}
+	}
+
+	lastReports[report.Provider] = report
+	return report, nil
+}
+
+// extendedLocally reports whether e outlives plan because it was extended
+// with /plans/{id}/extend; the upstream end is not the expiry to restore then
+func extendedLocally(e proxy.Entry, plan providers.ProxiesFOPlan) bool {
+	return e.ExtendedAt != 0 && e.ExpiresAt > plan.EndsDate
+}
+
+// diffProxiesFO adds the drift between plans and the live local entries at now to report
+func diffProxiesFO(report *Report, plans []providers.ProxiesFOPlan, entries []proxy.Entry, local map[string][]int, now int64) {
+	upstream := make(map[string]bool)
+	for _, plan := range plans {
+		upstream[plan.ID] = true
+		idx := local[plan.ID]
+		live := plan.Live(now)
+
+		_, known := plan.ResellerType()
+
+		switch {
+		case live && len(idx) == 0 && !known:
+			report.add(Drift{
+				Kind:     ProductMismatch,
+				PlanID:   plan.ID,
+				Username: plan.AuthUsername,
+				PlanType: plan.AuthHostname,
+				Upstream: fmt.Sprintf("reseller=%s", plan.Reseller),
+				Action:   "map the reseller in PROXIESFO_RESELLERS, then import",
+			})
+		case live && len(idx) == 0:
+			report.add(Drift{
+				Kind:     MissingLocally,
+				PlanID:   plan.ID,
+				Username: plan.AuthUsername,
+				PlanType: plan.AuthHostname,
+				Upstream: fmt.Sprintf("status=%s ends=%d", plan.Status, plan.EndsDate),
+				Action:   "import plan and spawn local listeners",
+			})
+		case !live && len(idx) > 0:
+			report.add(Drift{
+				Kind:       DisabledUpstream,
+				PlanID:     plan.ID,
+				Username:   plan.AuthUsername,
+				PlanType:   plan.AuthHostname,
+				Subdomains: subdomainsOf(entries, idx),
+				Local:      "serving",
+				Upstream:   fmt.Sprintf("status=%s ends=%d", plan.Status, plan.EndsDate),
+				Action:     "stop local listener and mark entry expired",
+			})
+		case len(idx) > 0 && entries[idx[0]].ExpiresAt != plan.EndsDate && !extendedLocally(entries[idx[0]], plan):
+			report.add(Drift{
+				Kind:       ExpiryMismatch,
+				PlanID:     plan.ID,
+				Username:   plan.AuthUsername,
+				PlanType:   plan.AuthHostname,
+				Subdomains: subdomainsOf(entries, idx),
+				Local:      fmt.Sprintf("expires_at=%d", entries[idx[0]].ExpiresAt),
+				Upstream:   fmt.Sprintf("ends=%d", plan.EndsDate),
+				Action:     "update local expires_at to upstream EndsDate",
+			})
+		}
+	}
+
+	for planID, idx := range local {
+		if upstream[planID] {
+			continue
+		}
+		report.add(Drift{
+			Kind:       OrphanedLocally,
+			PlanID:     planID,
+			Username:   entries[idx[0]].Username,
+			Subdomains: subdomainsOf(entries, idx),
+			Local:      "serving",
+			Upstream:   "not found",
+			Action:     "stop local listener and mark entry expired",
+		})
+	}
+	report.sort()
+}
+
+func applyProxiesFO(report *Report, f *fixes, entries []proxy.Entry, local map[string][]int, plans []providers.ProxiesFOPlan, kinds map[string]bool) {
+	byID := make(map[string]providers.ProxiesFOPlan)
+	for _, p := range plans {
+		byID[p.ID] = p
+	}
+
+	for i := range report.Drift {
+		d := &report.Drift[i]
+		if len(kinds) > 0 && !kinds[d.Kind] {
+			continue
+		}
+
+		switch d.Kind {
+		case MissingLocally:
+			plan := byID[d.PlanID]
+			reseller, _ := plan.ResellerType()
+			planEntries := providers.ProxiesFOEntries(reseller, plan.ID, plan.AuthUsername, plan.AuthPassword, plan.AuthHostname, plan.AuthPort, plan.EndsDate)
+			if err := nodes.Place(planEntries); err != nil {
+				d.Error = err.Error()
+				continue
+			}
+			var started []proxy.Entry
+			for _, e := range planEntries {
+				if err := proxy.Spawn3proxy(e); err != nil {
+					d.Error = err.Error()
+					proxy.ReleaseEntryPort(e)
+					continue
+				}
+				f.added = append(f.added, e)
+				started = append(started, e)
+				d.Subdomains = append(d.Subdomains, e.Subdomain)
+				log.Printf("📥 Imported proxies.fo plan %s on %s port %d", e.PlanID, e.Subdomain, e.LocalPort)
+			}
+			announce(started, "proxiesfo")
+			d.Applied = d.Error == ""
+		case DisabledUpstream, OrphanedLocally:
+			if err := stopEntries(f, entries, d.PlanID, local[d.PlanID]); err != nil {
+				d.Error = err.Error()
+			}
+			d.Applied = d.Error == ""
+			log.Printf("🛑 Reconciled %s proxies.fo plan %s: listener stopped", d.Kind, d.PlanID)
+		case ExpiryMismatch:
+			ends := byID[d.PlanID].EndsDate
+			f.edit(d.PlanID, func(e *proxy.Entry) { e.ExpiresAt = ends })
+			d.Applied = true
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package reconcile
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/events"
+	"oceanproxy-api/jobs"
+	"oceanproxy-api/proxy"
+)
+
+// Drift kinds reported by the reconcilers
+const (
+	MissingLocally   = "missing_locally"   // active upstream plan with no local listener
+	OrphanedLocally  = "orphaned_locally"  // local listener for a plan the provider no longer has
+	DisabledUpstream = "disabled_upstream" // plan disabled or inactive upstream but still served locally
+	QuotaMismatch    = "quota_mismatch"    // local quota differs from the upstream quota
+	ExpiryMismatch   = "expiry_mismatch"   // local expires_at differs from the upstream end date
+	ProductMismatch  = "product_mismatch"  // upstream plan whose reseller maps to no product we serve
+)
+
+// Drift is one difference between a provider and the local store
+type Drift struct {
+	Kind       string   `json:"kind"`
+	PlanID     string   `json:"plan_id"`
+	Username   string   `json:"username,omitempty"`
+	PlanType   string   `json:"plan_type,omitempty"`
+	Subdomains []string `json:"subdomains,omitempty"`
+	Local      string   `json:"local,omitempty"`
+	Upstream   string   `json:"upstream,omitempty"`
+	Action     string   `json:"action"`
+	Applied    bool     `json:"applied"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Report is the result of one reconciliation run
+type Report struct {
+	Provider      string         `json:"provider"`
+	RanAt         time.Time      `json:"ran_at"`
+	DryRun        bool           `json:"dry_run"`
+	UpstreamPlans int            `json:"upstream_plans"`
+	LocalPlans    int            `json:"local_plans"`
+	Counts        map[string]int `json:"counts"`
+	Drift         []Drift        `json:"drift"`
+}
+
+var (
+	runMu       sync.Mutex // serialises runs so fixes never race each other
+	lastReports = make(map[string]*Report)
+	startOnce   sync.Once
+)
+
+// Start schedules dry-run reconciliations for each provider on its configured
+// interval and logs any drift. Fixes are never applied by the schedule. The
+// intervals are re-read after each run so a config reload can enable, disable
+// or retime a schedule.
+func Start() {
+	startOnce.Do(func() {
+		schedule("nettify", func() time.Duration {
+			if config.Get().NettifyAPIKey == "" {
+				return 0
+			}
+			return config.Get().NettifyReconcileInterval
+		}, func() (*Report, error) { return Nettify(false, nil) })
+		schedule("proxies.fo", func() time.Duration { return config.Get().ProxiesFOReconcileInterval },
+			func() (*Report, error) { return ProxiesFO(false, nil) })
+	})
+}
+
+// How often a disabled schedule checks whether it has been enabled
+const disabledRecheck = time.Minute
+
+func schedule(provider string, interval func() time.Duration, run func() (*Report, error)) {
+	jobs.Every("reconcile "+provider, func() time.Duration {
+		if d := interval(); d > 0 {
+			return d
+		}
+		return disabledRecheck
+	}, func() {
+		if interval() <= 0 {
+			return
+		}
+		report, err := run()
+		if err != nil {
+			log.Printf("⚠️ Scheduled %s reconciliation failed: %v", provider, err)
+		} else if len(report.Drift) > 0 {
+			log.Printf("🧮 %s drift detected: %v", provider, report.Counts)
+		}
+	})
+	if d := interval(); d > 0 {
+		log.Printf("🧮 %s reconciliation scheduled every %s (dry-run)", provider, d)
+	}
+}
+
+// LastReport returns the most recent report for provider, or nil if none has run yet
+func LastReport(provider string) *Report {
+	runMu.Lock()
+	defer runMu.Unlock()
+	return lastReports[provider]
+}
+
+func newReport(provider string, apply bool, upstreamPlans, localPlans int) *Report {
+	return &Report{
+		Provider:      provider,
+		RanAt:         time.Now(),
+		DryRun:        !apply,
+		UpstreamPlans: upstreamPlans,
+		LocalPlans:    localPlans,
+		Counts:        make(map[string]int),
+	}
+}
+
+func (r *Report) add(d Drift) {
+	r.Drift = append(r.Drift, d)
+	r.Counts[d.Kind]++
+}
+
+func (r *Report) sort() {
+	sort.Slice(r.Drift, func(i, j int) bool {
+		if r.Drift[i].Kind != r.Drift[j].Kind {
+			return r.Drift[i].Kind < r.Drift[j].Kind
+		}
+		return r.Drift[i].PlanID < r.Drift[j].PlanID
+	})
+}
+
+func expired(e proxy.Entry, now int64) bool {
+	return e.ExpiresAt != 0 && e.ExpiresAt < now
+}
+
+// liveEntries groups the indexes of non-expired entries by plan ID for entries matching host
+func liveEntries(entries []proxy.Entry, match func(e proxy.Entry) bool) map[string][]int {
+	now := time.Now().Unix()
+	local := make(map[string][]int)
+	for i, e := range entries {
+		if !match(e) || expired(e, now) {
+			continue
+		}
+		local[e.PlanID] = append(local[e.PlanID], i)
+	}
+	return local
+}
+
+// fixes collects the store changes of an applied run. They are saved against
+// the proxy log as it is then, not the copy the drift was found in, so plans
+// created while listeners were spawned or stopped are kept.
+type fixes struct {
+	match func(e proxy.Entry) bool
+	added []proxy.Entry
+	edits map[string][]func(e *proxy.Entry) // by plan ID, for live matching entries
+}
+
+func newFixes(match func(e proxy.Entry) bool) *fixes {
+	return &fixes{match: match, edits: make(map[string][]func(e *proxy.Entry))}
+}
+
+func (f *fixes) edit(planID string, fn func(e *proxy.Entry)) {
+	f.edits[planID] = append(f.edits[planID], fn)
+}
+
+func (f *fixes) save() error {
+	if len(f.added) == 0 && len(f.edits) == 0 {
+		return nil
+	}
+	now := time.Now().Unix()
+	return proxy.UpdateProxyLog(func(entries []proxy.Entry) ([]proxy.Entry, error) {
+		for i := range entries {
+			if !f.match(entries[i]) || expired(entries[i], now) {
+				continue
+			}
+			for _, fn := range f.edits[entries[i].PlanID] {
+				fn(&entries[i])
+			}
+		}
+		return append(entries, f.added...), nil
+	})
+}
+
+// stopEntries kills the listeners at idx and has f mark the plan expired so
+// restore skips it
+func stopEntries(f *fixes, entries []proxy.Entry, planID string, idx []int) error {
+	var firstErr error
+	for _, i := range idx {
+		if err := proxy.StopListener(entries[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		proxy.ReleaseEntryPort(entries[i])
+	}
+	now := time.Now().Unix()
+	f.edit(planID, func(e *proxy.Entry) { e.ExpiresAt = now })
+	return firstErr
+}
+
+// announce emits plan.created for the listeners a reconciliation started
+func announce(started []proxy.Entry, provider string) {
+	if len(started) == 0 {
+		return
+	}
+	data := events.PlanData(started)
+	data["source"] = provider + "_reconcile"
+	events.Emit(events.PlanCreated, data)
+}
+
+func subdomainsOf(entries []proxy.Entry, idx []int) []string {
+	var subs []string
+	for _, i := range idx {
+		subs = append(subs, entries[i].Subdomain)
+	}
+	return subs
+}
@@ -0,0 +1,170 @@
+package reconcile
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/providers"
+	"oceanproxy-api/proxy"
+)
+
+// kinds returns the drift kind reported for each plan
+func kinds(r *Report) map[string]string {
+	got := make(map[string]string)
+	for _, d := range r.Drift {
+		got[d.PlanID] = d.Kind
+	}
+	return got
+}
+
+func TestDiffNettify(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	entries := []proxy.Entry{
+		{PlanID: "ok", AuthHost: nettifyHost, QuotaBytes: 100},
+		{PlanID: "disabled", AuthHost: nettifyHost},
+		{PlanID: "inactive", AuthHost: nettifyHost},
+		{PlanID: "quota", AuthHost: nettifyHost, QuotaBytes: 100},
+		{PlanID: "noquota", AuthHost: nettifyHost},
+		{PlanID: "orphan", AuthHost: nettifyHost, ExpiresAt: future},
+		{PlanID: "gone", AuthHost: nettifyHost, ExpiresAt: 1}, // expired, not live
+		{PlanID: "other", AuthHost: "x.proxies.fo"},
+	}
+	plans := []providers.NettifyPlan{
+		{PlanID: "ok", Active: true, Enabled: true, MaxBytes: 100},
+		{PlanID: "missing", Active: true, Enabled: true},
+		{PlanID: "disabled", Active: true, Enabled: false},
+		{PlanID: "inactive", Active: false, Enabled: true},
+		{PlanID: "quota", Active: true, Enabled: true, MaxBytes: 200},
+		{PlanID: "noquota", Active: true, Enabled: true, MaxBytes: 200},
+		{PlanID: "off", Active: false, Enabled: false},
+		{PlanID: "gone", Active: true, Enabled: true},
+	}
+
+	r := newReport("nettify", false, len(plans), 0)
+	diffNettify(r, plans, entries, liveEntries(entries, isNettifyEntry))
+
+	want := map[string]string{
+		"missing":  MissingLocally,
+		"disabled": DisabledUpstream,
+		"inactive": DisabledUpstream,
+		"quota":    QuotaMismatch,
+		"orphan":   OrphanedLocally,
+		"gone":     MissingLocally,
+	}
+	got := kinds(r)
+	if len(got) != len(want) {
+		t.Errorf("drift %v, want %v", got, want)
+	}
+	for id, kind := range want {
+		if got[id] != kind {
+			t.Errorf("plan %s: kind %q, want %q", id, got[id], kind)
+		}
+	}
+	if r.Counts[DisabledUpstream] != 2 {
+		t.Errorf("counts %v, want 2 disabled_upstream", r.Counts)
+	}
+}
+
+func TestDiffProxiesFO(t *testing.T) {
+	old := *config.Get()
+	config.Set(func(s *config.Settings) {
+		s.ProxiesFOResellers = map[string]string{"residential": "res-uuid", "isp": "isp-uuid"}
+	})
+	defer config.Set(func(s *config.Settings) { *s = old })
+
+	now := time.Now().Unix()
+	later := now + 3600
+	entries := []proxy.Entry{
+		{PlanID: "ok", AuthHost: "res.proxies.fo", ExpiresAt: later},
+		{PlanID: "cancelled", AuthHost: "res.proxies.fo", ExpiresAt: later},
+		{PlanID: "ended", AuthHost: "res.proxies.fo", ExpiresAt: later},
+		{PlanID: "moved", AuthHost: "res.proxies.fo", ExpiresAt: later},
+		{PlanID: "orphan", AuthHost: "res.proxies.fo", ExpiresAt: later},
+		{PlanID: "extended", AuthHost: "res.proxies.fo", ExpiresAt: later + 86400, ExtendedAt: now},
+		{PlanID: "overtaken", AuthHost: "res.proxies.fo", ExpiresAt: later, ExtendedAt: now},
+		{PlanID: "nettify", AuthHost: nettifyHost},
+	}
+	plans := []providers.ProxiesFOPlan{
+		{ID: "ok", Status: "active", EndsDate: later},
+		{ID: "new", Status: "active", EndsDate: later, Reseller: "isp-uuid"},
+		{ID: "forever", Status: "", AuthHostname: "dcp.proxies.fo"},
+		{ID: "dashboard", Status: "active", EndsDate: later, Reseller: "other-uuid"},
+		{ID: "cancelled", Status: "cancelled", EndsDate: later},
+		{ID: "ended", Status: "active", EndsDate: now - 1},
+		{ID: "moved", Status: "active", EndsDate: later + 86400},
+		{ID: "stale", Status: "expired", EndsDate: now - 1},
+		{ID: "extended", Status: "active", EndsDate: later},
+		{ID: "overtaken", Status: "active", EndsDate: later + 86400}, // renewed upstream past the local extension
+	}
+
+	r := newReport("proxies.fo", false, len(plans), 0)
+	diffProxiesFO(r, plans, entries, liveEntries(entries, isProxiesFOEntry), now)
+
+	want := map[string]string{
+		"new":       MissingLocally,
+		"forever":   MissingLocally,
+		"dashboard": ProductMismatch,
+		"cancelled": DisabledUpstream,
+		"ended":     DisabledUpstream,
+		"moved":     ExpiryMismatch,
+		"overtaken": ExpiryMismatch,
+		"orphan":    OrphanedLocally,
+	}
+	got := kinds(r)
+	if len(got) != len(want) {
+		t.Errorf("drift %v, want %v", got, want)
+	}
+	for id, kind := range want {
+		if got[id] != kind {
+			t.Errorf("plan %s: kind %q, want %q", id, got[id], kind)
+		}
+	}
+	for i := 1; i < len(r.Drift); i++ {
+		if a, b := r.Drift[i-1], r.Drift[i]; a.Kind > b.Kind || (a.Kind == b.Kind && a.PlanID > b.PlanID) {
+			t.Errorf("drift not sorted: %s/%s before %s/%s", a.Kind, a.PlanID, b.Kind, b.PlanID)
+		}
+	}
+}
+
+func TestFixesSaveKeepsNewPlans(t *testing.T) {
+	config.ProxyLogPath = filepath.Join(t.TempDir(), "proxies.json")
+	if err := proxy.LogProxy(
+		proxy.Entry{PlanID: "p1", AuthHost: nettifyHost, QuotaBytes: 100},
+		proxy.Entry{PlanID: "p2", AuthHost: nettifyHost, ExpiresAt: 1},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFixes(isNettifyEntry)
+	f.edit("p1", func(e *proxy.Entry) { e.QuotaBytes = 200 })
+	f.edit("p2", func(e *proxy.Entry) { e.QuotaBytes = 200 }) // expired, left alone
+	f.added = append(f.added, proxy.Entry{PlanID: "p3", AuthHost: nettifyHost})
+
+	// Created by someone else while the run was spawning and stopping listeners
+	if err := proxy.LogProxy(proxy.Entry{PlanID: "p4", AuthHost: nettifyHost}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := proxy.LoadProxyLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	quota := make(map[string]int64)
+	for _, e := range entries {
+		quota[e.PlanID] = e.QuotaBytes
+	}
+	if len(entries) != 4 {
+		t.Errorf("got %d entries, want 4", len(entries))
+	}
+	if _, ok := quota["p4"]; !ok {
+		t.Error("plan created during the run was lost")
+	}
+	if quota["p1"] != 200 || quota["p2"] != 0 {
+		t.Errorf("quotas %v, want p1 updated and expired p2 untouched", quota)
+	}
+}
@@ -9,17 +9,38 @@ PASSWORD="$4"
 UPSTREAM_HOST="$5"
 UPSTREAM_PORT="$6"
 SUBDOMAIN="$7"
+# Optional: credentials for the parent proxy when they differ from the customer's
+# (used by upstream failover to route through a standby provider)
+UPSTREAM_USERNAME="${8:-$USERNAME}"
+UPSTREAM_PASSWORD="${9:-$PASSWORD}"
 
 # Validate required arguments
 if [ $# -lt 7 ]; then
-    echo "❌ Usage: $0 PLAN_ID LOCAL_PORT USERNAME PASSWORD UPSTREAM_HOST UPSTREAM_PORT SUBDOMAIN"
+    echo "❌ Usage: $0 PLAN_ID LOCAL_PORT USERNAME PASSWORD UPSTREAM_HOST UPSTREAM_PORT SUBDOMAIN [UPSTREAM_USERNAME UPSTREAM_PASSWORD]"
     exit 1
 fi
 
 # Updated paths to match your setup
-CONFIG_DIR="/etc/3proxy/plans"
+CONFIG_DIR="${PROXY_CONFIG_DIR:-/etc/3proxy/plans}"
 CONFIG_FILE="${CONFIG_DIR}/${PLAN_ID}_${SUBDOMAIN}.cfg"
 PROXY_LOG="/var/log/oceanproxy/proxies.json"
+# Per-connection access logs, collected by the API (see accesslog package)
+ACCESS_LOG_DIR="${ACCESS_LOG_DIR:-/var/log/oceanproxy/access}"
+# Concurrent connection cap, the plan's purchased threads when it has them.
+# 3proxy does not refuse connections over the cap: it stops accepting, so
+# they wait in the kernel listen queue until a slot frees or the client
+# gives up. The API reports listeners at their cap in /metrics and /monitoring.
+MAX_CONN="${MAX_CONN:-2000}"
+# bandlimin/bandlimout/connlim lines for the plan's rate limits, rendered by the API
+PROXY_LIMITS="${PROXY_LIMITS-}"
+# 3proxy deny/allow lines for the destination policy, rendered by the API.
+# Run by hand (PROXY_ACL unset), the API binary prints the safe defaults.
+if [ -z "${PROXY_ACL+set}" ]; then
+    if ! PROXY_ACL="$("${OCEANPROXY_API:-oceanproxy-api}" policy defaults)" || [ -z "$PROXY_ACL" ]; then
+        echo "❌ PROXY_ACL is not set and the default policy could not be read from ${OCEANPROXY_API:-oceanproxy-api}"
+        exit 1
+    fi
+fi
 
 # Port ranges (2000 ports each)
 declare -A PORT_RANGES
@@ -57,6 +78,7 @@ PORT_RANGE=${PORT_RANGES[$SUBDOMAIN]}
 # Create directories if they don't exist
 mkdir -p "$CONFIG_DIR"
 mkdir -p "/var/log/oceanproxy"
+mkdir -p "$ACCESS_LOG_DIR"
 
 echo "🔧 Creating whitelabel HTTP proxy plan: $PLAN_ID [$SUBDOMAIN]"
 echo "   👤 Username: $USERNAME"
@@ -64,12 +86,17 @@ echo "   🔌 Local Port: $LOCAL_PORT"
 echo "   📊 Port Range: $PORT_RANGE (2000 ports max)"
 echo "   🌐 Public Endpoint: ${SUBDOMAIN}.oceanproxy.io:${PUBLIC_PORT}"
 echo "   📡 Upstream: $UPSTREAM_HOST:$UPSTREAM_PORT"
+echo "   🔢 Max connections: $MAX_CONN"
 
 # === Validate port is within allowed range ===
-IFS='-' read -r MIN_PORT MAX_PORT <<< "$PORT_RANGE"
-if [[ $LOCAL_PORT -lt $MIN_PORT ]] || [[ $LOCAL_PORT -gt $MAX_PORT ]]; then
-    echo "❌ Port $LOCAL_PORT is outside allowed range $PORT_RANGE for subdomain $SUBDOMAIN"
-    exit 1
+# Regions added through the API's topology file have no entry here; the API
+# has already allocated their port from the declared range.
+if [[ -n "$PORT_RANGE" ]]; then
+    IFS='-' read -r MIN_PORT MAX_PORT <<< "$PORT_RANGE"
+    if [[ $LOCAL_PORT -lt $MIN_PORT ]] || [[ $LOCAL_PORT -gt $MAX_PORT ]]; then
+        echo "❌ Port $LOCAL_PORT is outside allowed range $PORT_RANGE for subdomain $SUBDOMAIN"
+        exit 1
+    fi
 fi
 
 # === Kill any existing process using the port ===
@@ -86,7 +113,7 @@ rm -f "${CONFIG_DIR}/${PLAN_ID}_${SUBDOMAIN}.cfg" 2>/dev/null
 
 # === Validate upstream host ===
 case "$UPSTREAM_HOST" in
-    dcp.proxies.fo|pr-us.proxies.fo|pr-eu.proxies.fo|proxy.nettify.xyz)
+    dcp.proxies.fo|pr-*.proxies.fo|proxy.nettify.xyz)
         echo "✅ Valid upstream host: $UPSTREAM_HOST"
         ;;
     blank|"")
@@ -95,7 +122,7 @@ case "$UPSTREAM_HOST" in
         ;;
     *)
         echo "⚠️ Unknown upstream host: $UPSTREAM_HOST"
-        echo "   Supported hosts: dcp.proxies.fo, pr-us.proxies.fo, pr-eu.proxies.fo, proxy.nettify.xyz, blank"
+        echo "   Supported hosts: dcp.proxies.fo, pr-*.proxies.fo, proxy.nettify.xyz, blank"
         exit 1
         ;;
 esac
@@ -115,11 +142,22 @@ if [[ "$UPSTREAM_HOST" == "blank" ]]; then
 
 nscache 65536
 timeouts 10 20 60 300 300 1800 10 120
-maxconn 2000
+maxconn ${MAX_CONN}
+
+# One line per connection: time|user|client ip|target|bytes in|bytes out|duration ms|result code
+log ${ACCESS_LOG_DIR}/${PLAN_ID}_${SUBDOMAIN}.%y%m%d.log D
+rotate 2
+logformat "L%t.%.|%U|%C|%n:%q|%I|%O|%D|%E"
 
 # Authentication for this specific user
 users $USERNAME:CL:$PASSWORD
 auth strong
+
+# Rate limits, none when empty
+${PROXY_LIMITS}
+
+# Destination policy, first match wins
+${PROXY_ACL}
 allow $USERNAME
 
 # HTTP proxy listening on port $LOCAL_PORT (no parent)
@@ -133,19 +171,30 @@ else
 # User: $USERNAME
 # Client endpoint: ${SUBDOMAIN}.oceanproxy.io:${PUBLIC_PORT}:${USERNAME}:${PASSWORD}
 # Internal port: $LOCAL_PORT
-# Upstream: ${UPSTREAM_HOST}:${UPSTREAM_PORT}:${USERNAME}:${PASSWORD}
+# Upstream: ${UPSTREAM_HOST}:${UPSTREAM_PORT}:${UPSTREAM_USERNAME}:${UPSTREAM_PASSWORD}
 
 nscache 65536
 timeouts 10 20 60 300 300 1800 10 120
-maxconn 2000
+maxconn ${MAX_CONN}
+
+# One line per connection: time|user|client ip|target|bytes in|bytes out|duration ms|result code
+log ${ACCESS_LOG_DIR}/${PLAN_ID}_${SUBDOMAIN}.%y%m%d.log D
+rotate 2
+logformat "L%t.%.|%U|%C|%n:%q|%I|%O|%D|%E"
 
 # Authentication for this specific user
 users $USERNAME:CL:$PASSWORD
 auth strong
+
+# Rate limits, none when empty
+${PROXY_LIMITS}
+
+# Destination policy, first match wins
+${PROXY_ACL}
 allow $USERNAME
 
 # Parent proxy (upstream provider)
-parent 1000 http $UPSTREAM_HOST $UPSTREAM_PORT $USERNAME $PASSWORD
+parent 1000 http $UPSTREAM_HOST $UPSTREAM_PORT $UPSTREAM_USERNAME $UPSTREAM_PASSWORD
 
 # HTTP proxy listening on port $LOCAL_PORT
 proxy -n -a -p$LOCAL_PORT -i0.0.0.0 -e0.0.0.0
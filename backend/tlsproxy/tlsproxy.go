@@ -0,0 +1,191 @@
+// Package tlsproxy serves the TLS-wrapped customer endpoints. Each configured
+// region gets a port that terminates TLS and passes the plain stream on to the
+// region's public port, so nginx and 3proxy still route and authenticate it.
+// The certificate and key files are re-read when they change.
+package tlsproxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"oceanproxy-api/config"
+	"oceanproxy-api/jobs"
+	"oceanproxy-api/providers"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	dialTimeout      = 5 * time.Second
+)
+
+// certState is the loaded key pair and the files it came from
+type certState struct {
+	cert  *tls.Certificate
+	files [2]string
+	stamp [2]fileStamp
+}
+
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+var (
+	mu      sync.RWMutex
+	current certState
+)
+
+// upstream returns the plain address a region's TLS connections are passed
+// to, replaced in tests
+var upstream = func(region string) (string, error) {
+	port, ok := providers.PublicPort(region)
+	if !ok {
+		return "", fmt.Errorf("region %s is not in the plan topology", region)
+	}
+	return fmt.Sprintf("127.0.0.1:%d", port), nil
+}
+
+// Start opens a TLS port for every region in TLS_PORTS and checks the
+// certificate files for changes every TLS_RELOAD_INTERVAL. Ports are
+// read once; changing them needs a restart.
+func Start() {
+	ports := config.Get().TLSPorts
+	if len(ports) == 0 {
+		return
+	}
+	if err := Reload(); err != nil {
+		log.Printf("❌ TLS endpoints disabled, cannot load certificate: %v", err)
+		return
+	}
+
+	tlsConfig := &tls.Config{GetCertificate: getCertificate, MinVersion: tls.VersionTLS12}
+	regions := make([]string, 0, len(ports))
+	for region := range ports {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		port := ports[region]
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			log.Printf("❌ TLS endpoint for %s cannot listen on port %d: %v", region, port, err)
+			continue
+		}
+		log.Printf("🔒 TLS endpoint for %s listening on port %d", region, port)
+		go serve(tls.NewListener(ln, tlsConfig), region)
+	}
+
+	jobs.Every("tls-reload", func() time.Duration { return config.Get().TLSReloadInterval }, func() {
+		if err := Reload(); err != nil {
+			log.Printf("⚠️ TLS certificate reload failed, keeping the current one: %v", err)
+		}
+	})
+}
+
+// Reload loads the configured key pair if either file changed since it was
+// last loaded
+func Reload() error {
+	cfg := config.Get()
+	files := [2]string{cfg.TLSCertFile, cfg.TLSKeyFile}
+	var stamp [2]fileStamp
+	for i, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		stamp[i] = fileStamp{info.ModTime(), info.Size()}
+	}
+
+	mu.RLock()
+	unchanged := current.cert != nil && current.files == files && current.stamp == stamp
+	mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(files[0], files[1])
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	current = certState{cert: &cert, files: files, stamp: stamp}
+	mu.Unlock()
+
+	if cert.Leaf != nil {
+		log.Printf("🔒 Loaded TLS certificate %s for %v, expires %s", files[0], cert.Leaf.DNSNames, cert.Leaf.NotAfter.Format("2006-01-02"))
+	}
+	return nil
+}
+
+func getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if current.cert == nil {
+		return nil, errors.New("no TLS certificate loaded")
+	}
+	return current.cert, nil
+}
+
+// serve accepts TLS connections for a region until ln is closed
+func serve(ln net.Listener, region string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("⚠️ TLS endpoint for %s accept failed: %v", region, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go handle(conn.(*tls.Conn), region)
+	}
+}
+
+// handle completes the handshake and copies the decrypted stream to and from
+// the region's public port until either side closes
+func handle(conn *tls.Conn, region string) {
+	defer conn.Close()
+
+	// Failed handshakes are scanners and clients speaking plain HTTP, not logged
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	addr, err := upstream(region)
+	if err != nil {
+		log.Printf("⚠️ TLS endpoint for %s: %v", region, err)
+		return
+	}
+	up, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		log.Printf("⚠️ TLS endpoint for %s cannot reach %s: %v", region, addr, err)
+		return
+	}
+	defer up.Close()
+
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(up, conn); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(conn, up); done <- struct{}{} }()
+	<-done
+}
+
+// Port returns the TLS port of a region, 0 if it has none
+func Port(region string) int {
+	return config.Get().TLSPorts[region]
+}
+
+// Enabled reports whether any region has a TLS endpoint
+func Enabled() bool {
+	return len(config.Get().TLSPorts) > 0
+}
@@ -0,0 +1,139 @@
+package tlsproxy
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"oceanproxy-api/config"
+)
+
+// writeCert writes a self-signed certificate for name to dir
+func writeCert(t *testing.T, dir, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadPicksUpChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	config.Set(func(s *config.Settings) {
+		s.TLSCertFile = filepath.Join(dir, "cert.pem")
+		s.TLSKeyFile = filepath.Join(dir, "key.pem")
+	})
+
+	writeCert(t, dir, "usa.example.com")
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := getCertificate(nil)
+
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if same, _ := getCertificate(nil); same != first {
+		t.Error("unchanged files should not be reloaded")
+	}
+
+	writeCert(t, dir, "eu.example.com")
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := getCertificate(nil); c.Leaf.DNSNames[0] != "eu.example.com" {
+		t.Errorf("serving %v after the files changed", c.Leaf.DNSNames)
+	}
+
+	os.WriteFile(config.Get().TLSKeyFile, []byte("broken"), 0600)
+	if err := Reload(); err == nil {
+		t.Error("expected an error for a broken key")
+	}
+	if c, _ := getCertificate(nil); c.Leaf.DNSNames[0] != "eu.example.com" {
+		t.Error("a failed reload should keep the current certificate")
+	}
+}
+
+func TestServePassesDecryptedStream(t *testing.T) {
+	dir := t.TempDir()
+	config.Set(func(s *config.Settings) {
+		s.TLSCertFile = filepath.Join(dir, "cert.pem")
+		s.TLSKeyFile = filepath.Join(dir, "key.pem")
+	})
+	writeCert(t, dir, "usa.example.com")
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plain upstream standing in for the region's public port
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	go func() {
+		c, err := plain.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		c.Write([]byte("upstream got " + line))
+	}()
+	upstream = func(string) (string, error) { return plain.Addr().String(), nil }
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serve(tls.NewListener(ln, &tls.Config{GetCertificate: getCertificate}), "usa")
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("CONNECT example.com:443 HTTP/1.1\n"))
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "upstream got CONNECT example.com:443 HTTP/1.1\n" {
+		t.Errorf("reply = %q", reply)
+	}
+}
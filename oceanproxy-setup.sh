@@ -345,7 +345,8 @@ build_application() {
     sudo -u "$SERVICE_USER" go mod tidy
     
     # Build the application
-    sudo -u "$SERVICE_USER" go build -o exec/oceanproxy cmd/main.go
+    sudo -u "$SERVICE_USER" go build -o exec/oceanproxy ./cmd
+    sudo -u "$SERVICE_USER" go build -o exec/oceanctl ./cmd/oceanctl
     
     # Make scripts executable
     chmod +x scripts/*.sh
@@ -563,8 +564,9 @@ Requires=oceanproxy-api.service
 Type=oneshot
 User=$SERVICE_USER
 Group=$SERVICE_USER
-WorkingDirectory=$INSTALL_DIR/app/backend/scripts
-ExecStart=$INSTALL_DIR/app/backend/scripts/ensure_proxies.sh --restart --quiet
+WorkingDirectory=$INSTALL_DIR/app/backend
+EnvironmentFile=$INSTALL_DIR/app/backend/exec/.env
+ExecStart=$INSTALL_DIR/app/backend/exec/oceanctl health --restart -q
 StandardOutput=append:$LOG_DIR/monitor.log
 StandardError=append:$LOG_DIR/monitor.log
 
@@ -982,7 +984,7 @@ print_summary() {
     echo "  • Check API status: sudo systemctl status oceanproxy-api"
     echo "  • View API logs: sudo tail -f $LOG_DIR/api.log"
     echo "  • Test all proxies: cd $INSTALL_DIR/app/backend/scripts && ./curl_commands.sh"
-    echo "  • Health check: cd $INSTALL_DIR/app/backend/scripts && ./ensure_proxies.sh"
+    echo "  • Health check: $INSTALL_DIR/app/backend/exec/oceanctl health"
     echo "  • Create plan: curl -X POST -H 'Authorization: Bearer $BEARER_TOKEN' \\"
     echo "                      -d 'reseller=residential&bandwidth=5&username=USER&password=PASS' \\"
     echo "                      http://localhost:$API_PORT/plan"
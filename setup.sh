@@ -490,7 +490,8 @@ build_application() {
     
     # Build the application with PATH set
     log "Building Go application..."
-    if sudo -u "$SERVICE_USER" env PATH="$PATH" go build -o exec/oceanproxy cmd/main.go; then
+    if sudo -u "$SERVICE_USER" env PATH="$PATH" go build -o exec/oceanproxy ./cmd && \
+       sudo -u "$SERVICE_USER" env PATH="$PATH" go build -o exec/oceanctl ./cmd/oceanctl; then
         log "Go application built successfully"
     else
         error "Failed to build Go application"
@@ -928,7 +929,7 @@ ExecStart=$INSTALL_DIR/app/backend/exec/oceanproxy
 ExecReload=/bin/kill -HUP \$MAINPID
 Restart=always
 RestartSec=5
-KillMode=mixed
+KillMode=process
 TimeoutStopSec=30
 
 # Environment